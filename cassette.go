@@ -0,0 +1,368 @@
+// Copyright 2020 The Execstub Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package execstub
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+	"unicode/utf8"
+
+	comproto "github.com/congop/execstub/pkg/comproto"
+	"github.com/pkg/errors"
+)
+
+// CassetteMode specifies whether a cassette records or replays command executions.
+type CassetteMode string
+
+const (
+	// CassetteModeReplay requires the recorded outcomes to be served
+	// instead of running the real executable.
+	CassetteModeReplay CassetteMode = "replay"
+
+	// CassetteModeRecord requires the executions to be delegated to the real
+	// executable and the request/outcome pairs to be recorded.
+	CassetteModeRecord CassetteMode = "record"
+)
+
+// EnvCassetteMode is the environment variable selecting the cassette mode.
+const EnvCassetteMode = "EXECSTUB_CASSETTE_MODE"
+
+// CassetteDir is the directory cassettes are stored in by default,
+// relative to the package directory of the running test.
+var CassetteDir = filepath.Join("testdata", "cassettes")
+
+// cassetteModeFlag holds the value of the flag -execstub.cassette; nil if not registered
+// (see RegisterCassetteModeFlag).
+var cassetteModeFlag *string
+
+// RegisterCassetteModeFlag registers the flag -execstub.cassette selecting the cassette mode
+// into the given flag set, flag.CommandLine if nil. It is not registered by default so that
+// importing execstub does not add a flag to the importing binary; it is meant to be called
+// by TestMain before the flags are parsed, e.g.
+//
+//	func TestMain(m *testing.M) {
+//		execstub.RegisterCassetteModeFlag(nil)
+//		flag.Parse()
+//		os.Exit(m.Run())
+//	}
+func RegisterCassetteModeFlag(flagSet *flag.FlagSet) {
+	if flagSet == nil {
+		flagSet = flag.CommandLine
+	}
+	cassetteModeFlag = flagSet.String(
+		"execstub.cassette", "",
+		"cassette mode: record or replay; overrides env "+EnvCassetteMode+"; defaults to replay")
+}
+
+// CassetteModeFromFlagOrEnv returns the cassette mode selected using the test flag
+// -execstub.cassette if registered (see RegisterCassetteModeFlag) and set,
+// or else the environment variable EXECSTUB_CASSETTE_MODE.
+// It defaults to CassetteModeReplay.
+func CassetteModeFromFlagOrEnv() (CassetteMode, error) {
+	mode := ""
+	if cassetteModeFlag != nil {
+		mode = *cassetteModeFlag
+	}
+	if mode == "" {
+		mode = os.Getenv(EnvCassetteMode)
+	}
+	switch CassetteMode(strings.ToLower(strings.TrimSpace(mode))) {
+	case "", CassetteModeReplay:
+		return CassetteModeReplay, nil
+	case CassetteModeRecord:
+		return CassetteModeRecord, nil
+	default:
+		return "", errors.Errorf(
+			"unsupported cassette mode '%s', expecting %s or %s",
+			mode, CassetteModeRecord, CassetteModeReplay)
+	}
+}
+
+// CassetteInteraction is a recorded command execution.
+type CassetteInteraction struct {
+	Request comproto.StubRequest
+	Outcome comproto.ExecOutcome
+}
+
+// Cassette holds recorded command executions.
+// In record mode the executions are delegated to the real executable
+// and recorded together with their outcome as they complete (see WhenExecDoCassette).
+// In replay mode a request is served the outcome of the first recorded
+// interaction with the same command name, arguments and stdin; the
+// interactions matching the same request are served in recording order,
+// the last one being repeated.
+type Cassette struct {
+	Path string
+	Mode CassetteMode
+
+	mutex        sync.Mutex
+	interactions []CassetteInteraction
+	served       []bool
+	err          error
+}
+
+// NewCassette returns the cassette with the given name stored in CassetteDir,
+// using the mode selected by flag or env (see CassetteModeFromFlagOrEnv).
+func NewCassette(name string) (*Cassette, error) {
+	mode, err := CassetteModeFromFlagOrEnv()
+	if err != nil {
+		return nil, err
+	}
+	return NewCassetteAt(filepath.Join(CassetteDir, name+".json"), mode)
+}
+
+// NewCassetteAt returns the cassette stored at the given path using the given mode.
+// The cassette must exist in replay mode.
+func NewCassetteAt(path string, mode CassetteMode) (*Cassette, error) {
+	cassette := Cassette{Path: path, Mode: mode}
+	if mode == CassetteModeRecord {
+		return &cassette, nil
+	}
+	if mode != CassetteModeReplay {
+		return nil, errors.Errorf("unsupported cassette mode: %s", mode)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err,
+			"fail to read cassette %s; record it using %s=%s",
+			path, EnvCassetteMode, CassetteModeRecord)
+	}
+	var records []cassetteRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, errors.Wrapf(err, "fail to decode cassette %s", path)
+	}
+	for i, record := range records {
+		interaction, err := record.toInteraction()
+		if err != nil {
+			return nil, errors.Wrapf(err, "fail to decode interaction[%d] of cassette %s", i, path)
+		}
+		cassette.interactions = append(cassette.interactions, interaction)
+	}
+	cassette.served = make([]bool, len(cassette.interactions))
+	return &cassette, nil
+}
+
+// Interactions returns the recorded respectively loaded interactions.
+func (cassette *Cassette) Interactions() []CassetteInteraction {
+	cassette.mutex.Lock()
+	defer cassette.mutex.Unlock()
+	return append([]CassetteInteraction{}, cassette.interactions...)
+}
+
+// Save writes the recorded interactions into the cassette file.
+// It must be called after the stubbings using the cassette have been unregistered.
+// It does nothing in replay mode.
+func (cassette *Cassette) Save() error {
+	cassette.mutex.Lock()
+	defer cassette.mutex.Unlock()
+	if cassette.Mode != CassetteModeRecord {
+		return nil
+	}
+	if cassette.err != nil {
+		return cassette.err
+	}
+	records := make([]cassetteRecord, 0, len(cassette.interactions))
+	for _, interaction := range cassette.interactions {
+		records = append(records, cassetteRecordOf(interaction))
+	}
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return errors.Wrapf(err, "fail to encode cassette %s", cassette.Path)
+	}
+	if err := os.MkdirAll(filepath.Dir(cassette.Path), 0770); err != nil {
+		return errors.Wrapf(err, "fail to create cassette dir for %s", cassette.Path)
+	}
+	if err := ioutil.WriteFile(cassette.Path, append(data, '\n'), 0660); err != nil { //nolint:gosec
+		return errors.Wrapf(err, "fail to write cassette %s", cassette.Path)
+	}
+	return nil
+}
+
+func (cassette *Cassette) record(req comproto.StubRequest, outcome comproto.ExecOutcome) {
+	cassette.mutex.Lock()
+	defer cassette.mutex.Unlock()
+	// The key depends on the test process, so it is not recorded.
+	req.Key = ""
+	outcome.Key = ""
+	cassette.interactions = append(cassette.interactions,
+		CassetteInteraction{Request: req, Outcome: outcome})
+}
+
+func (cassette *Cassette) recordingFailed(err error) {
+	cassette.mutex.Lock()
+	defer cassette.mutex.Unlock()
+	cassette.err = err
+}
+
+func (cassette *Cassette) replay(sreq comproto.StubRequest) *comproto.ExecOutcome {
+	cassette.mutex.Lock()
+	defer cassette.mutex.Unlock()
+	lastMatch := -1
+	for i, interaction := range cassette.interactions {
+		if !interaction.matches(sreq) {
+			continue
+		}
+		lastMatch = i
+		if !cassette.served[i] {
+			break
+		}
+	}
+	if lastMatch < 0 {
+		return &comproto.ExecOutcome{
+			Key:      sreq.Key,
+			ExitCode: math.MaxUint8,
+			InternalErrTxt: fmt.Sprintf(
+				"no interaction recorded in cassette %s matches request: %v",
+				cassette.Path, sreq),
+		}
+	}
+	cassette.served[lastMatch] = true
+	outcome := cassette.interactions[lastMatch].Outcome
+	outcome.Key = sreq.Key
+	return &outcome
+}
+
+func (interaction CassetteInteraction) matches(sreq comproto.StubRequest) bool {
+	recorded := interaction.Request
+	return recorded.CmdName == sreq.CmdName &&
+		recorded.Stdin == sreq.Stdin &&
+		len(recorded.Args) == len(sreq.Args) &&
+		(len(sreq.Args) == 0 || reflect.DeepEqual(recorded.Args, sreq.Args))
+}
+
+// WhenExecDoCassette configures stubbing so that the execution of the command
+// is recorded into respectively replayed from the cassette according to its mode.
+// In record mode the real executable is the one discovered before the stubbing setup.
+// It is run by the stubber, so that each request is recorded together with its outcome:
+// the stubbing is then dynamic whatever the mode of the settings, stdin and the working directory
+// are captured to run the real executable (but only recorded if the settings capture them),
+// and the real executable must complete within the timeout of the settings.
+func (stubber *ExecStubber) WhenExecDoCassette(
+	cassette *Cassette, cmdToStub string,
+	settings comproto.Settings,
+) (key string, err error) {
+	if cassette.Mode != CassetteModeRecord {
+		return stubber.WhenExecDoStubFunc(cassette.replay, cmdToStub, settings)
+	}
+
+	recordStdin, recordDir := settings.CaptureStdin, settings.CaptureDir
+	settings.ModeDanymic()
+	settings.WithStdinCapture()
+	settings.WithDirCapture()
+	stubFunc := func(sreq comproto.StubRequest) *comproto.ExecOutcome {
+		outcome, err := stubber.passthroughOutcomeFor(sreq)
+		if err != nil {
+			cassette.recordingFailed(err)
+			return &comproto.ExecOutcome{Key: sreq.Key, ExitCode: math.MaxUint8, InternalErrTxt: err.Error()}
+		}
+		recorded := sreq
+		if !recordStdin {
+			recorded.Stdin = ""
+		}
+		if !recordDir {
+			recorded.Dir = ""
+		}
+		cassette.record(recorded, *outcome)
+		return outcome
+	}
+	return stubber.WhenExecDoStubFunc(stubFunc, cmdToStub, settings)
+}
+
+// passthroughOutcomeFor runs the real executable of the command stubbed for the request
+// and returns its outcome (see comproto.PassthroughOutcomeFor).
+func (stubber *ExecStubber) passthroughOutcomeFor(sreq comproto.StubRequest) (*comproto.ExecOutcome, error) {
+	stubber.mutex.Lock()
+	spec, ok := stubber.cmdStabStore[sreq.Key]
+	stubber.mutex.Unlock()
+	if !ok {
+		return nil, errors.Errorf("cannot run the real executable, no command is stubbed for key %s", sreq.Key)
+	}
+	cfg, err := comproto.CmdConfigForCommand(spec.instDirStruct.execPath)
+	if err != nil {
+		return nil, err
+	}
+	return comproto.PassthroughOutcomeFor(*cfg, sreq)
+}
+
+// cassetteRecord is the stored form of an interaction.
+// Byte strings which are not valid UTF-8 are stored base64 encoded so that
+// they survive the json encoding.
+type cassetteRecord struct {
+	CmdName           string   `json:"cmd"`
+	Args              []string `json:"args,omitempty"`
+	Stdin             string   `json:"stdin,omitempty"`
+	StdinBytes        []byte   `json:"stdinBase64,omitempty"`
+	Dir               string   `json:"dir,omitempty"`
+	Env               []string `json:"env,omitempty"`
+	Stdout            string   `json:"stdout,omitempty"`
+	StdoutBytes       []byte   `json:"stdoutBase64,omitempty"`
+	Stderr            string   `json:"stderr,omitempty"`
+	StderrBytes       []byte   `json:"stderrBase64,omitempty"`
+	ExitCode          uint8    `json:"exitCode"`
+	TerminationSignal int      `json:"terminationSignal,omitempty"`
+}
+
+func cassetteRecordOf(interaction CassetteInteraction) cassetteRecord {
+	req, outcome := interaction.Request, interaction.Outcome
+	record := cassetteRecord{
+		CmdName:           req.CmdName,
+		Args:              req.Args,
+		Dir:               req.Dir,
+		Env:               req.Env,
+		ExitCode:          outcome.ExitCode,
+		TerminationSignal: int(outcome.TerminationSignal),
+	}
+	record.Stdin, record.StdinBytes = toTextOrBytes(req.Stdin)
+	record.Stdout, record.StdoutBytes = toTextOrBytes(outcome.Stdout)
+	record.Stderr, record.StderrBytes = toTextOrBytes(outcome.Stderr)
+	return record
+}
+
+func (record cassetteRecord) toInteraction() (CassetteInteraction, error) {
+	if record.CmdName == "" {
+		return CassetteInteraction{}, errors.New("cmd must not be empty")
+	}
+	return CassetteInteraction{
+		Request: comproto.StubRequest{
+			CmdName: record.CmdName,
+			Args:    record.Args,
+			Stdin:   record.Stdin + string(record.StdinBytes),
+			Dir:     record.Dir,
+			Env:     record.Env,
+		},
+		Outcome: comproto.ExecOutcome{
+			Stdout:            record.Stdout + string(record.StdoutBytes),
+			Stderr:            record.Stderr + string(record.StderrBytes),
+			ExitCode:          record.ExitCode,
+			TerminationSignal: syscall.Signal(record.TerminationSignal),
+		},
+	}, nil
+}
+
+func toTextOrBytes(str string) (text string, bytes []byte) {
+	if utf8.ValidString(str) {
+		return str, nil
+	}
+	return "", []byte(str)
+}
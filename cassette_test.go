@@ -0,0 +1,242 @@
+// Copyright 2020 The Execstub Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package execstub
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	rt "github.com/congop/execstub/internal/runtime"
+	comproto "github.com/congop/execstub/pkg/comproto"
+)
+
+const realKubectlScript = `#!/bin/sh
+case "$1" in
+  version) printf "Client Version: v1.18.3\n" ;;
+  cp) printf "\377\376\000tar" ; printf "\000\n" >&2 ;;
+  *) printf "error: unknown command \"%s\"\n" "$1" >&2 ; exit 1 ;;
+esac
+`
+
+type kubectlRun struct {
+	args         []string
+	wantStdout   string
+	wantStderr   string
+	wantExitCode int
+}
+
+var kubectlRuns = []kubectlRun{
+	{args: []string{"version"}, wantStdout: "Client Version: v1.18.3\n"},
+	{args: []string{"cp", "p1:/data", "-"}, wantStdout: "\xff\xfe\x00tar", wantStderr: "\x00\n"},
+	{args: []string{"drain"}, wantStderr: "error: unknown command \"drain\"\n", wantExitCode: 1},
+}
+
+func runKubectl(t *testing.T, run kubectlRun) {
+	cmd := exec.Command("kubectl", run.args...)
+	var bufStdout, bufStderr bytes.Buffer
+	cmd.Stdout = &bufStdout
+	cmd.Stderr = &bufStderr
+	_ = cmd.Run()
+	assert.Equal(t, run.wantExitCode, cmd.ProcessState.ExitCode(), "exit code of %v", run.args)
+	assert.Equal(t, run.wantStdout, bufStdout.String(), "stdout of %v", run.args)
+	assert.Equal(t, run.wantStderr, bufStderr.String(), "stderr of %v", run.args)
+}
+
+func Test_cassetteRecordThenReplay(t *testing.T) {
+	if rt.IsWindows() {
+		t.Skip("real exec is a shell script")
+	}
+	cmdToStub := rt.EnsureHasExecExt("kubectl")
+	stubber := NewExecStubber()
+	t.Cleanup(stubber.CleanUp)
+	settings := comproto.SettingsDynaStubCmdDiscoveredByPath()
+	cassetteDir, err := ioutil.TempDir("", "execstub-cassettes-*")
+	if err != nil {
+		t.Fatalf("fail to create cassette dir: %v", err)
+	}
+	defer os.RemoveAll(cassetteDir)
+
+	runForAllStubKinds(t, settings, "record then replay", func(t *testing.T, settings comproto.Settings) {
+		cassettePath := filepath.Join(cassetteDir, t.Name()+".json")
+
+		resetPath := givenRealExecInPath(t, cmdToStub, realKubectlScript)
+		recording, err := NewCassetteAt(cassettePath, CassetteModeRecord)
+		assert.NoError(t, err)
+		key, err := stubber.WhenExecDoCassette(recording, cmdToStub, settings)
+		if err != nil {
+			resetPath()
+			t.Fatalf("Error resgistering cassette: err=%#v key=%s", err, key)
+		}
+		for _, run := range kubectlRuns {
+			runKubectl(t, run)
+		}
+		stubber.Unregister(key)
+		resetPath()
+		assert.NoError(t, recording.Save())
+		assert.Len(t, recording.Interactions(), len(kubectlRuns))
+
+		replaying, err := NewCassetteAt(cassettePath, CassetteModeReplay)
+		if err != nil {
+			t.Fatalf("fail to load recorded cassette: %v", err)
+		}
+		key, err = stubber.WhenExecDoCassette(replaying, cmdToStub, settings)
+		if err != nil {
+			t.Fatalf("Error resgistering cassette: err=%#v key=%s", err, key)
+		}
+		defer stubber.Unregister(key)
+		if settings.InModStatic() {
+			// the outcome is determined before any request in static mode
+			return
+		}
+		for i := len(kubectlRuns) - 1; i >= 0; i-- {
+			runKubectl(t, kubectlRuns[i])
+		}
+
+		cmd := exec.Command("kubectl", "delete", "pod", "p1")
+		var bufStderr bytes.Buffer
+		cmd.Stderr = &bufStderr
+		_ = cmd.Run()
+		assert.Equal(t, 255, cmd.ProcessState.ExitCode())
+		assert.Contains(t, bufStderr.String(), "no interaction recorded in cassette")
+	})
+}
+
+// realKubectlGetScript finishes the later pods first, so that concurrent executions complete out of order.
+const realKubectlGetScript = `#!/bin/sh
+sleep "0.0$(( 9 - $2 ))"
+printf "pod-%s\n" "$2"
+exit "$2"
+`
+
+func Test_cassetteRecordsConcurrentExecutionsWithTheirOutcome(t *testing.T) {
+	if rt.IsWindows() {
+		t.Skip("real exec is a shell script")
+	}
+	cmdToStub := rt.EnsureHasExecExt("kubectl")
+	stubber := NewExecStubber()
+	t.Cleanup(stubber.CleanUp)
+	settings := comproto.SettingsDynaStubCmdDiscoveredByPath()
+	const execCount = 8
+
+	runForAllStubKinds(t, settings, "concurrent recording", func(t *testing.T, settings comproto.Settings) {
+		resetPath := givenRealExecInPath(t, cmdToStub, realKubectlGetScript)
+		defer resetPath()
+		recording, err := NewCassetteAt(filepath.Join(os.TempDir(), "unsaved.json"), CassetteModeRecord)
+		assert.NoError(t, err)
+		key, err := stubber.WhenExecDoCassette(recording, cmdToStub, settings)
+		if err != nil {
+			t.Fatalf("Error resgistering cassette: err=%#v key=%s", err, key)
+		}
+		defer stubber.Unregister(key)
+
+		done := make(chan error, execCount)
+		for i := 0; i < execCount; i++ {
+			go func(i int) {
+				done <- exec.Command("kubectl", "get", strconv.Itoa(i)).Run()
+			}(i)
+		}
+		for i := 0; i < execCount; i++ {
+			<-done
+		}
+
+		interactions := recording.Interactions()
+		assert.Len(t, interactions, execCount)
+		for _, interaction := range interactions {
+			if !assert.Len(t, interaction.Request.Args, 2) {
+				continue
+			}
+			pod := interaction.Request.Args[1]
+			assert.Equal(t, "pod-"+pod+"\n", interaction.Outcome.Stdout, "outcome of %v", interaction.Request.Args)
+			assert.Equal(t, pod, strconv.Itoa(int(interaction.Outcome.ExitCode)), "exit code of %v", interaction.Request.Args)
+		}
+	})
+}
+
+func Test_cassetteReplayFromTestdata(t *testing.T) {
+	cassette, err := NewCassette("terraform_version")
+	if err != nil {
+		t.Fatalf("fail to load cassette: %v", err)
+	}
+	if cassette.Mode == CassetteModeRecord {
+		t.Skip("cassette is only replayed, there is no real terraform to record from")
+	}
+	stubber := NewExecStubber()
+	t.Cleanup(stubber.CleanUp)
+	key, err := stubber.WhenExecDoCassette(
+		cassette, rt.EnsureHasExecExt("terraform"), *comproto.SettingsDynaStubCmdDiscoveredByPath())
+	if err != nil {
+		t.Fatalf("Error resgistering cassette: err=%#v key=%s", err, key)
+	}
+
+	gotStdout, err := exec.Command("terraform", "version").Output()
+	assert.NoError(t, err)
+	assert.Equal(t, "Terraform v0.12.26\n", string(gotStdout))
+
+	cmd := exec.Command("terraform", "validate")
+	gotStderr := bytes.Buffer{}
+	cmd.Stderr = &gotStderr
+	err = cmd.Run()
+	assert.Error(t, err)
+	assert.Equal(t, 1, cmd.ProcessState.ExitCode())
+	assert.Equal(t, "Error: Missing required argument\n", gotStderr.String())
+}
+
+func TestCassetteModeFromFlagOrEnv(t *testing.T) {
+	defer os.Unsetenv(EnvCassetteMode)
+	defer func() { cassetteModeFlag = nil }()
+	tests := []struct {
+		name           string
+		unregisterFlag bool
+		flagMode       string
+		envMode        string
+		want           CassetteMode
+		wantErr        bool
+	}{
+		{name: "Should default to replay", want: CassetteModeReplay},
+		{name: "Should use env", envMode: "record", want: CassetteModeRecord},
+		{name: "Should prefer flag over env", flagMode: "replay", envMode: "record", want: CassetteModeReplay},
+		{name: "Should accept flag ignoring case", flagMode: "RECORD", want: CassetteModeRecord},
+		{name: "Should use env if flag is not registered", unregisterFlag: true, envMode: "record", want: CassetteModeRecord},
+		{name: "Should fail on unknown mode", envMode: "rewind", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cassetteModeFlag = nil
+			if !tt.unregisterFlag {
+				flagSet := flag.NewFlagSet("cassette", flag.ContinueOnError)
+				RegisterCassetteModeFlag(flagSet)
+				assert.NoError(t, flagSet.Parse([]string{"-execstub.cassette=" + tt.flagMode}))
+			}
+			os.Setenv(EnvCassetteMode, tt.envMode)
+			got, err := CassetteModeFromFlagOrEnv()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CassetteModeFromFlagOrEnv() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestCassetteModeFlagIsNotRegisteredByDefault(t *testing.T) {
+	assert.Nil(t, flag.Lookup("execstub.cassette"))
+}
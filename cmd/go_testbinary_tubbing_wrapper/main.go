@@ -20,6 +20,9 @@ import (
 	"math"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
 
 	"github.com/pkg/errors"
 
@@ -33,17 +36,32 @@ func main() {
 		fmt.Printf("error getting executable: %v", err)
 		os.Exit(math.MaxInt8)
 	}
-	exitCode, err := runStubbingExec(execPath, os.Args, os.Stderr, os.Stdout)
+	execPath, execArgs := stubbedExecPathAndArgs(execPath, os.Args)
+	exitCode, err := runStubbingExec(execPath, execArgs, os.Stdin, os.Stderr, os.Stdout)
 	if err != nil {
-		fmt.Printf("Error executing stubbing: %v", err)
-		os.Exit(math.MaxInt8)
+		// reported like the outcome of a failed stubbing, e.g. an incompatible execstub version
+		fmt.Fprintf(os.Stderr, "Error executing stubbing: %v", err)
+		os.Exit(math.MaxUint8)
 	}
 	os.Exit(int(exitCode))
 }
 
+// stubbedExecPathAndArgs returns the path and the arguments of the stubbed command.
+// They are the ones of the process, unless it is run as the transport client of
+// the bash stub executable, which passes the config of the stubbed command as first argument:
+//
+//	execstub_transport_client /tmp/.../git.config arg1 arg2
+func stubbedExecPathAndArgs(execPath string, args []string) (string, []string) {
+	if filepath.Base(execPath) != comproto.TransportClientExecName || len(args) < 2 {
+		return execPath, args
+	}
+	cmdPath := strings.TrimSuffix(args[1], ".config")
+	return cmdPath, append([]string{cmdPath}, args[2:]...)
+}
+
 func runStubbingExec(
 	execPath string, originalExecArgs []string,
-	stderr io.Writer, stdout io.Writer,
+	stdin io.Reader, stderr io.Writer, stdout io.Writer,
 ) (exitCode uint8, err error) {
 
 	cfg, err := comproto.CmdConfigForCommand(execPath)
@@ -57,24 +75,27 @@ func runStubbingExec(
 
 	if cfg.UseTestHelperProcess() {
 		return outcomeFromTestHelperProcess(
-			execPath+".config", cfg, originalExecArgs, stderr, stdout)
+			execPath+".config", cfg, originalExecArgs, stdin, stderr, stdout)
 	}
 
-	stubReq := cfg.StubRequestWith(originalExecArgs[1:])
+	stubReq, err := cfg.StubRequestFor(originalExecArgs[1:], stdin)
+	if err != nil {
+		exitCode = math.MaxUint8
+		return exitCode, err
+	}
 
 	if cfg.UseStaticOutCome() {
-		return outcomeFromStaticSpec(stubReq, cfg, stderr, stdout)
+		return outcomeFromStaticSpec(stubReq, cfg, stdin, stderr, stdout)
 	}
 
-	timeout, err := cfg.TimeoutAsDuration()
+	_, err = cfg.TimeoutAsDuration()
 	if err != nil {
 		exitCode = math.MaxUint8
 		err = errors.Wrapf(err, "could not get configured timeout")
 		return exitCode, err
 	}
-	exitCodeUint8 := comproto.EffectuateDynamicOutcome(
-		timeout, cfg.PipeStubber, cfg.PipeTestHelperProcess,
-		stubReq, stderr, stdout)
+	exitCodeUint8 := comproto.EffectuateDynamicOutcomeFor(
+		*cfg, stubReq, stdin, stderr, stdout)
 
 	return exitCodeUint8, nil
 
@@ -83,14 +104,21 @@ func runStubbingExec(
 func outcomeFromStaticSpec(
 	req comproto.StubRequest,
 	cfg *comproto.CmdConfig,
-	stderr io.Writer, stdout io.Writer,
+	stdin io.Reader, stderr io.Writer, stdout io.Writer,
 ) (exitCode uint8, err error) {
-	repo := comproto.NewStubRequestDirRepo(cfg.DataDir)
-	err = repo.Save(req)
+	err = comproto.SaveStaticRequest(*cfg, req)
 	if nil != err {
 		err = errors.Wrapf(err, "fail to save err into %s", cfg.DataDir)
 		return math.MaxUint8, err
 	}
+	if cfg.Passthrough {
+		return comproto.EffectuatePassthrough(*cfg, req, stdin, stderr, stdout)
+	}
+	renderedCfg, err := cfg.WithRenderedTemplate(req)
+	if nil != err {
+		return math.MaxUint8, err
+	}
+	cfg = &renderedCfg
 	exitCode, err = cfg.ExitCodeUint8()
 	if nil != err {
 		return math.MaxUint8, err
@@ -112,12 +140,21 @@ func outcomeFromStaticSpec(
 			stdout, cfg.TxtStdout)
 		return
 	}
+
+	err = comproto.WriteOutputScript(cfg.OutputScript, stderr, stdout)
+	if err != nil {
+		return math.MaxUint8, err
+	}
+	comproto.DelayExit(cfg.ExitDelay, cfg.HangUntilSignalled)
+	if cfg.TerminationSignal != 0 {
+		return math.MaxUint8, comproto.TerminateBySignal(cfg.TerminationSignal)
+	}
 	return exitCode, nil
 }
 
 func outcomeFromTestHelperProcess(
 	stubCmdConfigPath string, cfg *comproto.CmdConfig, originalExecArgs []string,
-	stderr io.Writer, stdout io.Writer,
+	stdin io.Reader, stderr io.Writer, stdout io.Writer,
 ) (exitCode uint8, err error) {
 	helperArgs := make([]string, 0, len(originalExecArgs)+3)
 	// # -test.run takes a regex therefore matching the exact test helper process method
@@ -125,12 +162,18 @@ func outcomeFromTestHelperProcess(
 	helperArgs = append(helperArgs, originalExecArgs[1:]...)
 
 	cmd := exec.Command(cfg.UnitTestExec, helperArgs...) // #nosec
-	stubingEnv := append(make([]string, 0, len(cmd.Env)+5),
+	// The test helper process captures the environment if configured,
+	// so it must be run in the environment of the stubbed command.
+	// Stubbing entries are added last so that they take precedence.
+	environ := os.Environ()
+	stubingEnv := append(make([]string, 0, len(environ)+2), environ...)
+	stubingEnv = append(stubingEnv,
 		"__EXECSTUBBING_GO_WANT_HELPER_PROCESS=1",
 		"__EXECSTUBBING_STUB_CMD_CONFIG="+stubCmdConfigPath,
 	)
-	stubingEnv = append(stubingEnv, cmd.Env...)
 	cmd.Env = stubingEnv
+	// The test helper process does the stdin capture if configured
+	cmd.Stdin = stdin
 	cmd.Stderr = stderr
 	cmd.Stdout = stdout
 
@@ -138,6 +181,12 @@ func outcomeFromTestHelperProcess(
 	if err != nil {
 
 		if _, ok := err.(*exec.ExitError); ok {
+			// The outcome may be a termination by signal, which has to be
+			// replicated by the command parent.
+			status, isWaitStatus := cmd.ProcessState.Sys().(syscall.WaitStatus)
+			if isWaitStatus && status.Signaled() {
+				return math.MaxUint8, comproto.TerminateBySignal(status.Signal())
+			}
 			// Cmd run but exited with non zero exit code
 			// We are ok with that, as it is the actual command execution outcome
 			// the command parent should merely return the child process exit code
@@ -20,7 +20,9 @@ import (
 	"math"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/congop/execstub/internal/fifo"
 	"github.com/congop/execstub/internal/ipc"
@@ -42,9 +44,14 @@ func TestProcHelperConfiguredOutcome(t *testing.T) { //nolint:unparam
 }
 
 func Test_runStubbingExec(t *testing.T) {
+	os.Setenv("EXECSTUB_WRAPPER_TEST_GIT_DIR", "/repo/.git")
+	os.Setenv("EXECSTUB_WRAPPER_TEST_DENIED", "denied")
+	defer os.Unsetenv("EXECSTUB_WRAPPER_TEST_GIT_DIR")
+	defer os.Unsetenv("EXECSTUB_WRAPPER_TEST_DENIED")
 
 	type args struct {
 		cmdArgs          []string
+		stdin            string
 		createComChannel bool
 		cmdConfig        comproto.CmdConfig
 	}
@@ -157,6 +164,187 @@ func Test_runStubbingExec(t *testing.T) {
 				InternalErrTxt: "XXX",
 			},
 		},
+		{
+			name: "Should capture stdin and effectuate configured static outcome",
+			args: args{
+				cmdArgs: []string{"apply", "-f", "-"},
+				stdin:   "kind: Pod\nmetadata:\n  name: p1\n",
+				cmdConfig: comproto.CmdConfig{
+					CmdToStub:               "Exe2",
+					StubKey:                 "Exe2_9876",
+					UnitTestExec:            os.Args[0],
+					TestHelperProcessMethod: "",
+					ExitCode:                0,
+					TxtStdout:               "pod/p1 created",
+					Timeout:                 comproto.CfgDefaultTimeout(),
+					CaptureStdin:            true,
+				},
+			},
+			wantErr:      false,
+			wantExitCode: 0,
+			wantOutcome: comproto.ExecOutcome{
+				ExitCode: 0,
+				Stdout:   "pod/p1 created",
+			},
+		},
+		{
+			name: "should capture stdin and effectuate dynamic outcome",
+			args: args{
+				cmdArgs: []string{"login", "--password-stdin"},
+				stdin:   "s3cr3t",
+				cmdConfig: comproto.CmdConfig{
+					CmdToStub:               "Exe3",
+					StubKey:                 "Exe3_9876",
+					UnitTestExec:            os.Args[0],
+					TestHelperProcessMethod: "",
+					ExitCode:                nil,
+					Timeout:                 comproto.CfgDefaultTimeout(),
+					CaptureStdin:            true,
+				},
+				createComChannel: true,
+			},
+			wantErr:      false,
+			wantExitCode: 0,
+			wantOutcome: comproto.ExecOutcome{
+				ExitCode: 0,
+				Stdout:   "Login Succeeded",
+			},
+		},
+		{
+			name: "Should effectuate configured static binary outcome",
+			args: args{
+				cmdArgs: []string{"cat-file", "blob", "HEAD:logo.png"},
+				cmdConfig: comproto.CmdConfig{
+					CmdToStub:    "Exe2",
+					StubKey:      "Exe2_9876",
+					UnitTestExec: os.Args[0],
+					ExitCode:     0,
+					TxtStderr:    "\x00\xff\n\n",
+					TxtStdout:    "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR\n",
+					Timeout:      comproto.CfgDefaultTimeout(),
+				},
+			},
+			wantErr:      false,
+			wantExitCode: 0,
+			wantOutcome: comproto.ExecOutcome{
+				ExitCode: 0,
+				Stderr:   "\x00\xff\n\n",
+				Stdout:   "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR\n",
+			},
+		},
+		{
+			name: "should effectuate dynamic binary outcome",
+			args: args{
+				cmdArgs: []string{"cat-file", "blob", "HEAD:logo.png"},
+				cmdConfig: comproto.CmdConfig{
+					CmdToStub:    "Exe3",
+					StubKey:      "Exe3_9876",
+					UnitTestExec: os.Args[0],
+					Timeout:      comproto.CfgDefaultTimeout(),
+				},
+				createComChannel: true,
+			},
+			wantErr:      false,
+			wantExitCode: 0,
+			wantOutcome: comproto.ExecOutcome{
+				ExitCode: 0,
+				Stderr:   "\x00\xff\n\n",
+				Stdout:   "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR\n",
+			},
+		},
+		{
+			name: "Should effectuate configured static outcome with output script",
+			args: args{
+				cmdArgs: []string{"logs", "-f", "p1"},
+				cmdConfig: comproto.CmdConfig{
+					CmdToStub:    "Exe2",
+					StubKey:      "Exe2_9876",
+					UnitTestExec: os.Args[0],
+					ExitCode:     0,
+					TxtStdout:    "streaming\n",
+					OutputScript: []comproto.OutputChunk{
+						comproto.StdoutChunk(0, "line1\n"),
+						comproto.StderrChunk(10*time.Millisecond, "warn\n"),
+						comproto.StdoutChunk(10*time.Millisecond, "line2\n"),
+					},
+					Timeout: comproto.CfgDefaultTimeout(),
+				},
+			},
+			wantErr:      false,
+			wantExitCode: 0,
+			wantOutcome: comproto.ExecOutcome{
+				ExitCode: 0,
+				Stdout:   "streaming\nline1\nline2\n",
+				Stderr:   "warn\n",
+			},
+		},
+		{
+			name: "should effectuate dynamic outcome with output script",
+			args: args{
+				cmdArgs: []string{"logs", "-f", "p1"},
+				cmdConfig: comproto.CmdConfig{
+					CmdToStub:    "Exe3",
+					StubKey:      "Exe3_9876",
+					UnitTestExec: os.Args[0],
+					Timeout:      comproto.CfgDefaultTimeout(),
+				},
+				createComChannel: true,
+			},
+			wantErr:      false,
+			wantExitCode: 0,
+			wantOutcome: comproto.ExecOutcome{
+				ExitCode: 0,
+				OutputScript: []comproto.OutputChunk{
+					comproto.StdoutChunk(0, "line1\n"),
+					comproto.StderrChunk(10*time.Millisecond, "\x00warn\n"),
+					comproto.StdoutChunk(10*time.Millisecond, ""),
+				},
+			},
+		},
+		{
+			name: "Should effectuate configured static outcome with exit delay",
+			args: args{
+				cmdArgs: []string{"apply", "-auto-approve"},
+				cmdConfig: comproto.CmdConfig{
+					CmdToStub:    "Exe2",
+					StubKey:      "Exe2_9876",
+					UnitTestExec: os.Args[0],
+					ExitCode:     1,
+					TxtStderr:    "Error: timeout while waiting for state\n",
+					ExitDelay:    20 * time.Millisecond,
+					Timeout:      comproto.CfgDefaultTimeout(),
+				},
+			},
+			wantErr:      false,
+			wantExitCode: 1,
+			wantOutcome: comproto.ExecOutcome{
+				ExitCode: 1,
+				Stderr:   "Error: timeout while waiting for state\n",
+			},
+		},
+		{
+			name: "should capture dir and env and effectuate dynamic outcome",
+			args: args{
+				cmdArgs: []string{"remote", "add", "origin"},
+				cmdConfig: comproto.CmdConfig{
+					CmdToStub:    "Exe3",
+					StubKey:      "Exe3_9876",
+					UnitTestExec: os.Args[0],
+					Timeout:      comproto.CfgDefaultTimeout(),
+					CaptureDir:   true,
+					EnvFilter: comproto.EnvFilter{
+						AllowList: []string{"EXECSTUB_WRAPPER_TEST_*"},
+						DenyList:  []string{"EXECSTUB_WRAPPER_TEST_DENIED"},
+					},
+				},
+				createComChannel: true,
+			},
+			wantErr:      false,
+			wantExitCode: 0,
+			wantOutcome: comproto.ExecOutcome{
+				ExitCode: 0,
+			},
+		},
 		{
 			name: "should effectuate dynamic outcome using test process helper",
 			args: args{
@@ -204,20 +392,21 @@ func Test_runStubbingExec(t *testing.T) {
 			var gotRequest *comproto.StubRequest
 			if comChannel != nil {
 				go func() {
-					req, ok := <-comChannel.StubRequestChan
-					t.Logf("Received (%t) request:%v", ok, req)
+					invocation, ok := <-comChannel.StubRequestChan
+					t.Logf("Received (%t) invocation:%v", ok, invocation)
 					if !ok {
 						return
 					}
-					reqCopy := *req
+					reqCopy := *invocation.Request
 					gotRequest = &reqCopy
 					resp := tt.wantOutcome
-					comChannel.ExecResponseChan <- &resp
+					_ = comChannel.EnqueueRespnse(invocation.ID, &resp)
 				}()
 			}
 
 			///
-			gotExitCode, err := runStubbingExec(stubExecPath, originalExecArgs, &stderr, &stdout)
+			gotExitCode, err := runStubbingExec(
+				stubExecPath, originalExecArgs, strings.NewReader(tt.args.stdin), &stderr, &stdout)
 			///
 
 			if (err != nil) != tt.wantErr {
@@ -235,14 +424,8 @@ func Test_runStubbingExec(t *testing.T) {
 			}
 			assert.Equal(t, mapToActualOutomeGivenInternalErr(tt.wantOutcome), gotOutcome)
 			if tt.args.cmdConfig.UseDynamicOutcome() {
-				assert.Equal(
-					t,
-					&comproto.StubRequest{
-						Key:     tt.args.cmdConfig.StubKey,
-						Args:    tt.args.cmdArgs,
-						CmdName: tt.args.cmdConfig.CmdToStub,
-					},
-					gotRequest)
+				wantRequest := wantRequest(t, tt.args.cmdConfig, tt.args.cmdArgs, tt.args.stdin)
+				assert.Equal(t, &wantRequest, gotRequest)
 			}
 
 			if tt.args.cmdConfig.UseStaticOutCome() {
@@ -251,11 +434,9 @@ func Test_runStubbingExec(t *testing.T) {
 				assert.NoError(t, err, "fail to find requests")
 				assert.Equal(
 					t,
-					[]comproto.StubRequest{{
-						Key:     tt.args.cmdConfig.StubKey,
-						Args:    tt.args.cmdArgs,
-						CmdName: tt.args.cmdConfig.CmdToStub,
-					}},
+					[]comproto.StubRequest{
+						wantRequest(t, tt.args.cmdConfig, tt.args.cmdArgs, tt.args.stdin),
+					},
 					*gotRequests)
 
 			}
@@ -263,7 +444,39 @@ func Test_runStubbingExec(t *testing.T) {
 	}
 }
 
+func wantRequest(
+	t *testing.T, cfg comproto.CmdConfig, args []string, stdin string,
+) comproto.StubRequest {
+	req := comproto.StubRequest{
+		Key:     cfg.StubKey,
+		Args:    args,
+		CmdName: cfg.CmdToStub,
+	}
+	if cfg.CaptureStdin {
+		req.Stdin = stdin
+	}
+	if cfg.CaptureDir {
+		wd, err := os.Getwd()
+		if err != nil {
+			t.Fatalf("fail to get working dir: %v", err)
+		}
+		req.Dir = wd
+	}
+	if cfg.EnvFilter.IsCapturing() {
+		req.Env = []string{"EXECSTUB_WRAPPER_TEST_GIT_DIR=/repo/.git"}
+	}
+	return req
+}
+
 func mapToActualOutomeGivenInternalErr(outcome comproto.ExecOutcome) comproto.ExecOutcome {
+	for _, chunk := range outcome.OutputScript {
+		if chunk.Stream == comproto.StreamStderr {
+			outcome.Stderr += chunk.Data
+		} else {
+			outcome.Stdout += chunk.Data
+		}
+	}
+	outcome.OutputScript = nil
 	if outcome.InternalErrTxt != "" {
 		outcome.Stderr += outcome.InternalErrTxt
 		outcome.ExitCode = math.MaxUint8
@@ -314,7 +527,7 @@ func createTmpDirWithCmdAndConfig(
 
 	if createComChannel {
 		stubberPipePath, testProcessHelperPipePath := fifo.NewFifoNamesForIpc(stubExecPath)
-		comChannel, err = ipc.NewStubbingComChannel(stubberPipePath, testProcessHelperPipePath)
+		comChannel, err = ipc.NewStubbingComChannel(stubberPipePath, testProcessHelperPipePath, nil)
 		if err != nil {
 			t.Errorf("Error creating com channel: %s %##v", err.Error(), err)
 			return
@@ -333,3 +546,35 @@ func createTmpDirWithCmdAndConfig(
 	}
 	return stubExecPath, dataDir, comChannel
 }
+
+func Test_stubbedExecPathAndArgs(t *testing.T) {
+	tests := []struct {
+		name         string
+		execPath     string
+		args         []string
+		wantExecPath string
+		wantArgs     []string
+	}{
+		{
+			name:         "Should keep path and args of stub executable",
+			execPath:     "/tmp/stub/git",
+			args:         []string{"git", "status"},
+			wantExecPath: "/tmp/stub/git",
+			wantArgs:     []string{"git", "status"},
+		},
+		{
+			name:         "Should take stubbed command from config of transport client",
+			execPath:     "/tmp/stub/data/" + comproto.TransportClientExecName,
+			args:         []string{comproto.TransportClientExecName, "/tmp/stub/git.config", "log", "-1"},
+			wantExecPath: "/tmp/stub/git",
+			wantArgs:     []string{"/tmp/stub/git", "log", "-1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotExecPath, gotArgs := stubbedExecPathAndArgs(tt.execPath, tt.args)
+			assert.Equal(t, tt.wantExecPath, gotExecPath)
+			assert.Equal(t, tt.wantArgs, gotArgs)
+		})
+	}
+}
@@ -70,6 +70,9 @@ or some sort of <HOME>+<Bin> configuration.
         - Starting the process
           It is modeled using StubRequest which hold the the command name and the
           execution argument so that the fake execution process can be function of them.
+          The content fed to the command through stdin, its working directory and
+          a filtered set of its environment variables can be captured as well if
+          required by the settings.
 
         - Execution
           The outcome can be a static sum of known in advance stderr, stdout and exit code.
@@ -82,9 +85,28 @@ or some sort of <HOME>+<Bin> configuration.
           We refer to these cases as dynamic mode.
           The execution is therefore modelled as function namely StubFunc.
           Thus, if required the StubFunc corresponding to the current stubbing will be looked-up and run.
+          Instead of switching on the request arguments by hand, a StubFunc can be built from
+          expectations, e.g. stubs.On("git").WithArgs("clone", AnyString(), HasPrefix("/tmp")).Return(&outcome)
+          (see comproto.Expectations). An unmatched request yields an InternalErrTxt listing
+          the closest expectations.
 
         - Outcome
           The stderr/stdout/exit-code part of the outcome is modeled as ExecOutcome.
+          Output which must be interleaved or arrive over time (e.g. progress, log tailing)
+          can be specified as an OutputScript of delayed stdout/stderr chunks.
+          A slow or wedged command can be simulated using ExitDelay respectively
+          HangUntilSignalled, e.g. to test exec.CommandContext timeout handling.
+          A command killed by a signal (e.g. OOM-killed) can be simulated using TerminationSignal.
+          An outcome can also require the execution to be delegated to the real executable
+          (see AdaptPassthroughToCmdStub, PassthroughOutcome), which is discovered before
+          the stubbing setup; its outcome is persisted (see FindAllPersistedExecOutcomes).
+          Stdout, stderr and exit code can be text/template templates rendered against the
+          request, e.g. "Cloning into '{{lastArg}}'..." (see OutcomeTemplate, TemplatedOutcome);
+          in static mode the stub executable renders them itself; the bash stub executable
+          delegates the rendering to the exe stub executable.
+          Executions can be recorded into a cassette under testdata and replayed later
+          (see Cassette, WhenExecDoCassette); the mode is selected using the env variable
+          EXECSTUB_CASSETTE_MODE or the test flag -execstub.cassette registered by RegisterCassetteModeFlag.
           The execution the the stubfunc may result however in an error.
           Such error is not an actual sub-process execution erroneous outcome.
           It therefore must be modeled separately as opposed to be added to stderr
@@ -115,13 +137,41 @@ or some sort of <HOME>+<Bin> configuration.
       been able to cope with multiline sterr and stdout. We choose a combination of :
         - base64 to encode the discrete data (e.g. stderr, stdout)
         - and comma separated value (easily encoded and decoded in bash) for envelope encoding
+      Between go processes (stubber, go based stub executable and test helper process)
+      a self-describing structured encoding is used instead: a length-prefixed frame holding
+      named length-prefixed fields, unknown fields being skipped, so that fields can be added
+      without positional changes. The bash path keeps the csv encoding, a subset carrying the
+      same data; decoders detect the encoding and an outcome is sent back in the encoding
+      of its request (see comproto.WireFormat).
+      Every StubRequest and ExecOutcome envelope starts with a protocol version marker
+      and the CmdConfig holds the protocol version as well (see comproto.ProtocolVersion).
+      The stub executables and the stubber must speak the same version; otherwise the execution
+      fails with an "incompatible execstub version" error, e.g. when a stale stub executable is used.
       Named pipes are used for the actual data transport, because they are file based and easily
       handle in different setups (e.g. in bash).
+      Each fake process execution (invocation) is identified by an id. Its request is written into
+      a dedicated file and only the id is sent through the named pipe shared by all invocations.
+      The ExecOutcome is sent back through a named pipe dedicated to the invocation.
+      So concurrent executions of the same stub get their own outcome.
+      Alternatively a unix domain socket served by the stubber can be selected as transport
+      (see Settings.WithTransportUnixSocket): each invocation connects to it and gets its outcome
+      through its own connection.
+      An HTTP server listening on 127.0.0.1 can be selected as well (see Settings.WithTransportHTTP),
+      so that stub executables run in a chroot or another mount namespace can reach the stubber;
+      each invocation is a request authenticated by a token found in the CmdConfig.
+      The bash stub executable delegates such invocations to the go based stub executable,
+      installed as transport client, because bash can connect to neither of them.
 
     - Stubbing Setup
       An ExecStubber is provided to manage the stubbing setup.
       Its key feature is to setup an invokation of a StubFunc to replace the execution
       of a process, See ExecStubber.WhenExecDoStubFunc(...).
+      A stubber bound to a test is obtained using New(t): it is cleaned up at the end of the test,
+      logs using t.Logf and reports internal errors (e.g. unregistered key, too many executions,
+      panicking StubFunc) as test failures, which otherwise only surface as exit code 255.
+      A StubFuncWithContext (see ExecStubber.WhenExecDoStubFuncWithContext(...)) may return an error
+      and gets a context which is cancelled once the execution cannot receive its outcome anymore:
+      its process is gone (e.g. killed) or the timeout of the Settings has passed.
       It allows settings beyond the basic requirement of specifying
       the executable to be stubbed and a StubFunc replacement.
       This is modeled using Settings, which provides the following configuration options:
@@ -130,11 +180,40 @@ or some sort of <HOME>+<Bin> configuration.
         - Selecting the stubbing mode (static vs. dynamic)
         - Specifying the test process helper method name
         - specifying a timeout  for a stub sub-process execution
+        - capturing stdin, working directory and environment of the stubbed command
       A stubbing setup is identified by a key.
       The key can be use to:
         - unwind the setup(Unregister(..))
         - access the stubbing data basis of static requests
           (FindAllPersistedStubRequests, DeleteAllPersistedStubRequests ).
+      The executions of all stubbed commands can be verified in both modes, e.g. call counts,
+      never-called and in-order checks (see AssertCalledTimes, AssertNeverCalled, AssertCalledInOrder),
+      the calls being described using comproto.CallTo(..) and argument matchers.
+      Multi-level CLIs (e.g. docker image ls, git remote add) can be stubbed using a comproto.SubcommandRouter,
+      which dispatches on the subcommand path, skipping the known global flags, with a default StubFunc as fallback.
+      Outcomes depending on previous executions (e.g. docker ps listing a container between docker run and docker rm)
+      can be modeled using a comproto.Scenario, a named state machine whose steps may move it to a new state.
+      Its state can be inspected (State) and reset between sub-tests (Reset).
+      All invocations are also recorded in one timeline shared by every stubbed command (see Timeline).
+      Each entry gets a sequence number claimed atomically by the executing process, so the order
+      of concurrent executions is exact and does not depend on clock resolution.
+      When the code under test executes commands from background goroutines, a comproto.InvocationRecorder
+      lets the test wait for a number of matching invocations (AwaitInvocations) or subscribe to them (Subscribe)
+      while the dynamic stubs are running; comproto.RecordingExecutionsWithSnapshot gives a snapshot of the
+      requests recorded so far, unlike the store of comproto.RecordingExecutions, which must only be read
+      once the stubbed commands are done.
+      Commands can also be stubbed without writing Go, using a YAML or JSON stub definition file
+      stored under testdata/stubs, which lists the commands with their settings, argument matchers
+      and outcomes; all of them are registered in one call (see LoadStubDefinitions).
+      Several commands (e.g. java and javac) and their aliases can be installed into one home/bin dir
+      with a single discovery change using a stub group (see WhenGroupExecDoStubFuncs).
+      Each command of the group keeps its own StubFunc (or StubFuncWithContext) and its own key
+      (StubGroup.CmdKey(..)), while the group key unwinds the whole setup.
+      In strict mode (see EnableStrictMode) PATH only leads to the stubbed commands and an allow-list,
+      every other command of PATH being replaced by a blocking stub exiting with code 127.
+      The blocked executions are reported at CleanUp, so that a test cannot quietly run a real tool.
+      The blocking stubs are a snapshot of PATH when strict mode is enabled, and an execution
+      by absolute path bypasses strict mode.
 
     - Concurrency and parallelism
       The mutation of the process environment is the key enabling mechanism of Execstub.
@@ -24,4 +24,5 @@ require (
 	github.com/stretchr/testify v1.6.1
 	golang.org/x/sys v0.0.0-20210412220455-f1c623a9e750 // indirect
 	gopkg.in/ini.v1 v1.57.0
+	gopkg.in/yaml.v3 v3.0.1
 )
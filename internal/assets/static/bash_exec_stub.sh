@@ -14,6 +14,23 @@
 # See the License for the specific language governing permissions and
 # limitations under the License.
 
+# version of the protocol spoken with the stubber, see comproto.ProtocolVersion
+stubProtocolVersion=3
+
+# the marker leading every encoded stub request and exec outcome
+protocolVersionMarker() {
+  printf "execstub-v%s" "${stubProtocolVersion}"
+}
+
+# Reports data encoded with another protocol version than the one of this stub and exits.
+# Encodings without version marker are from version 1.
+exitIncompatibleVersion() {
+  subject="$1"
+  version="${2:-1}"
+  printf "%s" "incompatible execstub version: ${subject} uses protocol version ${version} but version ${stubProtocolVersion} is expected; stub executables and stubber must come from the same execstub version" >&2
+  exit 255
+}
+
 isUint8() {
     val=$1; val="${val// /}"; val=${val:-xxxnan---};
     if (( val >= 0 &&  val <= 255 )); then
@@ -23,54 +40,342 @@ isUint8() {
     fi
 }
 
+# base64 wraps lines, so we remove the new lines to keep the value on one csv record
 strToBase64() {
   if [[ -n "$1" ]]; then
-    printf "%s" "$1" | base64
+    printf "%s" "$1" | base64 | tr -d '\n'
   fi
   #printf ""
 }
 
-echoStubRequestAsBase64CSV() {
-  req="$( strToBase64 ${__EXECSTUBBING_STUB_KEY} )"
+# stdin may be bigger than base64 line wrapping limit, so we remove the new lines
+stdinToBase64IfCaptured() {
+  if [[ "true" == "${__EXECSTUBBING_CAPTURE_STDIN}" ]]; then
+    base64 | tr -d '\n'
+  fi
+}
+
+dirToBase64IfCaptured() {
+  if [[ "true" == "${__EXECSTUBBING_CAPTURE_DIR}" ]]; then
+    strToBase64 "$(pwd)"
+  fi
+}
+
+# pattern matching is based on bash glob matching, so it is equivalent to golang path.Match
+matchesAnyEnvNamePattern() {
+  name="$1"
+  IFS="," read -ra patterns <<< "$2"
+  for pattern in "${patterns[@]}"
+  do
+    # pattern must not be quoted to be used for matching
+    if [[ -n "${pattern}" && "${name}" == ${pattern} ]]; then
+      return 0
+    fi
+  done
+  return 1
+}
+
+isEnvNameCaptured() {
+  name="$1"
+  if [[ "${name}" == __EXECSTUBBING_* ]]; then
+    return 1
+  fi
+  matchesAnyEnvNamePattern "${name}" "${__EXECSTUBBING_ENV_ALLOW_LIST}" \
+    && ! matchesAnyEnvNamePattern "${name}" "${__EXECSTUBBING_ENV_DENY_LIST}"
+}
+
+# Entries are NUL terminated (like /proc/<pid>/environ) and sorted like golang sort.Strings
+envToBase64IfCaptured() {
+  if [[ -z "${__EXECSTUBBING_ENV_ALLOW_LIST}" ]]; then
+    return
+  fi
+  for name in $(compgen -e)
+  do
+    if isEnvNameCaptured "${name}"; then
+      printf "%s=%s\0" "${name}" "${!name}"
+    fi
+  done | LC_ALL=C sort -z | base64 | tr -d '\n'
+}
+
+# sets req to the stub request as base64 csv given the command arguments
+stubRequestAsBase64CSV() {
+  req="$(protocolVersionMarker)"
+  req="${req},$( strToBase64 ${__EXECSTUBBING_STUB_KEY} )"
   req="${req},$( strToBase64 ${__EXECSTUBBING_CMD_TO_STUB} )"
+  req="${req},${__EXECSTUBBING_STDIN_BASE64}"
+  req="${req},$( dirToBase64IfCaptured )"
+  req="${req},$( envToBase64IfCaptured )"
 
-  dst=""
   for var in "$@"
   do
     ##varx="${var//[$'\t\r\n']/}"
-    if [[ -n $dst ]]; then
-      req="${req},$(strToBase64 ${var})"
-    else
-      #first argument is the destination
-      dst="${var}"
-    fi
+    req="${req},$(strToBase64 ${var})"
   done
-  timeout 3s echo "$req" > ${dst}
+}
+
+writeStubRequest() {
+  dst="$1"
+  # not using echo "$req" because captured stdin may exceed the max argument length
+  timeout 3s cat <<< "$req" > ${dst}
   timeoutRet=$?
   #echo "StubbingReqp=$req ---> ${__EXECSTUBBING_PIPE_STUBBER}(timeoutRet=$timeoutRet)"
   if [[ "0" != "$timeoutRet" ]]; then
-    echo 1>&2 "failed to send request: timeout_or_err:$timeoutRet dst=$dst req=$req params=$@"
+    echo 1>&2 "failed to send request: timeout_or_err:$timeoutRet dst=$dst req=$req"
     exit 255
   fi
 }
 
+echoStubRequestAsBase64CSV() {
+  #first argument is the destination
+  dst="$1"
+  shift
+  stubRequestAsBase64CSV "$@"
+  writeStubRequest "${dst}"
+}
+
+# Appends req to the invocation timeline and prints its sequence number.
+# The next sequence number is claimed by creating its entry file exclusively
+# (noclobber opens with O_EXCL), so concurrent invocations cannot claim the same number.
+appendStubRequestToTimeline() {
+  timelineDir="${__EXECSTUBBING_TIMELINE_DIR}"
+  # the high-water mark (hwm_) keeps the numbering going up after the entries were deleted
+  lastSeq="$( cd "${timelineDir}" && ls -1 | grep -E '^(inv|hwm)_' | sed -E 's/^(inv|hwm)_//' | sort | tail -n1 )"
+  lastSeq=$(( 10#${lastSeq:-0} ))
+  for (( attempt=1; attempt<=1000; attempt++ ))
+  do
+    seq=$(( lastSeq + attempt ))
+    entry="$( printf "%s/inv_%0.20d" "${timelineDir}" "${seq}" )"
+    if ( set -o noclobber; cat <<< "$req" > "${entry}" ) 2>/dev/null; then
+      printf "%d" "${seq}"
+      return 0
+    fi
+  done
+  echo 1>&2 "failed to claim a timeline sequence number in ${timelineDir}"
+  exit 255
+}
+
 nextStubRequestFilePath() {
   dataDir="$__EXECSTUBBING_DATA_DIR"
   printf "%s/ser_stubrequest_%s_%0.6d" "$dataDir" "$(date +'%Y%m%d-%H%M%S-%N')" $RANDOM
 }
 
+sequencedStubRequestFilePath() {
+  printf "%s/ser_stubrequest_seq_%0.20d" "$__EXECSTUBBING_DATA_DIR" "$1"
+}
+
+nextExecOutcomeFilePath() {
+  dataDir="$__EXECSTUBBING_DATA_DIR"
+  printf "%s/ser_execoutcome_%s_%0.6d" "$dataDir" "$(date +'%Y%m%d-%H%M%S-%N')" $RANDOM
+}
+
+fileToBase64() {
+  base64 < "$1" | tr -d '\n'
+}
+
+pathWithout() {
+  removedDir="$1"
+  newPath=""
+  IFS=":" read -ra pathParts <<< "${__EXECSTUBBING_CALLER_PATH}"
+  for part in "${pathParts[@]}"
+  do
+    if [[ "${part}" != "${removedDir}" ]]; then
+      newPath="${newPath:+${newPath}:}${part}"
+    fi
+  done
+  printf "%s" "${newPath}"
+}
+
+# Runs the real executable without the stubbing variables, without the stub
+# directory in PATH and with the home variable it was discovered by reset,
+# so that it does not end up calling the stub when executing itself.
+runRealExec() {
+  realExec="${__EXECSTUBBING_REAL_EXEC}"
+  realExecPath="$(pathWithout "$(dirname "${__CMD_ABS_PATH}")")"
+  captureStdin="${__EXECSTUBBING_CAPTURE_STDIN}"
+  stdinBase64="${__EXECSTUBBING_STDIN_BASE64}"
+  realHomeEnvKey="${__EXECSTUBBING_REAL_HOME_ENV_KEY}"
+  realHome="${__EXECSTUBBING_REAL_HOME}"
+  unset ${!__EXECSTUBBING_@}
+  if [[ -n "${realHomeEnvKey}" ]]; then
+    if [[ -n "${realHome}" ]]; then
+      export "${realHomeEnvKey}=${realHome}"
+    else
+      unset "${realHomeEnvKey}"
+    fi
+  fi
+  if [[ "true" == "${captureStdin}" ]]; then
+    # stdin has already been consumed while capturing it
+    base64ToStream "${stdinBase64}" | PATH="${realExecPath}" "${realExec}" "$@"
+  else
+    PATH="${realExecPath}" "${realExec}" "$@"
+  fi
+}
+
+# Delegates to the real executable, forwarding its output as it arrives.
+# Its outcome is persisted into the data dir; a termination by signal is
+# recorded as exit code 128+signal, the way bash reports it.
+effectuatePassthroughAndExit() {
+  if [[ -z "${__EXECSTUBBING_REAL_EXEC}" ]]; then
+    printf "%s" "cannot delegate to the real executable of ${__EXECSTUBBING_CMD_TO_STUB} because it was not found before stubbing" >&2
+    exit 255
+  fi
+  outFile="$(mktemp)"
+  errFile="$(mktemp)"
+  (
+    set -o pipefail
+    {
+      # stdout and stderr are swapped, so that stderr can be teed through the pipe
+      ( runRealExec "$@" ) 2>&1 1>&3 3>&- | tee "${errFile}" 1>&2
+    } 3>&1 | tee "${outFile}"
+  )
+  realExitCode=$?
+  outcome="$(protocolVersionMarker),$(strToBase64 "${realExitCode}"),,$(strToBase64 "${__EXECSTUBBING_STUB_KEY}")"
+  outcome="${outcome},$(fileToBase64 "${errFile}"),$(fileToBase64 "${outFile}")"
+  outcome="${outcome},$(strToBase64 0),$(strToBase64 false),$(strToBase64 0),$(strToBase64 false)"
+  rm -f "${outFile}" "${errFile}"
+  cat <<< "${outcome}" > "$(nextExecOutcomeFilePath)"
+  exit ${realExitCode}
+}
+
+isBase64() {
+  printf "%s" "$1" | base64 -d &>/dev/null
+}
+
+# Decoding directly into the target stream, because command substitution $(...)
+# would drop NUL bytes and trailing new lines.
+base64ToStream() {
+  if [[ -n "$1" ]]; then
+    printf "%s" "$1" | base64 -d
+  fi
+}
+
+nanosToSeconds() {
+  printf "%d.%09d" $(($1/1000000000)) $(($1%1000000000))
+}
+
+# sleep does not inherit the std streams of the stub, so that it does not keep
+# the output pipes of the stubbed command open when the stub gets killed.
+# Looping on a short sleep when hanging ensures that an orphaned sleep does not
+# linger either.
+delayExit() {
+  delayNanos="$1"
+  hang="$2"
+  if [[ "true" == "${hang}" ]]; then
+    while true
+    do
+      sleep 0.1 </dev/null &>/dev/null
+    done
+  elif [[ "${delayNanos}" =~ ^[0-9]+$ ]] && (( delayNanos > 0 )); then
+    sleep "$(nanosToSeconds ${delayNanos})" </dev/null &>/dev/null
+  fi
+}
+
+# bash does not handle signals like SIGSEGV or SIGABRT, so the stub is terminated
+# by them as a crashing command would.
+terminateBySignalIfRequired() {
+  signal="${1// /}"
+  if [[ -z "${signal}" || "0" == "${signal}" ]]; then
+    return
+  fi
+  if ! [[ "${signal}" =~ ^[0-9]+$ ]]; then
+    printf "%s" "bad termination signal: '${signal}'" >&2
+    exit 255
+  fi
+  kill -${signal} $$
+}
+
+# Writes the output script given as triples of base64 encoded:
+# stream file descriptor (1 or 2), delay in nanoseconds, data.
+# The data of the last chunk may be missing as trailing empty csv record are dropped.
+replayOutputScript() {
+  chunks=("$@")
+  for (( i=0; i<${#chunks[@]}; i+=3 ))
+  do
+    fd=$( printf "%s" "${chunks[i]}" | base64 -d )
+    delayNanos=$( printf "%s" "${chunks[i+1]}" | base64 -d )
+    if [[ "1" != "${fd}" && "2" != "${fd}" ]] || ! [[ "${delayNanos}" =~ ^[0-9]+$ ]]; then
+      printf "%s" "bad output script chunk[$((i/3))]: stream='${chunks[i]}' delay='${chunks[i+1]}'" >&2
+      exit 255
+    fi
+    if (( delayNanos > 0 )); then
+      sleep "$(nanosToSeconds ${delayNanos})"
+    fi
+    base64ToStream "${chunks[i+2]}" >&${fd}
+  done
+}
+
+# The invocation id correlates the request with its outcome when the stub is executed concurrently:
+# - the request is written into a file dedicated to the invocation
+# - only the id is sent through the stubber pipe; the line is smaller than PIPE_BUF so it is written atomically
+# - the outcome is read from a named pipe dedicated to the invocation
+newInvocationId() {
+  printf "%s_%x%x" "$$" "$RANDOM" "$RANDOM"
+}
+
+invocationResponsePipePath() {
+  printf "%s_%s" "${__EXECSTUBBING_PIPE_TEST_HELPER_PROC}" "$1"
+}
+
+removeInvocationFiles() {
+  respPipe="$(invocationResponsePipePath "$1")"
+  rm -f "${respPipe}" "${respPipe}_req"
+}
+
+sendInvocationRequest() {
+  invocationId="$1"
+  shift
+  respPipe="$(invocationResponsePipePath "${invocationId}")"
+  if ! mkfifo "${respPipe}"; then
+    echo 1>&2 "failed to create invocation pipe: ${respPipe}"
+    exit 255
+  fi
+  echoStubRequestAsBase64CSV "${respPipe}_req" "$@"
+  timeout 3s cat <<< "$(strToBase64 ${invocationId})" > "${__EXECSTUBBING_PIPE_STUBBER}"
+  timeoutRet=$?
+  if [[ "0" != "$timeoutRet" ]]; then
+    echo 1>&2 "failed to send invocation id: timeout_or_err:$timeoutRet invocationId=${invocationId}"
+    removeInvocationFiles "${invocationId}"
+    exit 255
+  fi
+}
+
+# Prints the seconds to wait for the outcome of an invocation, which is the configured timeout
+# as used by the exe stub executable and the stubber; the default timeout (10s) if misconfigured.
+outcomeTimeoutSeconds() {
+  timeoutNanos="${__EXECSTUBBING_TIMEOUT_NANOS// /}"
+  if [[ ! "${timeoutNanos}" =~ ^[0-9]+$ ]] || (( 10#${timeoutNanos} == 0 )); then
+    timeoutNanos=10000000000
+  fi
+  nanosToSeconds $(( 10#${timeoutNanos} ))
+}
+
 getThenDoDynamicExecOutcome() {
-  #echo "catting ${__EXECSTUBBING_PIPE_TEST_HELPER_PROC}"
-  oBase64CSV=$( timeout 2s cat ${__EXECSTUBBING_PIPE_TEST_HELPER_PROC} 2>&1 || echo timeout or err:${?} )
+  invocationId="$1"
+  shift
+  # The outcome is a single csv record; reading it line-wise instead of until EOF.
+  # Opening read-write does not block even if the stubber is gone.
+  exec 3<>"$(invocationResponsePipePath "${invocationId}")"
+  if ! IFS= read -r -t "$(outcomeTimeoutSeconds)" -u 3 oBase64CSV; then
+    oBase64CSV="${oBase64CSV} timeout or err:${?}"
+  fi
+  exec 3<&-
+  removeInvocationFiles "${invocationId}"
   OLD_IFS=${IFS}
   IFS=","
   #printf "${oBase64CSV},sss" | read -ra oData
   read -ra oData <<< "${oBase64CSV}"
   IFS="${OLD_IFS}"
+  if [[ "${oData[0]}" != "$(protocolVersionMarker)" ]]; then
+    if [[ "${oData[0]}" == execstub-v* ]]; then
+      exitIncompatibleVersion "exec outcome" "${oData[0]#execstub-v}"
+    elif (( ${#oData[@]} >= 9 )); then
+      exitIncompatibleVersion "exec outcome"
+    fi
+  fi
+  oData=("${oData[@]:1}")
   oLen="${#oData[@]}"
-  # last item may be empty --> length 4
-  if (( ${oLen}!=5 &&  ${oLen}!=4 )); then
-    printf "%s" "expects 5 records in cvs but got ${oLen}, cvs=${oBase64CSV}" >&2
+  if (( ${oLen} < 9 )); then
+    printf "%s" "expects at least 9 records in cvs but got ${oLen}, cvs=${oBase64CSV}" >&2
     exit 255
   fi
   decodeExits=""
@@ -80,29 +385,40 @@ getThenDoDynamicExecOutcome() {
   [[ "0" != "$?" ]] && decodeExits="${decodeExits} InternalErrTxt NotBase64='${oData[1]}'"
   key=$( echo "${oData[2]}" | base64 -d)
   [[ "0" != "$?" ]] && decodeExits="${decodeExits} Key NotBase64='${oData[2]}'"
-  stderr=$( echo "${oData[3]}" | base64 -d)
+  isBase64 "${oData[3]}"
   [[ "0" != "$?" ]] && decodeExits="${decodeExits} Stderr NotBase64='${oData[3]}'"
-  stdout=$( echo "${oData[4]}" | base64 -d)
+  isBase64 "${oData[4]}"
   [[ "0" != "$?" ]] && decodeExits="${decodeExits} Stdout NotBase64='${oData[4]}'"
+  exitDelayNanos=$( echo "${oData[5]}" | base64 -d)
+  [[ "0" != "$?" ]] && decodeExits="${decodeExits} ExitDelay NotBase64='${oData[5]}'"
+  hangUntilSignalled=$( echo "${oData[6]}" | base64 -d)
+  [[ "0" != "$?" ]] && decodeExits="${decodeExits} HangUntilSignalled NotBase64='${oData[6]}'"
+  terminationSignal=$( echo "${oData[7]}" | base64 -d)
+  [[ "0" != "$?" ]] && decodeExits="${decodeExits} TerminationSignal NotBase64='${oData[7]}'"
+  passthrough=$( echo "${oData[8]}" | base64 -d)
+  [[ "0" != "$?" ]] && decodeExits="${decodeExits} Passthrough NotBase64='${oData[8]}'"
 
   if [[ -n "${decodeExits}" ]]; then
     printf "%s" "bad base64 encoding ${decodeExits} csv=${oBase64CSV}" >&2
     exit 255
   fi
 
-  if [[ -n "${stderr}" ]]; then
-    printf "%s" "${stderr}" 1>&2
+  if [[ "true" == "${passthrough}" ]]; then
+    effectuatePassthroughAndExit "$@"
   fi
 
-  if [[ -n "${stdout}" ]]; then
-    printf "%s" "${stdout}"
-  fi
+  base64ToStream "${oData[3]}" 1>&2
+  base64ToStream "${oData[4]}"
+  replayOutputScript "${oData[@]:9}"
 
   if [[ -n "${internalErr}" ]]; then
     printf "%s" "${internalErr}" 1>&2
     exit 255
   fi
 
+  delayExit "${exitDelayNanos}" "${hangUntilSignalled}"
+  terminateBySignalIfRequired "${terminationSignal}"
+
   if isUint8 "${exitCode}" &>/dev/null; then
     exit ${exitCode};
   else
@@ -117,6 +433,14 @@ echoTimeoutAndExit() {
 }
 
 
+# In strict mode PATH only leads to stubs, so the tools used by this script are looked up
+# in the PATH in place before strict mode was enabled.
+# The processes started by this script get the PATH of the caller.
+__EXECSTUBBING_CALLER_PATH="${PATH}"
+if [[ -n "${__EXECSTUBBING_TOOLS_PATH}" ]]; then
+    PATH="${__EXECSTUBBING_TOOLS_PATH}"
+fi
+
 __CMD_ABS_PATH=$(dirname "$0")
 __CMD_ABS_PATH=$( cd "${__CMD_ABS_PATH}" && pwd )
 __CMD_ABS_PATH="${__CMD_ABS_PATH}/$(basename $0)"
@@ -125,9 +449,15 @@ __CMD_CONFIG_PATH="${__CMD_ABS_PATH}.config"
 
 source ${__CMD_CONFIG_PATH}
 
+if [[ "${__EXECSTUBBING_PROTOCOL_VERSION}" != "${stubProtocolVersion}" ]]; then
+    exitIncompatibleVersion "config file ${__CMD_CONFIG_PATH}" "${__EXECSTUBBING_PROTOCOL_VERSION}"
+fi
+
 export __EXECSTUBBING_STUB_KEY
 export __EXECSTUBBING_CMD_TO_STUB
 export __EXECSTUBBING_DATA_DIR
+export __EXECSTUBBING_TIMELINE_DIR
+export __EXECSTUBBING_CAPTURE_STDIN
 # The following is more elaborate to ease debugging while developping
 # - Glob and take the most recent even their should be exactly one named pipe
 # - Keep errors in the variable (|&)
@@ -147,26 +477,48 @@ if [[ -n "${testMethodName}" ]]; then
     export __EXECSTUBBING_STUB_CMD_CONFIG="${__CMD_CONFIG_PATH}"
     # e.g. /tmp/go-build720053430/b001/execstubbing.test -test.run=TestHelperProcess -- "$@"
     # -test.run takes a regex therefore matching the exact test helper process method
-    ${__EXECSTUBBING_UNIT_TEST_EXEC} -test.run="^${__EXECSTUBBING_TEST_HELPER_PROCESS_METHOD}\$" -- "$@"
+    # exec so that signals sent to the stub reach the test helper process
+    PATH="${__EXECSTUBBING_CALLER_PATH}"
+    exec ${__EXECSTUBBING_UNIT_TEST_EXEC} -test.run="^${__EXECSTUBBING_TEST_HELPER_PROCESS_METHOD}\$" -- "$@"
 
 else
-    staticConfig="${__EXECSTUBBING_STD_OUT}${__EXECSTUBBING_STD_ERR}${__EXECSTUBBING_EXIT_CODE}"
+    staticConfig="${__EXECSTUBBING_STD_OUT}${__EXECSTUBBING_STD_ERR}${__EXECSTUBBING_OUTPUT_SCRIPT}${__EXECSTUBBING_EXIT_DELAY_NANOS}${__EXECSTUBBING_HANG_UNTIL_SIGNALLED}${__EXECSTUBBING_TERMINATION_SIGNAL}${__EXECSTUBBING_PASSTHROUGH}${__EXECSTUBBING_EXIT_CODE}"
     staticConfig="${staticConfig// /}"
 
-    if [[ -n "${staticConfig}" ]]; then
-        export nextStubRequestFilePath
-        reqDstFilePath="$(nextStubRequestFilePath)"
-        echoStubRequestAsBase64CSV "$reqDstFilePath" "$@"
+    if [[ -n "${__EXECSTUBBING_TRANSPORT_CLIENT}" ]]; then
+        # bash can connect neither to a unix domain socket nor to an http server
+        # and cannot render an outcome template (static mode),
+        # so the invocation is delegated to the transport client,
+        # which captures stdin, dir and env by itself
+        PATH="${__EXECSTUBBING_CALLER_PATH}"
+        exec "${__EXECSTUBBING_TRANSPORT_CLIENT}" "${__CMD_CONFIG_PATH}" "$@"
+    fi
+
+    __EXECSTUBBING_STDIN_BASE64="$(stdinToBase64IfCaptured)"
 
-        if [[ -n "${__EXECSTUBBING_STD_OUT}" ]]; then
-          printf "%s" "${__EXECSTUBBING_STD_OUT}" | base64 -d
+    if [[ -n "${staticConfig}" ]]; then
+        stubRequestAsBase64CSV "$@"
+        if [[ -n "${__EXECSTUBBING_TIMELINE_DIR}" ]]; then
+            seq="$(appendStubRequestToTimeline)" || exit 255
+            writeStubRequest "$(sequencedStubRequestFilePath "${seq}")"
+        else
+            writeStubRequest "$(nextStubRequestFilePath)"
         fi
-        if [[ -n "${__EXECSTUBBING_STD_ERR}" ]]; then
-          printf "%s" "${__EXECSTUBBING_STD_ERR}" | base64 -d | cat  1>&2
+
+        if [[ "true" == "${__EXECSTUBBING_PASSTHROUGH}" ]]; then
+            effectuatePassthroughAndExit "$@"
         fi
+
+        base64ToStream "${__EXECSTUBBING_STD_OUT}"
+        base64ToStream "${__EXECSTUBBING_STD_ERR}" 1>&2
+        IFS="," read -ra outputScript <<< "${__EXECSTUBBING_OUTPUT_SCRIPT}"
+        replayOutputScript "${outputScript[@]}"
         exitCode="${__EXECSTUBBING_EXIT_CODE}"
         exitCode="${exitCode// /}"
 
+        delayExit "${__EXECSTUBBING_EXIT_DELAY_NANOS}" "${__EXECSTUBBING_HANG_UNTIL_SIGNALLED}"
+        terminateBySignalIfRequired "${__EXECSTUBBING_TERMINATION_SIGNAL}"
+
         if isUint8 "${exitCode}" &>/dev/null; then
             exit ${exitCode};
         else
@@ -174,8 +526,9 @@ else
             exit 255
         fi;
     else
-        echoStubRequestAsBase64CSV "${__EXECSTUBBING_PIPE_STUBBER}" "$@"
-        getThenDoDynamicExecOutcome
+        invocationId="$(newInvocationId)"
+        sendInvocationRequest "${invocationId}" "$@"
+        getThenDoDynamicExecOutcome "${invocationId}" "$@"
     fi
 
 fi
\ No newline at end of file
@@ -1,5 +1,5 @@
 // Code generated by "go_generate_stub_exec_assets static ../.."; DO NOT EDIT
-// generated at 2021-05-16T15:47:41.014038618+02:00
+// generated at 2026-10-16T20:03:42.080039613Z
 
 // Copyright 2020 The Execstub Authors
 //
@@ -22,12 +22,12 @@ import "encoding/base64"
 // ExecStubExeHash returns the hash of the bash script that can be use to stub an executable.
 // This is here mainly to aid detect changes without having to check be binary in base64.
 func ExecStubBashScriptHash() string {
-	hash := "7d362224bc4b49c98155431132601126"
+	hash := "563b4c0f5c4a7f8388e0bc613e238eae"
 	return hash
 }
 
 // ExecStubBashScript return a bash script that can be used to stub an executable.
 func ExecStubBashScript() (script []byte, err error) {
-	execStubBase64 := "IyEvdXNyL2Jpbi9lbnYgYmFzaAoKIyBDb3B5cmlnaHQgMjAyMCBUaGUgRXhlY3N0dWIgQXV0aG9ycwojCiMgTGljZW5zZWQgdW5kZXIgdGhlIEFwYWNoZSBMaWNlbnNlLCBWZXJzaW9uIDIuMCAodGhlICJMaWNlbnNlIik7CiMgeW91IG1heSBub3QgdXNlIHRoaXMgZmlsZSBleGNlcHQgaW4gY29tcGxpYW5jZSB3aXRoIHRoZSBMaWNlbnNlLgojIFlvdSBtYXkgb2J0YWluIGEgY29weSBvZiB0aGUgTGljZW5zZSBhdAojCiMgICAgaHR0cDovL3d3dy5hcGFjaGUub3JnL2xpY2Vuc2VzL0xJQ0VOU0UtMi4wCiMKIyBVbmxlc3MgcmVxdWlyZWQgYnkgYXBwbGljYWJsZSBsYXcgb3IgYWdyZWVkIHRvIGluIHdyaXRpbmcsIHNvZnR3YXJlCiMgZGlzdHJpYnV0ZWQgdW5kZXIgdGhlIExpY2Vuc2UgaXMgZGlzdHJpYnV0ZWQgb24gYW4gIkFTIElTIiBCQVNJUywKIyBXSVRIT1VUIFdBUlJBTlRJRVMgT1IgQ09ORElUSU9OUyBPRiBBTlkgS0lORCwgZWl0aGVyIGV4cHJlc3Mgb3IgaW1wbGllZC4KIyBTZWUgdGhlIExpY2Vuc2UgZm9yIHRoZSBzcGVjaWZpYyBsYW5ndWFnZSBnb3Zlcm5pbmcgcGVybWlzc2lvbnMgYW5kCiMgbGltaXRhdGlvbnMgdW5kZXIgdGhlIExpY2Vuc2UuCgppc1VpbnQ4KCkgewogICAgdmFsPSQxOyB2YWw9IiR7dmFsLy8gL30iOyB2YWw9JHt2YWw6LXh4eG5hbi0tLX07CiAgICBpZiAoKCB2YWwgPj0gMCAmJiAgdmFsIDw9IDI1NSApKTsgdGhlbgogICAgICAgIHRydWUKICAgIGVsc2UKICAgICAgICBmYWxzZQogICAgZmkKfQoKc3RyVG9CYXNlNjQoKSB7CiAgaWYgW1sgLW4gIiQxIiBdXTsgdGhlbgogICAgcHJpbnRmICIlcyIgIiQxIiB8IGJhc2U2NAogIGZpCiAgI3ByaW50ZiAiIgp9CgplY2hvU3R1YlJlcXVlc3RBc0Jhc2U2NENTVigpIHsKICByZXE9IiQoIHN0clRvQmFzZTY0ICR7X19FWEVDU1RVQkJJTkdfU1RVQl9LRVl9ICkiCiAgcmVxPSIke3JlcX0sJCggc3RyVG9CYXNlNjQgJHtfX0VYRUNTVFVCQklOR19DTURfVE9fU1RVQn0gKSIKCiAgZHN0PSIiCiAgZm9yIHZhciBpbiAiJEAiCiAgZG8KICAgICMjdmFyeD0iJHt2YXIvL1skJ1x0XHJcbiddL30iCiAgICBpZiBbWyAtbiAkZHN0IF1dOyB0aGVuCiAgICAgIHJlcT0iJHtyZXF9LCQoc3RyVG9CYXNlNjQgJHt2YXJ9KSIKICAgIGVsc2UKICAgICAgI2ZpcnN0IGFyZ3VtZW50IGlzIHRoZSBkZXN0aW5hdGlvbgogICAgICBkc3Q9IiR7dmFyfSIKICAgIGZpCiAgZG9uZQogIHRpbWVvdXQgM3MgZWNobyAiJHJlcSIgPiAke2RzdH0KICB0aW1lb3V0UmV0PSQ/CiAgI2VjaG8gIlN0dWJiaW5nUmVxcD0kcmVxIC0tLT4gJHtfX0VYRUNTVFVCQklOR19QSVBFX1NUVUJCRVJ9KHRpbWVvdXRSZXQ9JHRpbWVvdXRSZXQpIgogIGlmIFtbICIwIiAhPSAiJHRpbWVvdXRSZXQiIF1dOyB0aGVuCiAgICBlY2hvIDE+JjIgImZhaWxlZCB0byBzZW5kIHJlcXVlc3Q6IHRpbWVvdXRfb3JfZXJyOiR0aW1lb3V0UmV0IGRzdD0kZHN0IHJlcT0kcmVxIHBhcmFtcz0kQCIKICAgIGV4aXQgMjU1CiAgZmkKfQoKbmV4dFN0dWJSZXF1ZXN0RmlsZVBhdGgoKSB7CiAgZGF0YURpcj0iJF9fRVhFQ1NUVUJCSU5HX0RBVEFfRElSIgogIHByaW50ZiAiJXMvc2VyX3N0dWJyZXF1ZXN0XyVzXyUwLjZkIiAiJGRhdGFEaXIiICIkKGRhdGUgKyclWSVtJWQtJUglTSVTLSVOJykiICRSQU5ET00KfQoKZ2V0VGhlbkRvRHluYW1pY0V4ZWNPdXRjb21lKCkgewogICNlY2hvICJjYXR0aW5nICR7X19FWEVDU1RVQkJJTkdfUElQRV9URVNUX0hFTFBFUl9QUk9DfSIKICBvQmFzZTY0Q1NWPSQoIHRpbWVvdXQgMnMgY2F0ICR7X19FWEVDU1RVQkJJTkdfUElQRV9URVNUX0hFTFBFUl9QUk9DfSAyPiYxIHx8IGVjaG8gdGltZW91dCBvciBlcnI6JHs/fSApCiAgT0xEX0lGUz0ke0lGU30KICBJRlM9IiwiCiAgI3ByaW50ZiAiJHtvQmFzZTY0Q1NWfSxzc3MiIHwgcmVhZCAtcmEgb0RhdGEKICByZWFkIC1yYSBvRGF0YSA8PDwgIiR7b0Jhc2U2NENTVn0iCiAgSUZTPSIke09MRF9JRlN9IgogIG9MZW49IiR7I29EYXRhW0BdfSIKICAjIGxhc3QgaXRlbSBtYXkgYmUgZW1wdHkgLS0+IGxlbmd0aCA0CiAgaWYgKCggJHtvTGVufSE9NSAmJiAgJHtvTGVufSE9NCApKTsgdGhlbgogICAgcHJpbnRmICIlcyIgImV4cGVjdHMgNSByZWNvcmRzIGluIGN2cyBidXQgZ290ICR7b0xlbn0sIGN2cz0ke29CYXNlNjRDU1Z9IiA+JjIKICAgIGV4aXQgMjU1CiAgZmkKICBkZWNvZGVFeGl0cz0iIgogIGV4aXRDb2RlPSQoIGVjaG8gIiR7b0RhdGFbMF19IiB8IGJhc2U2NCAtZCkKICBbWyAiMCIgIT0gIiQ/IiBdXSAmJiBkZWNvZGVFeGl0cz0iJHtkZWNvZGVFeGl0c30gRXhpdENvZGUgTm90QmFzZTY0PScke29EYXRhWzBdfSciCiAgaW50ZXJuYWxFcnI9JCggZWNobyAiJHtvRGF0YVsxXX0iIHwgYmFzZTY0IC1kKQogIFtbICIwIiAhPSAiJD8iIF1dICYmIGRlY29kZUV4aXRzPSIke2RlY29kZUV4aXRzfSBJbnRlcm5hbEVyclR4dCBOb3RCYXNlNjQ9JyR7b0RhdGFbMV19JyIKICBrZXk9JCggZWNobyAiJHtvRGF0YVsyXX0iIHwgYmFzZTY0IC1kKQogIFtbICIwIiAhPSAiJD8iIF1dICYmIGRlY29kZUV4aXRzPSIke2RlY29kZUV4aXRzfSBLZXkgTm90QmFzZTY0PScke29EYXRhWzJdfSciCiAgc3RkZXJyPSQoIGVjaG8gIiR7b0RhdGFbM119IiB8IGJhc2U2NCAtZCkKICBbWyAiMCIgIT0gIiQ/IiBdXSAmJiBkZWNvZGVFeGl0cz0iJHtkZWNvZGVFeGl0c30gU3RkZXJyIE5vdEJhc2U2ND0nJHtvRGF0YVszXX0nIgogIHN0ZG91dD0kKCBlY2hvICIke29EYXRhWzRdfSIgfCBiYXNlNjQgLWQpCiAgW1sgIjAiICE9ICIkPyIgXV0gJiYgZGVjb2RlRXhpdHM9IiR7ZGVjb2RlRXhpdHN9IFN0ZG91dCBOb3RCYXNlNjQ9JyR7b0RhdGFbNF19JyIKCiAgaWYgW1sgLW4gIiR7ZGVjb2RlRXhpdHN9IiBdXTsgdGhlbgogICAgcHJpbnRmICIlcyIgImJhZCBiYXNlNjQgZW5jb2RpbmcgJHtkZWNvZGVFeGl0c30gY3N2PSR7b0Jhc2U2NENTVn0iID4mMgogICAgZXhpdCAyNTUKICBmaQoKICBpZiBbWyAtbiAiJHtzdGRlcnJ9IiBdXTsgdGhlbgogICAgcHJpbnRmICIlcyIgIiR7c3RkZXJyfSIgMT4mMgogIGZpCgogIGlmIFtbIC1uICIke3N0ZG91dH0iIF1dOyB0aGVuCiAgICBwcmludGYgIiVzIiAiJHtzdGRvdXR9IgogIGZpCgogIGlmIFtbIC1uICIke2ludGVybmFsRXJyfSIgXV07IHRoZW4KICAgIHByaW50ZiAiJXMiICIke2ludGVybmFsRXJyfSIgMT4mMgogICAgZXhpdCAyNTUKICBmaQoKICBpZiBpc1VpbnQ4ICIke2V4aXRDb2RlfSIgJj4vZGV2L251bGw7IHRoZW4KICAgIGV4aXQgJHtleGl0Q29kZX07CiAgZWxzZQogICAgcHJpbnRmICIlcyIgIkludmFsaWQgYmFzZTY0IGZvdW5kOiAnJHtleGl0Q29kZX0nIGNzdj0ke29CYXNlNjRDU1Z9IiAxPiYyCiAgICBleGl0IDI1NQogIGZpOwp9CgplY2hvVGltZW91dEFuZEV4aXQoKSB7CiAgICBlY2hvICJUaW1lb3V0IiAxPiYyCiAgICBraWxsIC05ICQxCn0KCgpfX0NNRF9BQlNfUEFUSD0kKGRpcm5hbWUgIiQwIikKX19DTURfQUJTX1BBVEg9JCggY2QgIiR7X19DTURfQUJTX1BBVEh9IiAmJiBwd2QgKQpfX0NNRF9BQlNfUEFUSD0iJHtfX0NNRF9BQlNfUEFUSH0vJChiYXNlbmFtZSAkMCkiCgpfX0NNRF9DT05GSUdfUEFUSD0iJHtfX0NNRF9BQlNfUEFUSH0uY29uZmlnIgoKc291cmNlICR7X19DTURfQ09ORklHX1BBVEh9CgpleHBvcnQgX19FWEVDU1RVQkJJTkdfU1RVQl9LRVkKZXhwb3J0IF9fRVhFQ1NUVUJCSU5HX0NNRF9UT19TVFVCCmV4cG9ydCBfX0VYRUNTVFVCQklOR19EQVRBX0RJUgojIFRoZSBmb2xsb3dpbmcgaXMgbW9yZSBlbGFib3JhdGUgdG8gZWFzZSBkZWJ1Z2dpbmcgd2hpbGUgZGV2ZWxvcHBpbmcKIyAtIEdsb2IgYW5kIHRha2UgdGhlIG1vc3QgcmVjZW50IGV2ZW4gdGhlaXIgc2hvdWxkIGJlIGV4YWN0bHkgb25lIG5hbWVkIHBpcGUKIyAtIEtlZXAgZXJyb3JzIGluIHRoZSB2YXJpYWJsZSAofCYpCiMgZXhwb3J0IF9fRVhFQ1NUVUJCSU5HX1BJUEVfU1RVQkJFUj0iJChscyAgLXQgJHtfX0NNRF9BQlNfUEFUSH1fc3R1YmJlcl9waXBlXyogfCYgaGVhZCAtbjEpIgojIGV4cG9ydCBfX0VYRUNTVFVCQklOR19QSVBFX1RFU1RfSEVMUEVSX1BST0M9IiQobHMgIC10ICR7X19DTURfQUJTX1BBVEh9X3Rlc3Rwcm9jZXNzaGVscGVyX3BpcGVfKiB8JiBoZWFkIC1uMSkiCmV4cG9ydCBfX0VYRUNTVFVCQklOR19QSVBFX1NUVUJCRVIKZXhwb3J0IF9fRVhFQ1NUVUJCSU5HX1BJUEVfVEVTVF9IRUxQRVJfUFJPQwoKdGVzdE1ldGhvZE5hbWU9IiR7X19FWEVDU1RVQkJJTkdfVEVTVF9IRUxQRVJfUFJPQ0VTU19NRVRIT0R9Igp0ZXN0TWV0aG9kTmFtZT0iJHt0ZXN0TWV0aG9kTmFtZS8vIC99IgoKCgppZiBbWyAtbiAiJHt0ZXN0TWV0aG9kTmFtZX0iIF1dOyB0aGVuCgogICAgZXhwb3J0IF9fRVhFQ1NUVUJCSU5HX0dPX1dBTlRfSEVMUEVSX1BST0NFU1M9MQogICAgZXhwb3J0IF9fRVhFQ1NUVUJCSU5HX1NUVUJfQ01EX0NPTkZJRz0iJHtfX0NNRF9DT05GSUdfUEFUSH0iCiAgICAjIGUuZy4gL3RtcC9nby1idWlsZDcyMDA1MzQzMC9iMDAxL2V4ZWNzdHViYmluZy50ZXN0IC10ZXN0LnJ1bj1UZXN0SGVscGVyUHJvY2VzcyAtLSAiJEAiCiAgICAjIC10ZXN0LnJ1biB0YWtlcyBhIHJlZ2V4IHRoZXJlZm9yZSBtYXRjaGluZyB0aGUgZXhhY3QgdGVzdCBoZWxwZXIgcHJvY2VzcyBtZXRob2QKICAgICR7X19FWEVDU1RVQkJJTkdfVU5JVF9URVNUX0VYRUN9IC10ZXN0LnJ1bj0iXiR7X19FWEVDU1RVQkJJTkdfVEVTVF9IRUxQRVJfUFJPQ0VTU19NRVRIT0R9XCQiIC0tICIkQCIKCmVsc2UKICAgIHN0YXRpY0NvbmZpZz0iJHtfX0VYRUNTVFVCQklOR19TVERfT1VUfSR7X19FWEVDU1RVQkJJTkdfU1REX0VSUn0ke19fRVhFQ1NUVUJCSU5HX0VYSVRfQ09ERX0iCiAgICBzdGF0aWNDb25maWc9IiR7c3RhdGljQ29uZmlnLy8gL30iCgogICAgaWYgW1sgLW4gIiR7c3RhdGljQ29uZmlnfSIgXV07IHRoZW4KICAgICAgICBleHBvcnQgbmV4dFN0dWJSZXF1ZXN0RmlsZVBhdGgKICAgICAgICByZXFEc3RGaWxlUGF0aD0iJChuZXh0U3R1YlJlcXVlc3RGaWxlUGF0aCkiCiAgICAgICAgZWNob1N0dWJSZXF1ZXN0QXNCYXNlNjRDU1YgIiRyZXFEc3RGaWxlUGF0aCIgIiRAIgoKICAgICAgICBpZiBbWyAtbiAiJHtfX0VYRUNTVFVCQklOR19TVERfT1VUfSIgXV07IHRoZW4KICAgICAgICAgIHByaW50ZiAiJXMiICIke19fRVhFQ1NUVUJCSU5HX1NURF9PVVR9IiB8IGJhc2U2NCAtZAogICAgICAgIGZpCiAgICAgICAgaWYgW1sgLW4gIiR7X19FWEVDU1RVQkJJTkdfU1REX0VSUn0iIF1dOyB0aGVuCiAgICAgICAgICBwcmludGYgIiVzIiAiJHtfX0VYRUNTVFVCQklOR19TVERfRVJSfSIgfCBiYXNlNjQgLWQgfCBjYXQgIDE+JjIKICAgICAgICBmaQogICAgICAgIGV4aXRDb2RlPSIke19fRVhFQ1NUVUJCSU5HX0VYSVRfQ09ERX0iCiAgICAgICAgZXhpdENvZGU9IiR7ZXhpdENvZGUvLyAvfSIKCiAgICAgICAgaWYgaXNVaW50OCAiJHtleGl0Q29kZX0iICY+L2Rldi9udWxsOyB0aGVuCiAgICAgICAgICAgIGV4aXQgJHtleGl0Q29kZX07CiAgICAgICAgZWxzZQogICAgICAgICAgICBwcmludGYgIiVzIiAiQmFkIGV4aXQgY29kZSAke19fRVhFQ1NUVUJCSU5HX0VYSVRfQ09ERX0gKHRyaW1tZWQ6JHtleGl0Q29kZX0pIiB8IGNhdCAxPiYyCiAgICAgICAgICAgIGV4aXQgMjU1CiAgICAgICAgZmk7CiAgICBlbHNlCiAgICAgICAgZWNob1N0dWJSZXF1ZXN0QXNCYXNlNjRDU1YgIiR7X19FWEVDU1RVQkJJTkdfUElQRV9TVFVCQkVSfSIgIiRAIgogICAgICAgIGdldFRoZW5Eb0R5bmFtaWNFeGVjT3V0Y29tZQogICAgZmkKCmZp"
+	execStubBase64 := "IyEvdXNyL2Jpbi9lbnYgYmFzaAoKIyBDb3B5cmlnaHQgMjAyMCBUaGUgRXhlY3N0dWIgQXV0aG9ycwojCiMgTGljZW5zZWQgdW5kZXIgdGhlIEFwYWNoZSBMaWNlbnNlLCBWZXJzaW9uIDIuMCAodGhlICJMaWNlbnNlIik7CiMgeW91IG1heSBub3QgdXNlIHRoaXMgZmlsZSBleGNlcHQgaW4gY29tcGxpYW5jZSB3aXRoIHRoZSBMaWNlbnNlLgojIFlvdSBtYXkgb2J0YWluIGEgY29weSBvZiB0aGUgTGljZW5zZSBhdAojCiMgICAgaHR0cDovL3d3dy5hcGFjaGUub3JnL2xpY2Vuc2VzL0xJQ0VOU0UtMi4wCiMKIyBVbmxlc3MgcmVxdWlyZWQgYnkgYXBwbGljYWJsZSBsYXcgb3IgYWdyZWVkIHRvIGluIHdyaXRpbmcsIHNvZnR3YXJlCiMgZGlzdHJpYnV0ZWQgdW5kZXIgdGhlIExpY2Vuc2UgaXMgZGlzdHJpYnV0ZWQgb24gYW4gIkFTIElTIiBCQVNJUywKIyBXSVRIT1VUIFdBUlJBTlRJRVMgT1IgQ09ORElUSU9OUyBPRiBBTlkgS0lORCwgZWl0aGVyIGV4cHJlc3Mgb3IgaW1wbGllZC4KIyBTZWUgdGhlIExpY2Vuc2UgZm9yIHRoZSBzcGVjaWZpYyBsYW5ndWFnZSBnb3Zlcm5pbmcgcGVybWlzc2lvbnMgYW5kCiMgbGltaXRhdGlvbnMgdW5kZXIgdGhlIExpY2Vuc2UuCgojIHZlcnNpb24gb2YgdGhlIHByb3RvY29sIHNwb2tlbiB3aXRoIHRoZSBzdHViYmVyLCBzZWUgY29tcHJvdG8uUHJvdG9jb2xWZXJzaW9uCnN0dWJQcm90b2NvbFZlcnNpb249MwoKIyB0aGUgbWFya2VyIGxlYWRpbmcgZXZlcnkgZW5jb2RlZCBzdHViIHJlcXVlc3QgYW5kIGV4ZWMgb3V0Y29tZQpwcm90b2NvbFZlcnNpb25NYXJrZXIoKSB7CiAgcHJpbnRmICJleGVjc3R1Yi12JXMiICIke3N0dWJQcm90b2NvbFZlcnNpb259Igp9CgojIFJlcG9ydHMgZGF0YSBlbmNvZGVkIHdpdGggYW5vdGhlciBwcm90b2NvbCB2ZXJzaW9uIHRoYW4gdGhlIG9uZSBvZiB0aGlzIHN0dWIgYW5kIGV4aXRzLgojIEVuY29kaW5ncyB3aXRob3V0IHZlcnNpb24gbWFya2VyIGFyZSBmcm9tIHZlcnNpb24gMS4KZXhpdEluY29tcGF0aWJsZVZlcnNpb24oKSB7CiAgc3ViamVjdD0iJDEiCiAgdmVyc2lvbj0iJHsyOi0xfSIKICBwcmludGYgIiVzIiAiaW5jb21wYXRpYmxlIGV4ZWNzdHViIHZlcnNpb246ICR7c3ViamVjdH0gdXNlcyBwcm90b2NvbCB2ZXJzaW9uICR7dmVyc2lvbn0gYnV0IHZlcnNpb24gJHtzdHViUHJvdG9jb2xWZXJzaW9ufSBpcyBleHBlY3RlZDsgc3R1YiBleGVjdXRhYmxlcyBhbmQgc3R1YmJlciBtdXN0IGNvbWUgZnJvbSB0aGUgc2FtZSBleGVjc3R1YiB2ZXJzaW9uIiA+JjIKICBleGl0IDI1NQp9Cgppc1VpbnQ4KCkgewogICAgdmFsPSQxOyB2YWw9IiR7dmFsLy8gL30iOyB2YWw9JHt2YWw6LXh4eG5hbi0tLX07CiAgICBpZiAoKCB2YWwgPj0gMCAmJiAgdmFsIDw9IDI1NSApKTsgdGhlbgogICAgICAgIHRydWUKICAgIGVsc2UKICAgICAgICBmYWxzZQogICAgZmkKfQoKIyBiYXNlNjQgd3JhcHMgbGluZXMsIHNvIHdlIHJlbW92ZSB0aGUgbmV3IGxpbmVzIHRvIGtlZXAgdGhlIHZhbHVlIG9uIG9uZSBjc3YgcmVjb3JkCnN0clRvQmFzZTY0KCkgewogIGlmIFtbIC1uICIkMSIgXV07IHRoZW4KICAgIHByaW50ZiAiJXMiICIkMSIgfCBiYXNlNjQgfCB0ciAtZCAnXG4nCiAgZmkKICAjcHJpbnRmICIiCn0KCiMgc3RkaW4gbWF5IGJlIGJpZ2dlciB0aGFuIGJhc2U2NCBsaW5lIHdyYXBwaW5nIGxpbWl0LCBzbyB3ZSByZW1vdmUgdGhlIG5ldyBsaW5lcwpzdGRpblRvQmFzZTY0SWZDYXB0dXJlZCgpIHsKICBpZiBbWyAidHJ1ZSIgPT0gIiR7X19FWEVDU1RVQkJJTkdfQ0FQVFVSRV9TVERJTn0iIF1dOyB0aGVuCiAgICBiYXNlNjQgfCB0ciAtZCAnXG4nCiAgZmkKfQoKZGlyVG9CYXNlNjRJZkNhcHR1cmVkKCkgewogIGlmIFtbICJ0cnVlIiA9PSAiJHtfX0VYRUNTVFVCQklOR19DQVBUVVJFX0RJUn0iIF1dOyB0aGVuCiAgICBzdHJUb0Jhc2U2NCAiJChwd2QpIgogIGZpCn0KCiMgcGF0dGVybiBtYXRjaGluZyBpcyBiYXNlZCBvbiBiYXNoIGdsb2IgbWF0Y2hpbmcsIHNvIGl0IGlzIGVxdWl2YWxlbnQgdG8gZ29sYW5nIHBhdGguTWF0Y2gKbWF0Y2hlc0FueUVudk5hbWVQYXR0ZXJuKCkgewogIG5hbWU9IiQxIgogIElGUz0iLCIgcmVhZCAtcmEgcGF0dGVybnMgPDw8ICIkMiIKICBmb3IgcGF0dGVybiBpbiAiJHtwYXR0ZXJuc1tAXX0iCiAgZG8KICAgICMgcGF0dGVybiBtdXN0IG5vdCBiZSBxdW90ZWQgdG8gYmUgdXNlZCBmb3IgbWF0Y2hpbmcKICAgIGlmIFtbIC1uICIke3BhdHRlcm59IiAmJiAiJHtuYW1lfSIgPT0gJHtwYXR0ZXJufSBdXTsgdGhlbgogICAgICByZXR1cm4gMAogICAgZmkKICBkb25lCiAgcmV0dXJuIDEKfQoKaXNFbnZOYW1lQ2FwdHVyZWQoKSB7CiAgbmFtZT0iJDEiCiAgaWYgW1sgIiR7bmFtZX0iID09IF9fRVhFQ1NUVUJCSU5HXyogXV07IHRoZW4KICAgIHJldHVybiAxCiAgZmkKICBtYXRjaGVzQW55RW52TmFtZVBhdHRlcm4gIiR7bmFtZX0iICIke19fRVhFQ1NUVUJCSU5HX0VOVl9BTExPV19MSVNUfSIgXAogICAgJiYgISBtYXRjaGVzQW55RW52TmFtZVBhdHRlcm4gIiR7bmFtZX0iICIke19fRVhFQ1NUVUJCSU5HX0VOVl9ERU5ZX0xJU1R9Igp9CgojIEVudHJpZXMgYXJlIE5VTCB0ZXJtaW5hdGVkIChsaWtlIC9wcm9jLzxwaWQ+L2Vudmlyb24pIGFuZCBzb3J0ZWQgbGlrZSBnb2xhbmcgc29ydC5TdHJpbmdzCmVudlRvQmFzZTY0SWZDYXB0dXJlZCgpIHsKICBpZiBbWyAteiAiJHtfX0VYRUNTVFVCQklOR19FTlZfQUxMT1dfTElTVH0iIF1dOyB0aGVuCiAgICByZXR1cm4KICBmaQogIGZvciBuYW1lIGluICQoY29tcGdlbiAtZSkKICBkbwogICAgaWYgaXNFbnZOYW1lQ2FwdHVyZWQgIiR7bmFtZX0iOyB0aGVuCiAgICAgIHByaW50ZiAiJXM9JXNcMCIgIiR7bmFtZX0iICIkeyFuYW1lfSIKICAgIGZpCiAgZG9uZSB8IExDX0FMTD1DIHNvcnQgLXogfCBiYXNlNjQgfCB0ciAtZCAnXG4nCn0KCiMgc2V0cyByZXEgdG8gdGhlIHN0dWIgcmVxdWVzdCBhcyBiYXNlNjQgY3N2IGdpdmVuIHRoZSBjb21tYW5kIGFyZ3VtZW50cwpzdHViUmVxdWVzdEFzQmFzZTY0Q1NWKCkgewogIHJlcT0iJChwcm90b2NvbFZlcnNpb25NYXJrZXIpIgogIHJlcT0iJHtyZXF9LCQoIHN0clRvQmFzZTY0ICR7X19FWEVDU1RVQkJJTkdfU1RVQl9LRVl9ICkiCiAgcmVxPSIke3JlcX0sJCggc3RyVG9CYXNlNjQgJHtfX0VYRUNTVFVCQklOR19DTURfVE9fU1RVQn0gKSIKICByZXE9IiR7cmVxfSwke19fRVhFQ1NUVUJCSU5HX1NURElOX0JBU0U2NH0iCiAgcmVxPSIke3JlcX0sJCggZGlyVG9CYXNlNjRJZkNhcHR1cmVkICkiCiAgcmVxPSIke3JlcX0sJCggZW52VG9CYXNlNjRJZkNhcHR1cmVkICkiCgogIGZvciB2YXIgaW4gIiRAIgogIGRvCiAgICAjI3Zhcng9IiR7dmFyLy9bJCdcdFxyXG4nXS99IgogICAgcmVxPSIke3JlcX0sJChzdHJUb0Jhc2U2NCAke3Zhcn0pIgogIGRvbmUKfQoKd3JpdGVTdHViUmVxdWVzdCgpIHsKICBkc3Q9IiQxIgogICMgbm90IHVzaW5nIGVjaG8gIiRyZXEiIGJlY2F1c2UgY2FwdHVyZWQgc3RkaW4gbWF5IGV4Y2VlZCB0aGUgbWF4IGFyZ3VtZW50IGxlbmd0aAogIHRpbWVvdXQgM3MgY2F0IDw8PCAiJHJlcSIgPiAke2RzdH0KICB0aW1lb3V0UmV0PSQ/CiAgI2VjaG8gIlN0dWJiaW5nUmVxcD0kcmVxIC0tLT4gJHtfX0VYRUNTVFVCQklOR19QSVBFX1NUVUJCRVJ9KHRpbWVvdXRSZXQ9JHRpbWVvdXRSZXQpIgogIGlmIFtbICIwIiAhPSAiJHRpbWVvdXRSZXQiIF1dOyB0aGVuCiAgICBlY2hvIDE+JjIgImZhaWxlZCB0byBzZW5kIHJlcXVlc3Q6IHRpbWVvdXRfb3JfZXJyOiR0aW1lb3V0UmV0IGRzdD0kZHN0IHJlcT0kcmVxIgogICAgZXhpdCAyNTUKICBmaQp9CgplY2hvU3R1YlJlcXVlc3RBc0Jhc2U2NENTVigpIHsKICAjZmlyc3QgYXJndW1lbnQgaXMgdGhlIGRlc3RpbmF0aW9uCiAgZHN0PSIkMSIKICBzaGlmdAogIHN0dWJSZXF1ZXN0QXNCYXNlNjRDU1YgIiRAIgogIHdyaXRlU3R1YlJlcXVlc3QgIiR7ZHN0fSIKfQoKIyBBcHBlbmRzIHJlcSB0byB0aGUgaW52b2NhdGlvbiB0aW1lbGluZSBhbmQgcHJpbnRzIGl0cyBzZXF1ZW5jZSBudW1iZXIuCiMgVGhlIG5leHQgc2VxdWVuY2UgbnVtYmVyIGlzIGNsYWltZWQgYnkgY3JlYXRpbmcgaXRzIGVudHJ5IGZpbGUgZXhjbHVzaXZlbHkKIyAobm9jbG9iYmVyIG9wZW5zIHdpdGggT19FWENMKSwgc28gY29uY3VycmVudCBpbnZvY2F0aW9ucyBjYW5ub3QgY2xhaW0gdGhlIHNhbWUgbnVtYmVyLgphcHBlbmRTdHViUmVxdWVzdFRvVGltZWxpbmUoKSB7CiAgdGltZWxpbmVEaXI9IiR7X19FWEVDU1RVQkJJTkdfVElNRUxJTkVfRElSfSIKICAjIHRoZSBoaWdoLXdhdGVyIG1hcmsgKGh3bV8pIGtlZXBzIHRoZSBudW1iZXJpbmcgZ29pbmcgdXAgYWZ0ZXIgdGhlIGVudHJpZXMgd2VyZSBkZWxldGVkCiAgbGFzdFNlcT0iJCggY2QgIiR7dGltZWxpbmVEaXJ9IiAmJiBscyAtMSB8IGdyZXAgLUUgJ14oaW52fGh3bSlfJyB8IHNlZCAtRSAncy9eKGludnxod20pXy8vJyB8IHNvcnQgfCB0YWlsIC1uMSApIgogIGxhc3RTZXE9JCgoIDEwIyR7bGFzdFNlcTotMH0gKSkKICBmb3IgKCggYXR0ZW1wdD0xOyBhdHRlbXB0PD0xMDAwOyBhdHRlbXB0KysgKSkKICBkbwogICAgc2VxPSQoKCBsYXN0U2VxICsgYXR0ZW1wdCApKQogICAgZW50cnk9IiQoIHByaW50ZiAiJXMvaW52XyUwLjIwZCIgIiR7dGltZWxpbmVEaXJ9IiAiJHtzZXF9IiApIgogICAgaWYgKCBzZXQgLW8gbm9jbG9iYmVyOyBjYXQgPDw8ICIkcmVxIiA+ICIke2VudHJ5fSIgKSAyPi9kZXYvbnVsbDsgdGhlbgogICAgICBwcmludGYgIiVkIiAiJHtzZXF9IgogICAgICByZXR1cm4gMAogICAgZmkKICBkb25lCiAgZWNobyAxPiYyICJmYWlsZWQgdG8gY2xhaW0gYSB0aW1lbGluZSBzZXF1ZW5jZSBudW1iZXIgaW4gJHt0aW1lbGluZURpcn0iCiAgZXhpdCAyNTUKfQoKbmV4dFN0dWJSZXF1ZXN0RmlsZVBhdGgoKSB7CiAgZGF0YURpcj0iJF9fRVhFQ1NUVUJCSU5HX0RBVEFfRElSIgogIHByaW50ZiAiJXMvc2VyX3N0dWJyZXF1ZXN0XyVzXyUwLjZkIiAiJGRhdGFEaXIiICIkKGRhdGUgKyclWSVtJWQtJUglTSVTLSVOJykiICRSQU5ET00KfQoKc2VxdWVuY2VkU3R1YlJlcXVlc3RGaWxlUGF0aCgpIHsKICBwcmludGYgIiVzL3Nlcl9zdHVicmVxdWVzdF9zZXFfJTAuMjBkIiAiJF9fRVhFQ1NUVUJCSU5HX0RBVEFfRElSIiAiJDEiCn0KCm5leHRFeGVjT3V0Y29tZUZpbGVQYXRoKCkgewogIGRhdGFEaXI9IiRfX0VYRUNTVFVCQklOR19EQVRBX0RJUiIKICBwcmludGYgIiVzL3Nlcl9leGVjb3V0Y29tZV8lc18lMC42ZCIgIiRkYXRhRGlyIiAiJChkYXRlICsnJVklbSVkLSVIJU0lUy0lTicpIiAkUkFORE9NCn0KCmZpbGVUb0Jhc2U2NCgpIHsKICBiYXNlNjQgPCAiJDEiIHwgdHIgLWQgJ1xuJwp9CgpwYXRoV2l0aG91dCgpIHsKICByZW1vdmVkRGlyPSIkMSIKICBuZXdQYXRoPSIiCiAgSUZTPSI6IiByZWFkIC1yYSBwYXRoUGFydHMgPDw8ICIke19fRVhFQ1NUVUJCSU5HX0NBTExFUl9QQVRIfSIKICBmb3IgcGFydCBpbiAiJHtwYXRoUGFydHNbQF19IgogIGRvCiAgICBpZiBbWyAiJHtwYXJ0fSIgIT0gIiR7cmVtb3ZlZERpcn0iIF1dOyB0aGVuCiAgICAgIG5ld1BhdGg9IiR7bmV3UGF0aDorJHtuZXdQYXRofTp9JHtwYXJ0fSIKICAgIGZpCiAgZG9uZQogIHByaW50ZiAiJXMiICIke25ld1BhdGh9Igp9CgojIFJ1bnMgdGhlIHJlYWwgZXhlY3V0YWJsZSB3aXRob3V0IHRoZSBzdHViYmluZyB2YXJpYWJsZXMsIHdpdGhvdXQgdGhlIHN0dWIKIyBkaXJlY3RvcnkgaW4gUEFUSCBhbmQgd2l0aCB0aGUgaG9tZSB2YXJpYWJsZSBpdCB3YXMgZGlzY292ZXJlZCBieSByZXNldCwKIyBzbyB0aGF0IGl0IGRvZXMgbm90IGVuZCB1cCBjYWxsaW5nIHRoZSBzdHViIHdoZW4gZXhlY3V0aW5nIGl0c2VsZi4KcnVuUmVhbEV4ZWMoKSB7CiAgcmVhbEV4ZWM9IiR7X19FWEVDU1RVQkJJTkdfUkVBTF9FWEVDfSIKICByZWFsRXhlY1BhdGg9IiQocGF0aFdpdGhvdXQgIiQoZGlybmFtZSAiJHtfX0NNRF9BQlNfUEFUSH0iKSIpIgogIGNhcHR1cmVTdGRpbj0iJHtfX0VYRUNTVFVCQklOR19DQVBUVVJFX1NURElOfSIKICBzdGRpbkJhc2U2ND0iJHtfX0VYRUNTVFVCQklOR19TVERJTl9CQVNFNjR9IgogIHJlYWxIb21lRW52S2V5PSIke19fRVhFQ1NUVUJCSU5HX1JFQUxfSE9NRV9FTlZfS0VZfSIKICByZWFsSG9tZT0iJHtfX0VYRUNTVFVCQklOR19SRUFMX0hPTUV9IgogIHVuc2V0ICR7IV9fRVhFQ1NUVUJCSU5HX0B9CiAgaWYgW1sgLW4gIiR7cmVhbEhvbWVFbnZLZXl9IiBdXTsgdGhlbgogICAgaWYgW1sgLW4gIiR7cmVhbEhvbWV9IiBdXTsgdGhlbgogICAgICBleHBvcnQgIiR7cmVhbEhvbWVFbnZLZXl9PSR7cmVhbEhvbWV9IgogICAgZWxzZQogICAgICB1bnNldCAiJHtyZWFsSG9tZUVudktleX0iCiAgICBmaQogIGZpCiAgaWYgW1sgInRydWUiID09ICIke2NhcHR1cmVTdGRpbn0iIF1dOyB0aGVuCiAgICAjIHN0ZGluIGhhcyBhbHJlYWR5IGJlZW4gY29uc3VtZWQgd2hpbGUgY2FwdHVyaW5nIGl0CiAgICBiYXNlNjRUb1N0cmVhbSAiJHtzdGRpbkJhc2U2NH0iIHwgUEFUSD0iJHtyZWFsRXhlY1BhdGh9IiAiJHtyZWFsRXhlY30iICIkQCIKICBlbHNlCiAgICBQQVRIPSIke3JlYWxFeGVjUGF0aH0iICIke3JlYWxFeGVjfSIgIiRAIgogIGZpCn0KCiMgRGVsZWdhdGVzIHRvIHRoZSByZWFsIGV4ZWN1dGFibGUsIGZvcndhcmRpbmcgaXRzIG91dHB1dCBhcyBpdCBhcnJpdmVzLgojIEl0cyBvdXRjb21lIGlzIHBlcnNpc3RlZCBpbnRvIHRoZSBkYXRhIGRpcjsgYSB0ZXJtaW5hdGlvbiBieSBzaWduYWwgaXMKIyByZWNvcmRlZCBhcyBleGl0IGNvZGUgMTI4K3NpZ25hbCwgdGhlIHdheSBiYXNoIHJlcG9ydHMgaXQuCmVmZmVjdHVhdGVQYXNzdGhyb3VnaEFuZEV4aXQoKSB7CiAgaWYgW1sgLXogIiR7X19FWEVDU1RVQkJJTkdfUkVBTF9FWEVDfSIgXV07IHRoZW4KICAgIHByaW50ZiAiJXMiICJjYW5ub3QgZGVsZWdhdGUgdG8gdGhlIHJlYWwgZXhlY3V0YWJsZSBvZiAke19fRVhFQ1NUVUJCSU5HX0NNRF9UT19TVFVCfSBiZWNhdXNlIGl0IHdhcyBub3QgZm91bmQgYmVmb3JlIHN0dWJiaW5nIiA+JjIKICAgIGV4aXQgMjU1CiAgZmkKICBvdXRGaWxlPSIkKG1rdGVtcCkiCiAgZXJyRmlsZT0iJChta3RlbXApIgogICgKICAgIHNldCAtbyBwaXBlZmFpbAogICAgewogICAgICAjIHN0ZG91dCBhbmQgc3RkZXJyIGFyZSBzd2FwcGVkLCBzbyB0aGF0IHN0ZGVyciBjYW4gYmUgdGVlZCB0aHJvdWdoIHRoZSBwaXBlCiAgICAgICggcnVuUmVhbEV4ZWMgIiRAIiApIDI+JjEgMT4mMyAzPiYtIHwgdGVlICIke2VyckZpbGV9IiAxPiYyCiAgICB9IDM+JjEgfCB0ZWUgIiR7b3V0RmlsZX0iCiAgKQogIHJlYWxFeGl0Q29kZT0kPwogIG91dGNvbWU9IiQocHJvdG9jb2xWZXJzaW9uTWFya2VyKSwkKHN0clRvQmFzZTY0ICIke3JlYWxFeGl0Q29kZX0iKSwsJChzdHJUb0Jhc2U2NCAiJHtfX0VYRUNTVFVCQklOR19TVFVCX0tFWX0iKSIKICBvdXRjb21lPSIke291dGNvbWV9LCQoZmlsZVRvQmFzZTY0ICIke2VyckZpbGV9IiksJChmaWxlVG9CYXNlNjQgIiR7b3V0RmlsZX0iKSIKICBvdXRjb21lPSIke291dGNvbWV9LCQoc3RyVG9CYXNlNjQgMCksJChzdHJUb0Jhc2U2NCBmYWxzZSksJChzdHJUb0Jhc2U2NCAwKSwkKHN0clRvQmFzZTY0IGZhbHNlKSIKICBybSAtZiAiJHtvdXRGaWxlfSIgIiR7ZXJyRmlsZX0iCiAgY2F0IDw8PCAiJHtvdXRjb21lfSIgPiAiJChuZXh0RXhlY091dGNvbWVGaWxlUGF0aCkiCiAgZXhpdCAke3JlYWxFeGl0Q29kZX0KfQoKaXNCYXNlNjQoKSB7CiAgcHJpbnRmICIlcyIgIiQxIiB8IGJhc2U2NCAtZCAmPi9kZXYvbnVsbAp9CgojIERlY29kaW5nIGRpcmVjdGx5IGludG8gdGhlIHRhcmdldCBzdHJlYW0sIGJlY2F1c2UgY29tbWFuZCBzdWJzdGl0dXRpb24gJCguLi4pCiMgd291bGQgZHJvcCBOVUwgYnl0ZXMgYW5kIHRyYWlsaW5nIG5ldyBsaW5lcy4KYmFzZTY0VG9TdHJlYW0oKSB7CiAgaWYgW1sgLW4gIiQxIiBdXTsgdGhlbgogICAgcHJpbnRmICIlcyIgIiQxIiB8IGJhc2U2NCAtZAogIGZpCn0KCm5hbm9zVG9TZWNvbmRzKCkgewogIHByaW50ZiAiJWQuJTA5ZCIgJCgoJDEvMTAwMDAwMDAwMCkpICQoKCQxJTEwMDAwMDAwMDApKQp9CgojIHNsZWVwIGRvZXMgbm90IGluaGVyaXQgdGhlIHN0ZCBzdHJlYW1zIG9mIHRoZSBzdHViLCBzbyB0aGF0IGl0IGRvZXMgbm90IGtlZXAKIyB0aGUgb3V0cHV0IHBpcGVzIG9mIHRoZSBzdHViYmVkIGNvbW1hbmQgb3BlbiB3aGVuIHRoZSBzdHViIGdldHMga2lsbGVkLgojIExvb3Bpbmcgb24gYSBzaG9ydCBzbGVlcCB3aGVuIGhhbmdpbmcgZW5zdXJlcyB0aGF0IGFuIG9ycGhhbmVkIHNsZWVwIGRvZXMgbm90CiMgbGluZ2VyIGVpdGhlci4KZGVsYXlFeGl0KCkgewogIGRlbGF5TmFub3M9IiQxIgogIGhhbmc9IiQyIgogIGlmIFtbICJ0cnVlIiA9PSAiJHtoYW5nfSIgXV07IHRoZW4KICAgIHdoaWxlIHRydWUKICAgIGRvCiAgICAgIHNsZWVwIDAuMSA8L2Rldi9udWxsICY+L2Rldi9udWxsCiAgICBkb25lCiAgZWxpZiBbWyAiJHtkZWxheU5hbm9zfSIgPX4gXlswLTldKyQgXV0gJiYgKCggZGVsYXlOYW5vcyA+IDAgKSk7IHRoZW4KICAgIHNsZWVwICIkKG5hbm9zVG9TZWNvbmRzICR7ZGVsYXlOYW5vc30pIiA8L2Rldi9udWxsICY+L2Rldi9udWxsCiAgZmkKfQoKIyBiYXNoIGRvZXMgbm90IGhhbmRsZSBzaWduYWxzIGxpa2UgU0lHU0VHViBvciBTSUdBQlJULCBzbyB0aGUgc3R1YiBpcyB0ZXJtaW5hdGVkCiMgYnkgdGhlbSBhcyBhIGNyYXNoaW5nIGNvbW1hbmQgd291bGQuCnRlcm1pbmF0ZUJ5U2lnbmFsSWZSZXF1aXJlZCgpIHsKICBzaWduYWw9IiR7MS8vIC99IgogIGlmIFtbIC16ICIke3NpZ25hbH0iIHx8ICIwIiA9PSAiJHtzaWduYWx9IiBdXTsgdGhlbgogICAgcmV0dXJuCiAgZmkKICBpZiAhIFtbICIke3NpZ25hbH0iID1+IF5bMC05XSskIF1dOyB0aGVuCiAgICBwcmludGYgIiVzIiAiYmFkIHRlcm1pbmF0aW9uIHNpZ25hbDogJyR7c2lnbmFsfSciID4mMgogICAgZXhpdCAyNTUKICBmaQogIGtpbGwgLSR7c2lnbmFsfSAkJAp9CgojIFdyaXRlcyB0aGUgb3V0cHV0IHNjcmlwdCBnaXZlbiBhcyB0cmlwbGVzIG9mIGJhc2U2NCBlbmNvZGVkOgojIHN0cmVhbSBmaWxlIGRlc2NyaXB0b3IgKDEgb3IgMiksIGRlbGF5IGluIG5hbm9zZWNvbmRzLCBkYXRhLgojIFRoZSBkYXRhIG9mIHRoZSBsYXN0IGNodW5rIG1heSBiZSBtaXNzaW5nIGFzIHRyYWlsaW5nIGVtcHR5IGNzdiByZWNvcmQgYXJlIGRyb3BwZWQuCnJlcGxheU91dHB1dFNjcmlwdCgpIHsKICBjaHVua3M9KCIkQCIpCiAgZm9yICgoIGk9MDsgaTwkeyNjaHVua3NbQF19OyBpKz0zICkpCiAgZG8KICAgIGZkPSQoIHByaW50ZiAiJXMiICIke2NodW5rc1tpXX0iIHwgYmFzZTY0IC1kICkKICAgIGRlbGF5TmFub3M9JCggcHJpbnRmICIlcyIgIiR7Y2h1bmtzW2krMV19IiB8IGJhc2U2NCAtZCApCiAgICBpZiBbWyAiMSIgIT0gIiR7ZmR9IiAmJiAiMiIgIT0gIiR7ZmR9IiBdXSB8fCAhIFtbICIke2RlbGF5TmFub3N9IiA9fiBeWzAtOV0rJCBdXTsgdGhlbgogICAgICBwcmludGYgIiVzIiAiYmFkIG91dHB1dCBzY3JpcHQgY2h1bmtbJCgoaS8zKSldOiBzdHJlYW09JyR7Y2h1bmtzW2ldfScgZGVsYXk9JyR7Y2h1bmtzW2krMV19JyIgPiYyCiAgICAgIGV4aXQgMjU1CiAgICBmaQogICAgaWYgKCggZGVsYXlOYW5vcyA+IDAgKSk7IHRoZW4KICAgICAgc2xlZXAgIiQobmFub3NUb1NlY29uZHMgJHtkZWxheU5hbm9zfSkiCiAgICBmaQogICAgYmFzZTY0VG9TdHJlYW0gIiR7Y2h1bmtzW2krMl19IiA+JiR7ZmR9CiAgZG9uZQp9CgojIFRoZSBpbnZvY2F0aW9uIGlkIGNvcnJlbGF0ZXMgdGhlIHJlcXVlc3Qgd2l0aCBpdHMgb3V0Y29tZSB3aGVuIHRoZSBzdHViIGlzIGV4ZWN1dGVkIGNvbmN1cnJlbnRseToKIyAtIHRoZSByZXF1ZXN0IGlzIHdyaXR0ZW4gaW50byBhIGZpbGUgZGVkaWNhdGVkIHRvIHRoZSBpbnZvY2F0aW9uCiMgLSBvbmx5IHRoZSBpZCBpcyBzZW50IHRocm91Z2ggdGhlIHN0dWJiZXIgcGlwZTsgdGhlIGxpbmUgaXMgc21hbGxlciB0aGFuIFBJUEVfQlVGIHNvIGl0IGlzIHdyaXR0ZW4gYXRvbWljYWxseQojIC0gdGhlIG91dGNvbWUgaXMgcmVhZCBmcm9tIGEgbmFtZWQgcGlwZSBkZWRpY2F0ZWQgdG8gdGhlIGludm9jYXRpb24KbmV3SW52b2NhdGlvbklkKCkgewogIHByaW50ZiAiJXNfJXgleCIgIiQkIiAiJFJBTkRPTSIgIiRSQU5ET00iCn0KCmludm9jYXRpb25SZXNwb25zZVBpcGVQYXRoKCkgewogIHByaW50ZiAiJXNfJXMiICIke19fRVhFQ1NUVUJCSU5HX1BJUEVfVEVTVF9IRUxQRVJfUFJPQ30iICIkMSIKfQoKcmVtb3ZlSW52b2NhdGlvbkZpbGVzKCkgewogIHJlc3BQaXBlPSIkKGludm9jYXRpb25SZXNwb25zZVBpcGVQYXRoICIkMSIpIgogIHJtIC1mICIke3Jlc3BQaXBlfSIgIiR7cmVzcFBpcGV9X3JlcSIKfQoKc2VuZEludm9jYXRpb25SZXF1ZXN0KCkgewogIGludm9jYXRpb25JZD0iJDEiCiAgc2hpZnQKICByZXNwUGlwZT0iJChpbnZvY2F0aW9uUmVzcG9uc2VQaXBlUGF0aCAiJHtpbnZvY2F0aW9uSWR9IikiCiAgaWYgISBta2ZpZm8gIiR7cmVzcFBpcGV9IjsgdGhlbgogICAgZWNobyAxPiYyICJmYWlsZWQgdG8gY3JlYXRlIGludm9jYXRpb24gcGlwZTogJHtyZXNwUGlwZX0iCiAgICBleGl0IDI1NQogIGZpCiAgZWNob1N0dWJSZXF1ZXN0QXNCYXNlNjRDU1YgIiR7cmVzcFBpcGV9X3JlcSIgIiRAIgogIHRpbWVvdXQgM3MgY2F0IDw8PCAiJChzdHJUb0Jhc2U2NCAke2ludm9jYXRpb25JZH0pIiA+ICIke19fRVhFQ1NUVUJCSU5HX1BJUEVfU1RVQkJFUn0iCiAgdGltZW91dFJldD0kPwogIGlmIFtbICIwIiAhPSAiJHRpbWVvdXRSZXQiIF1dOyB0aGVuCiAgICBlY2hvIDE+JjIgImZhaWxlZCB0byBzZW5kIGludm9jYXRpb24gaWQ6IHRpbWVvdXRfb3JfZXJyOiR0aW1lb3V0UmV0IGludm9jYXRpb25JZD0ke2ludm9jYXRpb25JZH0iCiAgICByZW1vdmVJbnZvY2F0aW9uRmlsZXMgIiR7aW52b2NhdGlvbklkfSIKICAgIGV4aXQgMjU1CiAgZmkKfQoKIyBQcmludHMgdGhlIHNlY29uZHMgdG8gd2FpdCBmb3IgdGhlIG91dGNvbWUgb2YgYW4gaW52b2NhdGlvbiwgd2hpY2ggaXMgdGhlIGNvbmZpZ3VyZWQgdGltZW91dAojIGFzIHVzZWQgYnkgdGhlIGV4ZSBzdHViIGV4ZWN1dGFibGUgYW5kIHRoZSBzdHViYmVyOyB0aGUgZGVmYXVsdCB0aW1lb3V0ICgxMHMpIGlmIG1pc2NvbmZpZ3VyZWQuCm91dGNvbWVUaW1lb3V0U2Vjb25kcygpIHsKICB0aW1lb3V0TmFub3M9IiR7X19FWEVDU1RVQkJJTkdfVElNRU9VVF9OQU5PUy8vIC99IgogIGlmIFtbICEgIiR7dGltZW91dE5hbm9zfSIgPX4gXlswLTldKyQgXV0gfHwgKCggMTAjJHt0aW1lb3V0TmFub3N9ID09IDAgKSk7IHRoZW4KICAgIHRpbWVvdXROYW5vcz0xMDAwMDAwMDAwMAogIGZpCiAgbmFub3NUb1NlY29uZHMgJCgoIDEwIyR7dGltZW91dE5hbm9zfSApKQp9CgpnZXRUaGVuRG9EeW5hbWljRXhlY091dGNvbWUoKSB7CiAgaW52b2NhdGlvbklkPSIkMSIKICBzaGlmdAogICMgVGhlIG91dGNvbWUgaXMgYSBzaW5nbGUgY3N2IHJlY29yZDsgcmVhZGluZyBpdCBsaW5lLXdpc2UgaW5zdGVhZCBvZiB1bnRpbCBFT0YuCiAgIyBPcGVuaW5nIHJlYWQtd3JpdGUgZG9lcyBub3QgYmxvY2sgZXZlbiBpZiB0aGUgc3R1YmJlciBpcyBnb25lLgogIGV4ZWMgMzw+IiQoaW52b2NhdGlvblJlc3BvbnNlUGlwZVBhdGggIiR7aW52b2NhdGlvbklkfSIpIgogIGlmICEgSUZTPSByZWFkIC1yIC10ICIkKG91dGNvbWVUaW1lb3V0U2Vjb25kcykiIC11IDMgb0Jhc2U2NENTVjsgdGhlbgogICAgb0Jhc2U2NENTVj0iJHtvQmFzZTY0Q1NWfSB0aW1lb3V0IG9yIGVycjokez99IgogIGZpCiAgZXhlYyAzPCYtCiAgcmVtb3ZlSW52b2NhdGlvbkZpbGVzICIke2ludm9jYXRpb25JZH0iCiAgT0xEX0lGUz0ke0lGU30KICBJRlM9IiwiCiAgI3ByaW50ZiAiJHtvQmFzZTY0Q1NWfSxzc3MiIHwgcmVhZCAtcmEgb0RhdGEKICByZWFkIC1yYSBvRGF0YSA8PDwgIiR7b0Jhc2U2NENTVn0iCiAgSUZTPSIke09MRF9JRlN9IgogIGlmIFtbICIke29EYXRhWzBdfSIgIT0gIiQocHJvdG9jb2xWZXJzaW9uTWFya2VyKSIgXV07IHRoZW4KICAgIGlmIFtbICIke29EYXRhWzBdfSIgPT0gZXhlY3N0dWItdiogXV07IHRoZW4KICAgICAgZXhpdEluY29tcGF0aWJsZVZlcnNpb24gImV4ZWMgb3V0Y29tZSIgIiR7b0RhdGFbMF0jZXhlY3N0dWItdn0iCiAgICBlbGlmICgoICR7I29EYXRhW0BdfSA+PSA5ICkpOyB0aGVuCiAgICAgIGV4aXRJbmNvbXBhdGlibGVWZXJzaW9uICJleGVjIG91dGNvbWUiCiAgICBmaQogIGZpCiAgb0RhdGE9KCIke29EYXRhW0BdOjF9IikKICBvTGVuPSIkeyNvRGF0YVtAXX0iCiAgaWYgKCggJHtvTGVufSA8IDkgKSk7IHRoZW4KICAgIHByaW50ZiAiJXMiICJleHBlY3RzIGF0IGxlYXN0IDkgcmVjb3JkcyBpbiBjdnMgYnV0IGdvdCAke29MZW59LCBjdnM9JHtvQmFzZTY0Q1NWfSIgPiYyCiAgICBleGl0IDI1NQogIGZpCiAgZGVjb2RlRXhpdHM9IiIKICBleGl0Q29kZT0kKCBlY2hvICIke29EYXRhWzBdfSIgfCBiYXNlNjQgLWQpCiAgW1sgIjAiICE9ICIkPyIgXV0gJiYgZGVjb2RlRXhpdHM9IiR7ZGVjb2RlRXhpdHN9IEV4aXRDb2RlIE5vdEJhc2U2ND0nJHtvRGF0YVswXX0nIgogIGludGVybmFsRXJyPSQoIGVjaG8gIiR7b0RhdGFbMV19IiB8IGJhc2U2NCAtZCkKICBbWyAiMCIgIT0gIiQ/IiBdXSAmJiBkZWNvZGVFeGl0cz0iJHtkZWNvZGVFeGl0c30gSW50ZXJuYWxFcnJUeHQgTm90QmFzZTY0PScke29EYXRhWzFdfSciCiAga2V5PSQoIGVjaG8gIiR7b0RhdGFbMl19IiB8IGJhc2U2NCAtZCkKICBbWyAiMCIgIT0gIiQ/IiBdXSAmJiBkZWNvZGVFeGl0cz0iJHtkZWNvZGVFeGl0c30gS2V5IE5vdEJhc2U2ND0nJHtvRGF0YVsyXX0nIgogIGlzQmFzZTY0ICIke29EYXRhWzNdfSIKICBbWyAiMCIgIT0gIiQ/IiBdXSAmJiBkZWNvZGVFeGl0cz0iJHtkZWNvZGVFeGl0c30gU3RkZXJyIE5vdEJhc2U2ND0nJHtvRGF0YVszXX0nIgogIGlzQmFzZTY0ICIke29EYXRhWzRdfSIKICBbWyAiMCIgIT0gIiQ/IiBdXSAmJiBkZWNvZGVFeGl0cz0iJHtkZWNvZGVFeGl0c30gU3Rkb3V0IE5vdEJhc2U2ND0nJHtvRGF0YVs0XX0nIgogIGV4aXREZWxheU5hbm9zPSQoIGVjaG8gIiR7b0RhdGFbNV19IiB8IGJhc2U2NCAtZCkKICBbWyAiMCIgIT0gIiQ/IiBdXSAmJiBkZWNvZGVFeGl0cz0iJHtkZWNvZGVFeGl0c30gRXhpdERlbGF5IE5vdEJhc2U2ND0nJHtvRGF0YVs1XX0nIgogIGhhbmdVbnRpbFNpZ25hbGxlZD0kKCBlY2hvICIke29EYXRhWzZdfSIgfCBiYXNlNjQgLWQpCiAgW1sgIjAiICE9ICIkPyIgXV0gJiYgZGVjb2RlRXhpdHM9IiR7ZGVjb2RlRXhpdHN9IEhhbmdVbnRpbFNpZ25hbGxlZCBOb3RCYXNlNjQ9JyR7b0RhdGFbNl19JyIKICB0ZXJtaW5hdGlvblNpZ25hbD0kKCBlY2hvICIke29EYXRhWzddfSIgfCBiYXNlNjQgLWQpCiAgW1sgIjAiICE9ICIkPyIgXV0gJiYgZGVjb2RlRXhpdHM9IiR7ZGVjb2RlRXhpdHN9IFRlcm1pbmF0aW9uU2lnbmFsIE5vdEJhc2U2ND0nJHtvRGF0YVs3XX0nIgogIHBhc3N0aHJvdWdoPSQoIGVjaG8gIiR7b0RhdGFbOF19IiB8IGJhc2U2NCAtZCkKICBbWyAiMCIgIT0gIiQ/IiBdXSAmJiBkZWNvZGVFeGl0cz0iJHtkZWNvZGVFeGl0c30gUGFzc3Rocm91Z2ggTm90QmFzZTY0PScke29EYXRhWzhdfSciCgogIGlmIFtbIC1uICIke2RlY29kZUV4aXRzfSIgXV07IHRoZW4KICAgIHByaW50ZiAiJXMiICJiYWQgYmFzZTY0IGVuY29kaW5nICR7ZGVjb2RlRXhpdHN9IGNzdj0ke29CYXNlNjRDU1Z9IiA+JjIKICAgIGV4aXQgMjU1CiAgZmkKCiAgaWYgW1sgInRydWUiID09ICIke3Bhc3N0aHJvdWdofSIgXV07IHRoZW4KICAgIGVmZmVjdHVhdGVQYXNzdGhyb3VnaEFuZEV4aXQgIiRAIgogIGZpCgogIGJhc2U2NFRvU3RyZWFtICIke29EYXRhWzNdfSIgMT4mMgogIGJhc2U2NFRvU3RyZWFtICIke29EYXRhWzRdfSIKICByZXBsYXlPdXRwdXRTY3JpcHQgIiR7b0RhdGFbQF06OX0iCgogIGlmIFtbIC1uICIke2ludGVybmFsRXJyfSIgXV07IHRoZW4KICAgIHByaW50ZiAiJXMiICIke2ludGVybmFsRXJyfSIgMT4mMgogICAgZXhpdCAyNTUKICBmaQoKICBkZWxheUV4aXQgIiR7ZXhpdERlbGF5TmFub3N9IiAiJHtoYW5nVW50aWxTaWduYWxsZWR9IgogIHRlcm1pbmF0ZUJ5U2lnbmFsSWZSZXF1aXJlZCAiJHt0ZXJtaW5hdGlvblNpZ25hbH0iCgogIGlmIGlzVWludDggIiR7ZXhpdENvZGV9IiAmPi9kZXYvbnVsbDsgdGhlbgogICAgZXhpdCAke2V4aXRDb2RlfTsKICBlbHNlCiAgICBwcmludGYgIiVzIiAiSW52YWxpZCBiYXNlNjQgZm91bmQ6ICcke2V4aXRDb2RlfScgY3N2PSR7b0Jhc2U2NENTVn0iIDE+JjIKICAgIGV4aXQgMjU1CiAgZmk7Cn0KCmVjaG9UaW1lb3V0QW5kRXhpdCgpIHsKICAgIGVjaG8gIlRpbWVvdXQiIDE+JjIKICAgIGtpbGwgLTkgJDEKfQoKCiMgSW4gc3RyaWN0IG1vZGUgUEFUSCBvbmx5IGxlYWRzIHRvIHN0dWJzLCBzbyB0aGUgdG9vbHMgdXNlZCBieSB0aGlzIHNjcmlwdCBhcmUgbG9va2VkIHVwCiMgaW4gdGhlIFBBVEggaW4gcGxhY2UgYmVmb3JlIHN0cmljdCBtb2RlIHdhcyBlbmFibGVkLgojIFRoZSBwcm9jZXNzZXMgc3RhcnRlZCBieSB0aGlzIHNjcmlwdCBnZXQgdGhlIFBBVEggb2YgdGhlIGNhbGxlci4KX19FWEVDU1RVQkJJTkdfQ0FMTEVSX1BBVEg9IiR7UEFUSH0iCmlmIFtbIC1uICIke19fRVhFQ1NUVUJCSU5HX1RPT0xTX1BBVEh9IiBdXTsgdGhlbgogICAgUEFUSD0iJHtfX0VYRUNTVFVCQklOR19UT09MU19QQVRIfSIKZmkKCl9fQ01EX0FCU19QQVRIPSQoZGlybmFtZSAiJDAiKQpfX0NNRF9BQlNfUEFUSD0kKCBjZCAiJHtfX0NNRF9BQlNfUEFUSH0iICYmIHB3ZCApCl9fQ01EX0FCU19QQVRIPSIke19fQ01EX0FCU19QQVRIfS8kKGJhc2VuYW1lICQwKSIKCl9fQ01EX0NPTkZJR19QQVRIPSIke19fQ01EX0FCU19QQVRIfS5jb25maWciCgpzb3VyY2UgJHtfX0NNRF9DT05GSUdfUEFUSH0KCmlmIFtbICIke19fRVhFQ1NUVUJCSU5HX1BST1RPQ09MX1ZFUlNJT059IiAhPSAiJHtzdHViUHJvdG9jb2xWZXJzaW9ufSIgXV07IHRoZW4KICAgIGV4aXRJbmNvbXBhdGlibGVWZXJzaW9uICJjb25maWcgZmlsZSAke19fQ01EX0NPTkZJR19QQVRIfSIgIiR7X19FWEVDU1RVQkJJTkdfUFJPVE9DT0xfVkVSU0lPTn0iCmZpCgpleHBvcnQgX19FWEVDU1RVQkJJTkdfU1RVQl9LRVkKZXhwb3J0IF9fRVhFQ1NUVUJCSU5HX0NNRF9UT19TVFVCCmV4cG9ydCBfX0VYRUNTVFVCQklOR19EQVRBX0RJUgpleHBvcnQgX19FWEVDU1RVQkJJTkdfVElNRUxJTkVfRElSCmV4cG9ydCBfX0VYRUNTVFVCQklOR19DQVBUVVJFX1NURElOCiMgVGhlIGZvbGxvd2luZyBpcyBtb3JlIGVsYWJvcmF0ZSB0byBlYXNlIGRlYnVnZ2luZyB3aGlsZSBkZXZlbG9wcGluZwojIC0gR2xvYiBhbmQgdGFrZSB0aGUgbW9zdCByZWNlbnQgZXZlbiB0aGVpciBzaG91bGQgYmUgZXhhY3RseSBvbmUgbmFtZWQgcGlwZQojIC0gS2VlcCBlcnJvcnMgaW4gdGhlIHZhcmlhYmxlICh8JikKIyBleHBvcnQgX19FWEVDU1RVQkJJTkdfUElQRV9TVFVCQkVSPSIkKGxzICAtdCAke19fQ01EX0FCU19QQVRIfV9zdHViYmVyX3BpcGVfKiB8JiBoZWFkIC1uMSkiCiMgZXhwb3J0IF9fRVhFQ1NUVUJCSU5HX1BJUEVfVEVTVF9IRUxQRVJfUFJPQz0iJChscyAgLXQgJHtfX0NNRF9BQlNfUEFUSH1fdGVzdHByb2Nlc3NoZWxwZXJfcGlwZV8qIHwmIGhlYWQgLW4xKSIKZXhwb3J0IF9fRVhFQ1NUVUJCSU5HX1BJUEVfU1RVQkJFUgpleHBvcnQgX19FWEVDU1RVQkJJTkdfUElQRV9URVNUX0hFTFBFUl9QUk9DCgp0ZXN0TWV0aG9kTmFtZT0iJHtfX0VYRUNTVFVCQklOR19URVNUX0hFTFBFUl9QUk9DRVNTX01FVEhPRH0iCnRlc3RNZXRob2ROYW1lPSIke3Rlc3RNZXRob2ROYW1lLy8gL30iCgoKCmlmIFtbIC1uICIke3Rlc3RNZXRob2ROYW1lfSIgXV07IHRoZW4KCiAgICBleHBvcnQgX19FWEVDU1RVQkJJTkdfR09fV0FOVF9IRUxQRVJfUFJPQ0VTUz0xCiAgICBleHBvcnQgX19FWEVDU1RVQkJJTkdfU1RVQl9DTURfQ09ORklHPSIke19fQ01EX0NPTkZJR19QQVRIfSIKICAgICMgZS5nLiAvdG1wL2dvLWJ1aWxkNzIwMDUzNDMwL2IwMDEvZXhlY3N0dWJiaW5nLnRlc3QgLXRlc3QucnVuPVRlc3RIZWxwZXJQcm9jZXNzIC0tICIkQCIKICAgICMgLXRlc3QucnVuIHRha2VzIGEgcmVnZXggdGhlcmVmb3JlIG1hdGNoaW5nIHRoZSBleGFjdCB0ZXN0IGhlbHBlciBwcm9jZXNzIG1ldGhvZAogICAgIyBleGVjIHNvIHRoYXQgc2lnbmFscyBzZW50IHRvIHRoZSBzdHViIHJlYWNoIHRoZSB0ZXN0IGhlbHBlciBwcm9jZXNzCiAgICBQQVRIPSIke19fRVhFQ1NUVUJCSU5HX0NBTExFUl9QQVRIfSIKICAgIGV4ZWMgJHtfX0VYRUNTVFVCQklOR19VTklUX1RFU1RfRVhFQ30gLXRlc3QucnVuPSJeJHtfX0VYRUNTVFVCQklOR19URVNUX0hFTFBFUl9QUk9DRVNTX01FVEhPRH1cJCIgLS0gIiRAIgoKZWxzZQogICAgc3RhdGljQ29uZmlnPSIke19fRVhFQ1NUVUJCSU5HX1NURF9PVVR9JHtfX0VYRUNTVFVCQklOR19TVERfRVJSfSR7X19FWEVDU1RVQkJJTkdfT1VUUFVUX1NDUklQVH0ke19fRVhFQ1NUVUJCSU5HX0VYSVRfREVMQVlfTkFOT1N9JHtfX0VYRUNTVFVCQklOR19IQU5HX1VOVElMX1NJR05BTExFRH0ke19fRVhFQ1NUVUJCSU5HX1RFUk1JTkFUSU9OX1NJR05BTH0ke19fRVhFQ1NUVUJCSU5HX1BBU1NUSFJPVUdIfSR7X19FWEVDU1RVQkJJTkdfRVhJVF9DT0RFfSIKICAgIHN0YXRpY0NvbmZpZz0iJHtzdGF0aWNDb25maWcvLyAvfSIKCiAgICBpZiBbWyAtbiAiJHtfX0VYRUNTVFVCQklOR19UUkFOU1BPUlRfQ0xJRU5UfSIgXV07IHRoZW4KICAgICAgICAjIGJhc2ggY2FuIGNvbm5lY3QgbmVpdGhlciB0byBhIHVuaXggZG9tYWluIHNvY2tldCBub3IgdG8gYW4gaHR0cCBzZXJ2ZXIKICAgICAgICAjIGFuZCBjYW5ub3QgcmVuZGVyIGFuIG91dGNvbWUgdGVtcGxhdGUgKHN0YXRpYyBtb2RlKSwKICAgICAgICAjIHNvIHRoZSBpbnZvY2F0aW9uIGlzIGRlbGVnYXRlZCB0byB0aGUgdHJhbnNwb3J0IGNsaWVudCwKICAgICAgICAjIHdoaWNoIGNhcHR1cmVzIHN0ZGluLCBkaXIgYW5kIGVudiBieSBpdHNlbGYKICAgICAgICBQQVRIPSIke19fRVhFQ1NUVUJCSU5HX0NBTExFUl9QQVRIfSIKICAgICAgICBleGVjICIke19fRVhFQ1NUVUJCSU5HX1RSQU5TUE9SVF9DTElFTlR9IiAiJHtfX0NNRF9DT05GSUdfUEFUSH0iICIkQCIKICAgIGZpCgogICAgX19FWEVDU1RVQkJJTkdfU1RESU5fQkFTRTY0PSIkKHN0ZGluVG9CYXNlNjRJZkNhcHR1cmVkKSIKCiAgICBpZiBbWyAtbiAiJHtzdGF0aWNDb25maWd9IiBdXTsgdGhlbgogICAgICAgIHN0dWJSZXF1ZXN0QXNCYXNlNjRDU1YgIiRAIgogICAgICAgIGlmIFtbIC1uICIke19fRVhFQ1NUVUJCSU5HX1RJTUVMSU5FX0RJUn0iIF1dOyB0aGVuCiAgICAgICAgICAgIHNlcT0iJChhcHBlbmRTdHViUmVxdWVzdFRvVGltZWxpbmUpIiB8fCBleGl0IDI1NQogICAgICAgICAgICB3cml0ZVN0dWJSZXF1ZXN0ICIkKHNlcXVlbmNlZFN0dWJSZXF1ZXN0RmlsZVBhdGggIiR7c2VxfSIpIgogICAgICAgIGVsc2UKICAgICAgICAgICAgd3JpdGVTdHViUmVxdWVzdCAiJChuZXh0U3R1YlJlcXVlc3RGaWxlUGF0aCkiCiAgICAgICAgZmkKCiAgICAgICAgaWYgW1sgInRydWUiID09ICIke19fRVhFQ1NUVUJCSU5HX1BBU1NUSFJPVUdIfSIgXV07IHRoZW4KICAgICAgICAgICAgZWZmZWN0dWF0ZVBhc3N0aHJvdWdoQW5kRXhpdCAiJEAiCiAgICAgICAgZmkKCiAgICAgICAgYmFzZTY0VG9TdHJlYW0gIiR7X19FWEVDU1RVQkJJTkdfU1REX09VVH0iCiAgICAgICAgYmFzZTY0VG9TdHJlYW0gIiR7X19FWEVDU1RVQkJJTkdfU1REX0VSUn0iIDE+JjIKICAgICAgICBJRlM9IiwiIHJlYWQgLXJhIG91dHB1dFNjcmlwdCA8PDwgIiR7X19FWEVDU1RVQkJJTkdfT1VUUFVUX1NDUklQVH0iCiAgICAgICAgcmVwbGF5T3V0cHV0U2NyaXB0ICIke291dHB1dFNjcmlwdFtAXX0iCiAgICAgICAgZXhpdENvZGU9IiR7X19FWEVDU1RVQkJJTkdfRVhJVF9DT0RFfSIKICAgICAgICBleGl0Q29kZT0iJHtleGl0Q29kZS8vIC99IgoKICAgICAgICBkZWxheUV4aXQgIiR7X19FWEVDU1RVQkJJTkdfRVhJVF9ERUxBWV9OQU5PU30iICIke19fRVhFQ1NUVUJCSU5HX0hBTkdfVU5USUxfU0lHTkFMTEVEfSIKICAgICAgICB0ZXJtaW5hdGVCeVNpZ25hbElmUmVxdWlyZWQgIiR7X19FWEVDU1RVQkJJTkdfVEVSTUlOQVRJT05fU0lHTkFMfSIKCiAgICAgICAgaWYgaXNVaW50OCAiJHtleGl0Q29kZX0iICY+L2Rldi9udWxsOyB0aGVuCiAgICAgICAgICAgIGV4aXQgJHtleGl0Q29kZX07CiAgICAgICAgZWxzZQogICAgICAgICAgICBwcmludGYgIiVzIiAiQmFkIGV4aXQgY29kZSAke19fRVhFQ1NUVUJCSU5HX0VYSVRfQ09ERX0gKHRyaW1tZWQ6JHtleGl0Q29kZX0pIiB8IGNhdCAxPiYyCiAgICAgICAgICAgIGV4aXQgMjU1CiAgICAgICAgZmk7CiAgICBlbHNlCiAgICAgICAgaW52b2NhdGlvbklkPSIkKG5ld0ludm9jYXRpb25JZCkiCiAgICAgICAgc2VuZEludm9jYXRpb25SZXF1ZXN0ICIke2ludm9jYXRpb25JZH0iICIkQCIKICAgICAgICBnZXRUaGVuRG9EeW5hbWljRXhlY091dGNvbWUgIiR7aW52b2NhdGlvbklkfSIgIiRAIgogICAgZmkKCmZp"
 	return base64.StdEncoding.DecodeString(execStubBase64)
 }
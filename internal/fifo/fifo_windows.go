@@ -194,8 +194,9 @@ func OpenFifoFs(ctx context.Context, fn string, flag int, perm os.FileMode) (io.
 	return &fifo, nil
 }
 
+// MkfifoFs creates the directory holding the message files of the fifo.
 func MkfifoFs(path string, mode uint32) (err error) {
-	return nil
+	return os.MkdirAll(path, os.FileMode(mode))
 }
 
 func NewFifoNamesForFs(
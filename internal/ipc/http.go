@@ -0,0 +1,141 @@
+// Copyright 2020 The Execstub Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipc
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"log"
+	"net"
+	"net/http"
+
+	"github.com/congop/execstub/pkg/comproto"
+	"github.com/pkg/errors"
+)
+
+// NewHTTPEndpointForIpc returns a listener bound to a free 127.0.0.1 port and a new random token
+// to authenticate the invocations with.
+// The listener is to be served by NewStubbingHTTPComChannel(..), so that the address written into
+// the config cannot be taken by another process in between; close it if no com channel is created.
+func NewHTTPEndpointForIpc() (listener net.Listener, token string, err error) {
+	tokenBytes := make([]byte, 16)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return nil, "", errors.Wrap(err, "fail to generate http token")
+	}
+	listener, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, "", errors.Wrap(err, "fail to listen to a free port on 127.0.0.1")
+	}
+	return listener, hex.EncodeToString(tokenBytes), nil
+}
+
+// NewStubbingHTTPComChannel creates a new com channel serving the invocations through
+// an http server on the given listener (see NewHTTPEndpointForIpc).
+// Each invocation posts its request to comproto.HTTPInvocationPath and receives its outcome
+// in the response; invocations which are not authenticated by the token are rejected.
+// The channel logs using logf; log.Printf is used if logf is nil.
+func NewStubbingHTTPComChannel(listener net.Listener, token string, logf Logf) (comChannel *StubbingComChannel, err error) {
+	if logf == nil {
+		logf = log.Printf
+	}
+	if listener == nil {
+		return nil, errors.New("Could not serve http for stubber: no listener")
+	}
+	address := listener.Addr().String()
+	comChannel = &StubbingComChannel{
+		HTTPAddress:      address,
+		listener:         listener,
+		httpToken:        token,
+		httpOutcomes:     make(map[string]chan *comproto.ExecOutcome),
+		StubRequestChan:  make(chan *Invocation, 8),
+		ExecResponseChan: make(chan *InvocationOutcome, 8),
+		logf:             logf,
+	}
+	comChannel.httpServer = &http.Server{Handler: http.HandlerFunc(comChannel.serveHTTPInvocation)}
+
+	comChannel.wg.Add(2)
+	go comChannel.serveResponses()
+	go func() {
+		defer comChannel.wg.Done()
+		err := comChannel.httpServer.Serve(listener)
+		comChannel.logf("Stop serving http address:%s, err:%v", address, err)
+	}()
+
+	return comChannel, nil
+}
+
+// serveHTTPInvocation enqueues the posted request and responds with its outcome.
+func (comChannel *StubbingComChannel) serveHTTPInvocation(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != comproto.HTTPInvocationPath {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	wantAuthorization := comproto.HTTPAuthorizationHeaderValue(comChannel.httpToken)
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(wantAuthorization)) != 1 {
+		comChannel.logf("Rejected unauthenticated invocation from:%s", r.RemoteAddr)
+		http.Error(w, "missing or invalid token", http.StatusUnauthorized)
+		return
+	}
+	req, format, err := comproto.StubRequestDecoderFuncWithFormat(r.Body)
+	if err != nil {
+		http.Error(w, "fail to decode request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	invocationID := comproto.NewInvocationID()
+	outcomeChan := make(chan *comproto.ExecOutcome, 1)
+	comChannel.httpOutcomesMutex.Lock()
+	comChannel.httpOutcomes[invocationID] = outcomeChan
+	comChannel.httpOutcomesMutex.Unlock()
+	defer comChannel.takeHTTPOutcomeChan(invocationID)
+	comChannel.watchInvocationGone(invocationID, r.Context().Done())
+	defer comChannel.takeInvocationGone(invocationID)
+	comChannel.logf("Invocation from http:%s", invocationID)
+
+	if err := comChannel.enqueueRequest(&Invocation{ID: invocationID, Request: req}); err != nil {
+		http.Error(w, "fail to enqueue request: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	select {
+	case outcome := <-outcomeChan:
+		err = comproto.ExecOutcomeEncoderFuncFor(w, format)(outcome)
+		comChannel.logf("Stubbing ExecOutcome send through http resp=%#v err:%v", outcome, err)
+	case <-r.Context().Done():
+		comChannel.logf("Invocation:%s gone before getting its outcome, err:%v", invocationID, r.Context().Err())
+	}
+}
+
+func (comChannel *StubbingComChannel) takeHTTPOutcomeChan(invocationID string) chan *comproto.ExecOutcome {
+	comChannel.httpOutcomesMutex.Lock()
+	defer comChannel.httpOutcomesMutex.Unlock()
+	outcomeChan := comChannel.httpOutcomes[invocationID]
+	delete(comChannel.httpOutcomes, invocationID)
+	return outcomeChan
+}
+
+func (comChannel *StubbingComChannel) sendResponseThroughHTTP(response *InvocationOutcome) error {
+	outcomeChan := comChannel.takeHTTPOutcomeChan(response.InvocationID)
+	if outcomeChan == nil {
+		return errors.Errorf("no http request awaiting the outcome of invocation:%s", response.InvocationID)
+	}
+	// buffered, so that it does not block even if the http request is gone
+	outcomeChan <- response.Outcome
+	return nil
+}
@@ -0,0 +1,94 @@
+// Copyright 2020 The Execstub Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipc
+
+import (
+	"context"
+	"time"
+
+	"github.com/congop/execstub/pkg/comproto"
+)
+
+// processPollInterval is the interval at which the process of an invocation
+// through the named pipes is checked to still exist.
+const processPollInterval = 100 * time.Millisecond
+
+// InvocationContext returns a context derived from parent, which is cancelled as soon as
+// the invocation having the given id cannot receive its outcome anymore:
+// its connection is gone for the unix domain socket and http, its process does not exist
+// anymore for the named pipes.
+// For the named pipes this is best-effort: the process is looked up by the pid found in the
+// invocation id, so a pid reused by another process keeps the invocation alive until its deadline.
+// cancel must be called once the outcome of the invocation has been enqueued.
+func (comChannel *StubbingComChannel) InvocationContext(
+	parent context.Context,
+	invocationID string,
+) (ctx context.Context, cancel context.CancelFunc) {
+	ctx, cancel = context.WithCancel(parent)
+	if gone := comChannel.takeInvocationGone(invocationID); gone != nil {
+		go func() {
+			select {
+			case <-gone:
+				comChannel.logf("Invocation:%s gone, cancelling its context", invocationID)
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		return ctx, cancel
+	}
+	if comChannel.StubberPipePath == "" {
+		return ctx, cancel
+	}
+	pid, err := comproto.InvocationProcessID(invocationID)
+	if err != nil {
+		comChannel.logf("Not watching process of invocation:%s, err:%v", invocationID, err)
+		return ctx, cancel
+	}
+	go func() {
+		ticker := time.NewTicker(processPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if !processExists(pid) {
+					comChannel.logf("Process:%d of invocation:%s gone, cancelling its context", pid, invocationID)
+					cancel()
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ctx, cancel
+}
+
+// watchInvocationGone registers the channel closed once the invocation having the given id is gone.
+func (comChannel *StubbingComChannel) watchInvocationGone(invocationID string, gone <-chan struct{}) {
+	comChannel.invocationsGoneMutex.Lock()
+	defer comChannel.invocationsGoneMutex.Unlock()
+	if comChannel.invocationsGone == nil {
+		comChannel.invocationsGone = make(map[string]<-chan struct{})
+	}
+	comChannel.invocationsGone[invocationID] = gone
+}
+
+func (comChannel *StubbingComChannel) takeInvocationGone(invocationID string) <-chan struct{} {
+	comChannel.invocationsGoneMutex.Lock()
+	defer comChannel.invocationsGoneMutex.Unlock()
+	gone := comChannel.invocationsGone[invocationID]
+	delete(comChannel.invocationsGone, invocationID)
+	return gone
+}
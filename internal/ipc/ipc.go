@@ -18,7 +18,11 @@ import (
 	"context"
 	"io"
 	"log"
+	"math"
+	"net"
+	"net/http"
 	"os"
+	"path/filepath"
 	"sync"
 	"syscall"
 	"time"
@@ -31,23 +35,80 @@ import (
 // ErrChannelClosed used to signal that the channel has been closed.
 var ErrChannelClosed = errors.New("Channel is closed")
 
+// responseTimeout is the maximum time to wait for an invocation to read its outcome.
+const responseTimeout = 5 * time.Second
+
+// Logf is the printf-like function the com channel logs with.
+type Logf func(format string, v ...interface{})
+
+// Invocation is a stub request together with the id of the invocation it originates from.
+type Invocation struct {
+	ID      string
+	Request *comproto.StubRequest
+	// Received is when the request has been received, set when it is enqueued;
+	// the stub executable waits for its outcome from about then on.
+	Received time.Time
+}
+
+// InvocationOutcome is an outcome to send back to the invocation having the given id.
+type InvocationOutcome struct {
+	InvocationID string
+	Outcome      *comproto.ExecOutcome
+}
+
 // StubbingComChannel enable inter process communication between test and test helper process.
 type StubbingComChannel struct {
 	StubberPipePath string
 	stubberPipeRwc  io.ReadWriteCloser
 
-	StubRequestChan       chan *comproto.StubRequest
+	StubRequestChan       chan *Invocation
 	StubRequestChanMutex  sync.Mutex
-	ExecResponseChan      chan *comproto.ExecOutcome
+	ExecResponseChan      chan *InvocationOutcome
 	ExecResponseChanMutex sync.Mutex
 
+	// TestProcessHelperPipePath is the base path of the named pipes dedicated to the invocations.
 	TestProcessHelperPipePath string
-	testProcessHelperPipeRwc  io.ReadWriteCloser
 
-	wg sync.WaitGroup
+	// SocketPath is the unix domain socket served instead of the named pipes; "" if not used.
+	SocketPath string
+	// listener is the listener of the unix domain socket respectively of the http server; nil if not used.
+	listener net.Listener
+	// socketConns holds the connections of the invocations awaiting their outcome by invocation id
+	socketConns      map[string]net.Conn
+	socketConnsMutex sync.Mutex
+	// socketReadTimeout is the maximum time to wait for an invocation to send its request through the socket
+	socketReadTimeout time.Duration
+
+	// HTTPAddress is the 127.0.0.1 address of the http server served instead of the named pipes; "" if not used.
+	HTTPAddress string
+	httpToken   string
+	httpServer  *http.Server
+	// httpOutcomes holds the channels the http requests of the invocations await their outcome from
+	httpOutcomes      map[string]chan *comproto.ExecOutcome
+	httpOutcomesMutex sync.Mutex
+	// invocationFormats holds, by invocation id, the wire format the outcome of an invocation
+	// is sent back with, the one of its request, because the bash stub executable only speaks csv
+	invocationFormats      map[string]comproto.WireFormat
+	invocationFormatsMutex sync.Mutex
+	// invocationsGone holds, by invocation id, the channels closed once the connection of the invocation is gone;
+	// taken by InvocationContext
+	invocationsGone      map[string]<-chan struct{}
+	invocationsGoneMutex sync.Mutex
+
+	// closed tells whether StubRequestChan has been closed, guarded by StubRequestChanMutex
+	closed bool
+	// requestsDone is closed when StubRequestChan is being closed, guarded by StubRequestChanMutex
+	requestsDone chan struct{}
+	// enqueuing tracks the enqueueRequest calls, which must be over before StubRequestChan is closed
+	enqueuing sync.WaitGroup
+	// responsesClosed tells whether ExecResponseChan has been closed, guarded by ExecResponseChanMutex
+	responsesClosed bool
+
+	wg   sync.WaitGroup
+	logf Logf
 }
 
-// CleanUp performs clean up of channels and named pipes
+// CleanUp performs clean up of channels, named pipes and socket
 func (comChannel *StubbingComChannel) CleanUp() {
 	comChannel.Close()
 	if comChannel.StubberPipePath != "" {
@@ -55,36 +116,120 @@ func (comChannel *StubbingComChannel) CleanUp() {
 		comChannel.StubberPipePath = ""
 	}
 	if comChannel.TestProcessHelperPipePath != "" {
-		os.Remove(comChannel.TestProcessHelperPipePath)
+		os.RemoveAll(comChannel.TestProcessHelperPipePath)
 		comChannel.TestProcessHelperPipePath = ""
 	}
+	if comChannel.SocketPath != "" {
+		os.RemoveAll(filepath.Dir(comChannel.SocketPath))
+		comChannel.SocketPath = ""
+	}
 }
 
-func (comChannel *StubbingComChannel) enqueueRequest(req *comproto.StubRequest) error {
+// enqueueRequest queues the request for the stubber; the request is dropped with ErrChannelClosed
+// if the channel is or gets closed while waiting for room in StubRequestChan.
+// StubRequestChanMutex is not held while waiting, so that closing the channel is not blocked.
+func (comChannel *StubbingComChannel) enqueueRequest(req *Invocation) error {
 	comChannel.StubRequestChanMutex.Lock()
-	defer comChannel.StubRequestChanMutex.Unlock()
-	if comChannel.StubRequestChan == nil {
+	if comChannel.StubRequestChan == nil || comChannel.closed {
+		comChannel.StubRequestChanMutex.Unlock()
 		return ErrChannelClosed
 	}
-	comChannel.StubRequestChan <- req
-	log.Printf("Stubbing resquest queued:%#v", req)
-	return nil
+	requests, done := comChannel.StubRequestChan, comChannel.requestsDoneLocked()
+	comChannel.enqueuing.Add(1)
+	comChannel.StubRequestChanMutex.Unlock()
+	defer comChannel.enqueuing.Done()
+
+	if req.Received.IsZero() {
+		req.Received = time.Now()
+	}
+	select {
+	case requests <- req:
+		comChannel.logf("Stubbing resquest queued:%#v", req)
+		return nil
+	case <-done:
+		return ErrChannelClosed
+	}
+}
+
+// requestsDoneLocked returns the channel closed once StubRequestChan is being closed;
+// StubRequestChanMutex must be held.
+func (comChannel *StubbingComChannel) requestsDoneLocked() chan struct{} {
+	if comChannel.requestsDone == nil {
+		comChannel.requestsDone = make(chan struct{})
+	}
+	return comChannel.requestsDone
 }
 
-func (comChannel *StubbingComChannel) EnqueueRespnse(resp *comproto.ExecOutcome) error {
+func (comChannel *StubbingComChannel) EnqueueRespnse(invocationID string, outcome *comproto.ExecOutcome) error {
+	resp := &InvocationOutcome{InvocationID: invocationID, Outcome: outcome}
 	comChannel.ExecResponseChanMutex.Lock()
 	defer comChannel.ExecResponseChanMutex.Unlock()
-	if comChannel.ExecResponseChan == nil {
+	if comChannel.ExecResponseChan == nil || comChannel.responsesClosed {
 		return ErrChannelClosed
 	}
 	comChannel.ExecResponseChan <- resp
-	log.Printf("Response queued:%#v", resp)
+	comChannel.logf("Response queued:%#v", resp)
 	return nil
 }
 
+// recordInvocationFormat records the wire format of the request of the invocation,
+// which its outcome is sent back with.
+func (comChannel *StubbingComChannel) recordInvocationFormat(invocationID string, format comproto.WireFormat) {
+	comChannel.invocationFormatsMutex.Lock()
+	defer comChannel.invocationFormatsMutex.Unlock()
+	if comChannel.invocationFormats == nil {
+		comChannel.invocationFormats = make(map[string]comproto.WireFormat)
+	}
+	comChannel.invocationFormats[invocationID] = format
+}
+
+// takeInvocationFormat returns the wire format of the request of the invocation and forgets it;
+// csv, which is understood by every stub executable, if not known.
+func (comChannel *StubbingComChannel) takeInvocationFormat(invocationID string) comproto.WireFormat {
+	comChannel.invocationFormatsMutex.Lock()
+	defer comChannel.invocationFormatsMutex.Unlock()
+	format, found := comChannel.invocationFormats[invocationID]
+	if !found {
+		return comproto.WireFormatCSV
+	}
+	delete(comChannel.invocationFormats, invocationID)
+	return format
+}
+
+// forgetInvocationFormats forgets the wire formats of the invocations which have not been answered.
+func (comChannel *StubbingComChannel) forgetInvocationFormats() {
+	comChannel.invocationFormatsMutex.Lock()
+	defer comChannel.invocationFormatsMutex.Unlock()
+	comChannel.invocationFormats = nil
+}
+
+// rejectIncompatibleInvocation sends a protocol version mismatch back to the invocation,
+// so that the stub executable fails with it instead of timing out waiting for an outcome.
+// It returns false if the error is not a protocol version mismatch.
+func (comChannel *StubbingComChannel) rejectIncompatibleInvocation(invocationID string, err error) bool {
+	if !comproto.IsIncompatibleVersionError(err) {
+		return false
+	}
+	outcome := &comproto.ExecOutcome{InternalErrTxt: err.Error(), ExitCode: math.MaxUint8}
+	if errq := comChannel.EnqueueRespnse(invocationID, outcome); errq != nil {
+		comChannel.logf("Failed to reject incompatible invocation:%s, err:%v", invocationID, errq)
+	}
+	return true
+}
+
+// closeStubResquestChan closes StubRequestChan once the pending enqueueRequest calls have given up.
 func (comChannel *StubbingComChannel) closeStubResquestChan() {
 	comChannel.StubRequestChanMutex.Lock()
-	defer comChannel.StubRequestChanMutex.Unlock()
+	if comChannel.closed {
+		comChannel.StubRequestChanMutex.Unlock()
+		return
+	}
+	comChannel.closed = true
+	close(comChannel.requestsDoneLocked())
+	comChannel.StubRequestChanMutex.Unlock()
+
+	// no enqueuing can start any more, the pending ones return on requestsDone
+	comChannel.enqueuing.Wait()
 	if comChannel.StubRequestChan != nil {
 		close(comChannel.StubRequestChan)
 	}
@@ -93,12 +238,13 @@ func (comChannel *StubbingComChannel) closeStubResquestChan() {
 func (comChannel *StubbingComChannel) closeExecResponseChan() {
 	comChannel.ExecResponseChanMutex.Lock()
 	defer comChannel.ExecResponseChanMutex.Unlock()
-	if comChannel.ExecResponseChan != nil {
+	if comChannel.ExecResponseChan != nil && !comChannel.responsesClosed {
 		close(comChannel.ExecResponseChan)
 	}
+	comChannel.responsesClosed = true
 }
 
-func waitTimeout(wg *sync.WaitGroup, timeout time.Duration, timeoutMsg string) {
+func waitTimeout(wg *sync.WaitGroup, timeout time.Duration, timeoutMsg string, logf Logf) {
 	c := make(chan struct{})
 	go func() {
 		defer close(c)
@@ -110,49 +256,56 @@ func waitTimeout(wg *sync.WaitGroup, timeout time.Duration, timeoutMsg string) {
 	case <-c:
 		return
 	case <-time.After(timeout):
-		log.Print(timeoutMsg)
+		logf("%s", timeoutMsg)
 		return
 	}
 }
 
 // Close closes all communication mechanisms this com-channel is using.
 func (comChannel *StubbingComChannel) Close() {
-	closeReq := comproto.StopOperationRequest()
-	_ = comproto.WriteStubRequestToNamedPipe(comChannel.StubberPipePath, *closeReq, time.Second)
+	if comChannel.httpServer != nil {
+		// closing the server also closes the connections of the pending invocations
+		comChannel.httpServer.Close()
+	} else if comChannel.listener != nil {
+		// closing the listener stops accepting, no stop request is needed
+		comChannel.listener.Close()
+	} else {
+		_ = comproto.WriteInvocationIDToNamedPipe(
+			comChannel.StubberPipePath, comproto.StopOperationInvocationID, time.Second)
+	}
 
 	comChannel.closeStubResquestChan()
 	comChannel.closeExecResponseChan()
 
-	waitTimeout(&comChannel.wg, 2*time.Second, "Timeout waiting channel activities to stop")
+	waitTimeout(&comChannel.wg, 2*time.Second, "Timeout waiting channel activities to stop", comChannel.logf)
 
 	if comChannel.stubberPipeRwc != nil {
 		comChannel.stubberPipeRwc.Close()
 	}
-	if comChannel.testProcessHelperPipeRwc != nil {
-		comChannel.testProcessHelperPipeRwc.Close()
-	}
+	comChannel.closeSocketConns()
+	comChannel.forgetInvocationFormats()
 }
 
 // NewStubbingComChannel create a new com channel given the executable path.
-func NewStubbingComChannel(stubberPipePath string, testProcessHelperPipePath string) (comChannel *StubbingComChannel, err error) {
+// The channel logs using logf; log.Printf is used if logf is nil.
+func NewStubbingComChannel(
+	stubberPipePath string, testProcessHelperPipePath string, logf Logf,
+) (comChannel *StubbingComChannel, err error) {
+	if logf == nil {
+		logf = log.Printf
+	}
 	if err := fifo.Mkfifo(stubberPipePath, 0770); nil != err {
 		err = errors.Wrapf(
 			err, "Could not create named pipe for stubber at: %s", stubberPipePath)
 		return nil, err
 	}
 
-	if err := fifo.Mkfifo(testProcessHelperPipePath, 0770); nil != err {
-		err = errors.Wrapf(
-			err,
-			"Could not create named pipe for test process helper at: %s",
-			stubberPipePath)
-		return nil, err
-	}
 	comChannel = &StubbingComChannel{
 		StubberPipePath:           stubberPipePath,
 		TestProcessHelperPipePath: testProcessHelperPipePath,
-		StubRequestChan:           make(chan *comproto.StubRequest, 8),
-		ExecResponseChan:          make(chan *comproto.ExecOutcome, 8),
+		StubRequestChan:           make(chan *Invocation, 8),
+		ExecResponseChan:          make(chan *InvocationOutcome, 8),
+		logf:                      logf,
 	}
 	var writer io.ReadWriteCloser
 	if writer, err = fifo.OpenFifo(
@@ -167,57 +320,40 @@ func NewStubbingComChannel(stubberPipePath string, testProcessHelperPipePath str
 		return nil, err
 	}
 	comChannel.stubberPipeRwc = writer
-	// syscall.O_WRONLY
-	if writer, err =
-		fifo.OpenFifo(
-			context.Background(),
-			comChannel.TestProcessHelperPipePath,
-			syscall.O_RDWR, os.ModeNamedPipe); err != nil {
-		err = errors.Wrapf(err,
-			"error getting writer for pipe[testProcessHelperPipePath]: %s",
-			comChannel.TestProcessHelperPipePath)
-		comChannel.CleanUp()
-		return nil, err
-	}
-	comChannel.testProcessHelperPipeRwc = writer
 
 	// hiding the named pipes behinds chan(s) so that client are not required
 	// to deal with the channel specific technology
 	comChannel.wg.Add(2)
-	go func() {
-		defer comChannel.wg.Done()
-		for {
-			resp := <-comChannel.ExecResponseChan
-			if resp == nil {
-				return
-			}
-			err := comChannel.sendResponseToTestProcess(resp)
-			if err != nil {
-				log.Printf(
-					"Error sending response to test process: "+
-						"\n\tresp=%v \n\terr:%s \n\terr:%##v",
-					resp, err, err)
-			}
-		}
-	}()
+	go comChannel.serveResponses()
 
 	go func() {
 		defer comChannel.wg.Done()
+		idReader := comproto.NewInvocationIDReader(comChannel.stubberPipeRwc)
 		for {
-			req, errd := comproto.StubRequestDecoderFunc(comChannel.stubberPipeRwc)
-			log.Printf("StubRequest from stubberPipe:%#v", req)
+			invocationID, errd := idReader.Next()
+			comChannel.logf("Invocation from stubberPipe:%s", invocationID)
 			if nil != errd {
-				log.Printf(
+				comChannel.logf(
 					"Failed to read from test process pipe:%s, err:%v",
 					stubberPipePath, errd)
 				return
 			}
-			if req.IsRequestingStop() {
-				log.Printf("Stop reading stubberPipeRwc on reqest:%#v", req)
+			if invocationID == comproto.StopOperationInvocationID {
+				comChannel.logf("Stop reading stubberPipeRwc on invocation:%s", invocationID)
 				return
 			}
-			errs := comChannel.enqueueRequest(req)
+			req, format, errd := comproto.ReadInvocationRequestWithFormat(testProcessHelperPipePath, invocationID)
+			comChannel.recordInvocationFormat(invocationID, format)
+			if nil != errd {
+				comChannel.logf("Failed to read request of invocation:%s, err:%v", invocationID, errd)
+				if !comChannel.rejectIncompatibleInvocation(invocationID, errd) {
+					comChannel.takeInvocationFormat(invocationID)
+				}
+				continue
+			}
+			errs := comChannel.enqueueRequest(&Invocation{ID: invocationID, Request: req})
 			if errs != nil {
+				comChannel.takeInvocationFormat(invocationID)
 				return
 			}
 		}
@@ -226,9 +362,40 @@ func NewStubbingComChannel(stubberPipePath string, testProcessHelperPipePath str
 	return comChannel, nil
 }
 
-func (comChannel *StubbingComChannel) sendResponseToTestProcess(response *comproto.ExecOutcome) error {
-	execEncoderFunc := comproto.ExecOutcomeEncoderFunc(comChannel.testProcessHelperPipeRwc)
-	err := execEncoderFunc(response)
-	log.Printf("Stubbing ExecOutcome send to test process resp=%#v err:%v", response, err)
+// serveResponses sends the queued responses to the invocations until ExecResponseChan is closed.
+func (comChannel *StubbingComChannel) serveResponses() {
+	defer comChannel.wg.Done()
+	for {
+		resp := <-comChannel.ExecResponseChan
+		if resp == nil {
+			return
+		}
+		// each invocation has its own pipe respectively connection, so a slow or gone reader
+		// must not delay the responses to the other invocations
+		comChannel.wg.Add(1)
+		go func() {
+			defer comChannel.wg.Done()
+			err := comChannel.sendResponseToTestProcess(resp)
+			if err != nil {
+				comChannel.logf(
+					"Error sending response to test process: "+
+						"\n\tresp=%v \n\terr:%s \n\terr:%##v",
+					resp, err, err)
+			}
+		}()
+	}
+}
+
+func (comChannel *StubbingComChannel) sendResponseToTestProcess(response *InvocationOutcome) error {
+	if comChannel.httpServer != nil {
+		return comChannel.sendResponseThroughHTTP(response)
+	}
+	if comChannel.listener != nil {
+		return comChannel.sendResponseThroughSocket(response)
+	}
+	err := comproto.WriteInvocationOutcomeFor(
+		comChannel.TestProcessHelperPipePath, response.InvocationID, response.Outcome,
+		comChannel.takeInvocationFormat(response.InvocationID), responseTimeout)
+	comChannel.logf("Stubbing ExecOutcome send to test process resp=%#v err:%v", response, err)
 	return err
 }
@@ -15,8 +15,15 @@
 package ipc
 
 import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
 	"math"
+	"net"
+	"net/http"
 	"os"
+	"syscall"
 	"testing"
 	"time"
 
@@ -30,7 +37,7 @@ func Test_newPipeComChannel(t *testing.T) {
 	timeout := time.Duration(10 * time.Second)
 	stubberPipePath, testProcessHelperPipePath := fifo.NewFifoNamesForIpc(testExecPath)
 	///
-	comChannel, err := NewStubbingComChannel(stubberPipePath, testProcessHelperPipePath)
+	comChannel, err := NewStubbingComChannel(stubberPipePath, testProcessHelperPipePath, nil)
 	t.Cleanup(comChannel.CleanUp)
 	///
 	if err != nil {
@@ -43,7 +50,17 @@ func Test_newPipeComChannel(t *testing.T) {
 		Args:    []string{"arg1", "argb"},
 	}
 
-	err = comproto.WriteStubRequestToNamedPipe(comChannel.StubberPipePath, req, timeout)
+	invocationID := comproto.NewInvocationID()
+	respPipePath := comproto.InvocationResponsePipePath(comChannel.TestProcessHelperPipePath, invocationID)
+	if err := fifo.Mkfifo(respPipePath, 0770); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(respPipePath)
+	err = comproto.WriteInvocationRequest(comChannel.TestProcessHelperPipePath, invocationID, req)
+	if err != nil {
+		t.Fatalf("WriteInvocationRequest failed: %##v", err)
+	}
+	err = comproto.WriteInvocationIDToNamedPipe(comChannel.StubberPipePath, invocationID, timeout)
 	if err != nil {
 		t.Fatalf("sendRequestStubRequest failed: %##v", err)
 	}
@@ -52,14 +69,15 @@ func Test_newPipeComChannel(t *testing.T) {
 	timeoutAufterFunc := time.AfterFunc(time.Duration(timeout), func() { timeoutChan <- true })
 	defer timeoutAufterFunc.Stop()
 
-	var reqRead *comproto.StubRequest
+	var invocationRead *Invocation
 	select {
-	case reqRead = <-comChannel.StubRequestChan:
+	case invocationRead = <-comChannel.StubRequestChan:
 	case <-timeoutChan:
 		t.Fatalf("Timeout could not read request from pipe")
 	}
 
-	assert.Equal(t, reqRead, &req)
+	assert.False(t, invocationRead.Received.IsZero(), "reception time should have been set")
+	assert.Equal(t, &Invocation{ID: invocationID, Request: &req, Received: invocationRead.Received}, invocationRead)
 
 	response := comproto.ExecOutcome{
 		InternalErrTxt: "errtxt1",
@@ -69,10 +87,406 @@ func Test_newPipeComChannel(t *testing.T) {
 		Stdout:         "stdout2",
 	}
 
-	comChannel.ExecResponseChan <- &response
-	stubbingOutcome, err := comproto.ReadStubbingResponseFromNamedPipe(comChannel.TestProcessHelperPipePath, time.Duration(10*time.Second))
+	err = comChannel.EnqueueRespnse(invocationID, &response)
+	assert.NoError(t, err)
+	stubbingOutcome, err := comproto.ReadStubbingResponseFromNamedPipe(respPipePath, time.Duration(10*time.Second))
 	if err != nil {
 		t.Fatalf("The outcome send through chan should have been read error free but had error(%s):%##v", err, err)
 	}
 	assert.Equal(t, *stubbingOutcome, response)
 }
+
+func Test_pipeComChannelAnswersInTheWireFormatOfTheRequest(t *testing.T) {
+	timeout := time.Duration(10 * time.Second)
+	stubberPipePath, testProcessHelperPipePath := fifo.NewFifoNamesForIpc(os.Args[0])
+	comChannel, err := NewStubbingComChannel(stubberPipePath, testProcessHelperPipePath, nil)
+	t.Cleanup(comChannel.CleanUp)
+	if err != nil {
+		t.Fatalf("New Com Channel should not have failed:%v", err)
+	}
+	req := comproto.StubRequest{CmdName: "BlablaExe", Key: "BlaBla_fffzzz_666444333"}
+	response := comproto.ExecOutcome{ExitCode: 3, Stdout: "stdout2"}
+
+	for _, format := range []comproto.WireFormat{comproto.WireFormatCSV, comproto.WireFormatStructured} {
+		t.Run(format.String(), func(t *testing.T) {
+			invocationID := comproto.NewInvocationID()
+			respPipePath := comproto.InvocationResponsePipePath(comChannel.TestProcessHelperPipePath, invocationID)
+			if err := fifo.Mkfifo(respPipePath, 0770); err != nil {
+				t.Fatal(err)
+			}
+			defer os.Remove(respPipePath)
+			// as written by the bash stub executable respectively by the go based ones
+			var reqBuf bytes.Buffer
+			if err := comproto.StubRequestEncoderFuncFor(&reqBuf, format)(&req); err != nil {
+				t.Fatal(err)
+			}
+			reqFilePath := comproto.InvocationRequestFilePath(comChannel.TestProcessHelperPipePath, invocationID)
+			if err := ioutil.WriteFile(reqFilePath, reqBuf.Bytes(), 0600); err != nil {
+				t.Fatal(err)
+			}
+			err = comproto.WriteInvocationIDToNamedPipe(comChannel.StubberPipePath, invocationID, timeout)
+			if err != nil {
+				t.Fatalf("sendRequestStubRequest failed: %##v", err)
+			}
+			select {
+			case invocationRead := <-comChannel.StubRequestChan:
+				assert.NoError(t, comChannel.EnqueueRespnse(invocationRead.ID, &response))
+			case <-time.After(timeout):
+				t.Fatalf("Timeout could not read request from pipe")
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			reader, err := fifo.OpenFifo(ctx, respPipePath, syscall.O_RDONLY, os.ModeNamedPipe)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer reader.Close()
+			gotBytes, err := ioutil.ReadAll(reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var wantBuf bytes.Buffer
+			assert.NoError(t, comproto.ExecOutcomeEncoderFuncFor(&wantBuf, format)(&response))
+			assert.Equal(t, wantBuf.String(), string(gotBytes))
+		})
+	}
+}
+
+func Test_newSocketComChannel(t *testing.T) {
+	timeout := time.Duration(10 * time.Second)
+	socketPath, err := NewSocketPathForIpc("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	comChannel, err := NewStubbingSocketComChannel(socketPath, 0, nil)
+	if err != nil {
+		t.Fatalf("New Com Channel should not have failed:%v", err)
+	}
+	t.Cleanup(comChannel.CleanUp)
+
+	req := comproto.StubRequest{
+		CmdName: "BlablaExe",
+		Key:     "BlaBla_fffzzz_666444333",
+		Args:    []string{"arg1", "argb"},
+	}
+	response := comproto.ExecOutcome{
+		ExitCode: 3,
+		Key:      "BlaBla_fffzzz_666444333",
+		Stderr:   "stderr0",
+		Stdout:   "stdout2",
+	}
+	outcomeChan := make(chan interface{}, 1)
+	go func() {
+		outcome, err := comproto.RequestOutcomeThroughSocket(socketPath, req, timeout)
+		if err != nil {
+			outcomeChan <- err
+			return
+		}
+		outcomeChan <- *outcome
+	}()
+
+	var invocationRead *Invocation
+	select {
+	case invocationRead = <-comChannel.StubRequestChan:
+	case <-time.After(timeout):
+		t.Fatalf("Timeout could not read request from socket")
+	}
+	assert.Equal(t, &req, invocationRead.Request)
+
+	err = comChannel.EnqueueRespnse(invocationRead.ID, &response)
+	assert.NoError(t, err)
+	assert.Equal(t, response, <-outcomeChan)
+
+	comChannel.Close()
+	_, err = comproto.RequestOutcomeThroughSocket(socketPath, req, timeout)
+	assert.Error(t, err, "socket should not be served anymore after close")
+	assert.Equal(t, ErrChannelClosed, comChannel.EnqueueRespnse(invocationRead.ID, &response))
+}
+
+func Test_socketComChannelRejectsIncompatibleProtocolVersion(t *testing.T) {
+	socketPath, err := NewSocketPathForIpc("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	comChannel, err := NewStubbingSocketComChannel(socketPath, 0, nil)
+	if err != nil {
+		t.Fatalf("New Com Channel should not have failed:%v", err)
+	}
+	t.Cleanup(comChannel.CleanUp)
+
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	assert.NoError(t, conn.SetDeadline(time.Now().Add(10*time.Second)))
+	// a request as encoded by a stub executable of a newer protocol version
+	_, err = io.WriteString(conn, "execstub-v4,QmxhQmxhXzY2Ng==,,,,\n")
+	assert.NoError(t, err)
+
+	outcome, err := comproto.ExecOutcomeDecoderFunc(conn)
+	if assert.NoError(t, err) {
+		assert.Equal(t, uint8(math.MaxUint8), outcome.ExitCode)
+		assert.Contains(t, outcome.InternalErrTxt,
+			"incompatible execstub version: stub request uses protocol version 4")
+	}
+	select {
+	case invocation := <-comChannel.StubRequestChan:
+		t.Errorf("incompatible request should not have been enqueued: %#v", invocation)
+	default:
+	}
+}
+
+func Test_socketInvocationContextIsCancelledOnceConnectionIsGone(t *testing.T) {
+	socketPath, err := NewSocketPathForIpc("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	comChannel, err := NewStubbingSocketComChannel(socketPath, 0, nil)
+	if err != nil {
+		t.Fatalf("New Com Channel should not have failed:%v", err)
+	}
+	t.Cleanup(comChannel.CleanUp)
+
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	err = comproto.StubRequestEncoderFunc(conn)(&comproto.StubRequest{Key: "BlaBla_666", CmdName: "BlaBla"})
+	assert.NoError(t, err)
+
+	var invocation *Invocation
+	select {
+	case invocation = <-comChannel.StubRequestChan:
+	case <-time.After(10 * time.Second):
+		t.Fatal("request should have been enqueued")
+	}
+	ctx, cancel := comChannel.InvocationContext(context.Background(), invocation.ID)
+	defer cancel()
+	select {
+	case <-ctx.Done():
+		t.Fatalf("context should not be done while the invocation awaits its outcome: %v", ctx.Err())
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	conn.Close()
+	select {
+	case <-ctx.Done():
+		assert.Equal(t, context.Canceled, ctx.Err())
+	case <-time.After(10 * time.Second):
+		t.Fatal("context should have been cancelled once the connection is gone")
+	}
+}
+
+func Test_socketAndHTTPComChannelsAnswerInTheWireFormatOfTheRequest(t *testing.T) {
+	timeout := time.Duration(10 * time.Second)
+	req := comproto.StubRequest{CmdName: "BlablaExe", Key: "BlaBla_fffzzz_666444333"}
+	response := comproto.ExecOutcome{ExitCode: 3, Stdout: "stdout2"}
+
+	socketPath, err := NewSocketPathForIpc("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	socketComChannel, err := NewStubbingSocketComChannel(socketPath, 0, nil)
+	if err != nil {
+		t.Fatalf("New Com Channel should not have failed:%v", err)
+	}
+	t.Cleanup(socketComChannel.CleanUp)
+	listener, token, err := NewHTTPEndpointForIpc()
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpComChannel, err := NewStubbingHTTPComChannel(listener, token, nil)
+	if err != nil {
+		t.Fatalf("New Com Channel should not have failed:%v", err)
+	}
+	t.Cleanup(httpComChannel.CleanUp)
+
+	// the request as written by a client speaking the given format, the raw outcome as received
+	exchanges := map[string]func(t *testing.T, reqBytes []byte) []byte{
+		"socket": func(t *testing.T, reqBytes []byte) []byte {
+			conn, err := net.Dial("unix", socketPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			assert.NoError(t, conn.SetDeadline(time.Now().Add(timeout)))
+			_, err = conn.Write(reqBytes)
+			assert.NoError(t, err)
+			gotBytes, err := ioutil.ReadAll(conn)
+			assert.NoError(t, err)
+			return gotBytes
+		},
+		"http": func(t *testing.T, reqBytes []byte) []byte {
+			httpReq, err := http.NewRequest(http.MethodPost,
+				"http://"+httpComChannel.HTTPAddress+comproto.HTTPInvocationPath, bytes.NewReader(reqBytes))
+			if err != nil {
+				t.Fatal(err)
+			}
+			httpReq.Header.Set("Authorization", comproto.HTTPAuthorizationHeaderValue(token))
+			resp, err := (&http.Client{Timeout: timeout}).Do(httpReq)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			gotBytes, err := ioutil.ReadAll(resp.Body)
+			assert.NoError(t, err)
+			return gotBytes
+		},
+	}
+	comChannels := map[string]*StubbingComChannel{"socket": socketComChannel, "http": httpComChannel}
+
+	for transport, exchange := range exchanges {
+		comChannel := comChannels[transport]
+		for _, format := range []comproto.WireFormat{comproto.WireFormatCSV, comproto.WireFormatStructured} {
+			t.Run(transport+" "+format.String(), func(t *testing.T) {
+				var reqBuf bytes.Buffer
+				if err := comproto.StubRequestEncoderFuncFor(&reqBuf, format)(&req); err != nil {
+					t.Fatal(err)
+				}
+				go func() {
+					select {
+					case invocation := <-comChannel.StubRequestChan:
+						assert.NoError(t, comChannel.EnqueueRespnse(invocation.ID, &response))
+					case <-time.After(timeout):
+						t.Errorf("Timeout could not read request")
+					}
+				}()
+
+				gotBytes := exchange(t, reqBuf.Bytes())
+
+				var wantBuf bytes.Buffer
+				assert.NoError(t, comproto.ExecOutcomeEncoderFuncFor(&wantBuf, format)(&response))
+				assert.Equal(t, wantBuf.String(), string(gotBytes))
+				comChannel.invocationFormatsMutex.Lock()
+				assert.Empty(t, comChannel.invocationFormats, "formats of answered invocations should be forgotten")
+				comChannel.invocationFormatsMutex.Unlock()
+			})
+		}
+	}
+}
+
+func Test_newHTTPComChannel(t *testing.T) {
+	timeout := time.Duration(10 * time.Second)
+	listener, token, err := NewHTTPEndpointForIpc()
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	comChannel, err := NewStubbingHTTPComChannel(listener, token, nil)
+	if err != nil {
+		t.Fatalf("New Com Channel should not have failed:%v", err)
+	}
+	t.Cleanup(comChannel.CleanUp)
+
+	req := comproto.StubRequest{
+		CmdName: "BlablaExe",
+		Key:     "BlaBla_fffzzz_666444333",
+		Args:    []string{"arg1", "argb"},
+	}
+	response := comproto.ExecOutcome{
+		ExitCode: 3,
+		Key:      "BlaBla_fffzzz_666444333",
+		Stderr:   "stderr0",
+		Stdout:   "stdout2",
+	}
+
+	_, err = comproto.RequestOutcomeThroughHTTP(address, "bad-token", req, timeout)
+	if assert.Error(t, err, "invocation with bad token should have been rejected") {
+		assert.Contains(t, err.Error(), "401")
+	}
+
+	outcomeChan := make(chan interface{}, 1)
+	go func() {
+		outcome, err := comproto.RequestOutcomeThroughHTTP(address, token, req, timeout)
+		if err != nil {
+			outcomeChan <- err
+			return
+		}
+		outcomeChan <- *outcome
+	}()
+
+	var invocationRead *Invocation
+	select {
+	case invocationRead = <-comChannel.StubRequestChan:
+	case <-time.After(timeout):
+		t.Fatalf("Timeout could not read request from http")
+	}
+	assert.Equal(t, &req, invocationRead.Request)
+
+	err = comChannel.EnqueueRespnse(invocationRead.ID, &response)
+	assert.NoError(t, err)
+	assert.Equal(t, response, <-outcomeChan)
+
+	comChannel.Close()
+	_, err = comproto.RequestOutcomeThroughHTTP(address, token, req, timeout)
+	assert.Error(t, err, "http should not be served anymore after close")
+}
+
+func Test_closeIsNotBlockedByRequestsWaitingForRoom(t *testing.T) {
+	socketPath, err := NewSocketPathForIpc("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	comChannel, err := NewStubbingSocketComChannel(socketPath, 0, nil)
+	if err != nil {
+		t.Fatalf("New Com Channel should not have failed:%v", err)
+	}
+	t.Cleanup(comChannel.CleanUp)
+	for i := 0; i < cap(comChannel.StubRequestChan); i++ {
+		assert.NoError(t, comChannel.enqueueRequest(&Invocation{ID: comproto.NewInvocationID()}))
+	}
+	enqueued := make(chan error, 1)
+	go func() {
+		enqueued <- comChannel.enqueueRequest(&Invocation{ID: comproto.NewInvocationID()})
+	}()
+	select {
+	case err := <-enqueued:
+		t.Fatalf("request should wait for room in a full channel, err:%v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		comChannel.Close()
+	}()
+	select {
+	case <-closed:
+	case <-time.After(10 * time.Second):
+		t.Fatal("close should not have been blocked by the waiting request")
+	}
+	select {
+	case err := <-enqueued:
+		assert.Equal(t, ErrChannelClosed, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("waiting request should have been dropped on close")
+	}
+	assert.Equal(t, ErrChannelClosed, comChannel.enqueueRequest(&Invocation{ID: comproto.NewInvocationID()}))
+}
+
+func Test_socketComChannelReadsRequestWithinTimeout(t *testing.T) {
+	socketPath, err := NewSocketPathForIpc("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	comChannel, err := NewStubbingSocketComChannel(socketPath, 200*time.Millisecond, nil)
+	if err != nil {
+		t.Fatalf("New Com Channel should not have failed:%v", err)
+	}
+	t.Cleanup(comChannel.CleanUp)
+
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	assert.NoError(t, conn.SetReadDeadline(time.Now().Add(10*time.Second)))
+	start := time.Now()
+	// no request is sent: the connection is dropped once the configured timeout elapsed
+	_, err = conn.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+	assert.Less(t, int64(time.Since(start)), int64(responseTimeout))
+}
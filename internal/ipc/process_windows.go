@@ -0,0 +1,42 @@
+// Copyright 2020 The Execstub Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build windows
+
+package ipc
+
+import "syscall"
+
+const (
+	// processQueryLimitedInformation is the access right needed to get the exit code of a process.
+	processQueryLimitedInformation = 0x1000
+	// stillActive is the exit code of a process which has not exited yet.
+	stillActive = 259
+)
+
+// processExists tells whether the process having the given id exists and has not exited.
+// Opening the process also succeeds for an exited process whose handle is still held open
+// (e.g. by its parent), so its exit code is checked as well.
+func processExists(pid int) bool {
+	handle, err := syscall.OpenProcess(processQueryLimitedInformation, false, uint32(pid))
+	if err != nil {
+		return false
+	}
+	defer syscall.CloseHandle(handle) //nolint:errcheck
+	var exitCode uint32
+	if err := syscall.GetExitCodeProcess(handle, &exitCode); err != nil {
+		return true
+	}
+	return exitCode == stillActive
+}
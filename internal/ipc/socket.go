@@ -0,0 +1,175 @@
+// Copyright 2020 The Execstub Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipc
+
+import (
+	"io/ioutil"
+	"log"
+	"net"
+	"path/filepath"
+	"time"
+
+	"github.com/congop/execstub/pkg/comproto"
+	"github.com/pkg/errors"
+)
+
+// NewSocketPathForIpc returns the path of a new unix domain socket in the given directory.
+// The name is kept short because the path of a unix domain socket is limited to about 100 bytes.
+func NewSocketPathForIpc(dir string) (socketPath string, err error) {
+	socketDir, err := ioutil.TempDir(dir, "sock")
+	if err != nil {
+		return "", errors.Wrapf(err, "fail to create socket dir in %s", dir)
+	}
+	return filepath.Join(socketDir, "s"), nil
+}
+
+// NewStubbingSocketComChannel creates a new com channel serving the invocations through
+// a unix domain socket at the given path.
+// Each invocation has its own connection, through which it sends its request and
+// receives its outcome, so that no invocation id needs to be exchanged.
+// Reading the request of an invocation times out after the given timeout, responseTimeout if not positive.
+// The channel logs using logf; log.Printf is used if logf is nil.
+func NewStubbingSocketComChannel(
+	socketPath string, timeout time.Duration, logf Logf,
+) (comChannel *StubbingComChannel, err error) {
+	if logf == nil {
+		logf = log.Printf
+	}
+	if timeout <= 0 {
+		timeout = responseTimeout
+	}
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not listen to unix socket for stubber at: %s", socketPath)
+	}
+	comChannel = &StubbingComChannel{
+		SocketPath:        socketPath,
+		listener:          listener,
+		socketConns:       make(map[string]net.Conn),
+		socketReadTimeout: timeout,
+		StubRequestChan:   make(chan *Invocation, 8),
+		ExecResponseChan:  make(chan *InvocationOutcome, 8),
+		logf:              logf,
+	}
+
+	comChannel.wg.Add(2)
+	go comChannel.serveResponses()
+	go func() {
+		defer comChannel.wg.Done()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				comChannel.logf("Stop accepting connections to socket:%s, err:%v", socketPath, err)
+				return
+			}
+			comChannel.wg.Add(1)
+			go func() {
+				defer comChannel.wg.Done()
+				comChannel.receiveRequestThroughSocket(conn)
+			}()
+		}
+	}()
+
+	return comChannel, nil
+}
+
+// receiveRequestThroughSocket reads the request sent through the connection and enqueues it;
+// the connection is kept until the outcome of the invocation has been sent.
+func (comChannel *StubbingComChannel) receiveRequestThroughSocket(conn net.Conn) {
+	if err := conn.SetReadDeadline(time.Now().Add(comChannel.socketReadTimeout)); err != nil {
+		comChannel.logf("Failed to set read deadline of connection to socket:%s, err:%v", comChannel.SocketPath, err)
+		conn.Close()
+		return
+	}
+	req, format, err := comproto.StubRequestDecoderFuncWithFormat(conn)
+	invocationID := comproto.NewInvocationID()
+	comChannel.socketConnsMutex.Lock()
+	comChannel.socketConns[invocationID] = conn
+	comChannel.socketConnsMutex.Unlock()
+	comChannel.recordInvocationFormat(invocationID, format)
+	if err != nil {
+		comChannel.logf("Failed to read request from socket:%s, err:%v", comChannel.SocketPath, err)
+		if !comChannel.rejectIncompatibleInvocation(invocationID, err) {
+			comChannel.takeInvocationFormat(invocationID)
+			if conn := comChannel.takeSocketConn(invocationID); conn != nil {
+				conn.Close()
+			}
+		}
+		return
+	}
+	comChannel.logf("Invocation from socket:%s", invocationID)
+	comChannel.watchSocketConnGone(invocationID, conn)
+
+	if err := comChannel.enqueueRequest(&Invocation{ID: invocationID, Request: req}); err != nil {
+		comChannel.logf("Failed to enqueue request of invocation:%s, err:%v", invocationID, err)
+		comChannel.takeInvocationGone(invocationID)
+		comChannel.takeInvocationFormat(invocationID)
+		if conn := comChannel.takeSocketConn(invocationID); conn != nil {
+			conn.Close()
+		}
+	}
+}
+
+// watchSocketConnGone registers the invocation to be gone once its connection is closed.
+// The stub executable sends nothing after its request, so a read only returns
+// once the connection has been closed by either side.
+func (comChannel *StubbingComChannel) watchSocketConnGone(invocationID string, conn net.Conn) {
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		comChannel.logf("Not watching connection of invocation:%s, err:%v", invocationID, err)
+		return
+	}
+	gone := make(chan struct{})
+	comChannel.watchInvocationGone(invocationID, gone)
+	go func() {
+		defer close(gone)
+		_, _ = conn.Read(make([]byte, 1))
+	}()
+}
+
+func (comChannel *StubbingComChannel) takeSocketConn(invocationID string) net.Conn {
+	comChannel.socketConnsMutex.Lock()
+	defer comChannel.socketConnsMutex.Unlock()
+	conn := comChannel.socketConns[invocationID]
+	delete(comChannel.socketConns, invocationID)
+	return conn
+}
+
+func (comChannel *StubbingComChannel) sendResponseThroughSocket(response *InvocationOutcome) error {
+	format := comChannel.takeInvocationFormat(response.InvocationID)
+	conn := comChannel.takeSocketConn(response.InvocationID)
+	if conn == nil {
+		return errors.Errorf("no connection awaiting the outcome of invocation:%s", response.InvocationID)
+	}
+	defer conn.Close()
+	if err := conn.SetWriteDeadline(time.Now().Add(responseTimeout)); err != nil {
+		return errors.Wrapf(err, "fail to set write deadline of connection of invocation:%s", response.InvocationID)
+	}
+	err := comproto.ExecOutcomeEncoderFuncFor(conn, format)(response.Outcome)
+	comChannel.logf("Stubbing ExecOutcome send through socket resp=%#v err:%v", response, err)
+	return err
+}
+
+// closeSocketConns closes the connections of the invocations still awaiting their outcome.
+func (comChannel *StubbingComChannel) closeSocketConns() {
+	if comChannel.SocketPath == "" {
+		return
+	}
+	comChannel.socketConnsMutex.Lock()
+	defer comChannel.socketConnsMutex.Unlock()
+	for invocationID, conn := range comChannel.socketConns {
+		conn.Close()
+		delete(comChannel.socketConns, invocationID)
+	}
+}
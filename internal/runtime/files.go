@@ -70,3 +70,21 @@ func EnsureHasExecExt(fn string) string {
 	}
 	return fn + ".exe"
 }
+
+// IsExecutableFile returns true if the file described by the given info can be executed
+// when found through PATH, based on its mode or on its extension on windows.
+func IsExecutableFile(info os.FileInfo) bool {
+	if !info.Mode().IsRegular() {
+		return false
+	}
+	if !IsWindows() {
+		return info.Mode().Perm()&0111 != 0
+	}
+	nameLowerCase := strings.ToLower(info.Name())
+	for _, ext := range execExts {
+		if ext != "" && strings.HasSuffix(nameLowerCase, ext) {
+			return true
+		}
+	}
+	return false
+}
@@ -17,12 +17,15 @@ package comproto
 import (
 	"encoding/base64"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"math"
 	"os"
 	"path/filepath"
 	"runtime/debug"
 	"strconv"
 	"strings"
+	"syscall"
 	"text/template"
 	"time"
 
@@ -38,11 +41,52 @@ type CmdConfig struct {
 	TestHelperProcessMethod string
 	TxtStdout               string
 	TxtStderr               string
+	OutputScript            []OutputChunk
+	ExitDelay               time.Duration
+	HangUntilSignalled      bool
+	TerminationSignal       syscall.Signal
 	ExitCode                OptionalUint8
 	PipeStubber             string
 	PipeTestHelperProcess   string
 	Timeout                 OptionalDuration
 	DataDir                 string
+	// TimelineDir is the directory of the invocation timeline shared by all commands of a stubber
+	TimelineDir  string
+	CaptureStdin bool
+	CaptureDir   bool
+	EnvFilter    EnvFilter
+	Passthrough  bool
+	// RealExec is the path of the real executable, as discovered before
+	// stubbing; "" if not found.
+	RealExec string
+	// RealHomeEnvKey is the environment variable (e.g. JAVA_HOME) the command is discovered by
+	// when discovered by home dir; "" if discovered by PATH.
+	RealHomeEnvKey string
+	// RealHome is the value of RealHomeEnvKey before it was replaced by the stub home dir; "" if unset.
+	// It is restored for the real executable on passthrough.
+	RealHome string
+	// OutcomeTemplate if not nil is rendered by the stub executable into
+	// TxtStdout, TxtStderr and ExitCode on each execution.
+	OutcomeTemplate *OutcomeTemplate
+	// SocketPath is the unix domain socket the stubber serves the invocations through
+	// when the unix socket transport is used instead of the pipes.
+	SocketPath string
+	// HTTPAddress is the 127.0.0.1 address of the http server the stubber serves the invocations
+	// through when the http transport is used instead of the pipes.
+	HTTPAddress string
+	// HTTPToken authenticates the invocations sent to the http server of the stubber.
+	HTTPToken string
+	// TransportClient is the executable the bash stub executable delegates an invocation to
+	// when the unix socket or the http transport is used, because bash cannot connect to them,
+	// respectively when a static outcome template is used, because bash cannot render it.
+	TransportClient string
+	// ProtocolVersion is the version of the protocol the config file is written with;
+	// 0 stands for ProtocolVersion. Loading a config file of another version fails.
+	ProtocolVersion int
+
+	// binDir is the directory of the stub executable,
+	// only known if loaded from the config file.
+	binDir string
 }
 
 func assertStubKeyHasRightFormat(key string, mesg string) error {
@@ -83,6 +127,14 @@ func (cfg CmdConfig) ExitCodeTxt() string {
 	return strconv.Itoa(int(code))
 }
 
+// ProtocolVersionTxt returns the text representation of the protocol version the config is written with.
+func (cfg CmdConfig) ProtocolVersionTxt() string {
+	if cfg.ProtocolVersion == 0 {
+		return strconv.Itoa(ProtocolVersion)
+	}
+	return strconv.Itoa(cfg.ProtocolVersion)
+}
+
 // CmdConfigForCommand loads the configuration for the given executable identified by its path.
 func CmdConfigForCommand(cmdPath string) (*CmdConfig, error) {
 	cmdConfigPath := cmdPath + ".config"
@@ -104,9 +156,14 @@ func CmdConfigLoadedFromFile(cmdConfigPath string) (*CmdConfig, error) {
 		return nil, errors.Wrapf(err, "error reading init file: %s", cmdConfigPathAbs)
 	}
 
-	cmdCfg := CmdConfig{}
+	cmdCfg := CmdConfig{binDir: filepath.Dir(cmdConfigPathAbs)}
 
 	section := cfg.Section("")
+	// checked first, as the other keys may have another meaning in another version
+	cmdCfg.ProtocolVersion, err = protocolVersionFrom(section, cmdConfigPathAbs)
+	if err != nil {
+		return nil, err
+	}
 	cmdCfg.StubKey = section.Key("__EXECSTUBBING_STUB_KEY").String()
 	err = assertStubKeyHasRightFormat(cmdCfg.StubKey, section.Body())
 	if err != nil {
@@ -116,8 +173,17 @@ func CmdConfigLoadedFromFile(cmdConfigPath string) (*CmdConfig, error) {
 	cmdCfg.UnitTestExec = section.Key("__EXECSTUBBING_UNIT_TEST_EXEC").String()
 	cmdCfg.TestHelperProcessMethod = section.Key("__EXECSTUBBING_TEST_HELPER_PROCESS_METHOD").String()
 	cmdCfg.DataDir = section.Key("__EXECSTUBBING_DATA_DIR").String()
+	cmdCfg.TimelineDir = section.Key("__EXECSTUBBING_TIMELINE_DIR").String()
 	cmdCfg.PipeStubber = section.Key("__EXECSTUBBING_PIPE_STUBBER").String()
 	cmdCfg.PipeTestHelperProcess = section.Key("__EXECSTUBBING_PIPE_TEST_HELPER_PROC").String()
+	cmdCfg.SocketPath = section.Key("__EXECSTUBBING_SOCKET").String()
+	cmdCfg.HTTPAddress = section.Key("__EXECSTUBBING_HTTP_ADDRESS").String()
+	cmdCfg.HTTPToken = section.Key("__EXECSTUBBING_HTTP_TOKEN").String()
+	cmdCfg.TransportClient = section.Key("__EXECSTUBBING_TRANSPORT_CLIENT").String()
+	cmdCfg.CaptureStdin = section.Key("__EXECSTUBBING_CAPTURE_STDIN").MustBool(false)
+	cmdCfg.CaptureDir = section.Key("__EXECSTUBBING_CAPTURE_DIR").MustBool(false)
+	cmdCfg.EnvFilter.AllowList = listSectionValue(section, "__EXECSTUBBING_ENV_ALLOW_LIST")
+	cmdCfg.EnvFilter.DenyList = listSectionValue(section, "__EXECSTUBBING_ENV_DENY_LIST")
 
 	cmdCfg.TxtStdout, err = decodeBase64SectionValue(section, "__EXECSTUBBING_STD_OUT")
 	if nil != err {
@@ -128,6 +194,33 @@ func CmdConfigLoadedFromFile(cmdConfigPath string) (*CmdConfig, error) {
 		return nil, err
 	}
 
+	cmdCfg.OutputScript, err = outputScriptFrom(section)
+	if nil != err {
+		return nil, err
+	}
+
+	cmdCfg.ExitDelay, err = exitDelayFrom(section)
+	if nil != err {
+		return nil, err
+	}
+
+	cmdCfg.HangUntilSignalled = section.Key("__EXECSTUBBING_HANG_UNTIL_SIGNALLED").MustBool(false)
+
+	cmdCfg.TerminationSignal, err = terminationSignalFrom(section)
+	if nil != err {
+		return nil, err
+	}
+
+	cmdCfg.Passthrough = section.Key("__EXECSTUBBING_PASSTHROUGH").MustBool(false)
+
+	cmdCfg.OutcomeTemplate, err = outcomeTemplateFrom(section)
+	if nil != err {
+		return nil, err
+	}
+	cmdCfg.RealExec = section.Key("__EXECSTUBBING_REAL_EXEC").String()
+	cmdCfg.RealHomeEnvKey = section.Key("__EXECSTUBBING_REAL_HOME_ENV_KEY").String()
+	cmdCfg.RealHome = section.Key("__EXECSTUBBING_REAL_HOME").String()
+
 	cmdCfg.ExitCode, err = readExitCodeAsUint8(section)
 	if err != nil {
 		return nil, err
@@ -166,6 +259,84 @@ func timeoutFrom(section *ini.Section) (timeout time.Duration, err error) {
 
 }
 
+func protocolVersionFrom(section *ini.Section, cmdConfigPath string) (version int, err error) {
+	versionTxt := strings.TrimSpace(section.Key("__EXECSTUBBING_PROTOCOL_VERSION").String())
+	if versionTxt == "" {
+		versionTxt = unversionedProtocolVersion
+	}
+	if versionTxt != strconv.Itoa(ProtocolVersion) {
+		return 0, &IncompatibleVersionError{Subject: "config file " + cmdConfigPath, Version: versionTxt}
+	}
+	return ProtocolVersion, nil
+}
+
+func outputScriptFrom(section *ini.Section) (script []OutputChunk, err error) {
+	scriptTxt := section.Key("__EXECSTUBBING_OUTPUT_SCRIPT").String()
+	if scriptTxt == "" {
+		return nil, nil
+	}
+	script, err = outputScriptFromRecords(strings.Split(scriptTxt, ","))
+	if err != nil {
+		return nil, errors.Wrapf(err, "fail to decode output script: %s", scriptTxt)
+	}
+	return script, nil
+}
+
+func exitDelayFrom(section *ini.Section) (exitDelay time.Duration, err error) {
+	exitDelayTxt := section.Key("__EXECSTUBBING_EXIT_DELAY_NANOS").String()
+	if exitDelayTxt == "" {
+		return 0, nil
+	}
+	nanos, err := strconv.ParseInt(exitDelayTxt, 10, 64)
+	if err != nil || nanos < 0 {
+		return 0, errors.Errorf(
+			"exit delay must be non negative nanos but was '%s': %v", exitDelayTxt, err)
+	}
+	return time.Duration(nanos), nil
+}
+
+func terminationSignalFrom(section *ini.Section) (sig syscall.Signal, err error) {
+	signalTxt := section.Key("__EXECSTUBBING_TERMINATION_SIGNAL").String()
+	if signalTxt == "" {
+		return 0, nil
+	}
+	signal, err := strconv.Atoi(signalTxt)
+	if err != nil || signal < 0 {
+		return 0, errors.Errorf(
+			"termination signal must be a non negative number but was '%s': %v", signalTxt, err)
+	}
+	return syscall.Signal(signal), nil
+}
+
+func outcomeTemplateFrom(section *ini.Section) (tmpl *OutcomeTemplate, err error) {
+	if !section.Key("__EXECSTUBBING_OUTCOME_TEMPLATE").MustBool(false) {
+		return nil, nil
+	}
+	tmpl = &OutcomeTemplate{}
+	tmpl.Stdout, err = decodeBase64SectionValue(section, "__EXECSTUBBING_STD_OUT_TEMPLATE")
+	if nil != err {
+		return nil, err
+	}
+	tmpl.Stderr, err = decodeBase64SectionValue(section, "__EXECSTUBBING_STD_ERR_TEMPLATE")
+	if nil != err {
+		return nil, err
+	}
+	tmpl.ExitCode, err = decodeBase64SectionValue(section, "__EXECSTUBBING_EXIT_CODE_TEMPLATE")
+	if nil != err {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+func listSectionValue(section *ini.Section, key string) (values []string) {
+	for _, value := range section.Key(key).Strings(",") {
+		if value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
 func decodeBase64SectionValue(section *ini.Section, key string) (decoded string, err error) {
 	strBase64 := section.Key(key).String()
 	if strBase64 == "" {
@@ -190,7 +361,10 @@ func (cfg CmdConfig) UseDynamicOutcome() bool {
 
 // UseStaticOutCome stells whether the us of static outcome is configured.
 func (cfg CmdConfig) UseStaticOutCome() bool {
-	return cfg.TxtStderr != "" || cfg.TxtStdout != "" || cfg.ExitCode != nil
+	return cfg.TxtStderr != "" || cfg.TxtStdout != "" ||
+		len(cfg.OutputScript) > 0 || cfg.ExitDelay > 0 || cfg.HangUntilSignalled ||
+		cfg.TerminationSignal != 0 || cfg.Passthrough || cfg.ExitCode != nil ||
+		cfg.OutcomeTemplate != nil
 }
 
 // StderrAvail return true if stderr data are available; false otherwise.
@@ -224,7 +398,8 @@ func (cfg CmdConfig) CreateConfigFile(
 		return errors.Wrap(err, "error getting CmdConfigTmpl")
 	}
 	tmpl = template.New("stub-cmd-config")
-	tmpl = tmpl.Funcs(template.FuncMap{"base64": toBase64})
+	tmpl = tmpl.Funcs(template.FuncMap{
+		"base64": toBase64, "list": toList, "outputScript": toOutputScript})
 	tmpl, err = tmpl.Parse(string(tmplBytes))
 	if err != nil {
 		return errors.Wrapf(err, "error parsing bytes\n %s", string(tmplBytes))
@@ -249,6 +424,14 @@ func toBase64(str string) (strBase64 string) {
 	return base64.StdEncoding.EncodeToString([]byte(str))
 }
 
+func toList(values []string) (list string) {
+	return strings.Join(values, ",")
+}
+
+func toOutputScript(script []OutputChunk) (scriptTxt string) {
+	return strings.Join(outputScriptToRecords(script), ",")
+}
+
 func readExitCodeAsUint8(section *ini.Section) (exitCode OptionalUint8, err error) {
 	txt := section.Key("__EXECSTUBBING_EXIT_CODE").String()
 	if txt == "" {
@@ -311,3 +494,37 @@ func (cfg CmdConfig) StubRequestWith(args []string) StubRequest {
 	}
 	return req
 }
+
+// StubRequestFor returns a stub request that requests execution of the
+// configured cmd with the given arguments in the context (stdin, working
+// directory and environment) of the current process.
+// The context is only captured as far as configured.
+func (cfg CmdConfig) StubRequestFor(args []string, stdin io.Reader) (req StubRequest, err error) {
+	req = cfg.StubRequestWith(args)
+	req.Stdin, err = cfg.CapturedStdin(stdin)
+	if err != nil {
+		return req, err
+	}
+	if cfg.CaptureDir {
+		req.Dir, err = os.Getwd()
+		if err != nil {
+			return req, errors.Wrap(err, "fail to get working directory")
+		}
+	}
+	req.Env = cfg.EnvFilter.Filter(os.Environ())
+	return req, nil
+}
+
+// CapturedStdin returns the content read from the given stdin if the capture
+// of stdin is configured; "" otherwise.
+// Reading consumes stdin until EOF.
+func (cfg CmdConfig) CapturedStdin(stdin io.Reader) (string, error) {
+	if !cfg.CaptureStdin || stdin == nil {
+		return "", nil
+	}
+	stdinBytes, err := ioutil.ReadAll(stdin)
+	if err != nil {
+		return "", errors.Wrap(err, "fail to read stdin to capture")
+	}
+	return string(stdinBytes), nil
+}
@@ -1,5 +1,5 @@
 // Code generated by "go_generate_stub_exec_assets static_com_config ../.."; DO NOT EDIT
-// generated at 2021-05-18T15:59:19.189189047+02:00
+// generated at 2026-10-16T20:02:16.134361641Z
 
 // Copyright 2020 The Execstub Authors
 //
@@ -21,6 +21,6 @@ import "encoding/base64"
 
 // CmdConfigTmpl returns the template used to generate stub cmd configuration.
 func CmdConfigTmpl() (script []byte, err error) {
-	cmdConfigTmpl64 := "IyBDb3B5cmlnaHQgMjAyMCBUaGUgRXhlY3N0dWIgQXV0aG9ycwojCiMgTGljZW5zZWQgdW5kZXIgdGhlIEFwYWNoZSBMaWNlbnNlLCBWZXJzaW9uIDIuMCAodGhlICJMaWNlbnNlIik7CiMgeW91IG1heSBub3QgdXNlIHRoaXMgZmlsZSBleGNlcHQgaW4gY29tcGxpYW5jZSB3aXRoIHRoZSBMaWNlbnNlLgojIFlvdSBtYXkgb2J0YWluIGEgY29weSBvZiB0aGUgTGljZW5zZSBhdAojCiMgICAgaHR0cDovL3d3dy5hcGFjaGUub3JnL2xpY2Vuc2VzL0xJQ0VOU0UtMi4wCiMKIyBVbmxlc3MgcmVxdWlyZWQgYnkgYXBwbGljYWJsZSBsYXcgb3IgYWdyZWVkIHRvIGluIHdyaXRpbmcsIHNvZnR3YXJlCiMgZGlzdHJpYnV0ZWQgdW5kZXIgdGhlIExpY2Vuc2UgaXMgZGlzdHJpYnV0ZWQgb24gYW4gIkFTIElTIiBCQVNJUywKIyBXSVRIT1VUIFdBUlJBTlRJRVMgT1IgQ09ORElUSU9OUyBPRiBBTlkgS0lORCwgZWl0aGVyIGV4cHJlc3Mgb3IgaW1wbGllZC4KIyBTZWUgdGhlIExpY2Vuc2UgZm9yIHRoZSBzcGVjaWZpYyBsYW5ndWFnZSBnb3Zlcm5pbmcgcGVybWlzc2lvbnMgYW5kCiMgbGltaXRhdGlvbnMgdW5kZXIgdGhlIExpY2Vuc2UuCgojQ29uZmlnIGd1cmF0aW9uIGZvciBzdHViaW5nCl9fRVhFQ1NUVUJCSU5HX1NUVUJfS0VZPSJ7ey5TdHViS2V5fX0iCl9fRVhFQ1NUVUJCSU5HX0NNRF9UT19TVFVCPSJ7ey5DbWRUb1N0dWJ9fSIKX19FWEVDU1RVQkJJTkdfVU5JVF9URVNUX0VYRUM9Int7LlVuaXRUZXN0RXhlY319IgpfX0VYRUNTVFVCQklOR19URVNUX0hFTFBFUl9QUk9DRVNTX01FVEhPRD0ie3suVGVzdEhlbHBlclByb2Nlc3NNZXRob2R9fSIKX19FWEVDU1RVQkJJTkdfREFUQV9ESVI9Int7LkRhdGFEaXJ9fSIKX19FWEVDU1RVQkJJTkdfVElNRU9VVF9OQU5PUz17ey5UaW1lb3V0QXNGb3JtYXR0ZWROYW5vc319Cl9fRVhFQ1NUVUJCSU5HX1BJUEVfU1RVQkJFUj17ey5QaXBlU3R1YmJlcn19Cl9fRVhFQ1NUVUJCSU5HX1BJUEVfVEVTVF9IRUxQRVJfUFJPQz17ey5QaXBlVGVzdEhlbHBlclByb2Nlc3N9fQoKIyBTdGF0aWMgb3V0Y29tZSBzcGVjaWZpZWQgaWYgYW55IG5vbiBFbXB0eQpfX0VYRUNTVFVCQklOR19TVERfRVJSPXt7IGJhc2U2NCAuVHh0U3RkZXJyIH19Cl9fRVhFQ1NUVUJCSU5HX1NURF9PVVQ9e3sgYmFzZTY0IC5UeHRTdGRvdXQgfX0KX19FWEVDU1RVQkJJTkdfRVhJVF9DT0RFPXt7LkV4aXRDb2RlVHh0fX0K"
+	cmdConfigTmpl64 := "IyBDb3B5cmlnaHQgMjAyMCBUaGUgRXhlY3N0dWIgQXV0aG9ycwojCiMgTGljZW5zZWQgdW5kZXIgdGhlIEFwYWNoZSBMaWNlbnNlLCBWZXJzaW9uIDIuMCAodGhlICJMaWNlbnNlIik7CiMgeW91IG1heSBub3QgdXNlIHRoaXMgZmlsZSBleGNlcHQgaW4gY29tcGxpYW5jZSB3aXRoIHRoZSBMaWNlbnNlLgojIFlvdSBtYXkgb2J0YWluIGEgY29weSBvZiB0aGUgTGljZW5zZSBhdAojCiMgICAgaHR0cDovL3d3dy5hcGFjaGUub3JnL2xpY2Vuc2VzL0xJQ0VOU0UtMi4wCiMKIyBVbmxlc3MgcmVxdWlyZWQgYnkgYXBwbGljYWJsZSBsYXcgb3IgYWdyZWVkIHRvIGluIHdyaXRpbmcsIHNvZnR3YXJlCiMgZGlzdHJpYnV0ZWQgdW5kZXIgdGhlIExpY2Vuc2UgaXMgZGlzdHJpYnV0ZWQgb24gYW4gIkFTIElTIiBCQVNJUywKIyBXSVRIT1VUIFdBUlJBTlRJRVMgT1IgQ09ORElUSU9OUyBPRiBBTlkgS0lORCwgZWl0aGVyIGV4cHJlc3Mgb3IgaW1wbGllZC4KIyBTZWUgdGhlIExpY2Vuc2UgZm9yIHRoZSBzcGVjaWZpYyBsYW5ndWFnZSBnb3Zlcm5pbmcgcGVybWlzc2lvbnMgYW5kCiMgbGltaXRhdGlvbnMgdW5kZXIgdGhlIExpY2Vuc2UuCgojQ29uZmlnIGd1cmF0aW9uIGZvciBzdHViaW5nCl9fRVhFQ1NUVUJCSU5HX1BST1RPQ09MX1ZFUlNJT049e3suUHJvdG9jb2xWZXJzaW9uVHh0fX0KX19FWEVDU1RVQkJJTkdfU1RVQl9LRVk9Int7LlN0dWJLZXl9fSIKX19FWEVDU1RVQkJJTkdfQ01EX1RPX1NUVUI9Int7LkNtZFRvU3R1Yn19IgpfX0VYRUNTVFVCQklOR19VTklUX1RFU1RfRVhFQz0ie3suVW5pdFRlc3RFeGVjfX0iCl9fRVhFQ1NUVUJCSU5HX1RFU1RfSEVMUEVSX1BST0NFU1NfTUVUSE9EPSJ7ey5UZXN0SGVscGVyUHJvY2Vzc01ldGhvZH19IgpfX0VYRUNTVFVCQklOR19EQVRBX0RJUj0ie3suRGF0YURpcn19IgpfX0VYRUNTVFVCQklOR19USU1FTElORV9ESVI9Int7LlRpbWVsaW5lRGlyfX0iCl9fRVhFQ1NUVUJCSU5HX1RJTUVPVVRfTkFOT1M9e3suVGltZW91dEFzRm9ybWF0dGVkTmFub3N9fQpfX0VYRUNTVFVCQklOR19QSVBFX1NUVUJCRVI9e3suUGlwZVN0dWJiZXJ9fQpfX0VYRUNTVFVCQklOR19QSVBFX1RFU1RfSEVMUEVSX1BST0M9e3suUGlwZVRlc3RIZWxwZXJQcm9jZXNzfX0KX19FWEVDU1RVQkJJTkdfU09DS0VUPSJ7ey5Tb2NrZXRQYXRofX0iCl9fRVhFQ1NUVUJCSU5HX0hUVFBfQUREUkVTUz0ie3suSFRUUEFkZHJlc3N9fSIKX19FWEVDU1RVQkJJTkdfSFRUUF9UT0tFTj0ie3suSFRUUFRva2VufX0iCl9fRVhFQ1NUVUJCSU5HX1RSQU5TUE9SVF9DTElFTlQ9Int7LlRyYW5zcG9ydENsaWVudH19IgpfX0VYRUNTVFVCQklOR19DQVBUVVJFX1NURElOPXt7LkNhcHR1cmVTdGRpbn19Cl9fRVhFQ1NUVUJCSU5HX0NBUFRVUkVfRElSPXt7LkNhcHR1cmVEaXJ9fQpfX0VYRUNTVFVCQklOR19FTlZfQUxMT1dfTElTVD0ie3sgbGlzdCAuRW52RmlsdGVyLkFsbG93TGlzdCB9fSIKX19FWEVDU1RVQkJJTkdfRU5WX0RFTllfTElTVD0ie3sgbGlzdCAuRW52RmlsdGVyLkRlbnlMaXN0IH19IgpfX0VYRUNTVFVCQklOR19SRUFMX0VYRUM9Int7LlJlYWxFeGVjfX0iCl9fRVhFQ1NUVUJCSU5HX1JFQUxfSE9NRV9FTlZfS0VZPSJ7ey5SZWFsSG9tZUVudktleX19IgpfX0VYRUNTVFVCQklOR19SRUFMX0hPTUU9Int7LlJlYWxIb21lfX0iCgojIFN0YXRpYyBvdXRjb21lIHNwZWNpZmllZCBpZiBhbnkgbm9uIEVtcHR5Cl9fRVhFQ1NUVUJCSU5HX1NURF9FUlI9e3sgYmFzZTY0IC5UeHRTdGRlcnIgfX0KX19FWEVDU1RVQkJJTkdfU1REX09VVD17eyBiYXNlNjQgLlR4dFN0ZG91dCB9fQojIHRyaXBsZXMgb2YgYmFzZTY0IGVuY29kZWQ6IHN0cmVhbSBmaWxlIGRlc2NyaXB0b3IsIGRlbGF5IG5hbm9zLCBkYXRhCl9fRVhFQ1NUVUJCSU5HX09VVFBVVF9TQ1JJUFQ9e3sgb3V0cHV0U2NyaXB0IC5PdXRwdXRTY3JpcHQgfX0KX19FWEVDU1RVQkJJTkdfRVhJVF9ERUxBWV9OQU5PUz17eyBpZiAuRXhpdERlbGF5IH19e3sgLkV4aXREZWxheS5OYW5vc2Vjb25kcyB9fXt7IGVuZCB9fQpfX0VYRUNTVFVCQklOR19IQU5HX1VOVElMX1NJR05BTExFRD17eyBpZiAuSGFuZ1VudGlsU2lnbmFsbGVkIH19dHJ1ZXt7IGVuZCB9fQpfX0VYRUNTVFVCQklOR19URVJNSU5BVElPTl9TSUdOQUw9e3sgaWYgLlRlcm1pbmF0aW9uU2lnbmFsIH19e3sgcHJpbnRmICIlZCIgLlRlcm1pbmF0aW9uU2lnbmFsIH19e3sgZW5kIH19Cl9fRVhFQ1NUVUJCSU5HX1BBU1NUSFJPVUdIPXt7IGlmIC5QYXNzdGhyb3VnaCB9fXRydWV7eyBlbmQgfX0KX19FWEVDU1RVQkJJTkdfRVhJVF9DT0RFPXt7LkV4aXRDb2RlVHh0fX0KIyB0ZXh0L3RlbXBsYXRlIHRlbXBsYXRlcyByZW5kZXJlZCBhZ2FpbnN0IHRoZSByZXF1ZXN0IGJ5IHRoZSBzdHViIGV4ZWN1dGFibGUKX19FWEVDU1RVQkJJTkdfT1VUQ09NRV9URU1QTEFURT17eyBpZiAuT3V0Y29tZVRlbXBsYXRlIH19dHJ1ZXt7IGVuZCB9fQpfX0VYRUNTVFVCQklOR19TVERfT1VUX1RFTVBMQVRFPXt7IHdpdGggLk91dGNvbWVUZW1wbGF0ZSB9fXt7IGJhc2U2NCAuU3Rkb3V0IH19e3sgZW5kIH19Cl9fRVhFQ1NUVUJCSU5HX1NURF9FUlJfVEVNUExBVEU9e3sgd2l0aCAuT3V0Y29tZVRlbXBsYXRlIH19e3sgYmFzZTY0IC5TdGRlcnIgfX17eyBlbmQgfX0KX19FWEVDU1RVQkJJTkdfRVhJVF9DT0RFX1RFTVBMQVRFPXt7IHdpdGggLk91dGNvbWVUZW1wbGF0ZSB9fXt7IGJhc2U2NCAuRXhpdENvZGUgfX17eyBlbmQgfX0K"
 	return base64.StdEncoding.DecodeString(cmdConfigTmpl64)
 }
@@ -15,43 +15,83 @@
 package comproto
 
 import (
+	"bufio"
 	"encoding/base64"
 	"encoding/csv"
 	"fmt"
 	"io"
 	"math"
 	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/pkg/errors"
 )
 
-// ExecOutcomeEncoderFunc return an encoder which can write exec outcome representation into the writer.
+// WireFormat is the format stub requests and exec outcomes are encoded with.
+// Decoding detects the format, so that data encoded with either format can be read.
+type WireFormat int
+
+const (
+	// WireFormatStructured is the self-describing format with named length-prefixed fields,
+	// spoken between go processes. Fields can be added without breaking decoders not knowing them.
+	WireFormatStructured WireFormat = iota
+	// WireFormatCSV is the base64 csv format spoken by the bash stub executable.
+	// It carries the same data in fixed positions.
+	WireFormatCSV
+)
+
+func (format WireFormat) String() string {
+	switch format {
+	case WireFormatStructured:
+		return "structured"
+	case WireFormatCSV:
+		return "csv"
+	default:
+		return fmt.Sprintf("WireFormat(%d)", int(format))
+	}
+}
+
+// ExecOutcomeEncoderFunc return an encoder which can write exec outcome representation into the writer,
+// using the structured wire format.
 func ExecOutcomeEncoderFunc(writer io.Writer) func(outcome *ExecOutcome) error {
-	// return func(outcome *ExecOutcome) error {
-	// 	enc := gob.NewEncoder(writer)
-	// 	return enc.Encode(outcome)
-	// }
-	return execOutcomeEncoderFuncBase64CSV(writer)
+	return ExecOutcomeEncoderFuncFor(writer, WireFormatStructured)
 }
 
-// ExecOutcomeDecoderFunc decodes an exec-outcome from the given reader.
+// ExecOutcomeEncoderFuncFor return an encoder which can write exec outcome representation into the writer,
+// using the given wire format.
+func ExecOutcomeEncoderFuncFor(writer io.Writer, format WireFormat) func(outcome *ExecOutcome) error {
+	if format == WireFormatCSV {
+		return execOutcomeEncoderFuncBase64CSV(writer)
+	}
+	return execOutcomeEncoderFuncStructured(writer)
+}
+
+// ExecOutcomeDecoderFunc decodes an exec-outcome from the given reader, whichever its wire format.
 func ExecOutcomeDecoderFunc(reader io.Reader) (*ExecOutcome, error) {
-	// dec := gob.NewDecoder(reader)
-	// outcome := ExecOutcome{}
-	// err := dec.Decode(&outcome)
-	// return &outcome, err
-	return execOutcomeDecoderFuncBase64CVS(reader)
+	bufReader := bufio.NewReader(reader)
+	if isStructuredFrame(bufReader) {
+		return execOutcomeDecoderFuncStructured(bufReader)
+	}
+	return execOutcomeDecoderFuncBase64CVS(bufReader)
 }
 
 func execOutcomeEncoderFuncBase64CSV(writer io.Writer) func(outcome *ExecOutcome) error {
 	return func(outcome *ExecOutcome) error {
 		writerCSV := csv.NewWriter(writer)
-		rec := make([]string, 0, 5)
+		rec := make([]string, 0, 1+execOutcomeFixedRecLen+3*len(outcome.OutputScript))
+		rec = append(rec, protocolVersionMarker(ProtocolVersion))
 		rec = append(rec, strToBase64(strconv.Itoa(int(outcome.ExitCode))))
 		rec = append(rec, strToBase64(outcome.InternalErrTxt))
 		rec = append(rec, strToBase64(outcome.Key))
 		rec = append(rec, strToBase64(outcome.Stderr))
 		rec = append(rec, strToBase64(outcome.Stdout))
+		rec = append(rec, strToBase64(strconv.FormatInt(outcome.ExitDelay.Nanoseconds(), 10)))
+		rec = append(rec, strToBase64(strconv.FormatBool(outcome.HangUntilSignalled)))
+		rec = append(rec, strToBase64(strconv.Itoa(int(outcome.TerminationSignal))))
+		rec = append(rec, strToBase64(strconv.FormatBool(outcome.Passthrough)))
+		rec = append(rec, outputScriptToRecords(outcome.OutputScript)...)
 		err := writerCSV.Write(rec)
 		if err != nil {
 			return err
@@ -67,8 +107,14 @@ func execOutcomeDecoderFuncBase64CVS(reader io.Reader) (*ExecOutcome, error) {
 	if err != nil {
 		return nil, errors.Wrap(err, "while execOutcomeDecoderFuncBase64CVS")
 	}
-	if len(rec) != 5 {
-		return nil, fmt.Errorf("expect 5 record items but got %d, rec=%v", len(rec), rec)
+	rec, err = recordsAfterVersionMarker("exec outcome", rec, oldestCSVProtocolVersion)
+	if err != nil {
+		return nil, err
+	}
+	if len(rec) < execOutcomeFixedRecLen {
+		return nil, fmt.Errorf(
+			"expect at least %d record items but got %d, rec=%v",
+			execOutcomeFixedRecLen, len(rec), rec)
 	}
 	outcome := ExecOutcome{}
 	outcome.ExitCode, err = base64ToStrToUint8(rec[0])
@@ -111,9 +157,128 @@ func execOutcomeDecoderFuncBase64CVS(reader io.Reader) (*ExecOutcome, error) {
 			rec[4], rec)
 		return nil, err
 	}
+	exitDelayStr, err := base64ToStr(rec[5])
+	if err != nil {
+		err = errors.Wrapf(
+			err,
+			"while ExitDelay <- base64ToStr('%s') records=%v",
+			rec[5], rec)
+		return nil, err
+	}
+	exitDelay, err := strconv.ParseInt(exitDelayStr, 10, 64)
+	if err != nil || exitDelay < 0 {
+		return nil, errors.Errorf(
+			"ExitDelay must be non negative nanos but was '%s': err=%v records=%v",
+			exitDelayStr, err, rec)
+	}
+	outcome.ExitDelay = time.Duration(exitDelay)
+	hangStr, err := base64ToStr(rec[6])
+	if err != nil {
+		err = errors.Wrapf(
+			err,
+			"while HangUntilSignalled <- base64ToStr('%s') records=%v",
+			rec[6], rec)
+		return nil, err
+	}
+	outcome.HangUntilSignalled, err = strconv.ParseBool(hangStr)
+	if err != nil {
+		err = errors.Wrapf(
+			err,
+			"while HangUntilSignalled <- ParseBool('%s') records=%v",
+			hangStr, rec)
+		return nil, err
+	}
+	signalStr, err := base64ToStr(rec[7])
+	if err != nil {
+		err = errors.Wrapf(
+			err,
+			"while TerminationSignal <- base64ToStr('%s') records=%v",
+			rec[7], rec)
+		return nil, err
+	}
+	signal, err := strconv.Atoi(signalStr)
+	if err != nil || signal < 0 {
+		return nil, errors.Errorf(
+			"TerminationSignal must be a non negative number but was '%s': err=%v records=%v",
+			signalStr, err, rec)
+	}
+	outcome.TerminationSignal = syscall.Signal(signal)
+	passthroughStr, err := base64ToStr(rec[8])
+	if err != nil {
+		err = errors.Wrapf(
+			err,
+			"while Passthrough <- base64ToStr('%s') records=%v",
+			rec[8], rec)
+		return nil, err
+	}
+	outcome.Passthrough, err = strconv.ParseBool(passthroughStr)
+	if err != nil {
+		err = errors.Wrapf(
+			err,
+			"while Passthrough <- ParseBool('%s') records=%v",
+			passthroughStr, rec)
+		return nil, err
+	}
+	outcome.OutputScript, err = outputScriptFromRecords(rec[execOutcomeFixedRecLen:])
+	if err != nil {
+		err = errors.Wrapf(err, "while OutputScript <- records=%v", rec)
+		return nil, err
+	}
 	return &outcome, err
 }
 
+// execOutcomeFixedRecLen is the number of records between the version marker and the output
+// script in an exec outcome csv: exit code, internal error, key, stderr, stdout,
+// exit delay, hang-until-signalled, termination signal and passthrough.
+const execOutcomeFixedRecLen = 9
+
+// outputScriptToRecords returns a triple of records for each chunk:
+// stream (file descriptor), delay in nanoseconds and data, all base64 encoded.
+func outputScriptToRecords(script []OutputChunk) []string {
+	rec := make([]string, 0, 3*len(script))
+	for _, chunk := range script {
+		rec = append(rec, strToBase64(strconv.Itoa(int(chunk.Stream))))
+		rec = append(rec, strToBase64(strconv.FormatInt(chunk.Delay.Nanoseconds(), 10)))
+		rec = append(rec, strToBase64(chunk.Data))
+	}
+	return rec
+}
+
+func outputScriptFromRecords(rec []string) (script []OutputChunk, err error) {
+	if len(rec)%3 != 0 {
+		return nil, errors.Errorf(
+			"expect a record triple (stream, delay, data) per chunk but got %d records: %v",
+			len(rec), rec)
+	}
+	for i := 0; i < len(rec); i += 3 {
+		stream, err := base64ToStrToUint8(rec[i])
+		if err != nil {
+			return nil, errors.Wrapf(err, "while chunk[%d].Stream <- '%s'", i/3, rec[i])
+		}
+		if OutputStream(stream) != StreamStdout && OutputStream(stream) != StreamStderr {
+			return nil, errors.Errorf("unsupported chunk[%d].Stream: %d", i/3, stream)
+		}
+		delayStr, err := base64ToStr(rec[i+1])
+		if err != nil {
+			return nil, errors.Wrapf(err, "while chunk[%d].Delay <- '%s'", i/3, rec[i+1])
+		}
+		delay, err := strconv.ParseInt(delayStr, 10, 64)
+		if err != nil || delay < 0 {
+			return nil, errors.Errorf(
+				"chunk[%d].Delay must be non negative nanos but was '%s': %v",
+				i/3, delayStr, err)
+		}
+		data, err := base64ToStr(rec[i+2])
+		if err != nil {
+			return nil, errors.Wrapf(err, "while chunk[%d].Data <- '%s'", i/3, rec[i+2])
+		}
+		script = append(script, OutputChunk{
+			Stream: OutputStream(stream), Delay: time.Duration(delay), Data: data,
+		})
+	}
+	return script, nil
+}
+
 func strToBase64(str string) (strBase64 string) {
 	if str == "" {
 		return ""
@@ -147,33 +312,57 @@ func base64ToStrToUint8(base64Str string) (valUin8 uint8, err error) {
 	return uint8(val64), nil
 }
 
-// StubRequestEncoderFunc return an encoder which can write stubbing request representation into the writer.
+// StubRequestEncoderFunc return an encoder which can write stubbing request representation into the writer,
+// using the structured wire format.
 func StubRequestEncoderFunc(writer io.Writer) func(outcome *StubRequest) error {
-	// return func(req *StubRequest) error {
-	// 	enc := gob.NewEncoder(writer)
-	// 	return enc.Encode(req)
-	// }
-	return stubRequestEncoderFuncBase64CVS(writer)
+	return StubRequestEncoderFuncFor(writer, WireFormatStructured)
 }
 
-// StubRequestDecoderFunc decodes an stubbing request from the given reader.
+// StubRequestEncoderFuncFor return an encoder which can write stubbing request representation into the writer,
+// using the given wire format.
+func StubRequestEncoderFuncFor(writer io.Writer, format WireFormat) func(req *StubRequest) error {
+	if format == WireFormatCSV {
+		return stubRequestEncoderFuncBase64CVS(writer)
+	}
+	return stubRequestEncoderFuncStructured(writer)
+}
+
+// StubRequestDecoderFunc decodes an stubbing request from the given reader, whichever its wire format.
 func StubRequestDecoderFunc(reader io.Reader) (*StubRequest, error) {
-	// dec := gob.NewDecoder(reader)
-	// req := StubRequest{}
-	// err := dec.Decode(&req)
-	// return &req, err
-	return stubRequestDecoderFuncBase64CVS(reader)
+	req, _, err := StubRequestDecoderFuncWithFormat(reader)
+	return req, err
+}
+
+// StubRequestDecoderFuncWithFormat decodes an stubbing request from the given reader
+// and tells the wire format it has been encoded with,
+// so that the outcome can be sent back using a format understood by the requester.
+func StubRequestDecoderFuncWithFormat(reader io.Reader) (*StubRequest, WireFormat, error) {
+	bufReader := bufio.NewReader(reader)
+	if isStructuredFrame(bufReader) {
+		req, err := stubRequestDecoderFuncStructured(bufReader)
+		return req, WireFormatStructured, err
+	}
+	req, err := stubRequestDecoderFuncBase64CVS(bufReader)
+	return req, WireFormatCSV, err
 }
 
+// stubRequestFixedRecLen is the number of records between the version marker and the arguments
+// in a stub request csv: key, cmd-name, stdin, dir and env.
+const stubRequestFixedRecLen = 5
+
 func stubRequestEncoderFuncBase64CVS(writer io.Writer) func(req *StubRequest) error {
 	return func(req *StubRequest) error {
 		if err := assertStubKeyHasRightFormat(req.Key, fmt.Sprintf("req-to-base64-cvs:%#v", req)); err != nil {
 			return err
 		}
 		writerCSV := csv.NewWriter(writer)
-		rec := make([]string, 0, 2+len(req.Args))
+		rec := make([]string, 0, 1+stubRequestFixedRecLen+len(req.Args))
+		rec = append(rec, protocolVersionMarker(ProtocolVersion))
 		rec = append(rec, strToBase64(req.Key))
 		rec = append(rec, strToBase64(req.CmdName))
+		rec = append(rec, strToBase64(req.Stdin))
+		rec = append(rec, strToBase64(req.Dir))
+		rec = append(rec, strToBase64(envToNulSeparated(req.Env)))
 		for _, arg := range req.Args {
 			rec = append(rec, strToBase64(arg))
 		}
@@ -192,6 +381,15 @@ func stubRequestDecoderFuncBase64CVS(reader io.Reader) (*StubRequest, error) {
 	if err != nil {
 		return nil, errors.Wrapf(err, "while stubRequestDecoderFuncBase64CVS rec=%v, err=%v", rec, err)
 	}
+	rec, err = recordsAfterVersionMarker("stub request", rec, oldestCSVProtocolVersion)
+	if err != nil {
+		return nil, err
+	}
+	if len(rec) < stubRequestFixedRecLen {
+		return nil, fmt.Errorf(
+			"expect at least %d record items but got %d, rec=%v",
+			stubRequestFixedRecLen, len(rec), rec)
+	}
 	req := StubRequest{}
 	req.Key, err = base64ToStr(rec[0])
 	if err != nil {
@@ -213,18 +411,43 @@ func stubRequestDecoderFuncBase64CVS(reader io.Reader) (*StubRequest, error) {
 			rec[1], rec)
 		return nil, err
 	}
-	if recLen := len(rec); recLen > 2 {
+	req.Stdin, err = base64ToStr(rec[2])
+	if err != nil {
+		err = errors.Wrapf(
+			err,
+			"while Stdin <- base64ToStrTo('%s') records=%v",
+			rec[2], rec)
+		return nil, err
+	}
+	req.Dir, err = base64ToStr(rec[3])
+	if err != nil {
+		err = errors.Wrapf(
+			err,
+			"while Dir <- base64ToStrTo('%s') records=%v",
+			rec[3], rec)
+		return nil, err
+	}
+	envNulSeparated, err := base64ToStr(rec[4])
+	if err != nil {
+		err = errors.Wrapf(
+			err,
+			"while Env <- base64ToStrTo('%s') records=%v",
+			rec[4], rec)
+		return nil, err
+	}
+	req.Env = envFromNulSeparated(envNulSeparated)
+	if recLen := len(rec); recLen > stubRequestFixedRecLen {
 		// because we will be using asignment to transfer rec to args
 		// we need an array with the appopriate length
-		args := make([]string, recLen-2)
-		for i := recLen - 1; i >= 2; i-- {
+		args := make([]string, recLen-stubRequestFixedRecLen)
+		for i := recLen - 1; i >= stubRequestFixedRecLen; i-- {
 			recValI := rec[i]
-			args[i-2], err = base64ToStr(recValI)
+			args[i-stubRequestFixedRecLen], err = base64ToStr(recValI)
 			if err != nil {
 				err = errors.Wrapf(
 					err,
 					"while args[%d] <- base64ToStrTo(rec[%d]='%s') records=%v",
-					i-2, i, recValI, rec)
+					i-stubRequestFixedRecLen, i, recValI, rec)
 				return nil, err
 			}
 		}
@@ -233,3 +456,21 @@ func stubRequestDecoderFuncBase64CVS(reader io.Reader) (*StubRequest, error) {
 
 	return &req, nil
 }
+
+// envToNulSeparated joins the env entries using NUL as terminator,
+// like in /proc/<pid>/environ.
+// NUL is used because it cannot be part of an environment variable.
+func envToNulSeparated(env []string) string {
+	if len(env) == 0 {
+		return ""
+	}
+	return strings.Join(env, "\x00") + "\x00"
+}
+
+func envFromNulSeparated(envNulSeparated string) []string {
+	envNulSeparated = strings.TrimSuffix(envNulSeparated, "\x00")
+	if envNulSeparated == "" {
+		return nil
+	}
+	return strings.Split(envNulSeparated, "\x00")
+}
@@ -17,7 +17,9 @@ package comproto
 import (
 	"bytes"
 	"reflect"
+	"syscall"
 	"testing"
+	"time"
 )
 
 func TestExecOutcomeDecoderFunc(t *testing.T) {
@@ -41,6 +43,51 @@ func TestExecOutcomeDecoderFunc(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "Should be able to encode decode binary stdout and stderr",
+			args: args{
+				outcome: ExecOutcome{
+					Key:    "k1",
+					Stderr: "\x00\xff\xfe\r\n,\"\n\n",
+					Stdout: allByteValues() + "\n\n",
+				},
+			},
+		},
+		{
+			name: "Should be able to encode decode output script",
+			args: args{
+				outcome: ExecOutcome{
+					Key:    "k1",
+					Stdout: "started\n",
+					OutputScript: []OutputChunk{
+						StdoutChunk(0, "line1\n"),
+						StderrChunk(250*time.Millisecond, "\x00warn,\n"),
+						StdoutChunk(time.Second, ""),
+					},
+				},
+			},
+		},
+		{
+			name: "Should be able to encode decode exit delay and hang until signalled",
+			args: args{
+				outcome: ExecOutcome{
+					Key:                "k1",
+					ExitCode:           3,
+					ExitDelay:          1500 * time.Millisecond,
+					HangUntilSignalled: true,
+				},
+			},
+		},
+		{
+			name: "Should be able to encode decode termination signal",
+			args: args{
+				outcome: ExecOutcome{
+					Key:               "k1",
+					Stderr:            "fatal error: runtime: out of memory\n",
+					TerminationSignal: syscall.SIGKILL,
+				},
+			},
+		},
 		{
 			name: "Should be able to encode decode null execoutome",
 			args: args{
@@ -48,29 +95,31 @@ func TestExecOutcomeDecoderFunc(t *testing.T) {
 			},
 		},
 	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			var buf bytes.Buffer
-			encoderFunc := ExecOutcomeEncoderFunc(&buf)
-			erre := encoderFunc(&tt.args.outcome)
-			if erre != nil {
-				t.Errorf("could not encode [%#v]: %v", tt.args.outcome, erre)
-				return
-			}
-			bufStr := buf.String()
-			buf.Reset()
-			buf.WriteString(bufStr)
-			got, errDec := ExecOutcomeDecoderFunc(&buf)
-			if (errDec != nil) != tt.wantErr {
-				t.Errorf("Could not decode= %v, wantErr %v", errDec, tt.wantErr)
-				return
-			}
-			want := tt.args.outcome
-			if !reflect.DeepEqual(*got, want) {
-				t.Errorf("ExecOutcomeDecoderFunc() \ngot = %v, \nwant= %v \nbuf=%s",
-					*got, want, bufStr)
-			}
-		})
+	for _, format := range []WireFormat{WireFormatStructured, WireFormatCSV} {
+		for _, tt := range tests {
+			t.Run(format.String()+" "+tt.name, func(t *testing.T) {
+				var buf bytes.Buffer
+				encoderFunc := ExecOutcomeEncoderFuncFor(&buf, format)
+				erre := encoderFunc(&tt.args.outcome)
+				if erre != nil {
+					t.Errorf("could not encode [%#v]: %v", tt.args.outcome, erre)
+					return
+				}
+				bufStr := buf.String()
+				buf.Reset()
+				buf.WriteString(bufStr)
+				got, errDec := ExecOutcomeDecoderFunc(&buf)
+				if (errDec != nil) != tt.wantErr {
+					t.Errorf("Could not decode= %v, wantErr %v", errDec, tt.wantErr)
+					return
+				}
+				want := tt.args.outcome
+				if !reflect.DeepEqual(*got, want) {
+					t.Errorf("ExecOutcomeDecoderFunc() \ngot = %v, \nwant= %v \nbuf=%s",
+						*got, want, bufStr)
+				}
+			})
+		}
 	}
 }
 
@@ -92,6 +141,36 @@ func TestStubbingExecEncodeDecode(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "Should be able to encode decode request with stdin",
+			args: args{
+				req: StubRequest{
+					Args:  []string{"apply", "-f", "-"},
+					Key:   "k_1",
+					Stdin: "kind: Pod\n\x00,binary\n",
+				},
+			},
+		},
+		{
+			name: "Should be able to encode decode request with dir and env",
+			args: args{
+				req: StubRequest{
+					Args: []string{"remote", "add"},
+					Key:  "k_1",
+					Dir:  "/tmp/my repo",
+					Env:  []string{"EMPTY=", "GIT_SSH_COMMAND=ssh -i key\n-v"},
+				},
+			},
+		},
+		{
+			name: "Should be able to encode decode args which are not valid utf-8",
+			args: args{
+				req: StubRequest{
+					Args: []string{"\xff\xfe", "", "a\x00b"},
+					Key:  "k_1",
+				},
+			},
+		},
 		{
 			name: "Should not be able to encode decode null stubrequest",
 			args: args{
@@ -100,32 +179,43 @@ func TestStubbingExecEncodeDecode(t *testing.T) {
 			wantErr: true,
 		},
 	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			var buf bytes.Buffer
-			encoderFunc := StubRequestEncoderFunc(&buf)
-			erre := encoderFunc(&tt.args.req)
-			if (erre != nil) != tt.wantErr {
-				t.Errorf(
-					"unexpected error status while encoding stubbing request [%#v]: wantError=%t error=%v",
-					tt.args.req, tt.wantErr, erre)
-				return
-			}
-			bufStr := buf.String()
-			buf.Reset()
-			buf.WriteString(bufStr)
-			got, errDec := StubRequestDecoderFunc(&buf)
-			if (errDec != nil) != tt.wantErr {
-				t.Errorf("unexpected error status while decoding stubbing request: error=%v, wantErr %v", errDec, tt.wantErr)
-				return
-			}
-			if errDec == nil {
-				wantReq := tt.args.req
-				if !reflect.DeepEqual(*got, wantReq) {
-					t.Errorf("ExecOutcomeDecoderFunc() \ngot = %#v, \nwant= %#v buf=%s",
-						*got, wantReq, bufStr)
+	for _, format := range []WireFormat{WireFormatStructured, WireFormatCSV} {
+		for _, tt := range tests {
+			t.Run(format.String()+" "+tt.name, func(t *testing.T) {
+				var buf bytes.Buffer
+				encoderFunc := StubRequestEncoderFuncFor(&buf, format)
+				erre := encoderFunc(&tt.args.req)
+				if (erre != nil) != tt.wantErr {
+					t.Errorf(
+						"unexpected error status while encoding stubbing request [%#v]: wantError=%t error=%v",
+						tt.args.req, tt.wantErr, erre)
+					return
+				}
+				bufStr := buf.String()
+				buf.Reset()
+				buf.WriteString(bufStr)
+				got, errDec := StubRequestDecoderFunc(&buf)
+				if (errDec != nil) != tt.wantErr {
+					t.Errorf("unexpected error status while decoding stubbing request: error=%v, wantErr %v", errDec, tt.wantErr)
+					return
+				}
+				if errDec == nil {
+					wantReq := tt.args.req
+					if !reflect.DeepEqual(*got, wantReq) {
+						t.Errorf("ExecOutcomeDecoderFunc() \ngot = %#v, \nwant= %#v buf=%s",
+							*got, wantReq, bufStr)
+					}
 				}
-			}
-		})
+			})
+		}
+	}
+}
+
+// allByteValues returns a string holding each byte value once.
+func allByteValues() string {
+	all := make([]byte, 0, 256)
+	for i := 0; i < 256; i++ {
+		all = append(all, byte(i))
 	}
+	return string(all)
 }
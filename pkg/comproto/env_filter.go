@@ -0,0 +1,82 @@
+// Copyright 2020 The Execstub Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package comproto
+
+import (
+	"path"
+	"sort"
+	"strings"
+)
+
+// envNamePrefixExecstubbing is the prefix of environment variables used
+// internally by the stubbing. They are never captured.
+const envNamePrefixExecstubbing = "__EXECSTUBBING_"
+
+// EnvFilter specifies which environment variables of a stubbed command
+// execution are captured into the StubRequest.
+// Both lists hold patterns as understood by path.Match (e.g. GIT_*).
+// A variable is captured if its name matches a pattern of the allow list
+// and no pattern of the deny list.
+// Nothing is captured if the allow list is empty; use "*" to capture
+// all variables.
+// Note that the bash stub executable sees shell maintained variables
+// (e.g. SHLVL, _) the way bash does.
+type EnvFilter struct {
+	AllowList []string
+	DenyList  []string
+}
+
+// IsCapturing returns true if some variables may be captured; false otherwise.
+func (f EnvFilter) IsCapturing() bool {
+	return len(f.AllowList) > 0
+}
+
+// Accepts returns true if the variable with the given name is captured; false otherwise.
+func (f EnvFilter) Accepts(name string) bool {
+	if strings.HasPrefix(name, envNamePrefixExecstubbing) {
+		return false
+	}
+	return matchesAnyEnvNamePattern(name, f.AllowList) &&
+		!matchesAnyEnvNamePattern(name, f.DenyList)
+}
+
+// Filter returns the sorted entries (key=value) of the given environment
+// which are captured; nil if none is captured.
+func (f EnvFilter) Filter(environ []string) []string {
+	if !f.IsCapturing() {
+		return nil
+	}
+	var captured []string
+	for _, entry := range environ {
+		name := entry
+		if i := strings.Index(entry, "="); i >= 0 {
+			name = entry[:i]
+		}
+		if name != "" && f.Accepts(name) {
+			captured = append(captured, entry)
+		}
+	}
+	sort.Strings(captured)
+	return captured
+}
+
+func matchesAnyEnvNamePattern(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if matched, err := path.Match(pattern, name); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,68 @@
+// Copyright 2020 The Execstub Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package comproto
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEnvFilter_Filter(t *testing.T) {
+	environ := []string{
+		"PATH=/usr/bin", "GIT_SSH_COMMAND=ssh -i key", "GIT_DIR=/repo/.git",
+		"HOME=/home/u1", "__EXECSTUBBING_STUB_KEY=git_123", "EMPTY=",
+		"AWS_SECRET_ACCESS_KEY=xyz",
+	}
+	tests := []struct {
+		name   string
+		filter EnvFilter
+		want   []string
+	}{
+		{
+			name:   "Should capture nothing without allow list",
+			filter: EnvFilter{DenyList: []string{"HOME"}},
+			want:   nil,
+		},
+		{
+			name:   "Should capture allowed names and patterns sorted",
+			filter: EnvFilter{AllowList: []string{"GIT_*", "HOME", "EMPTY"}},
+			want: []string{
+				"EMPTY=", "GIT_DIR=/repo/.git", "GIT_SSH_COMMAND=ssh -i key", "HOME=/home/u1",
+			},
+		},
+		{
+			name: "Should capture all but denied and execstubbing internals",
+			filter: EnvFilter{
+				AllowList: []string{"*"},
+				DenyList:  []string{"AWS_*", "PATH"},
+			},
+			want: []string{
+				"EMPTY=", "GIT_DIR=/repo/.git", "GIT_SSH_COMMAND=ssh -i key", "HOME=/home/u1",
+			},
+		},
+		{
+			name:   "Should capture nothing if nothing matches",
+			filter: EnvFilter{AllowList: []string{"DOCKER_*"}},
+			want:   nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.Filter(environ); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("EnvFilter.Filter() \ngot = %#v, \nwant= %#v", got, tt.want)
+			}
+		})
+	}
+}
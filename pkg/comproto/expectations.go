@@ -0,0 +1,335 @@
+// Copyright 2020 The Execstub Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package comproto
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	rt "github.com/congop/execstub/internal/runtime"
+)
+
+// ArgMatcher matches a single command argument.
+type ArgMatcher interface {
+	// Matches returns true if the argument matches and false otherwise.
+	Matches(arg string) bool
+	// String describes the matcher, e.g. HasPrefix("/tmp").
+	String() string
+}
+
+type argMatcher struct {
+	matches     func(arg string) bool
+	description string
+}
+
+func (m argMatcher) Matches(arg string) bool {
+	return m.matches(arg)
+}
+
+func (m argMatcher) String() string {
+	return m.description
+}
+
+// Eq returns a matcher matching an argument equal to the given value.
+// Strings given to WithArgs are matched using Eq.
+func Eq(value string) ArgMatcher {
+	return argMatcher{
+		matches:     func(arg string) bool { return arg == value },
+		description: fmt.Sprintf("%q", value),
+	}
+}
+
+// AnyString returns a matcher matching any argument.
+func AnyString() ArgMatcher {
+	return argMatcher{
+		matches:     func(arg string) bool { return true },
+		description: "AnyString()",
+	}
+}
+
+// HasPrefix returns a matcher matching an argument starting with the given prefix.
+func HasPrefix(prefix string) ArgMatcher {
+	return argMatcher{
+		matches:     func(arg string) bool { return strings.HasPrefix(arg, prefix) },
+		description: fmt.Sprintf("HasPrefix(%q)", prefix),
+	}
+}
+
+// MatchesRegex returns a matcher matching an argument matching the given regular expression.
+// It panics if the expression cannot be compiled.
+func MatchesRegex(expr string) ArgMatcher {
+	regex := regexp.MustCompile(expr)
+	return argMatcher{
+		matches:     regex.MatchString,
+		description: fmt.Sprintf("MatchesRegex(%q)", expr),
+	}
+}
+
+// Expectation is an expected command execution together with the outcome it yields.
+// It is created using Expectations.On(..) and configured using its fluent methods,
+// which may also be used while the expectations are serving executions.
+type Expectation struct {
+	// mutex guards the expectation; it is the mutex of the owning Expectations if any
+	mutex       *sync.Mutex
+	cmd         string
+	argMatchers []ArgMatcher
+	argCount    int
+	anyArgs     bool
+	// argsDescription describes the argument requirement, e.g. .WithArgCount(2)
+	argsDescription string
+	stubFunc        StubFunc
+}
+
+// WithArgs requires the arguments to match the given values one by one, as well as their count.
+// A value is either an ArgMatcher or a string, which is matched using Eq.
+// It panics if a value is neither.
+func (exp *Expectation) WithArgs(args ...interface{}) *Expectation {
+	matchers := make([]ArgMatcher, 0, len(args))
+	for i, arg := range args {
+		switch argTyped := arg.(type) {
+		case string:
+			matchers = append(matchers, Eq(argTyped))
+		case ArgMatcher:
+			matchers = append(matchers, argTyped)
+		default:
+			panic(fmt.Sprintf(
+				"arg[%d] of %s must be a string or an ArgMatcher, but got:%T", i, exp.cmd, arg))
+		}
+	}
+	descs := make([]string, 0, len(matchers))
+	for _, matcher := range matchers {
+		descs = append(descs, matcher.String())
+	}
+	exp.mutex.Lock()
+	defer exp.mutex.Unlock()
+	exp.argMatchers = matchers
+	exp.argCount = len(matchers)
+	exp.anyArgs = false
+	exp.argsDescription = ".WithArgs(" + strings.Join(descs, ", ") + ")"
+	return exp
+}
+
+// WithArgCount requires the given number of arguments, whatever their values.
+func (exp *Expectation) WithArgCount(count int) *Expectation {
+	matchers := make([]ArgMatcher, 0, count)
+	for i := 0; i < count; i++ {
+		matchers = append(matchers, AnyString())
+	}
+	exp.mutex.Lock()
+	defer exp.mutex.Unlock()
+	exp.argMatchers = matchers
+	exp.argCount = count
+	exp.anyArgs = false
+	exp.argsDescription = fmt.Sprintf(".WithArgCount(%d)", count)
+	return exp
+}
+
+// WithAnyArgs accepts any arguments; this is the default.
+func (exp *Expectation) WithAnyArgs() *Expectation {
+	exp.mutex.Lock()
+	defer exp.mutex.Unlock()
+	exp.acceptAnyArgs()
+	return exp
+}
+
+// acceptAnyArgs removes the argument requirement; the mutex of the expectation must be held.
+func (exp *Expectation) acceptAnyArgs() {
+	exp.argMatchers = nil
+	exp.argCount = 0
+	exp.anyArgs = true
+	exp.argsDescription = ".WithAnyArgs()"
+}
+
+// Return specifies the outcome of the matched executions.
+func (exp *Expectation) Return(outcome *ExecOutcome) *Expectation {
+	return exp.ReturnFunc(stubFuncReturning(outcome))
+}
+
+func stubFuncReturning(outcome *ExecOutcome) StubFunc {
+	outcomeCopy := *outcome
+	return func(sreq StubRequest) *ExecOutcome {
+		outcomeReturned := outcomeCopy
+		return &outcomeReturned
+	}
+}
+
+// ReturnFunc specifies the StubFunc producing the outcome of the matched executions.
+func (exp *Expectation) ReturnFunc(stubFunc StubFunc) *Expectation {
+	exp.mutex.Lock()
+	defer exp.mutex.Unlock()
+	exp.stubFunc = stubFunc
+	return exp
+}
+
+// String describes the expectation, e.g. On("git").WithArgs("clone", AnyString()).
+func (exp *Expectation) String() string {
+	exp.mutex.Lock()
+	defer exp.mutex.Unlock()
+	return exp.describe()
+}
+
+// describe describes the expectation; the mutex of the expectation must be held.
+func (exp *Expectation) describe() string {
+	return fmt.Sprintf("On(%q)", exp.cmd) + exp.argsDescription
+}
+
+// Matches returns true if the request matches the expectation and false otherwise.
+func (exp *Expectation) Matches(sreq StubRequest) bool {
+	exp.mutex.Lock()
+	defer exp.mutex.Unlock()
+	reasons, _ := exp.mismatches(sreq)
+	return len(reasons) == 0
+}
+
+func (exp *Expectation) matchesCmd(cmdName string) bool {
+	return cmdName == exp.cmd || cmdName == rt.EnsureHasExecExt(exp.cmd)
+}
+
+// cmdMismatchDistance is the distance of an expectation for another command,
+// so that expectations for the requested command are always closer.
+const cmdMismatchDistance = 1000
+
+// mismatches returns the reasons the request does not match the expectation, none if it matches,
+// as well as the distance used to find the expectations closest to an unmatched request.
+// The mutex of the expectation must be held.
+func (exp *Expectation) mismatches(sreq StubRequest) (reasons []string, distance int) {
+	reasons = make([]string, 0)
+	if !exp.matchesCmd(sreq.CmdName) {
+		reasons = append(reasons, fmt.Sprintf("cmd %q != %q", sreq.CmdName, exp.cmd))
+		distance += cmdMismatchDistance
+	}
+	if exp.anyArgs {
+		return reasons, distance
+	}
+	if len(sreq.Args) != exp.argCount {
+		reasons = append(reasons, fmt.Sprintf("arg count %d != %d", len(sreq.Args), exp.argCount))
+		distance++
+	}
+	for i, matcher := range exp.argMatchers {
+		if i >= len(sreq.Args) {
+			break
+		}
+		if !matcher.Matches(sreq.Args[i]) {
+			reasons = append(reasons, fmt.Sprintf("arg[%d] %q does not match %s", i, sreq.Args[i], matcher))
+			distance++
+		}
+	}
+	return reasons, distance
+}
+
+// Expectations is a set of expected command executions, which serves as StubFunc.
+// An execution yields the outcome of the first expectation it matches.
+// An unmatched execution yields an outcome with an InternalErrTxt listing the closest expectations.
+//
+// e.g.
+//
+//	stubs := comproto.NewExpectations()
+//	stubs.On("git").WithArgs("clone", comproto.AnyString(), comproto.HasPrefix("/tmp")).Return(&outcome)
+//	key, err := stubber.WhenExecDoStubFunc(stubs.StubFunc, "git", settings)
+type Expectations struct {
+	expectations []*Expectation
+	mutex        sync.Mutex
+}
+
+// maxClosestExpectations is the maximum number of expectations listed for an unmatched request.
+const maxClosestExpectations = 3
+
+// NewExpectations constructs a new empty Expectations.
+func NewExpectations() *Expectations {
+	return &Expectations{expectations: make([]*Expectation, 0)}
+}
+
+// On adds an expectation for an execution of the given command, accepting any arguments
+// and yielding an empty successful outcome until configured otherwise.
+func (stubs *Expectations) On(cmd string) *Expectation {
+	stubs.mutex.Lock()
+	defer stubs.mutex.Unlock()
+	exp := newExpectation(cmd, &stubs.mutex)
+	stubs.expectations = append(stubs.expectations, exp)
+	return exp
+}
+
+// CallTo returns an expectation for an execution of the given command, accepting any arguments
+// and yielding an empty successful outcome until configured otherwise.
+// It is also used to describe the executions to verify, e.g.
+//
+//	stubber.AssertCalledTimes(t, 1, comproto.CallTo("git").WithArgs("push", comproto.AnyString()))
+func CallTo(cmd string) *Expectation {
+	return newExpectation(cmd, &sync.Mutex{})
+}
+
+func newExpectation(cmd string, mutex *sync.Mutex) *Expectation {
+	exp := &Expectation{mutex: mutex, cmd: strings.TrimSpace(cmd), stubFunc: stubFuncReturning(&ExecOutcome{})}
+	exp.acceptAnyArgs()
+	return exp
+}
+
+// StubFunc returns the outcome of the first expectation matching the request.
+// The stub func of the matched expectation is called without holding the lock,
+// so that it may configure the expectations.
+func (stubs *Expectations) StubFunc(sreq StubRequest) *ExecOutcome {
+	stubFunc, errTxt := stubs.match(sreq)
+	if stubFunc != nil {
+		return stubFunc(sreq)
+	}
+	return &ExecOutcome{
+		Key:            sreq.Key,
+		ExitCode:       math.MaxUint8,
+		InternalErrTxt: errTxt,
+	}
+}
+
+// match returns the stub func of the first expectation matching the request,
+// or the text describing the closest expectations if none matches.
+func (stubs *Expectations) match(sreq StubRequest) (stubFunc StubFunc, errTxt string) {
+	stubs.mutex.Lock()
+	defer stubs.mutex.Unlock()
+
+	type closeExpectation struct {
+		description string
+		reasons     []string
+		distance    int
+	}
+	closest := make([]closeExpectation, 0, len(stubs.expectations))
+	for _, exp := range stubs.expectations {
+		reasons, distance := exp.mismatches(sreq)
+		if len(reasons) == 0 {
+			return exp.stubFunc, ""
+		}
+		closest = append(closest, closeExpectation{description: exp.describe(), reasons: reasons, distance: distance})
+	}
+
+	sort.SliceStable(closest, func(i, j int) bool {
+		return closest[i].distance < closest[j].distance
+	})
+	var errTxtBuilder strings.Builder
+	fmt.Fprintf(&errTxtBuilder, "no expectation matches request: cmd=%q args=%q", sreq.CmdName, sreq.Args)
+	if len(closest) == 0 {
+		errTxtBuilder.WriteString("\nno expectation has been specified")
+	} else {
+		errTxtBuilder.WriteString("\nclosest expectations:")
+	}
+	for i, closeExp := range closest {
+		if i >= maxClosestExpectations {
+			break
+		}
+		fmt.Fprintf(&errTxtBuilder, "\n\t- %s: %s", closeExp.description, strings.Join(closeExp.reasons, "; "))
+	}
+	return nil, errTxtBuilder.String()
+}
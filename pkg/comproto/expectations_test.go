@@ -0,0 +1,167 @@
+// Copyright 2020 The Execstub Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package comproto
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	rt "github.com/congop/execstub/internal/runtime"
+)
+
+func gitExpectations() *Expectations {
+	stubs := NewExpectations()
+	stubs.On("git").WithArgs("clone", AnyString(), HasPrefix("/tmp")).
+		Return(&ExecOutcome{Stderr: "Cloning into '/tmp/repo'...", ExitCode: 0})
+	stubs.On("git").WithArgs("checkout", MatchesRegex(`^v\d+\.\d+\.\d+$`)).
+		Return(&ExecOutcome{Stderr: "HEAD is now at 1a2b3c4", ExitCode: 0})
+	stubs.On("git").WithArgCount(0).
+		Return(&ExecOutcome{Stdout: "usage: git [--version] [--help]", ExitCode: 1})
+	stubs.On("git").WithArgs("status").
+		ReturnFunc(func(sreq StubRequest) *ExecOutcome {
+			return &ExecOutcome{Stdout: "On branch main in " + sreq.Dir}
+		})
+	stubs.On("kubectl").
+		Return(&ExecOutcome{Stdout: "kubectl any args"})
+	return stubs
+}
+
+func TestExpectations_StubFunc(t *testing.T) {
+	tests := []struct {
+		name string
+		sreq StubRequest
+		want ExecOutcome
+	}{
+		{
+			name: "exact, any and prefix matchers",
+			sreq: StubRequest{CmdName: "git", Args: []string{"clone", "https://github.com/congop/execstub", "/tmp/repo"}},
+			want: ExecOutcome{Stderr: "Cloning into '/tmp/repo'...", ExitCode: 0},
+		},
+		{
+			name: "regex matcher",
+			sreq: StubRequest{CmdName: "git", Args: []string{"checkout", "v1.14.2"}},
+			want: ExecOutcome{Stderr: "HEAD is now at 1a2b3c4", ExitCode: 0},
+		},
+		{
+			name: "arg count matcher",
+			sreq: StubRequest{CmdName: rt.EnsureHasExecExt("git"), Args: nil},
+			want: ExecOutcome{Stdout: "usage: git [--version] [--help]", ExitCode: 1},
+		},
+		{
+			name: "return func",
+			sreq: StubRequest{CmdName: "git", Args: []string{"status"}, Dir: "/src"},
+			want: ExecOutcome{Stdout: "On branch main in /src"},
+		},
+		{
+			name: "any args",
+			sreq: StubRequest{CmdName: "kubectl", Args: []string{"get", "pods", "-o", "json"}},
+			want: ExecOutcome{Stdout: "kubectl any args"},
+		},
+	}
+	stubs := gitExpectations()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := stubs.StubFunc(tt.sreq)
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("StubFunc() = %#v, want %#v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpectations_StubFuncFirstMatchWins(t *testing.T) {
+	stubs := NewExpectations()
+	stubs.On("helm").WithArgs("list").Return(&ExecOutcome{Stdout: "first"})
+	stubs.On("helm").WithAnyArgs().Return(&ExecOutcome{Stdout: "second"})
+
+	if got := stubs.StubFunc(StubRequest{CmdName: "helm", Args: []string{"list"}}); got.Stdout != "first" {
+		t.Errorf("first matching expectation should have been used, but got:%#v", got)
+	}
+	if got := stubs.StubFunc(StubRequest{CmdName: "helm", Args: []string{"version"}}); got.Stdout != "second" {
+		t.Errorf("second expectation should have been used, but got:%#v", got)
+	}
+}
+
+func TestExpectations_StubFuncUnmatchedListsClosestExpectations(t *testing.T) {
+	stubs := gitExpectations()
+	sreq := StubRequest{Key: "git_42", CmdName: "git", Args: []string{"clone", "https://github.com/congop/execstub", "/home/repo"}}
+
+	got := stubs.StubFunc(sreq)
+
+	if got.ExitCode != math.MaxUint8 || got.Key != "git_42" {
+		t.Errorf("unmatched request should yield exit code 255 and the request key, but got:%#v", got)
+	}
+	wantLines := []string{
+		`no expectation matches request: cmd="git" args=["clone" "https://github.com/congop/execstub" "/home/repo"]`,
+		`closest expectations:`,
+		"\t" + `- On("git").WithArgs("clone", AnyString(), HasPrefix("/tmp")): arg[2] "/home/repo" does not match HasPrefix("/tmp")`,
+		"\t" + `- On("git").WithArgCount(0): arg count 3 != 0`,
+		"\t" + `- On("git").WithArgs("status"): arg count 3 != 1; arg[0] "clone" does not match "status"`,
+	}
+	if gotLines := strings.Split(got.InternalErrTxt, "\n"); !reflect.DeepEqual(gotLines, wantLines) {
+		t.Errorf("InternalErrTxt=\n%s\nwant:\n%s", got.InternalErrTxt, strings.Join(wantLines, "\n"))
+	}
+}
+
+func TestExpectations_StubFuncWithoutExpectation(t *testing.T) {
+	got := NewExpectations().StubFunc(StubRequest{CmdName: "git", Args: []string{"pull"}})
+	if !strings.Contains(got.InternalErrTxt, "no expectation has been specified") {
+		t.Errorf("InternalErrTxt should state that no expectation has been specified, but got:%s", got.InternalErrTxt)
+	}
+}
+
+func TestExpectations_ConfiguredWhileInUse(t *testing.T) {
+	stubs := NewExpectations()
+	exp := stubs.On("docker")
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			stubs.StubFunc(StubRequest{CmdName: "docker", Args: []string{"ps"}})
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			exp.WithArgCount(2).WithArgs("ps").WithAnyArgs().Return(&ExecOutcome{ExitCode: uint8(i)})
+			_ = exp.String()
+		}
+	}()
+	wg.Wait()
+
+	if got := stubs.StubFunc(StubRequest{CmdName: "docker", Args: []string{"ps"}}); got.ExitCode != 99 {
+		t.Errorf("StubFunc() got ExitCode=%d, want 99 from the last configured outcome", got.ExitCode)
+	}
+}
+
+func TestExpectations_StubFuncMayConfigureExpectations(t *testing.T) {
+	stubs := NewExpectations()
+	exp := stubs.On("docker")
+	exp.ReturnFunc(func(sreq StubRequest) *ExecOutcome {
+		exp.Return(&ExecOutcome{Stdout: "second"})
+		stubs.On("podman")
+		return &ExecOutcome{Stdout: "first"}
+	})
+
+	for _, want := range []string{"first", "second"} {
+		if got := stubs.StubFunc(StubRequest{CmdName: "docker"}); got.Stdout != want {
+			t.Errorf("StubFunc() got stdout=%q, want %q", got.Stdout, want)
+		}
+	}
+}
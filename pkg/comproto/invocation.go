@@ -0,0 +1,225 @@
+// Copyright 2020 The Execstub Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package comproto
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/congop/execstub/internal/fifo"
+	"github.com/congop/execstub/internal/rand"
+	"github.com/pkg/errors"
+)
+
+// An invocation is a single execution of a stub executable in dynamic mode.
+// It is identified by an id which is used to correlate the request with its outcome:
+//	- the request is written into a file dedicated to the invocation,
+//	- only the invocation id is sent through the shared stubber pipe,
+//	  keeping the write smaller than PIPE_BUF and therefore atomic,
+//	- the outcome is sent back through a named pipe dedicated to the invocation.
+// So concurrent executions of the same stub can neither interleave their requests
+// nor read each others outcomes.
+
+// StopOperationInvocationID is the invocation id used to request the stubber side to stop.
+const StopOperationInvocationID = "0_0"
+
+var invocationIDRegex = regexp.MustCompile(`^[0-9a-zA-Z_]+$`)
+
+// NewInvocationID returns a new invocation id.
+// The process id is part of the id, so that ids of concurrent invocation do not collide.
+func NewInvocationID() string {
+	return fmt.Sprintf("%d_%s", os.Getpid(), rand.NextRandInt63AsHexStr())
+}
+
+// InvocationProcessID returns the id of the process which has created the given invocation id.
+func InvocationProcessID(invocationID string) (pid int, err error) {
+	pidTxt := strings.SplitN(invocationID, "_", 2)[0]
+	pid, err = strconv.Atoi(pidTxt)
+	if err != nil || pid <= 0 {
+		return 0, errors.Errorf("no process id in invocation id:'%s'", invocationID)
+	}
+	return pid, nil
+}
+
+func assertInvocationIDHasRightFormat(invocationID string) error {
+	if !invocationIDRegex.MatchString(invocationID) {
+		return errors.Errorf("bad invocation id format, expected:%s, but got:'%s'", invocationIDRegex, invocationID)
+	}
+	return nil
+}
+
+// InvocationResponsePipePath returns the path of the named pipe the outcome of the invocation is sent through.
+func InvocationResponsePipePath(pipeTestHelperProc string, invocationID string) string {
+	return pipeTestHelperProc + "_" + invocationID
+}
+
+// InvocationRequestFilePath returns the path of the file holding the request of the invocation.
+func InvocationRequestFilePath(pipeTestHelperProc string, invocationID string) string {
+	return InvocationResponsePipePath(pipeTestHelperProc, invocationID) + "_req"
+}
+
+// InvocationIDReader reads the ids of the invocations sent through the stubber pipe.
+type InvocationIDReader struct {
+	reader *bufio.Reader
+}
+
+// NewInvocationIDReader creates a new InvocationIDReader reading from the given reader.
+func NewInvocationIDReader(reader io.Reader) *InvocationIDReader {
+	return &InvocationIDReader{reader: bufio.NewReader(reader)}
+}
+
+// Next reads the id of the next invocation.
+func (idReader *InvocationIDReader) Next() (string, error) {
+	line, err := idReader.reader.ReadString('\n')
+	if err != nil {
+		return "", errors.Wrapf(err, "fail to read invocation id line, line read so far='%s'", line)
+	}
+	invocationID, err := base64ToStr(strings.TrimSpace(line))
+	if err != nil {
+		return "", errors.Wrapf(err, "while invocation id <- base64ToStr('%s')", line)
+	}
+	if err := assertInvocationIDHasRightFormat(invocationID); err != nil {
+		return "", err
+	}
+	return invocationID, nil
+}
+
+// WriteInvocationIDToNamedPipe writes the invocation id to the stubber named pipe.
+func WriteInvocationIDToNamedPipe(pipePath string, invocationID string, timeout time.Duration) error {
+	if err := assertInvocationIDHasRightFormat(invocationID); err != nil {
+		return err
+	}
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	writer, err := fifo.OpenFifo(ctx, pipePath, syscall.O_WRONLY, os.ModeNamedPipe)
+	if err != nil {
+		return errors.Wrapf(err,
+			"Error getting writer for named pipe[%s]: err:%v", pipePath, err)
+	}
+	defer writer.Close()
+	// a single write, so that the line does not interleave with the ones of concurrent invocations
+	if _, err := io.WriteString(writer, strToBase64(invocationID)+"\n"); err != nil {
+		return errors.Wrapf(err,
+			"Error writing invocation id to named pipe[%s]: err:%v", pipePath, err)
+	}
+	return nil
+}
+
+// WriteInvocationRequest writes the request of the invocation into its dedicated file.
+func WriteInvocationRequest(pipeTestHelperProc string, invocationID string, req StubRequest) error {
+	reqFilePath := InvocationRequestFilePath(pipeTestHelperProc, invocationID)
+	reqFile, err := os.OpenFile(reqFilePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return errors.Wrapf(err, "fail to create invocation request file:%s", reqFilePath)
+	}
+	defer reqFile.Close()
+	if err := StubRequestEncoderFunc(reqFile)(&req); err != nil {
+		return errors.Wrapf(err, "fail to write invocation request to file:%s", reqFilePath)
+	}
+	return nil
+}
+
+// ReadInvocationRequest reads the request of the invocation from its dedicated file and deletes the file.
+func ReadInvocationRequest(pipeTestHelperProc string, invocationID string) (*StubRequest, error) {
+	req, _, err := ReadInvocationRequestWithFormat(pipeTestHelperProc, invocationID)
+	return req, err
+}
+
+// ReadInvocationRequestWithFormat reads the request of the invocation from its dedicated file,
+// deletes the file and tells the wire format the outcome has to be sent back with:
+// the one the request has been written with.
+func ReadInvocationRequestWithFormat(
+	pipeTestHelperProc string, invocationID string,
+) (*StubRequest, WireFormat, error) {
+	if err := assertInvocationIDHasRightFormat(invocationID); err != nil {
+		return nil, WireFormatCSV, err
+	}
+	reqFilePath := InvocationRequestFilePath(pipeTestHelperProc, invocationID)
+	reqBytes, err := ioutil.ReadFile(reqFilePath)
+	if err != nil {
+		return nil, WireFormatCSV, errors.Wrapf(err, "fail to read invocation request file:%s", reqFilePath)
+	}
+	_ = os.Remove(reqFilePath)
+	req, format, err := StubRequestDecoderFuncWithFormat(strings.NewReader(string(reqBytes)))
+	if err != nil {
+		return nil, format, errors.Wrapf(err, "fail to decode invocation request from file:%s", reqFilePath)
+	}
+	return req, format, nil
+}
+
+// WriteInvocationOutcome writes the outcome to the named pipe of the invocation,
+// using the structured wire format.
+func WriteInvocationOutcome(
+	pipeTestHelperProc string, invocationID string, outcome *ExecOutcome, timeout time.Duration,
+) error {
+	return WriteInvocationOutcomeFor(pipeTestHelperProc, invocationID, outcome, WireFormatStructured, timeout)
+}
+
+// WriteInvocationOutcomeFor writes the outcome to the named pipe of the invocation, using the given wire format.
+func WriteInvocationOutcomeFor(
+	pipeTestHelperProc string, invocationID string, outcome *ExecOutcome,
+	format WireFormat, timeout time.Duration,
+) error {
+	if err := assertInvocationIDHasRightFormat(invocationID); err != nil {
+		return err
+	}
+	pipePath := InvocationResponsePipePath(pipeTestHelperProc, invocationID)
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	writer, err := fifo.OpenFifo(ctx, pipePath, syscall.O_WRONLY, os.ModeNamedPipe)
+	if err != nil {
+		return errors.Wrapf(err,
+			"Error getting writer for invocation named pipe[%s]: err:%v", pipePath, err)
+	}
+	defer writer.Close()
+	if err := ExecOutcomeEncoderFuncFor(writer, format)(outcome); err != nil {
+		return errors.Wrapf(err,
+			"Error writing outcome to invocation named pipe[%s]: err:%v", pipePath, err)
+	}
+	return nil
+}
+
+// RequestOutcomeForInvocation sends the request to the stubber and
+// reads the outcome sent back through the named pipe dedicated to the invocation.
+func RequestOutcomeForInvocation(
+	pipeStubber string, pipeTestHelperProc string, req StubRequest, timeout time.Duration,
+) (*ExecOutcome, error) {
+	invocationID := NewInvocationID()
+	respPipePath := InvocationResponsePipePath(pipeTestHelperProc, invocationID)
+	if err := fifo.Mkfifo(respPipePath, 0770); err != nil {
+		return nil, errors.Wrapf(err, "Could not create named pipe for invocation at: %s", respPipePath)
+	}
+	defer os.RemoveAll(respPipePath)
+	defer os.Remove(InvocationRequestFilePath(pipeTestHelperProc, invocationID))
+
+	if err := WriteInvocationRequest(pipeTestHelperProc, invocationID, req); err != nil {
+		return nil, err
+	}
+	if err := WriteInvocationIDToNamedPipe(pipeStubber, invocationID, timeout); err != nil {
+		return nil, err
+	}
+	return ReadStubbingResponseFromNamedPipe(respPipePath, timeout)
+}
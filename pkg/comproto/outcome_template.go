@@ -0,0 +1,232 @@
+// Copyright 2020 The Execstub Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package comproto
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// OutcomeTemplate holds text/template templates of the standard output, the standard error
+// and the exit code of an outcome, which are rendered against the StubRequest of each execution.
+// Besides the fields of the request (e.g. {{.CmdName}}, {{index .Args 0}}) the templates can use:
+//
+//	arg i         the i-th (0-based) argument; "" if there are not enough arguments
+//	lastArg       the last argument; "" if there is none
+//	flag name     the value of the flag (e.g. flag "--branch" for --branch=main or --branch main); "" if absent
+//	hasFlag name  whether the flag is present
+//	env key       the value of the captured environment variable (see Settings.WithEnvCapture); "" if not captured
+//	call          the 1-based number of the execution of the stubbed command
+//	json v        the JSON encoding of v, e.g. to embed an argument as a JSON string
+//	join list sep the elements of the list separated by sep
+//
+// The exit code template must render to a number between 0 and 255 (surrounding spaces are ignored);
+// an empty exit code template means 0.
+type OutcomeTemplate struct {
+	Stdout   string
+	Stderr   string
+	ExitCode string
+}
+
+// callsDirName is the name of the directory within the data dir holding the calls
+// of a stubbed command, used to count them in static mode.
+const callsDirName = "calls"
+
+// Validate checks that all the templates can be parsed.
+func (tmpl OutcomeTemplate) Validate() error {
+	for _, named := range tmpl.named() {
+		if _, err := parseOutcomeTemplate(named[0], named[1], StubRequest{}, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Render renders the templates against the request and the number of the call.
+func (tmpl OutcomeTemplate) Render(req StubRequest, call int) (*ExecOutcome, error) {
+	rendered := make([]string, 0, 3)
+	for _, named := range tmpl.named() {
+		parsed, err := parseOutcomeTemplate(named[0], named[1], req, call)
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		if err := parsed.Execute(&buf, req); err != nil {
+			return nil, errors.Wrapf(err, "fail to render %s template for request %s", named[0], req)
+		}
+		rendered = append(rendered, buf.String())
+	}
+	exitCode, err := exitCodeFromRendered(rendered[2])
+	if err != nil {
+		return nil, err
+	}
+	return &ExecOutcome{
+		Key:      req.Key,
+		Stdout:   rendered[0],
+		Stderr:   rendered[1],
+		ExitCode: exitCode,
+	}, nil
+}
+
+func (tmpl OutcomeTemplate) named() [][2]string {
+	return [][2]string{{"stdout", tmpl.Stdout}, {"stderr", tmpl.Stderr}, {"exit code", tmpl.ExitCode}}
+}
+
+func parseOutcomeTemplate(name string, text string, req StubRequest, call int) (*template.Template, error) {
+	parsed, err := template.New(name).Funcs(outcomeTemplateFuncs(req, call)).Parse(text)
+	if err != nil {
+		return nil, errors.Wrapf(err, "fail to parse %s template: %q", name, text)
+	}
+	return parsed, nil
+}
+
+func outcomeTemplateFuncs(req StubRequest, call int) template.FuncMap {
+	return template.FuncMap{
+		"arg": func(i int) string {
+			if i < 0 || i >= len(req.Args) {
+				return ""
+			}
+			return req.Args[i]
+		},
+		"lastArg": func() string {
+			if len(req.Args) == 0 {
+				return ""
+			}
+			return req.Args[len(req.Args)-1]
+		},
+		"flag": func(name string) string {
+			value, _ := flagValue(req.Args, name)
+			return value
+		},
+		"hasFlag": func(name string) bool {
+			_, found := flagValue(req.Args, name)
+			return found
+		},
+		"env": func(key string) string {
+			value, _ := req.LookupEnv(key)
+			return value
+		},
+		"call": func() int {
+			return call
+		},
+		"json": func(v interface{}) (string, error) {
+			encoded, err := json.Marshal(v)
+			return string(encoded), err
+		},
+		"join": func(list []string, sep string) string {
+			return strings.Join(list, sep)
+		},
+	}
+}
+
+// flagValue returns the value of the flag given as name=value or as name followed by the value.
+// The value is "" for a flag followed by another flag or by nothing.
+func flagValue(args []string, name string) (value string, found bool) {
+	for i, arg := range args {
+		if arg == "--" {
+			return "", false
+		}
+		if strings.HasPrefix(arg, name+"=") {
+			return strings.TrimPrefix(arg, name+"="), true
+		}
+		if arg != name {
+			continue
+		}
+		if i+1 < len(args) && !strings.HasPrefix(args[i+1], "-") {
+			return args[i+1], true
+		}
+		return "", true
+	}
+	return "", false
+}
+
+func exitCodeFromRendered(rendered string) (uint8, error) {
+	txt := strings.TrimSpace(rendered)
+	if txt == "" {
+		return 0, nil
+	}
+	code, err := strconv.ParseUint(txt, 10, 8)
+	if err != nil {
+		return math.MaxUint8, errors.Errorf(
+			"exit code template must render to a number between 0 and %d but rendered %q", math.MaxUint8, rendered)
+	}
+	return uint8(code), nil
+}
+
+// WithRenderedTemplate returns the outcome whose stdout, stderr and exit code have been
+// rendered from its template; the outcome itself if it has no template.
+// A failing rendering is reported as internal error.
+func (outcome ExecOutcome) WithRenderedTemplate(req StubRequest, call int) *ExecOutcome {
+	if outcome.Template == nil {
+		return &outcome
+	}
+	rendered, err := outcome.Template.Render(req, call)
+	outcome.Template = nil
+	if err != nil {
+		outcome.ExitCode = math.MaxUint8
+		outcome.InternalErrTxt = err.Error()
+		return &outcome
+	}
+	outcome.Stdout = rendered.Stdout
+	outcome.Stderr = rendered.Stderr
+	outcome.ExitCode = rendered.ExitCode
+	return &outcome
+}
+
+// WithRenderedTemplate returns the config whose static stdout, stderr and exit code have been
+// rendered from its outcome template; the config itself if it has no outcome template.
+// The number of the call is claimed from the data dir, so that concurrent executions get distinct numbers.
+func (cfg CmdConfig) WithRenderedTemplate(req StubRequest) (CmdConfig, error) {
+	if cfg.OutcomeTemplate == nil {
+		return cfg, nil
+	}
+	call, err := ClaimCallNumber(cfg.DataDir, req)
+	if err != nil {
+		return cfg, err
+	}
+	rendered, err := cfg.OutcomeTemplate.Render(req, call)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.TxtStdout = rendered.Stdout
+	cfg.TxtStderr = rendered.Stderr
+	cfg.ExitCode = rendered.ExitCode
+	return cfg, nil
+}
+
+// ClaimCallNumber claims the 1-based number of the current execution of the stubbed command
+// whose data dir is given.
+func ClaimCallNumber(dataDir string, req StubRequest) (int, error) {
+	if dataDir == "" {
+		return 0, errors.New("data dir must not be empty, so cannot claim call number")
+	}
+	callsDir := filepath.Join(dataDir, callsDirName)
+	if err := os.MkdirAll(callsDir, 0700); err != nil {
+		return 0, errors.Wrapf(err, "fail to create calls dir:%s", callsDir)
+	}
+	seq, err := NewInvocationTimeline(callsDir).Append(req)
+	if err != nil {
+		return 0, errors.Wrapf(err, "fail to claim call number")
+	}
+	return int(seq), nil
+}
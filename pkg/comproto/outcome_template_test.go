@@ -0,0 +1,175 @@
+// Copyright 2020 The Execstub Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package comproto
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOutcomeTemplate_Render(t *testing.T) {
+	req := StubRequest{
+		Key:     "git_1234",
+		CmdName: "git",
+		Args:    []string{"clone", "--branch", "main", "--depth=1", "https://example.com/repo.git", "repo"},
+		Env:     []string{"HOME=/home/gopher"},
+	}
+	tests := []struct {
+		name    string
+		tmpl    OutcomeTemplate
+		call    int
+		want    *ExecOutcome
+		wantErr string
+	}{
+		{
+			name: "Should render args, flags, env and call",
+			tmpl: OutcomeTemplate{
+				Stdout:   "Cloning into '{{lastArg}}' ({{flag \"--branch\"}},{{flag \"--depth\"}}) #{{call}}\n",
+				Stderr:   "{{.CmdName}} {{arg 0}}{{arg 42}} home={{env \"HOME\"}}{{env \"NOPE\"}}",
+				ExitCode: " {{if hasFlag \"--force\"}}1{{else}}0{{end}} ",
+			},
+			call: 3,
+			want: &ExecOutcome{
+				Key:    "git_1234",
+				Stdout: "Cloning into 'repo' (main,1) #3\n",
+				Stderr: "git clone home=/home/gopher",
+			},
+		},
+		{
+			name: "Should render json and join",
+			tmpl: OutcomeTemplate{
+				Stdout:   `{"name":{{json (lastArg)}},"args":{{json .Args}}}`,
+				Stderr:   `{{join .Args " "}}`,
+				ExitCode: "{{len .Args}}",
+			},
+			want: &ExecOutcome{
+				Key:      "git_1234",
+				Stdout:   `{"name":"repo","args":["clone","--branch","main","--depth=1","https://example.com/repo.git","repo"]}`,
+				Stderr:   "clone --branch main --depth=1 https://example.com/repo.git repo",
+				ExitCode: 6,
+			},
+		},
+		{
+			name:    "Should fail on unparsable template",
+			tmpl:    OutcomeTemplate{Stdout: "{{lastArg"},
+			wantErr: "fail to parse stdout template",
+		},
+		{
+			name:    "Should fail on exit code out of range",
+			tmpl:    OutcomeTemplate{ExitCode: "256"},
+			wantErr: "exit code template must render to a number between 0 and 255",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.tmpl.Render(req, tt.call)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("OutcomeTemplate.Render() error = %v, wantErr %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("OutcomeTemplate.Render() error = %v", err)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("OutcomeTemplate.Render() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_flagValue(t *testing.T) {
+	tests := []struct {
+		name      string
+		args      []string
+		flag      string
+		wantValue string
+		wantFound bool
+	}{
+		{name: "equal sign", args: []string{"-n", "--output=json"}, flag: "--output", wantValue: "json", wantFound: true},
+		{name: "next arg", args: []string{"get", "-o", "yaml"}, flag: "-o", wantValue: "yaml", wantFound: true},
+		{name: "followed by flag", args: []string{"--force", "-v"}, flag: "--force", wantFound: true},
+		{name: "last", args: []string{"rm", "--force"}, flag: "--force", wantFound: true},
+		{name: "absent", args: []string{"rm", "--forced"}, flag: "--force"},
+		{name: "after double dash", args: []string{"--", "--force"}, flag: "--force"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotValue, gotFound := flagValue(tt.args, tt.flag)
+			if gotValue != tt.wantValue || gotFound != tt.wantFound {
+				t.Errorf("flagValue() = (%q, %v), want (%q, %v)", gotValue, gotFound, tt.wantValue, tt.wantFound)
+			}
+		})
+	}
+}
+
+func TestExecOutcome_WithRenderedTemplate(t *testing.T) {
+	req := StubRequest{Key: "kubectl_1234", CmdName: "kubectl", Args: []string{"get", "pod", "web"}}
+	outcome := ExecOutcome{
+		ExitDelay: 1,
+		Template:  &OutcomeTemplate{Stdout: "pod/{{lastArg}}", ExitCode: "{{call}}"},
+	}
+
+	got := outcome.WithRenderedTemplate(req, 2)
+	want := &ExecOutcome{Stdout: "pod/web", ExitCode: 2, ExitDelay: 1}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ExecOutcome.WithRenderedTemplate() = %#v, want %#v", got, want)
+	}
+
+	outcome.Template = &OutcomeTemplate{Stdout: "{{arg}}"}
+	got = outcome.WithRenderedTemplate(req, 1)
+	if got.ExitCode != 255 || !strings.Contains(got.InternalErrTxt, "fail to render stdout template") {
+		t.Errorf("ExecOutcome.WithRenderedTemplate() should report rendering error, but got %#v", got)
+	}
+}
+
+func TestCmdConfig_WithRenderedTemplateCountsCalls(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "execstub-outcome-template")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dataDir)
+	cfg := CmdConfig{
+		DataDir:         dataDir,
+		OutcomeTemplate: &OutcomeTemplate{Stdout: "call {{call}} of {{.CmdName}}"},
+	}
+	req := StubRequest{Key: "make_1234", CmdName: "make"}
+
+	for call := 1; call <= 3; call++ {
+		rendered, err := cfg.WithRenderedTemplate(req)
+		if err != nil {
+			t.Fatalf("CmdConfig.WithRenderedTemplate() error = %v", err)
+		}
+		want := fmt.Sprintf("call %d of make", call)
+		if rendered.TxtStdout != want || rendered.ExitCode == nil {
+			t.Errorf("CmdConfig.WithRenderedTemplate() stdout=%q exitCode=%v, want stdout=%q exitCode=0",
+				rendered.TxtStdout, rendered.ExitCode, want)
+		}
+	}
+	if cfg.TxtStdout != "" {
+		t.Errorf("CmdConfig.WithRenderedTemplate() must not change the receiver, but stdout=%q", cfg.TxtStdout)
+	}
+
+	requests, err := NewStubRequestDirRepo(dataDir).FindAll()
+	if err != nil || len(*requests) != 0 {
+		t.Errorf("claimed calls must not be taken for persisted requests: requests=%v, err=%v", requests, err)
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright 2020 The Execstub Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package comproto
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// EffectuatePassthrough runs the real executable replaced by the stub with
+// the arguments, stdin and environment of the stubbed command execution.
+// The output of the real executable is forwarded as it arrives.
+// Its outcome is persisted into the data directory (see StubRequestDirRepo.FindAllOutcomes)
+// and replicated, including a termination by signal.
+// The discovery setup in place before stubbing is restored for the real executable:
+// the stub executable directory is removed from PATH, respectively the home env variable
+// is reset to its former value, so that the real executable does not end up calling the stub
+// when executing itself.
+func EffectuatePassthrough(
+	cfg CmdConfig, req StubRequest,
+	stdin io.Reader, stderr io.Writer, stdout io.Writer,
+) (exitCode uint8, err error) {
+	if cfg.CaptureStdin {
+		// stdin has already been consumed while capturing it
+		stdin = strings.NewReader(req.Stdin)
+	}
+	outcome, err := runRealExec(cfg, req, "", os.Environ(), stdin, stderr, stdout)
+	if err != nil {
+		return math.MaxUint8, err
+	}
+	if outcome.TerminationSignal != 0 {
+		return math.MaxUint8, TerminateBySignal(outcome.TerminationSignal)
+	}
+	return outcome.ExitCode, nil
+}
+
+// PassthroughOutcomeFor runs the real executable replaced by the stub from the stubber process
+// and returns its outcome, e.g. to record it together with the request.
+// The real executable gets the captured stdin and working directory of the request
+// and the environment of the stubber, overridden by the captured environment of the request.
+// Like for EffectuatePassthrough its outcome is persisted into the data directory.
+func PassthroughOutcomeFor(cfg CmdConfig, req StubRequest) (*ExecOutcome, error) {
+	environ := append(os.Environ(), req.Env...)
+	outcome, err := runRealExec(
+		cfg, req, req.Dir, environ, strings.NewReader(req.Stdin), ioutil.Discard, ioutil.Discard)
+	if err != nil {
+		return nil, err
+	}
+	return &outcome, nil
+}
+
+// runRealExec runs the real executable in the given dir ("" for the current one) and persists its outcome.
+func runRealExec(
+	cfg CmdConfig, req StubRequest, dir string, environ []string,
+	stdin io.Reader, stderr io.Writer, stdout io.Writer,
+) (outcome ExecOutcome, err error) {
+	if cfg.RealExec == "" {
+		return outcome, errors.Errorf(
+			"cannot delegate to the real executable of %s because it was not found before stubbing",
+			cfg.CmdToStub)
+	}
+	var bufStdout, bufStderr bytes.Buffer
+	cmd := exec.Command(cfg.RealExec, req.Args...) // #nosec
+	cmd.Dir = dir
+	cmd.Env = passthroughEnv(environ, cfg.binDir, cfg.RealHomeEnvKey, cfg.RealHome)
+	cmd.Stdin = stdin
+	cmd.Stdout = io.MultiWriter(stdout, &bufStdout)
+	cmd.Stderr = io.MultiWriter(stderr, &bufStderr)
+	err = cmd.Run()
+	if _, isExitErr := err.(*exec.ExitError); err != nil && !isExitErr {
+		return outcome, errors.Wrapf(err, "fail to run real executable %s", cfg.RealExec)
+	}
+
+	outcome = ExecOutcome{
+		Key:      req.Key,
+		Stdout:   bufStdout.String(),
+		Stderr:   bufStderr.String(),
+		ExitCode: uint8(cmd.ProcessState.ExitCode()),
+	}
+	status, isWaitStatus := cmd.ProcessState.Sys().(syscall.WaitStatus)
+	if isWaitStatus && status.Signaled() {
+		outcome.TerminationSignal = status.Signal()
+	}
+	err = NewStubRequestDirRepo(cfg.DataDir).SaveOutcome(outcome)
+	if err != nil {
+		return outcome, errors.Wrapf(err, "fail to save outcome of real executable %s", cfg.RealExec)
+	}
+	return outcome, nil
+}
+
+// passthroughEnv returns the given environment without stubbing internal
+// variables, with the stub executable directory removed from PATH and
+// with the home env variable reset to the given home, unset if "".
+func passthroughEnv(environ []string, stubBinDir string, homeEnvKey string, home string) []string {
+	env := make([]string, 0, len(environ)+1)
+	for _, entry := range environ {
+		if strings.HasPrefix(entry, envNamePrefixExecstubbing) {
+			continue
+		}
+		if homeEnvKey != "" && strings.HasPrefix(entry, homeEnvKey+"=") {
+			continue
+		}
+		if stubBinDir != "" && strings.HasPrefix(entry, "PATH=") {
+			parts := filepath.SplitList(strings.TrimPrefix(entry, "PATH="))
+			kept := make([]string, 0, len(parts))
+			for _, part := range parts {
+				if filepath.Clean(part) != filepath.Clean(stubBinDir) {
+					kept = append(kept, part)
+				}
+			}
+			entry = "PATH=" + strings.Join(kept, string(os.PathListSeparator))
+		}
+		env = append(env, entry)
+	}
+	if homeEnvKey != "" && home != "" {
+		env = append(env, homeEnvKey+"="+home)
+	}
+	return env
+}
@@ -42,7 +42,7 @@ func EffectuateAlternativeExecOutcome(stubFunc StubFunc) {
 	}
 	cfg, req := cmdConfigWithRequestOrExitOnError()
 
-	err := NewStubRequestDirRepo(cfg.DataDir).Save(req)
+	err := SaveStaticRequest(*cfg, req)
 	if nil != err {
 		fmt.Fprintf(os.Stderr, "fail to save request[%#v]; err=%v", req, err)
 		os.Exit(math.MaxUint8)
@@ -54,16 +54,33 @@ func EffectuateAlternativeExecOutcome(stubFunc StubFunc) {
 	}
 
 	outcome := stubFunc(req)
+	if outcome.Template != nil {
+		call, err := ClaimCallNumber(cfg.DataDir, req)
+		if err != nil {
+			fmt.Fprint(os.Stderr, err)
+			os.Exit(math.MaxUint8)
+		}
+		outcome = outcome.WithRenderedTemplate(req, call)
+	}
+	if outcome.Passthrough {
+		exitPassthrough(*cfg, req)
+	}
 	if outcome.Stderr != "" {
 		fmt.Fprint(os.Stderr, outcome.Stderr)
 	}
 	if outcome.Stdout != "" {
 		fmt.Fprint(os.Stdout, outcome.Stdout)
 	}
+	if err := WriteOutputScript(outcome.OutputScript, os.Stderr, os.Stdout); err != nil {
+		fmt.Fprint(os.Stderr, err)
+		os.Exit(math.MaxUint8)
+	}
 	if outcome.InternalErrTxt != "" {
 		fmt.Fprint(os.Stderr, outcome.InternalErrTxt)
 		os.Exit(math.MaxUint8)
 	}
+	DelayExit(outcome.ExitDelay, outcome.HangUntilSignalled)
+	terminateBySignalIfRequired(outcome.TerminationSignal, os.Stderr)
 	os.Exit(int(outcome.ExitCode))
 }
 
@@ -82,25 +99,32 @@ func EffectuateConfiguredExecOutcome(
 		effectuateStaticOutcome(*cfg, extraJobOnStubRequestForStaticMode, req)
 	}
 
-	pipePathStubber := cfg.PipeStubber
-	pipePathTestHelperProc := cfg.PipeTestHelperProcess
-	timeout := cfg.TimeoutAsDurationOrDefault()
-	exitCode := EffectuateDynamicOutcome(
-		timeout, pipePathStubber, pipePathTestHelperProc,
-		req, os.Stderr, os.Stdout)
+	exitCode := EffectuateDynamicOutcomeFor(*cfg, req, os.Stdin, os.Stderr, os.Stdout)
 	defer os.Exit(int(exitCode))
 }
 
+func exitPassthrough(cfg CmdConfig, req StubRequest) {
+	exitCode, err := EffectuatePassthrough(cfg, req, os.Stdin, os.Stderr, os.Stdout)
+	if err != nil {
+		fmt.Fprint(os.Stderr, err)
+	}
+	os.Exit(int(exitCode))
+}
+
 func cmdConfigWithRequestOrExitOnError() (*CmdConfig, StubRequest) {
 	stubCmdConfigPath := os.Getenv("__EXECSTUBBING_STUB_CMD_CONFIG")
 	cfg, err := CmdConfigLoadedFromFile(stubCmdConfigPath)
 	if err != nil {
-		fmt.Printf(
+		fmt.Fprintf(os.Stderr,
 			"could not load CmdConfig from \nfile:%s \nerr=%v",
 			stubCmdConfigPath, err)
 		os.Exit(math.MaxUint8)
 	}
-	req := cfg.StubRequestWith(actualCmdArgsFromTestHelpProcess())
+	req, err := cfg.StubRequestFor(actualCmdArgsFromTestHelpProcess(), os.Stdin)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not create stub request: err=%v", err)
+		os.Exit(math.MaxUint8)
+	}
 	return cfg, req
 }
 
@@ -146,7 +170,7 @@ func effectuateStaticOutcome(
 	extraJobOnStubRequest func(StubRequest) error,
 	req StubRequest,
 ) {
-	err := NewStubRequestDirRepo(cfg.DataDir).Save(req)
+	err := SaveStaticRequest(cfg, req)
 	if nil != err {
 		fmt.Fprint(os.Stderr,
 			"will not effectuate static outcome because failed at saving request:",
@@ -160,22 +184,40 @@ func effectuateStaticOutcome(
 		os.Exit(math.MaxUint8)
 	}
 
+	if cfg.Passthrough {
+		exitPassthrough(cfg, req)
+	}
+
+	cfg, err = cfg.WithRenderedTemplate(req)
+	if err != nil {
+		fmt.Fprint(os.Stderr, err)
+		os.Exit(math.MaxUint8)
+	}
+
 	if cfg.StderrAvail() {
 		fmt.Fprint(os.Stderr, cfg.TxtStderr)
 	}
 	if cfg.StdoutAvail() {
 		fmt.Fprint(os.Stdout, cfg.TxtStdout)
 	}
+	err = WriteOutputScript(cfg.OutputScript, os.Stderr, os.Stdout)
+	if err != nil {
+		fmt.Fprint(os.Stderr, err)
+		os.Exit(math.MaxUint8)
+	}
 	exitCode, err := cfg.ExitCodeUint8()
 	if err != nil {
 		fmt.Fprint(os.Stderr, err)
 		os.Exit(math.MaxUint8)
 	}
 
+	DelayExit(cfg.ExitDelay, cfg.HangUntilSignalled)
+	terminateBySignalIfRequired(cfg.TerminationSignal, os.Stderr)
 	defer os.Exit(int(exitCode))
 }
 
 // EffectuateDynamicOutcome uses IPC to request execution outcome from running test.
+// Delegating to the real executable is not supported, see EffectuateDynamicOutcomeFor.
 func EffectuateDynamicOutcome(
 	timeout time.Duration,
 	pipePathStubber string,
@@ -185,28 +227,50 @@ func EffectuateDynamicOutcome(
 	stdout io.Writer,
 
 ) (exitCode uint8) {
+	cfg := CmdConfig{
+		StubKey:               req.Key,
+		CmdToStub:             req.CmdName,
+		Timeout:               timeout,
+		PipeStubber:           pipePathStubber,
+		PipeTestHelperProcess: pipePathTestHelperProc,
+	}
+	return EffectuateDynamicOutcomeFor(cfg, req, nil, stderr, stdout)
+}
+
+// EffectuateDynamicOutcomeFor uses IPC to request execution outcome from running test,
+// using the transport (pipes, unix socket or http) and the timeout of the given config.
+// The execution is delegated to the real executable if the outcome requires it,
+// in that case stdin is forwarded to the real executable.
+func EffectuateDynamicOutcomeFor(
+	cfg CmdConfig,
+	req StubRequest,
+	stdin io.Reader,
+	stderr io.Writer,
+	stdout io.Writer,
+) (exitCode uint8) {
+	timeout := cfg.TimeoutAsDurationOrDefault()
 	err := assertStubKeyHasRightFormat(req.Key, fmt.Sprintf("req-to-effectuate-dynamically:::%#v", req))
 	if err != nil {
 		fmt.Fprintf(stderr,
-			"bad Stub Key while writing stubbing request to stubber named pipe[%s] running stub:%v",
-			pipePathStubber, err)
+			"bad Stub Key while writing stubbing request to stubber %s running stub:%v",
+			cfg.transportDescription(), err)
 		return 66
 	}
-	err = WriteStubRequestToNamedPipe(pipePathStubber, req, timeout)
+	outcome, err := requestOutcomeThroughTransport(cfg, req, timeout)
 	if err != nil {
 		fmt.Fprintf(stderr,
-			"error while writing stubbing request to stubber named pipe[%s] running stub:%v // %#v",
-			pipePathStubber, err, err)
+			"error while requesting stubing outcome through %s running stub:"+
+				" \n\ttimeoutMillis=%d \n\terr=%v",
+			cfg.transportDescription(), timeout/time.Millisecond, err)
 		return math.MaxUint8
 	}
 
-	outcome, err := ReadStubbingResponseFromNamedPipe(pipePathTestHelperProc, timeout)
-	if err != nil {
-		fmt.Fprintf(stderr,
-			"error while reading stubing outcome from test helper named pipe[%s]running stub:"+
-				" \n\ttimeoutMillis=%d \n\terr=%v",
-			pipePathTestHelperProc, timeout/time.Microsecond, err)
-		return math.MaxUint8
+	if outcome.Passthrough {
+		exitCode, err = EffectuatePassthrough(cfg, req, stdin, stderr, stdout)
+		if err != nil {
+			fmt.Fprint(stderr, err)
+		}
+		return exitCode
 	}
 
 	if outcome.StdoutAvail() {
@@ -216,14 +280,89 @@ func EffectuateDynamicOutcome(
 		fmt.Fprint(stderr, outcome.Stderr)
 	}
 
+	err = WriteOutputScript(outcome.OutputScript, stderr, stdout)
+	if err != nil {
+		fmt.Fprint(stderr, err)
+		return math.MaxUint8
+	}
+
 	if outcome.InternalErrTxtAvail() {
 		fmt.Fprint(stderr, outcome.InternalErrTxt)
 		return math.MaxUint8
 	}
 
+	DelayExit(outcome.ExitDelay, outcome.HangUntilSignalled)
+	if outcome.TerminationSignal != 0 {
+		err = TerminateBySignal(outcome.TerminationSignal)
+		fmt.Fprint(stderr, err)
+		return math.MaxUint8
+	}
 	return outcome.ExitCode
 }
 
+func terminateBySignalIfRequired(sig syscall.Signal, stderr io.Writer) {
+	if sig == 0 {
+		return
+	}
+	err := TerminateBySignal(sig)
+	fmt.Fprint(stderr, err)
+	os.Exit(math.MaxUint8)
+}
+
+// delayExitParentCheckPeriod is the period at which DelayExit checks
+// whether the parent process is still alive.
+const delayExitParentCheckPeriod = 100 * time.Millisecond
+
+// DelayExit blocks for the given exit delay or forever if hanging until signalled.
+// It is intended to be called by a stub process just before exiting so that
+// the process behaves like a slow respectively wedged command, which only
+// terminates on a signal (e.g. SIGKILL sent by exec.CommandContext).
+// It returns early if the parent process is gone, so that a test helper
+// process does not outlive the killed stub executable which spawned it.
+func DelayExit(exitDelay time.Duration, hangUntilSignalled bool) {
+	if exitDelay <= 0 && !hangUntilSignalled {
+		return
+	}
+	parentPid := os.Getppid()
+	deadline := time.Now().Add(exitDelay)
+	for {
+		remaining := time.Until(deadline)
+		if !hangUntilSignalled && remaining <= 0 {
+			return
+		}
+		if hangUntilSignalled || remaining > delayExitParentCheckPeriod {
+			remaining = delayExitParentCheckPeriod
+		}
+		time.Sleep(remaining)
+		if os.Getppid() != parentPid {
+			return
+		}
+	}
+}
+
+// WriteOutputScript writes the chunks of the output script in order
+// into the corresponding stream, each one after its delay has elapsed.
+func WriteOutputScript(script []OutputChunk, stderr io.Writer, stdout io.Writer) error {
+	for i, chunk := range script {
+		if chunk.Delay > 0 {
+			time.Sleep(chunk.Delay)
+		}
+		var writer io.Writer
+		switch chunk.Stream {
+		case StreamStdout:
+			writer = stdout
+		case StreamStderr:
+			writer = stderr
+		default:
+			return errors.Errorf("unsupported stream of chunk[%d]: %d", i, chunk.Stream)
+		}
+		if _, err := io.WriteString(writer, chunk.Data); err != nil {
+			return errors.Wrapf(err, "fail to write chunk[%d] to stream %d", i, chunk.Stream)
+		}
+	}
+	return nil
+}
+
 // ReadStubbingResponseFromNamedPipe reads the stubbing outcome from the named pipe.
 func ReadStubbingResponseFromNamedPipe(pipePath string, timeout time.Duration) (*ExecOutcome, error) {
 	timeoutChan := make(chan bool, 1)
@@ -14,7 +14,12 @@
 
 package comproto
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+	"syscall"
+	"time"
+)
 
 // StubRequest holds data send to a stubber through a ComChannel to request stubbing
 type StubRequest struct {
@@ -22,6 +27,21 @@ type StubRequest struct {
 	Key     string
 	CmdName string
 	Args    []string
+
+	// Stdin holds the bytes the stubbed command has received through its
+	// standard input. It is only captured if requested by the settings
+	// (see Settings.WithStdinCapture), otherwise it is "".
+	Stdin string
+
+	// Dir is the working directory of the stubbed command.
+	// It is only captured if requested by the settings
+	// (see Settings.WithDirCapture), otherwise it is "".
+	Dir string
+
+	// Env holds the environment entries (key=value) of the stubbed command
+	// which have been accepted by the configured EnvFilter
+	// (see Settings.WithEnvCapture).
+	Env []string
 }
 
 // ExecOutcome modells what happens when the stubbed command is executed.
@@ -38,16 +58,97 @@ type ExecOutcome struct {
 	Key string
 
 	// Expected command exec outcome
-	// the receiving side is expected forward them as is
+	// the receiving side is expected forward them as is.
+	// Stdout and Stderr are byte strings: any byte sequence (e.g. NUL bytes,
+	// trailing new lines, non UTF-8 data) is written byte-exact by every
+	// stub executable kind, in static as well as in dynamic mode.
 	Stdout   string
 	Stderr   string
 	ExitCode uint8
 
+	// OutputScript holds chunks of output which are written in order after
+	// Stdout and Stderr, each one after its delay has elapsed.
+	// It allows simulating output as it arrives (e.g. progress bars)
+	// and interleaving of stdout and stderr.
+	OutputScript []OutputChunk
+
+	// ExitDelay is the time the stub process waits after having written
+	// its output before exiting, simulating a slow command.
+	ExitDelay time.Duration
+
+	// HangUntilSignalled makes the stub process never exit after having
+	// written its output until it gets signalled (e.g. killed by
+	// exec.CommandContext), simulating a wedged command.
+	// ExitDelay is ignored if set.
+	HangUntilSignalled bool
+
+	// TerminationSignal if not 0 makes the stub process kill itself with the
+	// signal after having written its output, instead of exiting with ExitCode.
+	// It allows simulating e.g. OOM-kills (SIGKILL) or crashes (SIGSEGV, SIGABRT).
+	// It is not supported on windows.
+	TerminationSignal syscall.Signal
+
+	// Passthrough requires the stubbed command execution to be delegated to
+	// the real executable (see AdaptPassthroughToCmdStub); the other outcome
+	// fields are then ignored.
+	Passthrough bool
+
+	// Template if not nil holds templates of Stdout, Stderr and ExitCode, which are
+	// rendered against the request of each execution and replace them
+	// (see TemplatedOutcome). It is rendered before the outcome is sent to the
+	// stub executable, respectively by the stub executable itself in static mode.
+	Template *OutcomeTemplate
+
 	InternalErrTxt string
 }
 
+// OutputStream identifies the standard stream an output chunk is written to.
+// Its value is the file descriptor of the stream.
+type OutputStream uint8
+
+const (
+	// StreamStdout identifies the standard output stream.
+	StreamStdout OutputStream = 1
+
+	// StreamStderr identifies the standard error stream.
+	StreamStderr OutputStream = 2
+)
+
+// OutputChunk is a piece of output written into a stream after a delay.
+type OutputChunk struct {
+	Stream OutputStream
+	// Data is a byte string, which is written byte-exact.
+	Data string
+	// Delay is the time to wait before writing Data.
+	Delay time.Duration
+}
+
+// StdoutChunk returns a chunk of data written to stdout after the given delay.
+func StdoutChunk(delay time.Duration, data string) OutputChunk {
+	return OutputChunk{Stream: StreamStdout, Data: data, Delay: delay}
+}
+
+// StderrChunk returns a chunk of data written to stderr after the given delay.
+func StderrChunk(delay time.Duration, data string) OutputChunk {
+	return OutputChunk{Stream: StreamStderr, Data: data, Delay: delay}
+}
+
 func (req StubRequest) String() string {
-	return fmt.Sprintf("StubRequest[Key=%s, CmdName=%s, Args=%v]", req.Key, req.CmdName, req.Args)
+	return fmt.Sprintf(
+		"StubRequest[Key=%s, CmdName=%s, Args=%v, Stdin=%q, Dir=%s, Env=%v]",
+		req.Key, req.CmdName, req.Args, req.Stdin, req.Dir, req.Env)
+}
+
+// LookupEnv retrieves the value of the captured environment variable named by the key.
+// The returned boolean is false if the variable has not been captured.
+func (req StubRequest) LookupEnv(key string) (value string, found bool) {
+	prefix := key + "="
+	for _, entry := range req.Env {
+		if strings.HasPrefix(entry, prefix) {
+			return entry[len(prefix):], true
+		}
+	}
+	return "", false
 }
 
 // StderrAvail return true if Stderr is available (not "") and false otherwise.
@@ -60,16 +161,27 @@ func (o ExecOutcome) StdoutAvail() bool {
 	return o.Stdout != ""
 }
 
+// OutputScriptAvail return true if OutputScript has chunks and false otherwise.
+func (o ExecOutcome) OutputScriptAvail() bool {
+	return len(o.OutputScript) > 0
+}
+
 // InternalErrTxtAvail return true if InternalErrTxt is available (not "") and false otherwise.
 func (o ExecOutcome) InternalErrTxtAvail() bool {
 	return o.InternalErrTxt != ""
 }
 
+// StopOperationRequest returns the request which used to stop the stubber side.
+//
+// Deprecated: the stubber side stops once its com channel is closed, stop requests are not enqueued.
 func StopOperationRequest() *StubRequest {
 	req := StubRequest{Key: "0_0"}
 	return &req
 }
 
+// IsRequestingStop tells whether the request is a stop request (see StopOperationRequest).
+//
+// Deprecated: the stubber side stops once its com channel is closed, stop requests are not enqueued.
 func (req StubRequest) IsRequestingStop() bool {
 	return req.Key == "0_0"
 }
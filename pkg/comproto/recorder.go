@@ -0,0 +1,161 @@
+// Copyright 2020 The Execstub Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package comproto
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RequestMatcher decides whether a stub request is of interest, e.g. an *Expectation built using CallTo(..).
+type RequestMatcher interface {
+	Matches(sreq StubRequest) bool
+}
+
+// InvocationRecorder records stub requests and can safely be read while the stubs are running,
+// e.g. when the code under test executes commands from background goroutines.
+// A test can block until a number of matching invocations have happened (see AwaitInvocations)
+// or subscribe to the invocations as they occur (see Subscribe).
+type InvocationRecorder struct {
+	mutex    sync.Mutex
+	requests []StubRequest
+	// recorded is closed and replaced each time a request is recorded,
+	// so that it wakes up everyone waiting for a new request.
+	recorded chan struct{}
+}
+
+// NewInvocationRecorder constructs a new InvocationRecorder.
+func NewInvocationRecorder() *InvocationRecorder {
+	return &InvocationRecorder{requests: make([]StubRequest, 0), recorded: make(chan struct{})}
+}
+
+// Recording returns a StubFunc recording the requests before delegating them to the given StubFunc.
+func (recorder *InvocationRecorder) Recording(stubFunc StubFunc) StubFunc {
+	return func(sreq StubRequest) *ExecOutcome {
+		recorder.Record(sreq)
+		return stubFunc(sreq)
+	}
+}
+
+// Record records the stub request.
+func (recorder *InvocationRecorder) Record(sreq StubRequest) {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	recorder.requests = append(recorder.requests, sreq)
+	close(recorder.recorded)
+	recorder.recorded = make(chan struct{})
+}
+
+// Requests returns a snapshot of the requests recorded so far, in recording order.
+func (recorder *InvocationRecorder) Requests() []StubRequest {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	return append([]StubRequest{}, recorder.requests...)
+}
+
+// AwaitInvocations blocks until at least n requests matching the matcher have been recorded
+// and returns the matching requests; a nil matcher matches every request.
+// An error is returned together with the matching requests recorded so far if it times out.
+func (recorder *InvocationRecorder) AwaitInvocations(
+	n int, matcher RequestMatcher, timeout time.Duration,
+) ([]StubRequest, error) {
+	timeoutC := time.After(timeout)
+	for {
+		recorder.mutex.Lock()
+		matching := matchingRequests(recorder.requests, matcher)
+		recorded := recorder.recorded
+		recorder.mutex.Unlock()
+		if len(matching) >= n {
+			return matching, nil
+		}
+		select {
+		case <-recorded:
+		case <-timeoutC:
+			return matching, errors.Errorf(
+				"timeout after %s waiting for %d invocation(s) matching %s, but got %d:%s",
+				timeout, n, describeMatcher(matcher), len(matching), describeRequests(recorder.Requests()))
+		}
+	}
+}
+
+// Subscribe returns a channel receiving the requests recorded from now on, in recording order,
+// and a function to end the subscription, which closes the channel.
+// The stubs do not wait for the subscriber: the requests are delivered at the pace they are received.
+func (recorder *InvocationRecorder) Subscribe() (invocations <-chan StubRequest, unsubscribe func()) {
+	recorder.mutex.Lock()
+	next := len(recorder.requests)
+	recorder.mutex.Unlock()
+
+	invocationsC := make(chan StubRequest)
+	stop := make(chan struct{})
+	var stopOnce sync.Once
+	go func() {
+		defer close(invocationsC)
+		for {
+			recorder.mutex.Lock()
+			if next < len(recorder.requests) {
+				sreq := recorder.requests[next]
+				recorder.mutex.Unlock()
+				select {
+				case invocationsC <- sreq:
+					next++
+				case <-stop:
+					return
+				}
+				continue
+			}
+			recorded := recorder.recorded
+			recorder.mutex.Unlock()
+			select {
+			case <-recorded:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return invocationsC, func() { stopOnce.Do(func() { close(stop) }) }
+}
+
+func matchingRequests(sreqs []StubRequest, matcher RequestMatcher) []StubRequest {
+	matching := make([]StubRequest, 0, len(sreqs))
+	for _, sreq := range sreqs {
+		if matcher == nil || matcher.Matches(sreq) {
+			matching = append(matching, sreq)
+		}
+	}
+	return matching
+}
+
+func describeMatcher(matcher RequestMatcher) string {
+	if matcher == nil {
+		return "any request"
+	}
+	if stringer, ok := matcher.(fmt.Stringer); ok {
+		return stringer.String()
+	}
+	return fmt.Sprintf("%#v", matcher)
+}
+
+func describeRequests(sreqs []StubRequest) string {
+	var txt strings.Builder
+	for i, sreq := range sreqs {
+		fmt.Fprintf(&txt, "\n\t%d. %s %q", i+1, sreq.CmdName, sreq.Args)
+	}
+	return txt.String()
+}
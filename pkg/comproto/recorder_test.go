@@ -0,0 +1,147 @@
+// Copyright 2020 The Execstub Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package comproto
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestInvocationRecorder_AwaitInvocationsFromBackgroundGoroutines(t *testing.T) {
+	recorder := NewInvocationRecorder()
+	stubFunc := recorder.Recording(AdaptOutcomeToCmdStub(&ExecOutcome{}))
+	const pushCount = 8
+
+	for i := 0; i < pushCount; i++ {
+		go func() {
+			stubFunc(StubRequest{CmdName: "git", Args: []string{"fetch"}})
+			time.Sleep(10 * time.Millisecond)
+			stubFunc(StubRequest{CmdName: "git", Args: []string{"push"}})
+		}()
+	}
+	pushes, err := recorder.AwaitInvocations(pushCount, CallTo("git").WithArgs("push"), 5*time.Second)
+
+	if err != nil {
+		t.Fatalf("AwaitInvocations() unexpected error: %v", err)
+	}
+	if len(pushes) != pushCount {
+		t.Errorf("AwaitInvocations() got %d requests, want %d: %v", len(pushes), pushCount, pushes)
+	}
+	if got := len(recorder.Requests()); got != 2*pushCount {
+		t.Errorf("Requests() got %d requests, want %d", got, 2*pushCount)
+	}
+}
+
+func TestInvocationRecorder_AwaitInvocationsTimesOut(t *testing.T) {
+	recorder := NewInvocationRecorder()
+	recorder.Record(StubRequest{CmdName: "git", Args: []string{"fetch"}})
+
+	got, err := recorder.AwaitInvocations(1, CallTo("git").WithArgs("push"), 50*time.Millisecond)
+
+	if err == nil {
+		t.Fatalf("AwaitInvocations() expected a timeout error")
+	}
+	for _, want := range []string{
+		`waiting for 1 invocation(s) matching On("git").WithArgs("push"), but got 0`,
+		`1. git ["fetch"]`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("AwaitInvocations() error=%q, want it to contain %q", err, want)
+		}
+	}
+	if len(got) != 0 {
+		t.Errorf("AwaitInvocations() got %v, want no requests", got)
+	}
+}
+
+func TestInvocationRecorder_Subscribe(t *testing.T) {
+	recorder := NewInvocationRecorder()
+	recorder.Record(StubRequest{CmdName: "before-subscription"})
+	invocations, unsubscribe := recorder.Subscribe()
+	want := []StubRequest{
+		{CmdName: "docker", Args: []string{"build", "."}},
+		{CmdName: "docker", Args: []string{"push"}},
+		{CmdName: "kubectl", Args: []string{"apply"}},
+	}
+
+	go func() {
+		for _, sreq := range want {
+			recorder.Record(sreq)
+		}
+	}()
+	got := make([]StubRequest, 0, len(want))
+	for len(got) < len(want) {
+		select {
+		case sreq := <-invocations:
+			got = append(got, sreq)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Subscribe() timeout, got only %v", got)
+		}
+	}
+	unsubscribe()
+	unsubscribe()
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Subscribe() \ngot = %v \nwant= %v", got, want)
+	}
+	select {
+	case _, ok := <-invocations:
+		if ok {
+			t.Errorf("Subscribe() channel should be closed after unsubscribing")
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("Subscribe() channel not closed after unsubscribing")
+	}
+}
+
+func TestAdaptFuncsToCmdStub_ConcurrentExecutions(t *testing.T) {
+	const funcCount = 32
+	funcs := make([]StubFunc, 0, funcCount)
+	for i := 0; i < funcCount; i++ {
+		funcs = append(funcs, AdaptOutcomeToCmdStub(&ExecOutcome{ExitCode: uint8(i)}))
+	}
+	stubFunc := AdaptFuncsToCmdStub(funcs, false)
+
+	var wg sync.WaitGroup
+	exitCodes := make(chan int, funcCount+1)
+	for i := 0; i < funcCount+1; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			exitCodes <- int(stubFunc(StubRequest{CmdName: "git"}).ExitCode)
+		}()
+	}
+	wg.Wait()
+	close(exitCodes)
+
+	got := make([]int, 0, funcCount+1)
+	for exitCode := range exitCodes {
+		got = append(got, exitCode)
+	}
+	sort.Ints(got)
+	want := make([]int, 0, funcCount+1)
+	for i := 0; i < funcCount; i++ {
+		want = append(want, i)
+	}
+	// the execution in excess gets the internal error exit code
+	want = append(want, 255)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("AdaptFuncsToCmdStub() exit codes \ngot = %v \nwant= %v", got, want)
+	}
+}
@@ -29,6 +29,7 @@ import (
 )
 
 // StubRequestDirRepo a repository to save and load stubbing requests from a directory.
+// It also holds the outcomes of executions delegated to the real executable (passthrough).
 type StubRequestDirRepo struct {
 	dataDir string
 }
@@ -78,7 +79,41 @@ func (repo StubRequestDirRepo) FindAll() (requests *[]StubRequest, err error) {
 	return &reqs, nil
 }
 
-// DeleteAll deletes all persisted requests.
+// FindAllOutcomes return all persisted outcomes of executions delegated
+// to the real executable, in the order of their execution.
+func (repo StubRequestDirRepo) FindAllOutcomes() (outcomes *[]ExecOutcome, err error) {
+	if err := repo.validateDataDir("FindAllOutcomes"); err != nil {
+		return nil, err
+	}
+	outcomeFiles, err := repo.globSerFile(serExecOutcomeFilePrefix)
+	if err != nil {
+		err = errors.Wrap(err, "FindAllOutcomes could not get outcome files")
+		return nil, err
+	}
+	sort.Strings(outcomeFiles)
+
+	found := make([]ExecOutcome, 0, len(outcomeFiles))
+	for _, outcomeFile := range outcomeFiles {
+		srcFile, err := os.OpenFile(outcomeFile, os.O_RDONLY, 0660)
+		if err != nil {
+			err = errors.Wrapf(err,
+				"FindAllOutcomes could not open outcome file for reading, outcomeFile=%s",
+				outcomeFile)
+			return nil, err
+		}
+		outcome, err := ExecOutcomeDecoderFunc(srcFile)
+		srcFile.Close()
+		if err != nil {
+			err = errors.Wrapf(err, "fail to decode outcome from file:%s", outcomeFile)
+			return nil, err
+		}
+		found = append(found, *outcome)
+	}
+
+	return &found, nil
+}
+
+// DeleteAll deletes all persisted requests and outcomes.
 func (repo StubRequestDirRepo) DeleteAll() error {
 	if err := repo.validateDataDir("FindAll"); err != nil {
 		return err
@@ -88,6 +123,12 @@ func (repo StubRequestDirRepo) DeleteAll() error {
 		err = errors.Wrapf(err, "DeleteAll could not get request files")
 		return err
 	}
+	outcomeFiles, err := repo.globSerFile(serExecOutcomeFilePrefix)
+	if err != nil {
+		err = errors.Wrapf(err, "DeleteAll could not get outcome files")
+		return err
+	}
+	reqFiles = append(reqFiles, outcomeFiles...)
 
 	bogusRemovals := make([]string, 0, len(reqFiles))
 	for _, rf := range reqFiles {
@@ -104,6 +145,16 @@ func (repo StubRequestDirRepo) DeleteAll() error {
 
 // Save saves the given request.
 func (repo StubRequestDirRepo) Save(req StubRequest) error {
+	return repo.saveAs(req, nextStubRequestFileName())
+}
+
+// SaveSequenced saves the given request using its sequence number in the invocation timeline
+// to order it, instead of the current time.
+func (repo StubRequestDirRepo) SaveSequenced(req StubRequest, seq uint64) error {
+	return repo.saveAs(req, sequencedStubRequestFileName(seq))
+}
+
+func (repo StubRequestDirRepo) saveAs(req StubRequest, targetFileName string) (err error) {
 	if err := assertStubKeyHasRightFormat(req.Key, fmt.Sprintf("req-to-save-to-repo:::%#v", req)); err != nil {
 		return err
 	}
@@ -111,7 +162,6 @@ func (repo StubRequestDirRepo) Save(req StubRequest) error {
 		return err
 	}
 
-	targetFileName := nextStubRequestFileName()
 	targetFilePath := filepath.Join(repo.dataDir, targetFileName)
 	dstFile, err := os.OpenFile(targetFilePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0660)
 	if err != nil {
@@ -134,6 +184,36 @@ func (repo StubRequestDirRepo) Save(req StubRequest) error {
 	return nil
 }
 
+// SaveOutcome saves the given outcome of an execution delegated to the real executable.
+func (repo StubRequestDirRepo) SaveOutcome(outcome ExecOutcome) (err error) {
+	if err := assertStubKeyHasRightFormat(outcome.Key, fmt.Sprintf("outcome-to-save-to-repo:::%#v", outcome)); err != nil {
+		return err
+	}
+	if err := repo.validateDataDir("SaveOutcome"); err != nil {
+		return err
+	}
+
+	targetFilePath := filepath.Join(repo.dataDir, nextSerFileName(serExecOutcomeFilePrefix))
+	dstFile, err := os.OpenFile(targetFilePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0660)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"fail to create file to write outcome:%s", targetFilePath)
+		return err
+	}
+	defer func() {
+		dstFile.Close()
+		if err != nil {
+			os.Remove(targetFilePath)
+		}
+	}()
+	err = ExecOutcomeEncoderFunc(dstFile)(&outcome)
+	if nil != err {
+		err = errors.Wrapf(err, "faild to encode outcome %#v", &outcome)
+		return err
+	}
+	return nil
+}
+
 func (repo StubRequestDirRepo) validateDataDir(action string) error {
 	if repo.dataDir == "" {
 		return errors.Errorf("dataDir must not be empty, so cannot do <%s>", action)
@@ -141,7 +221,23 @@ func (repo StubRequestDirRepo) validateDataDir(action string) error {
 	return nil
 }
 
+const (
+	serStubRequestFilePrefix = "ser_stubrequest_"
+	serExecOutcomeFilePrefix = "ser_execoutcome_"
+)
+
 func nextStubRequestFileName() string {
+	return nextSerFileName(serStubRequestFilePrefix)
+}
+
+// sequencedStubRequestFileName returns the name of a request file ordered by the given sequence number.
+// The "seq" part sorts after the digits of the time based names, which are not used
+// together with sequenced ones for the same data dir.
+func sequencedStubRequestFileName(seq uint64) string {
+	return fmt.Sprintf("%sseq_%0.20d", serStubRequestFilePrefix, seq)
+}
+
+func nextSerFileName(prefix string) string {
 	// ensures names are chronologically ordered in case the cpu is so faster that
 	// 1 millisecond time resolution will result into duplicates name
 	time.Sleep(2 * time.Millisecond)
@@ -150,11 +246,15 @@ func nextStubRequestFileName() string {
 
 	nowStr = strings.ReplaceAll(nowStr, ".", "-")
 	nowStr = strings.ReplaceAll(nowStr, ":", "-")
-	return fmt.Sprintf("ser_stubrequest_%s_019035%0.6d", nowStr, int(rand.NextUint16()))
+	return fmt.Sprintf("%s%s_019035%0.6d", prefix, nowStr, int(rand.NextUint16()))
 }
 
 func (repo StubRequestDirRepo) globStubRequestSerFile() (reqFiles []string, err error) {
-	reqFilePattern := filepath.Join(repo.dataDir, "ser_stubrequest_*")
+	return repo.globSerFile(serStubRequestFilePrefix)
+}
+
+func (repo StubRequestDirRepo) globSerFile(prefix string) (reqFiles []string, err error) {
+	reqFilePattern := filepath.Join(repo.dataDir, prefix+"*")
 	reqFiles, err = filepath.Glob(reqFilePattern)
 	if err != nil {
 		err = errors.Wrapf(err,
@@ -201,6 +201,59 @@ func TestStubRequestDirRepo_MultSaveFindAllDellAll(t *testing.T) {
 	}
 }
 
+func TestStubRequestDirRepo_SaveFindAllDeleteAllOutcomes(t *testing.T) {
+	req := StubRequest{CmdName: "git", Args: []string{"status"}, Key: "git_223344"}
+	outcomes := []ExecOutcome{
+		{Key: "git_223344", Stdout: "On branch master\n", ExitCode: 0},
+		{Key: "git_223344", Stderr: "fatal: not a git repository\n", ExitCode: 128},
+	}
+
+	tmpDir, err := ioutil.TempDir("", "00-datadir-save-find-del-outcomes*")
+	if err != nil {
+		t.Fatalf("fail to create tmp dir, err=%v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	repo := StubRequestDirRepo{dataDir: tmpDir}
+	if err := repo.Save(req); err != nil {
+		t.Fatalf("StubRequestDirRepo.Save() error = %v", err)
+	}
+	for _, outcome := range outcomes {
+		if err := repo.SaveOutcome(outcome); err != nil {
+			t.Fatalf("StubRequestDirRepo.SaveOutcome() error = %v, outcome=%#v", err, outcome)
+		}
+	}
+	if err := repo.SaveOutcome(ExecOutcome{}); err == nil {
+		t.Errorf("outcome without a legal key should not be saved")
+	}
+
+	gotOutcomes, err := repo.FindAllOutcomes()
+	if err != nil {
+		t.Fatalf("repo fails to FindAllOutcomes with=%v", err)
+	}
+	if !reflect.DeepEqual(outcomes, *gotOutcomes) {
+		t.Errorf("persisted outcomes do not match loaded outcomes:\nwant=%v \ngot =%v", outcomes, *gotOutcomes)
+	}
+	gotReqs, err := repo.FindAll()
+	if err != nil {
+		t.Fatalf("repo fails to FindAll with=%v", err)
+	}
+	if !reflect.DeepEqual([]StubRequest{req}, *gotReqs) {
+		t.Errorf("outcomes should not be found as requests:\nwant=%v \ngot =%v", req, *gotReqs)
+	}
+
+	if err := repo.DeleteAll(); err != nil {
+		t.Fatalf("DeleteAll failed: %v", err)
+	}
+	remainingFiles, err := listDir(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(remainingFiles) != 0 {
+		t.Errorf("DeleteAll should have deleted requests and outcomes, remaining=%v", remainingFiles)
+	}
+}
+
 func listDir(dir string) (dirFileNames []string, err error) {
 	if dir == "" {
 		return []string{}, fmt.Errorf("dir must not be empty")
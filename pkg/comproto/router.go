@@ -0,0 +1,213 @@
+// Copyright 2020 The Execstub Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package comproto
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// SubcommandRouter is a StubFunc dispatching the executions of multi-level CLIs
+// (e.g. git, docker, kubectl, gcloud) on their subcommand path, e.g. "docker image ls" or "git remote add".
+// The subcommand path is made of the leading non-flag arguments; the known global flags
+// (and their values) are skipped wherever they appear, while any other flag ends the path.
+// An execution is dispatched to the route with the longest path which is a prefix of its subcommand path,
+// or to the default StubFunc if none matches.
+//
+// e.g.
+//
+//	router := comproto.NewSubcommandRouter().
+//		WithGlobalFlags("--debug").
+//		WithGlobalValueFlags("-H", "--host").
+//		RouteOutcomes("image ls", []*comproto.ExecOutcome{&listing, &emptyListing}, true).
+//		Route("push", pushStubFunc)
+//	key, err := stubber.WhenExecDoStubFunc(router.StubFunc, "docker", settings)
+type SubcommandRouter struct {
+	globalFlags      map[string]bool
+	globalValueFlags map[string]bool
+	// routes are sorted by decreasing length of their path, so that the longest matching path wins
+	routes      []*subcommandRoute
+	defaultFunc StubFunc
+	mutex       sync.Mutex
+}
+
+type subcommandRoute struct {
+	path     []string
+	stubFunc StubFunc
+}
+
+// NewSubcommandRouter constructs a new SubcommandRouter without routes and without default StubFunc.
+func NewSubcommandRouter() *SubcommandRouter {
+	return &SubcommandRouter{
+		globalFlags:      make(map[string]bool),
+		globalValueFlags: make(map[string]bool),
+		routes:           make([]*subcommandRoute, 0),
+	}
+}
+
+// WithGlobalFlags declares global flags which do not take a value (e.g. "--no-pager"),
+// to be skipped while determining the subcommand path.
+func (router *SubcommandRouter) WithGlobalFlags(flags ...string) *SubcommandRouter {
+	router.mutex.Lock()
+	defer router.mutex.Unlock()
+	for _, flag := range flags {
+		router.globalFlags[strings.TrimSpace(flag)] = true
+	}
+	return router
+}
+
+// WithGlobalValueFlags declares global flags taking a value (e.g. "-C" for git or "--namespace" for kubectl),
+// to be skipped together with their value while determining the subcommand path.
+// Both forms "--flag value" and "--flag=value" are supported.
+func (router *SubcommandRouter) WithGlobalValueFlags(flags ...string) *SubcommandRouter {
+	router.mutex.Lock()
+	defer router.mutex.Unlock()
+	for _, flag := range flags {
+		router.globalValueFlags[strings.TrimSpace(flag)] = true
+	}
+	return router
+}
+
+// Route dispatches the executions with the given subcommand path (e.g. "remote add") to the StubFunc.
+// A route already specified for the path is replaced.
+// It panics if the path is empty; use Default to handle the executions without subcommand.
+func (router *SubcommandRouter) Route(path string, stubFunc StubFunc) *SubcommandRouter {
+	pathWords := strings.Fields(path)
+	if len(pathWords) == 0 {
+		panic("SubcommandRouter: route path must not be empty, use Default instead")
+	}
+	router.mutex.Lock()
+	defer router.mutex.Unlock()
+	for _, route := range router.routes {
+		if strings.Join(route.path, " ") == strings.Join(pathWords, " ") {
+			route.stubFunc = stubFunc
+			return router
+		}
+	}
+	router.routes = append(router.routes, &subcommandRoute{path: pathWords, stubFunc: stubFunc})
+	sort.SliceStable(router.routes, func(i, j int) bool {
+		return len(router.routes[i].path) > len(router.routes[j].path)
+	})
+	return router
+}
+
+// RouteOutcomes dispatches the executions with the given subcommand path to a StubFunc
+// yielding the given outcomes in sequence (see AdaptOutcomesToCmdStub).
+func (router *SubcommandRouter) RouteOutcomes(
+	path string, outcomes []*ExecOutcome, repeatLast bool,
+) *SubcommandRouter {
+	return router.Route(path, AdaptOutcomesToCmdStub(outcomes, repeatLast))
+}
+
+// Default sets the StubFunc handling the executions not matching any route.
+func (router *SubcommandRouter) Default(stubFunc StubFunc) *SubcommandRouter {
+	router.mutex.Lock()
+	defer router.mutex.Unlock()
+	router.defaultFunc = stubFunc
+	return router
+}
+
+// SubcommandPath returns the subcommand path of the arguments, skipping the known global flags.
+func (router *SubcommandRouter) SubcommandPath(args []string) []string {
+	router.mutex.Lock()
+	defer router.mutex.Unlock()
+	return router.subcommandPathNotThreadSafe(args)
+}
+
+func (router *SubcommandRouter) subcommandPathNotThreadSafe(args []string) []string {
+	path := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			break
+		}
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			path = append(path, arg)
+			continue
+		}
+		flag := arg
+		if index := strings.Index(arg, "="); index >= 0 {
+			flag = arg[:index]
+		}
+		switch {
+		case router.globalValueFlags[flag]:
+			if flag == arg {
+				// the value is the next argument
+				i++
+			}
+		case router.globalFlags[flag]:
+		default:
+			// a subcommand flag ends the subcommand path
+			return path
+		}
+	}
+	return path
+}
+
+// StubFunc dispatches the request to the StubFunc of the matching route, or to the default StubFunc.
+// A request matching no route yields an outcome with an InternalErrTxt listing the routes
+// if no default StubFunc has been set.
+func (router *SubcommandRouter) StubFunc(sreq StubRequest) *ExecOutcome {
+	router.mutex.Lock()
+	path := router.subcommandPathNotThreadSafe(sreq.Args)
+	stubFunc := router.defaultFunc
+	for _, route := range router.routes {
+		if isPathPrefix(route.path, path) {
+			stubFunc = route.stubFunc
+			break
+		}
+	}
+	routePaths := make([]string, 0, len(router.routes))
+	for _, route := range router.routes {
+		routePaths = append(routePaths, strings.Join(route.path, " "))
+	}
+	router.mutex.Unlock()
+
+	if stubFunc != nil {
+		return stubFunc(sreq)
+	}
+	var errTxt strings.Builder
+	fmt.Fprintf(&errTxt, "no route matches subcommand path %q of request: cmd=%q args=%q",
+		path, sreq.CmdName, sreq.Args)
+	if len(routePaths) == 0 {
+		errTxt.WriteString("\nno route has been specified")
+	} else {
+		errTxt.WriteString("\nroutes:")
+	}
+	sort.Strings(routePaths)
+	for _, routePath := range routePaths {
+		fmt.Fprintf(&errTxt, "\n\t- %s", routePath)
+	}
+	return &ExecOutcome{
+		Key:            sreq.Key,
+		ExitCode:       math.MaxUint8,
+		InternalErrTxt: errTxt.String(),
+	}
+}
+
+func isPathPrefix(prefix []string, path []string) bool {
+	if len(prefix) > len(path) {
+		return false
+	}
+	for i, word := range prefix {
+		if path[i] != word {
+			return false
+		}
+	}
+	return true
+}
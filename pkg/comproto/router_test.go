@@ -0,0 +1,117 @@
+// Copyright 2020 The Execstub Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package comproto
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSubcommandRouter_SubcommandPath(t *testing.T) {
+	router := NewSubcommandRouter().
+		WithGlobalFlags("--debug", "--no-pager").
+		WithGlobalValueFlags("-H", "-C", "--namespace")
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{name: "no args", args: []string{}, want: []string{}},
+		{name: "plain path", args: []string{"image", "ls"}, want: []string{"image", "ls"}},
+		{
+			name: "global flags mixed in",
+			args: []string{"--debug", "-H", "tcp://docker:2375", "image", "--no-pager", "ls"},
+			want: []string{"image", "ls"},
+		},
+		{
+			name: "global value flag with equal sign",
+			args: []string{"get", "--namespace=kube-system", "pods"},
+			want: []string{"get", "pods"},
+		},
+		{
+			name: "subcommand flag ends the path",
+			args: []string{"-C", "/repo", "remote", "add", "-f", "origin", "https://example.com/repo.git"},
+			want: []string{"remote", "add"},
+		},
+		{
+			name: "double dash ends the path",
+			args: []string{"checkout", "--", "main.go"},
+			want: []string{"checkout"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := router.SubcommandPath(tt.args); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SubcommandPath() \ngot = %q \nwant= %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubcommandRouter_StubFunc(t *testing.T) {
+	stdoutOf := func(stdout string) StubFunc {
+		return AdaptOutcomeToCmdStub(&ExecOutcome{Stdout: stdout})
+	}
+	router := NewSubcommandRouter().
+		WithGlobalFlags("--debug").
+		WithGlobalValueFlags("-H").
+		Route("image", stdoutOf("image")).
+		RouteOutcomes("image ls", []*ExecOutcome{{Stdout: "nginx"}, {Stdout: "nginx redis"}}, true).
+		Route("push", stdoutOf("push")).
+		Default(stdoutOf("default"))
+	tests := []struct {
+		name       string
+		args       []string
+		wantStdout string
+	}{
+		{name: "longest route wins", args: []string{"image", "ls"}, wantStdout: "nginx"},
+		{name: "route keeps its outcome sequence", args: []string{"--debug", "image", "ls", "-a"}, wantStdout: "nginx redis"},
+		{name: "last outcome repeated", args: []string{"-H", "tcp://docker:2375", "image", "ls"}, wantStdout: "nginx redis"},
+		{name: "shorter route", args: []string{"image", "prune"}, wantStdout: "image"},
+		{name: "single level route", args: []string{"push", "registry/nginx:1.19"}, wantStdout: "push"},
+		{name: "default", args: []string{"version"}, wantStdout: "default"},
+		{name: "default without subcommand", args: []string{"--debug"}, wantStdout: "default"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := router.StubFunc(StubRequest{CmdName: "docker", Args: tt.args})
+			if got.Stdout != tt.wantStdout {
+				t.Errorf("StubFunc() stdout got = %q, want = %q", got.Stdout, tt.wantStdout)
+			}
+		})
+	}
+}
+
+func TestSubcommandRouter_StubFuncWithoutDefault(t *testing.T) {
+	router := NewSubcommandRouter().
+		Route("remote add", AdaptOutcomeToCmdStub(&ExecOutcome{})).
+		Route("push", AdaptOutcomeToCmdStub(&ExecOutcome{}))
+
+	got := router.StubFunc(StubRequest{Key: "git_1", CmdName: "git", Args: []string{"remote", "remove", "origin"}})
+
+	if got.ExitCode != 255 || got.Key != "git_1" {
+		t.Errorf("StubFunc() got ExitCode=%d Key=%s, want 255 and git_1", got.ExitCode, got.Key)
+	}
+	wantErrTxt := strings.Join([]string{
+		`no route matches subcommand path ["remote" "remove" "origin"] of request: cmd="git" args=["remote" "remove" "origin"]`,
+		"routes:",
+		"\t- push",
+		"\t- remote add",
+	}, "\n")
+	if got.InternalErrTxt != wantErrTxt {
+		t.Errorf("StubFunc() InternalErrTxt \ngot = %s \nwant= %s", got.InternalErrTxt, wantErrTxt)
+	}
+}
@@ -0,0 +1,284 @@
+// Copyright 2020 The Execstub Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package comproto
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+)
+
+// ScenarioStarted is the state a scenario starts in, and returns to when reset.
+const ScenarioStarted = "Started"
+
+// Scenario is a named state machine whose outcomes depend on its current state.
+// Each step applies to the requests it matches while the scenario is in the step's state;
+// it yields an outcome and may move the scenario to a new state.
+// A request matching no step of the current state yields an outcome with an InternalErrTxt,
+// unless a default StubFunc has been set.
+//
+// e.g. docker ps lists the container only between docker run and docker rm
+//
+//	scenario := comproto.NewScenario("container")
+//	scenario.WhenIn(comproto.ScenarioStarted, comproto.CallTo("docker").WithArgs("ps")).Return(&noContainer)
+//	scenario.WhenIn(comproto.ScenarioStarted, comproto.CallTo("docker").WithArgs("run", "nginx")).MoveTo("Running")
+//	scenario.WhenIn("Running", comproto.CallTo("docker").WithArgs("ps")).Return(&nginxContainer)
+//	scenario.WhenIn("Running", comproto.CallTo("docker").WithArgs("rm", "nginx")).MoveTo(comproto.ScenarioStarted)
+//	key, err := stubber.WhenExecDoStubFunc(scenario.StubFunc, "docker", settings)
+type Scenario struct {
+	name        string
+	state       string
+	steps       []*ScenarioStep
+	defaultFunc StubFunc
+	mutex       sync.Mutex
+}
+
+// ScenarioStep is a step of a scenario, created using Scenario.WhenIn(..) and configured using its fluent methods.
+// It can be configured while the scenario is in use.
+type ScenarioStep struct {
+	// scenario is the scenario of the step, whose mutex guards the step
+	scenario  *Scenario
+	state     string
+	matcher   RequestMatcher
+	stubFunc  StubFunc
+	nextState string
+}
+
+// NewScenario constructs a new scenario without steps, in state ScenarioStarted.
+func NewScenario(name string) *Scenario {
+	return &Scenario{name: name, state: ScenarioStarted, steps: make([]*ScenarioStep, 0)}
+}
+
+// Name returns the name of the scenario.
+func (scenario *Scenario) Name() string {
+	return scenario.name
+}
+
+// WhenIn adds a step applying to the requests matching the matcher while the scenario is in the given state;
+// a nil matcher matches any request.
+// The step yields an empty successful outcome and keeps the state until configured otherwise.
+// When several steps of a state match a request, the first one added applies.
+func (scenario *Scenario) WhenIn(state string, matcher RequestMatcher) *ScenarioStep {
+	step := &ScenarioStep{
+		scenario: scenario, state: state, matcher: matcher,
+		stubFunc: AdaptOutcomeToCmdStub(&ExecOutcome{}), nextState: state,
+	}
+	scenario.mutex.Lock()
+	defer scenario.mutex.Unlock()
+	scenario.steps = append(scenario.steps, step)
+	return step
+}
+
+// Return makes the step yield the given outcome.
+func (step *ScenarioStep) Return(outcome *ExecOutcome) *ScenarioStep {
+	outcomeCopy := *outcome
+	return step.ReturnFunc(AdaptOutcomeToCmdStub(&outcomeCopy))
+}
+
+// ReturnFunc makes the step yield the outcome produced by the given StubFunc.
+func (step *ScenarioStep) ReturnFunc(stubFunc StubFunc) *ScenarioStep {
+	step.scenario.mutex.Lock()
+	defer step.scenario.mutex.Unlock()
+	step.stubFunc = stubFunc
+	return step
+}
+
+// MoveTo makes the step move the scenario to the given state.
+func (step *ScenarioStep) MoveTo(state string) *ScenarioStep {
+	step.scenario.mutex.Lock()
+	defer step.scenario.mutex.Unlock()
+	step.nextState = state
+	return step
+}
+
+// String describes the step, e.g. In("Running") On("docker").WithArgs("ps") -> "Running".
+func (step *ScenarioStep) String() string {
+	step.scenario.mutex.Lock()
+	defer step.scenario.mutex.Unlock()
+	return step.describe()
+}
+
+// describe describes the step; the mutex of the scenario must be held.
+func (step *ScenarioStep) describe() string {
+	desc := fmt.Sprintf("In(%q) %s", step.state, describeMatcher(step.matcher))
+	if step.nextState != step.state {
+		desc += fmt.Sprintf(" -> %q", step.nextState)
+	}
+	return desc
+}
+
+// Default sets the StubFunc handling the requests matching no step of the current state.
+// It does not change the state.
+func (scenario *Scenario) Default(stubFunc StubFunc) *Scenario {
+	scenario.mutex.Lock()
+	defer scenario.mutex.Unlock()
+	scenario.defaultFunc = stubFunc
+	return scenario
+}
+
+// State returns the current state of the scenario.
+func (scenario *Scenario) State() string {
+	scenario.mutex.Lock()
+	defer scenario.mutex.Unlock()
+	return scenario.state
+}
+
+// Reset moves the scenario back to state ScenarioStarted, e.g. between sub-tests.
+func (scenario *Scenario) Reset() {
+	scenario.mutex.Lock()
+	defer scenario.mutex.Unlock()
+	scenario.state = ScenarioStarted
+}
+
+// StubFunc applies the step of the current state matching the request.
+func (scenario *Scenario) StubFunc(sreq StubRequest) *ExecOutcome {
+	stubFunc, ok := scenario.advance(sreq)
+	if ok {
+		return stubFunc(sreq)
+	}
+	scenario.mutex.Lock()
+	defaultFunc := scenario.defaultFunc
+	scenario.mutex.Unlock()
+	if defaultFunc != nil {
+		return defaultFunc(sreq)
+	}
+	return &ExecOutcome{
+		Key:            sreq.Key,
+		ExitCode:       math.MaxUint8,
+		InternalErrTxt: scenario.mismatchTxt(sreq),
+	}
+}
+
+// advance moves the scenario according to the step of the current state matching the request,
+// returning the StubFunc of the step; ok is false if no step matches.
+// Matching and moving is atomic, so that concurrent requests see consistent states.
+func (scenario *Scenario) advance(sreq StubRequest) (stubFunc StubFunc, ok bool) {
+	scenario.mutex.Lock()
+	defer scenario.mutex.Unlock()
+	for _, step := range scenario.steps {
+		if step.state == scenario.state && (step.matcher == nil || step.matcher.Matches(sreq)) {
+			scenario.state = step.nextState
+			return step.stubFunc, true
+		}
+	}
+	return nil, false
+}
+
+func (scenario *Scenario) mismatchTxt(sreq StubRequest) string {
+	scenario.mutex.Lock()
+	defer scenario.mutex.Unlock()
+	var errTxt strings.Builder
+	fmt.Fprintf(&errTxt, "no step of scenario %q in state %q matches request: cmd=%q args=%q",
+		scenario.name, scenario.state, sreq.CmdName, sreq.Args)
+	stateSteps := make([]string, 0, len(scenario.steps))
+	for _, step := range scenario.steps {
+		if step.state == scenario.state {
+			stateSteps = append(stateSteps, step.describe())
+		}
+	}
+	if len(stateSteps) == 0 {
+		errTxt.WriteString("\nthe state has no step")
+	} else {
+		errTxt.WriteString("\nsteps of the state:")
+	}
+	for _, stepDesc := range stateSteps {
+		fmt.Fprintf(&errTxt, "\n\t- %s", stepDesc)
+	}
+	return errTxt.String()
+}
+
+// Scenarios combines named scenarios into one StubFunc, e.g. a container and an image scenario for docker.
+// A request is handled by the first scenario having a step of its current state matching the request.
+// The defaults of the scenarios only apply to their own StubFunc.
+type Scenarios struct {
+	scenarios []*Scenario
+	mutex     sync.Mutex
+}
+
+// NewScenarios constructs a new empty Scenarios.
+func NewScenarios() *Scenarios {
+	return &Scenarios{scenarios: make([]*Scenario, 0)}
+}
+
+// Scenario returns the scenario having the given name, which is created if not existing yet.
+func (scenarios *Scenarios) Scenario(name string) *Scenario {
+	scenarios.mutex.Lock()
+	defer scenarios.mutex.Unlock()
+	for _, scenario := range scenarios.scenarios {
+		if scenario.name == name {
+			return scenario
+		}
+	}
+	scenario := NewScenario(name)
+	scenarios.scenarios = append(scenarios.scenarios, scenario)
+	return scenario
+}
+
+// State returns the current state of the scenario having the given name; "" if there is none.
+func (scenarios *Scenarios) State(name string) string {
+	for _, scenario := range scenarios.all() {
+		if scenario.name == name {
+			return scenario.State()
+		}
+	}
+	return ""
+}
+
+// States returns the current state of every scenario by name.
+func (scenarios *Scenarios) States() map[string]string {
+	states := make(map[string]string)
+	for _, scenario := range scenarios.all() {
+		states[scenario.name] = scenario.State()
+	}
+	return states
+}
+
+// Reset moves every scenario back to state ScenarioStarted.
+func (scenarios *Scenarios) Reset() {
+	for _, scenario := range scenarios.all() {
+		scenario.Reset()
+	}
+}
+
+// StubFunc applies the first step matching the request among the current states of the scenarios.
+// A request matching no step yields an outcome with an InternalErrTxt listing the steps of the current states.
+func (scenarios *Scenarios) StubFunc(sreq StubRequest) *ExecOutcome {
+	all := scenarios.all()
+	for _, scenario := range all {
+		if stubFunc, ok := scenario.advance(sreq); ok {
+			return stubFunc(sreq)
+		}
+	}
+	mismatches := make([]string, 0, len(all))
+	for _, scenario := range all {
+		mismatches = append(mismatches, scenario.mismatchTxt(sreq))
+	}
+	if len(mismatches) == 0 {
+		mismatches = append(mismatches, fmt.Sprintf(
+			"no scenario matches request: cmd=%q args=%q\nno scenario has been specified", sreq.CmdName, sreq.Args))
+	}
+	return &ExecOutcome{
+		Key:            sreq.Key,
+		ExitCode:       math.MaxUint8,
+		InternalErrTxt: strings.Join(mismatches, "\n"),
+	}
+}
+
+func (scenarios *Scenarios) all() []*Scenario {
+	scenarios.mutex.Lock()
+	defer scenarios.mutex.Unlock()
+	return append(make([]*Scenario, 0, len(scenarios.scenarios)), scenarios.scenarios...)
+}
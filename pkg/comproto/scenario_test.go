@@ -0,0 +1,188 @@
+// Copyright 2020 The Execstub Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package comproto
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func containerScenario() *Scenario {
+	scenario := NewScenario("container")
+	scenario.WhenIn(ScenarioStarted, CallTo("docker").WithArgs("ps")).
+		Return(&ExecOutcome{Stdout: "CONTAINER ID   IMAGE"})
+	scenario.WhenIn(ScenarioStarted, CallTo("docker").WithArgs("run", "-d", AnyString())).
+		Return(&ExecOutcome{Stdout: "4c01db0b339c"}).
+		MoveTo("Running")
+	scenario.WhenIn("Running", CallTo("docker").WithArgs("ps")).
+		Return(&ExecOutcome{Stdout: "CONTAINER ID   IMAGE\n4c01db0b339c   nginx"})
+	scenario.WhenIn("Running", CallTo("docker").WithArgs("rm", "-f", "4c01db0b339c")).
+		MoveTo(ScenarioStarted)
+	return scenario
+}
+
+func TestScenario_StubFunc(t *testing.T) {
+	scenario := containerScenario()
+	docker := func(args ...string) *ExecOutcome {
+		return scenario.StubFunc(StubRequest{Key: "docker_1", CmdName: "docker", Args: args})
+	}
+	steps := []struct {
+		args       []string
+		wantStdout string
+		wantState  string
+	}{
+		{args: []string{"ps"}, wantStdout: "CONTAINER ID   IMAGE", wantState: ScenarioStarted},
+		{args: []string{"run", "-d", "nginx"}, wantStdout: "4c01db0b339c", wantState: "Running"},
+		{args: []string{"ps"}, wantStdout: "CONTAINER ID   IMAGE\n4c01db0b339c   nginx", wantState: "Running"},
+		{args: []string{"ps"}, wantStdout: "CONTAINER ID   IMAGE\n4c01db0b339c   nginx", wantState: "Running"},
+		{args: []string{"rm", "-f", "4c01db0b339c"}, wantStdout: "", wantState: ScenarioStarted},
+		{args: []string{"ps"}, wantStdout: "CONTAINER ID   IMAGE", wantState: ScenarioStarted},
+	}
+	for i, step := range steps {
+		got := docker(step.args...)
+		if got.Stdout != step.wantStdout || got.InternalErrTxt != "" {
+			t.Errorf("step[%d] %q: got stdout=%q internalErrTxt=%q, want stdout=%q",
+				i, step.args, got.Stdout, got.InternalErrTxt, step.wantStdout)
+		}
+		if state := scenario.State(); state != step.wantState {
+			t.Errorf("step[%d] %q: got state=%q, want %q", i, step.args, state, step.wantState)
+		}
+	}
+}
+
+func TestScenario_Reset(t *testing.T) {
+	scenario := containerScenario()
+	scenario.StubFunc(StubRequest{CmdName: "docker", Args: []string{"run", "-d", "nginx"}})
+	if state := scenario.State(); state != "Running" {
+		t.Fatalf("State() got %q, want Running", state)
+	}
+
+	scenario.Reset()
+
+	if state := scenario.State(); state != ScenarioStarted {
+		t.Errorf("State() after Reset() got %q, want %q", state, ScenarioStarted)
+	}
+}
+
+func TestScenario_StubFuncWithoutMatchingStep(t *testing.T) {
+	scenario := containerScenario()
+
+	got := scenario.StubFunc(StubRequest{Key: "docker_1", CmdName: "docker", Args: []string{"rm", "-f", "4c01db0b339c"}})
+
+	if got.ExitCode != 255 || got.Key != "docker_1" {
+		t.Errorf("StubFunc() got ExitCode=%d Key=%s, want 255 and docker_1", got.ExitCode, got.Key)
+	}
+	wantErrTxt := strings.Join([]string{
+		`no step of scenario "container" in state "Started" matches request: cmd="docker" args=["rm" "-f" "4c01db0b339c"]`,
+		"steps of the state:",
+		`	- In("Started") On("docker").WithArgs("ps")`,
+		`	- In("Started") On("docker").WithArgs("run", "-d", AnyString()) -> "Running"`,
+	}, "\n")
+	if got.InternalErrTxt != wantErrTxt {
+		t.Errorf("StubFunc() InternalErrTxt \ngot = %s \nwant= %s", got.InternalErrTxt, wantErrTxt)
+	}
+	if state := scenario.State(); state != ScenarioStarted {
+		t.Errorf("State() got %q, want state unchanged", state)
+	}
+
+	scenario.Default(AdaptOutcomeToCmdStub(&ExecOutcome{ExitCode: 1}))
+	if got := scenario.StubFunc(StubRequest{CmdName: "docker", Args: []string{"version"}}); got.ExitCode != 1 {
+		t.Errorf("StubFunc() with default got ExitCode=%d, want 1", got.ExitCode)
+	}
+}
+
+func TestScenario_StepWithNilMatcherMatchesAnyRequest(t *testing.T) {
+	scenario := NewScenario("any")
+	step := scenario.WhenIn(ScenarioStarted, nil).Return(&ExecOutcome{Stdout: "any"}).MoveTo("Done")
+
+	got := scenario.StubFunc(StubRequest{CmdName: "docker", Args: []string{"version"}})
+
+	if got.Stdout != "any" || got.InternalErrTxt != "" {
+		t.Errorf("StubFunc() got stdout=%q internalErrTxt=%q, want stdout=any", got.Stdout, got.InternalErrTxt)
+	}
+	if state := scenario.State(); state != "Done" {
+		t.Errorf("State() got %q, want Done", state)
+	}
+	if desc, want := step.String(), `In("Started") any request -> "Done"`; desc != want {
+		t.Errorf("String() got %s, want %s", desc, want)
+	}
+}
+
+func TestScenario_StepsConfiguredWhileInUse(t *testing.T) {
+	scenario := NewScenario("concurrent")
+	step := scenario.WhenIn(ScenarioStarted, CallTo("docker"))
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			scenario.StubFunc(StubRequest{CmdName: "docker"})
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			step.Return(&ExecOutcome{ExitCode: uint8(i)}).MoveTo(ScenarioStarted)
+			_ = step.String()
+		}
+	}()
+	wg.Wait()
+
+	if got := scenario.StubFunc(StubRequest{CmdName: "docker"}); got.ExitCode != 99 {
+		t.Errorf("StubFunc() got ExitCode=%d, want 99 from the last configured outcome", got.ExitCode)
+	}
+}
+
+func TestScenarios(t *testing.T) {
+	scenarios := NewScenarios()
+	scenarios.Scenario("container").
+		WhenIn(ScenarioStarted, CallTo("docker").WithArgs("run", "-d", "nginx")).
+		MoveTo("Running")
+	scenarios.Scenario("image").
+		WhenIn(ScenarioStarted, CallTo("docker").WithArgs("pull", "nginx")).
+		MoveTo("Pulled")
+	scenarios.Scenario("image").
+		WhenIn("Pulled", CallTo("docker").WithArgs("images", "-q")).
+		Return(&ExecOutcome{Stdout: "f6d0b4767a6c"})
+
+	scenarios.StubFunc(StubRequest{CmdName: "docker", Args: []string{"pull", "nginx"}})
+	gotImages := scenarios.StubFunc(StubRequest{CmdName: "docker", Args: []string{"images", "-q"}})
+
+	if gotImages.Stdout != "f6d0b4767a6c" {
+		t.Errorf("StubFunc() got stdout=%q internalErrTxt=%q", gotImages.Stdout, gotImages.InternalErrTxt)
+	}
+	wantStates := map[string]string{"container": ScenarioStarted, "image": "Pulled"}
+	if got := scenarios.States(); !reflect.DeepEqual(got, wantStates) {
+		t.Errorf("States() got %v, want %v", got, wantStates)
+	}
+	if got := scenarios.State("image"); got != "Pulled" {
+		t.Errorf("State(image) got %q, want Pulled", got)
+	}
+	gotUnmatched := scenarios.StubFunc(StubRequest{CmdName: "docker", Args: []string{"ps"}})
+	for _, want := range []string{`scenario "container" in state "Started"`, `scenario "image" in state "Pulled"`} {
+		if !strings.Contains(gotUnmatched.InternalErrTxt, want) {
+			t.Errorf("StubFunc() InternalErrTxt=%q, want it to contain %q", gotUnmatched.InternalErrTxt, want)
+		}
+	}
+
+	scenarios.Reset()
+
+	wantStates = map[string]string{"container": ScenarioStarted, "image": ScenarioStarted}
+	if got := scenarios.States(); !reflect.DeepEqual(got, wantStates) {
+		t.Errorf("States() after Reset() got %v, want %v", got, wantStates)
+	}
+}
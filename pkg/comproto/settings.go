@@ -53,6 +53,26 @@ const (
 	StubbingModeDyna = "DYNAMIC"
 )
 
+// Transport specifies how stub executables request dynamic outcomes from the stubber.
+type Transport string
+
+const (
+	// TransportFifo requires the use of named pipes: the invocation ids are sent
+	// through a pipe shared by all invocations and each outcome through a pipe
+	// dedicated to the invocation. It is the default.
+	TransportFifo = "FIFO"
+
+	// TransportUnixSocket requires the use of a unix domain socket served by the stubber,
+	// with one connection per invocation carrying the request and its outcome.
+	TransportUnixSocket = "UNIX_SOCKET"
+
+	// TransportHTTP requires the use of an http server served by the stubber on 127.0.0.1,
+	// with one authenticated request per invocation carrying the request and its outcome.
+	// Unlike the other transports it does not require the stub executable to share
+	// the filesystem of the stubber (e.g. tools run in a chroot or a mount namespace).
+	TransportHTTP = "HTTP"
+)
+
 // DiscoveredByHomeDirBinData holds data used to the home environment key and
 // the binary sub-directory structure which contains the executable
 // e.g. For Java: EnvHomeKey=JAVA_HOME and BinDirs=[]string{"bin"}
@@ -76,6 +96,23 @@ type Settings struct {
 	TestHelperProcessMethodName string
 
 	Timeout OptionalDuration
+
+	// CaptureStdin requires the stub executable to read its stdin until EOF
+	// and forward it as part of the StubRequest.
+	// Only select it if the code under test closes the stdin of the command,
+	// because the stub executable will otherwise block.
+	CaptureStdin bool
+
+	// CaptureDir requires the working directory of the stubbed command
+	// to be captured into the StubRequest.
+	CaptureDir bool
+
+	// EnvFilter selects the environment variables of the stubbed command
+	// captured into the StubRequest.
+	EnvFilter EnvFilter
+
+	// Transport is used in dynamic mode; "" means TransportFifo.
+	Transport Transport
 }
 
 // SettingsDynaStubCmdDiscoveredByPath constructs a new Settings for dynamically stubbing
@@ -158,3 +195,70 @@ func (s Settings) IsUsingTestProcessHelper() bool {
 func (s Settings) IsUsingExecTypeBash() bool {
 	return ExecTypeBash == s.ExecType
 }
+
+// WithTransportFifo requires the use of named pipes in dynamic mode.
+func (s *Settings) WithTransportFifo() {
+	s.Transport = TransportFifo
+}
+
+// WithTransportUnixSocket requires the use of a unix domain socket in dynamic mode.
+func (s *Settings) WithTransportUnixSocket() {
+	s.Transport = TransportUnixSocket
+}
+
+// IsUsingTransportUnixSocket returns true if the unix domain socket transport is specified; false otherwise.
+func (s Settings) IsUsingTransportUnixSocket() bool {
+	return TransportUnixSocket == s.Transport
+}
+
+// WithTransportHTTP requires the use of an http server on 127.0.0.1 in dynamic mode.
+func (s *Settings) WithTransportHTTP() {
+	s.Transport = TransportHTTP
+}
+
+// IsUsingTransportHTTP returns true if the http transport is specified; false otherwise.
+func (s Settings) IsUsingTransportHTTP() bool {
+	return TransportHTTP == s.Transport
+}
+
+// WithStdinCapture requires the stdin of the stubbed command to be captured.
+func (s *Settings) WithStdinCapture() {
+	s.CaptureStdin = true
+}
+
+// WithoutStdinCapture requires the stdin of the stubbed command not to be captured.
+func (s *Settings) WithoutStdinCapture() {
+	s.CaptureStdin = false
+}
+
+// IsCapturingStdin returns true if stdin capture is specified; false otherwise.
+func (s Settings) IsCapturingStdin() bool {
+	return s.CaptureStdin
+}
+
+// WithDirCapture requires the working directory of the stubbed command to be captured.
+func (s *Settings) WithDirCapture() {
+	s.CaptureDir = true
+}
+
+// WithoutDirCapture requires the working directory of the stubbed command not to be captured.
+func (s *Settings) WithoutDirCapture() {
+	s.CaptureDir = false
+}
+
+// WithEnvCapture requires the environment variables matching the given
+// allow list patterns to be captured (see EnvFilter).
+func (s *Settings) WithEnvCapture(allowList ...string) {
+	s.EnvFilter.AllowList = allowList
+}
+
+// WithEnvCaptureDenied requires the environment variables matching the given
+// deny list patterns not to be captured (see EnvFilter).
+func (s *Settings) WithEnvCaptureDenied(denyList ...string) {
+	s.EnvFilter.DenyList = denyList
+}
+
+// WithoutEnvCapture requires no environment variable to be captured.
+func (s *Settings) WithoutEnvCapture() {
+	s.EnvFilter = EnvFilter{}
+}
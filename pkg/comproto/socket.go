@@ -0,0 +1,47 @@
+// Copyright 2020 The Execstub Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package comproto
+
+import (
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RequestOutcomeThroughSocket sends the request to the stubber through a new connection
+// to its unix domain socket and reads the outcome sent back through the same connection.
+// Each invocation having its own connection, concurrent invocations are told apart
+// without any invocation id.
+func RequestOutcomeThroughSocket(
+	socketPath string, req StubRequest, timeout time.Duration,
+) (*ExecOutcome, error) {
+	conn, err := net.DialTimeout("unix", socketPath, timeout)
+	if err != nil {
+		return nil, errors.Wrapf(err, "fail to connect to stubber socket[%s]", socketPath)
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, errors.Wrapf(err, "fail to set deadline of connection to stubber socket[%s]", socketPath)
+	}
+	if err := StubRequestEncoderFunc(conn)(&req); err != nil {
+		return nil, errors.Wrapf(err, "fail to write request to stubber socket[%s]", socketPath)
+	}
+	outcome, err := ExecOutcomeDecoderFunc(conn)
+	if err != nil {
+		return nil, errors.Wrapf(err, "fail to read outcome from stubber socket[%s]", socketPath)
+	}
+	return outcome, nil
+}
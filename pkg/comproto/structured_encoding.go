@@ -0,0 +1,350 @@
+// Copyright 2020 The Execstub Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package comproto
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// The structured encoding is spoken between go processes (stubber, go based stub executable and
+// test helper process). A stub request respectively an exec outcome is encoded as a frame:
+//
+//	#execstub-v<protocol version> <payload length>\n<payload>
+//
+// The payload is a sequence of named fields, each of them length-prefixed, so that values
+// are byte exact and need no escaping:
+//
+//	<name> <value length>\n<value>\n
+//
+// Lists are repeated fields, e.g. one arg field per argument, and a structured value is
+// a field whose value is itself a sequence of fields, e.g. a chunk of the output script.
+// Fields having their zero value are omitted; unknown fields are skipped, so that
+// a field can be added without breaking decoders not knowing it yet.
+//
+// The bash stub executable speaks the base64 csv encoding, a subset carrying the same data
+// in fixed positions, see WireFormatCSV.
+
+// structuredFrameStart starts a structured frame; it is neither part of the base64 alphabet
+// nor the start of a version marker, so that a frame cannot be mistaken for a csv record.
+const structuredFrameStart = '#'
+
+// maxStructuredFrameLen bounds the payload length read from a frame header,
+// so that a corrupted header does not lead to a huge allocation.
+const maxStructuredFrameLen = 1 << 30
+
+// Field names of the structured encoding of StubRequest.
+const (
+	fieldKey     = "key"
+	fieldCmdName = "cmdName"
+	fieldArg     = "arg"
+	fieldStdin   = "stdin"
+	fieldDir     = "dir"
+	fieldEnv     = "env"
+)
+
+// Field names of the structured encoding of ExecOutcome, key being shared with StubRequest.
+const (
+	fieldExitCode           = "exitCode"
+	fieldInternalErrTxt     = "internalErrTxt"
+	fieldStderr             = "stderr"
+	fieldStdout             = "stdout"
+	fieldExitDelay          = "exitDelay"
+	fieldHangUntilSignalled = "hangUntilSignalled"
+	fieldTerminationSignal  = "terminationSignal"
+	fieldPassthrough        = "passthrough"
+	fieldChunk              = "chunk"
+	fieldChunkStream        = "stream"
+	fieldChunkDelay         = "delay"
+	fieldChunkData          = "data"
+)
+
+// structuredFields builds the payload of a structured frame.
+type structuredFields struct {
+	buf bytes.Buffer
+}
+
+func (fields *structuredFields) add(name string, value string) {
+	fields.buf.WriteString(name)
+	fields.buf.WriteByte(' ')
+	fields.buf.WriteString(strconv.Itoa(len(value)))
+	fields.buf.WriteByte('\n')
+	fields.buf.WriteString(value)
+	fields.buf.WriteByte('\n')
+}
+
+func (fields *structuredFields) addIfNotEmpty(name string, value string) {
+	if value != "" {
+		fields.add(name, value)
+	}
+}
+
+func (fields *structuredFields) addAll(name string, values []string) {
+	for _, value := range values {
+		fields.add(name, value)
+	}
+}
+
+func (fields *structuredFields) writeFrameTo(writer io.Writer) error {
+	header := "#" + protocolVersionMarker(ProtocolVersion) + " " + strconv.Itoa(fields.buf.Len()) + "\n"
+	if _, err := io.WriteString(writer, header); err != nil {
+		return err
+	}
+	_, err := fields.buf.WriteTo(writer)
+	return err
+}
+
+// isStructuredFrame tells whether the reader is positioned at the start of a structured frame.
+func isStructuredFrame(reader *bufio.Reader) bool {
+	start, err := reader.Peek(1)
+	return err == nil && start[0] == structuredFrameStart
+}
+
+// readStructuredFrame reads a frame and returns its payload.
+func readStructuredFrame(reader *bufio.Reader, subject string) (payload []byte, err error) {
+	// bounded by the buffer size of the reader
+	headerLine, err := reader.ReadSlice('\n')
+	if err != nil {
+		return nil, errors.Wrapf(err, "while reading frame header of %s: %q", subject, headerLine)
+	}
+	header := strings.TrimSuffix(string(headerLine[1:]), "\n")
+	headerParts := strings.Split(header, " ")
+	if len(headerParts) != 2 || !strings.HasPrefix(headerParts[0], protocolVersionMarkerPrefix) {
+		return nil, errors.Errorf("bad frame header of %s: %q", subject, header)
+	}
+	if _, err := recordsAfterVersionMarker(subject, headerParts[:1], ProtocolVersion); err != nil {
+		return nil, err
+	}
+	payloadLen, err := strconv.Atoi(headerParts[1])
+	if err != nil || payloadLen < 0 || payloadLen > maxStructuredFrameLen {
+		return nil, errors.Errorf(
+			"frame length of %s must be between 0 and %d but was '%s': %v",
+			subject, maxStructuredFrameLen, headerParts[1], err)
+	}
+	payload, err = ioutil.ReadAll(io.LimitReader(reader, int64(payloadLen)))
+	if err != nil {
+		return nil, errors.Wrapf(err, "while reading frame payload of %s", subject)
+	}
+	if len(payload) != payloadLen {
+		return nil, errors.Errorf(
+			"truncated frame of %s: expect %d bytes but got %d", subject, payloadLen, len(payload))
+	}
+	return payload, nil
+}
+
+// forEachStructuredField calls fieldFunc for each field of the payload, in order.
+func forEachStructuredField(payload []byte, fieldFunc func(name string, value []byte) error) error {
+	for len(payload) > 0 {
+		headerEnd := bytes.IndexByte(payload, '\n')
+		if headerEnd < 0 {
+			return errors.Errorf("bad field header: %q", payload)
+		}
+		header := string(payload[:headerEnd])
+		nameEnd := strings.LastIndexByte(header, ' ')
+		if nameEnd <= 0 {
+			return errors.Errorf("bad field header: %q", header)
+		}
+		valueLen, err := strconv.Atoi(header[nameEnd+1:])
+		// the value is followed by a new line
+		if err != nil || valueLen < 0 || valueLen >= len(payload)-headerEnd-1 ||
+			payload[headerEnd+1+valueLen] != '\n' {
+			return errors.Errorf("bad length of field %q: %v", header, err)
+		}
+		valueEnd := headerEnd + 1 + valueLen
+		if err := fieldFunc(header[:nameEnd], payload[headerEnd+1:valueEnd]); err != nil {
+			return errors.Wrapf(err, "while field %s", header[:nameEnd])
+		}
+		payload = payload[valueEnd+1:]
+	}
+	return nil
+}
+
+func stubRequestEncoderFuncStructured(writer io.Writer) func(req *StubRequest) error {
+	return func(req *StubRequest) error {
+		if err := assertStubKeyHasRightFormat(req.Key, fmt.Sprintf("req-to-structured:%#v", req)); err != nil {
+			return err
+		}
+		fields := structuredFields{}
+		fields.add(fieldKey, req.Key)
+		fields.addIfNotEmpty(fieldCmdName, req.CmdName)
+		fields.addAll(fieldArg, req.Args)
+		fields.addIfNotEmpty(fieldStdin, req.Stdin)
+		fields.addIfNotEmpty(fieldDir, req.Dir)
+		fields.addAll(fieldEnv, req.Env)
+		return fields.writeFrameTo(writer)
+	}
+}
+
+func stubRequestDecoderFuncStructured(reader *bufio.Reader) (*StubRequest, error) {
+	payload, err := readStructuredFrame(reader, "stub request")
+	if err != nil {
+		return nil, err
+	}
+	req := StubRequest{}
+	err = forEachStructuredField(payload, func(name string, value []byte) error {
+		switch name {
+		case fieldKey:
+			req.Key = string(value)
+		case fieldCmdName:
+			req.CmdName = string(value)
+		case fieldArg:
+			req.Args = append(req.Args, string(value))
+		case fieldStdin:
+			req.Stdin = string(value)
+		case fieldDir:
+			req.Dir = string(value)
+		case fieldEnv:
+			req.Env = append(req.Env, string(value))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "while decoding stub request from frame: %q", payload)
+	}
+	if err := assertStubKeyHasRightFormat(req.Key, fmt.Sprintf("req-from-structured=%q", payload)); err != nil {
+		return nil, err
+	}
+	return &req, nil
+}
+
+func execOutcomeEncoderFuncStructured(writer io.Writer) func(outcome *ExecOutcome) error {
+	return func(outcome *ExecOutcome) error {
+		fields := structuredFields{}
+		if outcome.ExitCode != 0 {
+			fields.add(fieldExitCode, strconv.Itoa(int(outcome.ExitCode)))
+		}
+		fields.addIfNotEmpty(fieldInternalErrTxt, outcome.InternalErrTxt)
+		fields.addIfNotEmpty(fieldKey, outcome.Key)
+		fields.addIfNotEmpty(fieldStderr, outcome.Stderr)
+		fields.addIfNotEmpty(fieldStdout, outcome.Stdout)
+		if outcome.ExitDelay != 0 {
+			fields.add(fieldExitDelay, strconv.FormatInt(outcome.ExitDelay.Nanoseconds(), 10))
+		}
+		if outcome.HangUntilSignalled {
+			fields.add(fieldHangUntilSignalled, strconv.FormatBool(outcome.HangUntilSignalled))
+		}
+		if outcome.TerminationSignal != 0 {
+			fields.add(fieldTerminationSignal, strconv.Itoa(int(outcome.TerminationSignal)))
+		}
+		if outcome.Passthrough {
+			fields.add(fieldPassthrough, strconv.FormatBool(outcome.Passthrough))
+		}
+		for _, chunk := range outcome.OutputScript {
+			chunkFields := structuredFields{}
+			chunkFields.add(fieldChunkStream, strconv.Itoa(int(chunk.Stream)))
+			if chunk.Delay != 0 {
+				chunkFields.add(fieldChunkDelay, strconv.FormatInt(chunk.Delay.Nanoseconds(), 10))
+			}
+			chunkFields.addIfNotEmpty(fieldChunkData, chunk.Data)
+			fields.add(fieldChunk, chunkFields.buf.String())
+		}
+		return fields.writeFrameTo(writer)
+	}
+}
+
+func execOutcomeDecoderFuncStructured(reader *bufio.Reader) (*ExecOutcome, error) {
+	payload, err := readStructuredFrame(reader, "exec outcome")
+	if err != nil {
+		return nil, err
+	}
+	outcome := ExecOutcome{}
+	err = forEachStructuredField(payload, func(name string, value []byte) (err error) {
+		switch name {
+		case fieldExitCode:
+			outcome.ExitCode, err = parseUint8(string(value))
+		case fieldInternalErrTxt:
+			outcome.InternalErrTxt = string(value)
+		case fieldKey:
+			outcome.Key = string(value)
+		case fieldStderr:
+			outcome.Stderr = string(value)
+		case fieldStdout:
+			outcome.Stdout = string(value)
+		case fieldExitDelay:
+			outcome.ExitDelay, err = parseNonNegativeNanos(string(value))
+		case fieldHangUntilSignalled:
+			outcome.HangUntilSignalled, err = strconv.ParseBool(string(value))
+		case fieldTerminationSignal:
+			outcome.TerminationSignal, err = parseSignal(string(value))
+		case fieldPassthrough:
+			outcome.Passthrough, err = strconv.ParseBool(string(value))
+		case fieldChunk:
+			var chunk OutputChunk
+			chunk, err = outputChunkFromStructuredFields(value)
+			outcome.OutputScript = append(outcome.OutputScript, chunk)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "while decoding exec outcome from frame: %q", payload)
+	}
+	return &outcome, nil
+}
+
+func outputChunkFromStructuredFields(chunkFields []byte) (chunk OutputChunk, err error) {
+	err = forEachStructuredField(chunkFields, func(name string, value []byte) (err error) {
+		switch name {
+		case fieldChunkStream:
+			var stream uint8
+			stream, err = parseUint8(string(value))
+			chunk.Stream = OutputStream(stream)
+		case fieldChunkDelay:
+			chunk.Delay, err = parseNonNegativeNanos(string(value))
+		case fieldChunkData:
+			chunk.Data = string(value)
+		}
+		return err
+	})
+	if err != nil {
+		return chunk, err
+	}
+	if chunk.Stream != StreamStdout && chunk.Stream != StreamStderr {
+		return chunk, errors.Errorf("unsupported chunk stream: %d", chunk.Stream)
+	}
+	return chunk, nil
+}
+
+func parseUint8(txt string) (uint8, error) {
+	val, err := strconv.ParseUint(txt, 10, 8)
+	if err != nil {
+		return 0, errors.Wrapf(err, "while ParseUint('%s')", txt)
+	}
+	return uint8(val), nil
+}
+
+func parseNonNegativeNanos(txt string) (time.Duration, error) {
+	nanos, err := strconv.ParseInt(txt, 10, 64)
+	if err != nil || nanos < 0 {
+		return 0, errors.Errorf("must be non negative nanos but was '%s': %v", txt, err)
+	}
+	return time.Duration(nanos), nil
+}
+
+func parseSignal(txt string) (syscall.Signal, error) {
+	signal, err := strconv.Atoi(txt)
+	if err != nil || signal < 0 {
+		return 0, errors.Errorf("must be a non negative signal number but was '%s': %v", txt, err)
+	}
+	return syscall.Signal(signal), nil
+}
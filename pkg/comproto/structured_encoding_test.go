@@ -0,0 +1,115 @@
+// Copyright 2020 The Execstub Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package comproto
+
+import (
+	"bytes"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// frameOf returns a structured frame holding the given payload.
+func frameOf(payload string) string {
+	return "#" + protocolVersionMarker(ProtocolVersion) + " " + strconv.Itoa(len(payload)) + "\n" + payload
+}
+
+func TestStructuredDecodersSkipUnknownFields(t *testing.T) {
+	reqFields := structuredFields{}
+	reqFields.add("futureFlag", "true")
+	reqFields.add(fieldKey, "k_1")
+	reqFields.add(fieldArg, "logs")
+	reqFields.add("futureList", "a\nb\n\nc,d")
+	reqFields.add(fieldCmdName, "docker")
+	gotReq, err := StubRequestDecoderFunc(strings.NewReader(frameOf(reqFields.buf.String())))
+	wantReq := StubRequest{Key: "k_1", CmdName: "docker", Args: []string{"logs"}}
+	if err != nil || !reflect.DeepEqual(*gotReq, wantReq) {
+		t.Errorf("StubRequestDecoderFunc() = %#v, %v, want %#v", gotReq, err, wantReq)
+	}
+
+	chunkFields := structuredFields{}
+	chunkFields.add(fieldChunkStream, "2")
+	chunkFields.add("futureDelay", "0")
+	outcomeFields := structuredFields{}
+	outcomeFields.add(fieldExitCode, "7")
+	outcomeFields.add(fieldChunk, chunkFields.buf.String())
+	outcomeFields.add("futureOutcome", "ok")
+	gotOutcome, err := ExecOutcomeDecoderFunc(strings.NewReader(frameOf(outcomeFields.buf.String())))
+	wantOutcome := ExecOutcome{ExitCode: 7, OutputScript: []OutputChunk{StderrChunk(0, "")}}
+	if err != nil || !reflect.DeepEqual(*gotOutcome, wantOutcome) {
+		t.Errorf("ExecOutcomeDecoderFunc() = %#v, %v, want %#v", gotOutcome, err, wantOutcome)
+	}
+}
+
+func TestStructuredDecodersRejectMalformedFrames(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame string
+		// validRequest tells whether the frame is a valid stub request, the malformed fields being outcome ones
+		validRequest bool
+	}{
+		{name: "Should reject frame without version", frame: "#12\nkey 3\nk_1\n"},
+		{name: "Should reject frame without length", frame: "#execstub-v3\nkey 3\nk_1\n"},
+		{name: "Should reject truncated frame", frame: "#execstub-v3 100\nkey 3\nk_1\n"},
+		{name: "Should reject field value exceeding the frame", frame: frameOf("key 30\nk_1\n")},
+		{name: "Should reject field without length", frame: frameOf("key\nk_1\n")},
+		{name: "Should reject field not terminated by new line", frame: frameOf("key 2\nk_1\n")},
+		{name: "Should reject bad exit code", frame: frameOf("key 3\nk_1\nexitCode 3\n256\n"), validRequest: true},
+		{name: "Should reject bad chunk stream", frame: frameOf("key 3\nk_1\nchunk 11\nstream 1\n3\n\n"), validRequest: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := StubRequestDecoderFunc(strings.NewReader(tt.frame)); (err == nil) != tt.validRequest {
+				t.Errorf("StubRequestDecoderFunc() = %#v, %v, want valid request %t", got, err, tt.validRequest)
+			}
+			if got, err := ExecOutcomeDecoderFunc(strings.NewReader(tt.frame)); err == nil {
+				t.Errorf("ExecOutcomeDecoderFunc() should fail but got %#v", got)
+			}
+		})
+	}
+}
+
+func TestStubRequestDecoderFuncWithFormat(t *testing.T) {
+	req := StubRequest{
+		Key: "k_1", CmdName: "kubectl", Args: []string{"apply", "-f", "-"},
+		Stdin: "kind: Pod\n", Dir: "/tmp", Env: []string{"KUBECONFIG=/tmp/config"},
+	}
+	outcome := ExecOutcome{
+		Key: "k_1", Stdout: "pod/web created\n", ExitCode: 1, ExitDelay: time.Second,
+		OutputScript: []OutputChunk{StdoutChunk(time.Millisecond, "done\n")},
+	}
+	for _, format := range []WireFormat{WireFormatStructured, WireFormatCSV} {
+		t.Run(format.String(), func(t *testing.T) {
+			var reqBuf, outcomeBuf bytes.Buffer
+			if err := StubRequestEncoderFuncFor(&reqBuf, format)(&req); err != nil {
+				t.Fatalf("fail to encode request: %v", err)
+			}
+			if err := ExecOutcomeEncoderFuncFor(&outcomeBuf, format)(&outcome); err != nil {
+				t.Fatalf("fail to encode outcome: %v", err)
+			}
+			gotReq, gotFormat, err := StubRequestDecoderFuncWithFormat(&reqBuf)
+			if err != nil || gotFormat != format || !reflect.DeepEqual(*gotReq, req) {
+				t.Errorf("StubRequestDecoderFuncWithFormat() = %#v, %v, %v, want %#v, %v",
+					gotReq, gotFormat, err, req, format)
+			}
+			gotOutcome, err := ExecOutcomeDecoderFunc(&outcomeBuf)
+			if err != nil || !reflect.DeepEqual(*gotOutcome, outcome) {
+				t.Errorf("ExecOutcomeDecoderFunc() = %#v, %v, want %#v", gotOutcome, err, outcome)
+			}
+		})
+	}
+}
@@ -15,28 +15,79 @@
 package comproto
 
 import (
+	"context"
 	"fmt"
 	"math"
+	"sync"
 	"sync/atomic"
 )
 
 // StubFunc function use to produce the outcome for a stubbed command execution
 type StubFunc func(sreq StubRequest) *ExecOutcome
 
-// RecordingExecutions adds request recording feature to the given StubFunc
+// StubFuncWithContext is a StubFunc which gets the context of the execution and may fail.
+// In dynamic mode the context is cancelled as soon as the outcome cannot be delivered anymore:
+// the stub process is gone (e.g. killed) or the invocation deadline (Settings.Timeout) has passed.
+// Detecting a gone process is best-effort with the named pipe transport, which polls the process
+// by its pid and cannot tell a reused pid from the original process; the deadline still applies.
+// A returned error fails the execution with exit code 255, the error text being its internal error.
+type StubFuncWithContext func(ctx context.Context, sreq StubRequest) (*ExecOutcome, error)
+
+// AdaptCmdStubToStubFuncWithContext returns a StubFuncWithContext delegating to the given StubFunc,
+// ignoring the context and never failing.
+func AdaptCmdStubToStubFuncWithContext(stubFunc StubFunc) StubFuncWithContext {
+	return func(ctx context.Context, sreq StubRequest) (*ExecOutcome, error) {
+		return stubFunc(sreq), nil
+	}
+}
+
+// AdaptStubFuncWithContextToCmdStub returns a StubFunc delegating to the given StubFuncWithContext
+// with a background context; an error is turned into the internal error of the outcome.
+func AdaptStubFuncWithContextToCmdStub(stubFunc StubFuncWithContext) StubFunc {
+	return func(sreq StubRequest) *ExecOutcome {
+		outcome, err := stubFunc(context.Background(), sreq)
+		if err != nil {
+			return &ExecOutcome{
+				Key:            sreq.Key,
+				ExitCode:       math.MaxUint8,
+				InternalErrTxt: err.Error(),
+			}
+		}
+		return outcome
+	}
+}
+
+// RecordingExecutions adds request recording feature to the given StubFunc.
+// The store must only be read once the stubbed commands are done.
+//
+// Deprecated: reading the store while the stubs are running is a data race;
+// use RecordingExecutionsWithSnapshot or an InvocationRecorder instead.
 func RecordingExecutions(
 	stubFunc StubFunc,
 ) (recordingStubFunc StubFunc, reqsStore *[]StubRequest) {
 	sreqs := make([]StubRequest, 0)
+	var mutex sync.Mutex
 
 	recordingStubFunc = func(sreq StubRequest) *ExecOutcome {
+		mutex.Lock()
 		sreqs = append(sreqs, sreq)
+		mutex.Unlock()
 		return stubFunc(sreq)
 	}
 	reqsStore = &sreqs
 	return
 }
 
+// RecordingExecutionsWithSnapshot adds request recording feature to the given StubFunc.
+// requests returns a snapshot of the requests recorded so far, in recording order;
+// it may be called while the stubbed commands are running.
+func RecordingExecutionsWithSnapshot(
+	stubFunc StubFunc,
+) (recordingStubFunc StubFunc, requests func() []StubRequest) {
+	recorder := NewInvocationRecorder()
+	return recorder.Recording(stubFunc), recorder.Requests
+}
+
 // AdaptOutcomeToCmdStub returns a StubFunc which will return the given outcome.
 func AdaptOutcomeToCmdStub(
 	outcome *ExecOutcome,
@@ -46,6 +97,36 @@ func AdaptOutcomeToCmdStub(
 	}
 }
 
+// AdaptPassthroughToCmdStub returns a StubFunc which delegates every execution
+// to the real executable, turning the stubbing into a spy.
+// Use a StubFunc returning PassthroughOutcome() for some requests to decide
+// per request whether to delegate or to fake.
+func AdaptPassthroughToCmdStub() func(sreq StubRequest) *ExecOutcome {
+	return func(sreq StubRequest) *ExecOutcome {
+		return PassthroughOutcome()
+	}
+}
+
+// PassthroughOutcome returns an outcome requiring the execution to be delegated
+// to the real executable.
+func PassthroughOutcome() *ExecOutcome {
+	return &ExecOutcome{Passthrough: true}
+}
+
+// AdaptOutcomeTemplateToCmdStub returns a StubFunc whose outcomes are rendered
+// from the given templates against the request of each execution.
+// It works in static mode as well, where the stub executable renders the templates;
+// the bash stub executable delegates such executions to the exe stub.
+func AdaptOutcomeTemplateToCmdStub(tmpl OutcomeTemplate) func(sreq StubRequest) *ExecOutcome {
+	return AdaptOutcomeToCmdStub(TemplatedOutcome(tmpl))
+}
+
+// TemplatedOutcome returns an outcome whose stdout, stderr and exit code are
+// rendered from the given templates (see OutcomeTemplate).
+func TemplatedOutcome(tmpl OutcomeTemplate) *ExecOutcome {
+	return &ExecOutcome{Template: &tmpl}
+}
+
 // AdaptOutcomesToCmdStub returns a CmdStub which will yield the given outcomes.
 func AdaptOutcomesToCmdStub(
 	outcomes []*ExecOutcome,
@@ -66,10 +147,8 @@ func AdaptFuncsToCmdStub(
 	repeatLast bool,
 ) func(sreq StubRequest) *ExecOutcome {
 	next := new(int32)
-	*next = 0
 	return func(sreq StubRequest) *ExecOutcome {
-		index := int(*next)
-		defer func() { atomic.AddInt32(next, 1) }()
+		index := int(atomic.AddInt32(next, 1)) - 1
 		if index >= len(stubFuncs) {
 			if !repeatLast {
 				internalErrTxt := fmt.Sprintf(
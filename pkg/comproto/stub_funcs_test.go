@@ -15,7 +15,11 @@
 package comproto
 
 import (
+	"context"
+	"errors"
 	"reflect"
+	"strconv"
+	"sync"
 	"testing"
 )
 
@@ -268,3 +272,76 @@ func TestRecordingExecutions(t *testing.T) {
 		})
 	}
 }
+
+func TestAdaptStubFuncWithContextToCmdStub(t *testing.T) {
+	tests := []struct {
+		name     string
+		stubFunc StubFuncWithContext
+		want     *ExecOutcome
+	}{
+		{
+			name: "Should return the outcome",
+			stubFunc: func(ctx context.Context, sreq StubRequest) (*ExecOutcome, error) {
+				return &ExecOutcome{Key: sreq.Key, Stdout: "o1"}, nil
+			},
+			want: &ExecOutcome{Key: "k1", Stdout: "o1"},
+		},
+		{
+			name: "Should turn the error into the internal error",
+			stubFunc: func(ctx context.Context, sreq StubRequest) (*ExecOutcome, error) {
+				return &ExecOutcome{Stdout: "ignored"}, errors.New("no db")
+			},
+			want: &ExecOutcome{Key: "k1", ExitCode: 255, InternalErrTxt: "no db"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := AdaptStubFuncWithContextToCmdStub(tt.stubFunc)(StubRequest{Key: "k1"})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("AdaptStubFuncWithContextToCmdStub() \ngot = %v \nwant= %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAdaptCmdStubToStubFuncWithContext(t *testing.T) {
+	want := &ExecOutcome{Key: "k1", Stdout: "o1"}
+	stubFunc := AdaptCmdStubToStubFuncWithContext(func(sreq StubRequest) *ExecOutcome {
+		return &ExecOutcome{Key: sreq.Key, Stdout: "o1"}
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	got, err := stubFunc(ctx, StubRequest{Key: "k1"})
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("AdaptCmdStubToStubFuncWithContext() \ngot = %v, %v \nwant= %v, nil", got, err, want)
+	}
+}
+
+func TestRecordingExecutionsWithSnapshot(t *testing.T) {
+	recordingStubFunc, requests := RecordingExecutionsWithSnapshot(AdaptOutcomeToCmdStub(&ExecOutcome{}))
+	const execCount = 50
+	var wg sync.WaitGroup
+	for i := 0; i < execCount; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			recordingStubFunc(StubRequest{Key: "k1", Args: []string{strconv.Itoa(i)}})
+		}(i)
+	}
+	// reading while recording
+	for i := 0; i < execCount; i++ {
+		if got := len(requests()); got > execCount {
+			t.Fatalf("RecordingExecutionsWithSnapshot() got %d requests, want at most %d", got, execCount)
+		}
+	}
+	wg.Wait()
+
+	snapshot := requests()
+	if len(snapshot) != execCount {
+		t.Fatalf("RecordingExecutionsWithSnapshot() got %d requests, want %d", len(snapshot), execCount)
+	}
+	snapshot[0].Key = "changed"
+	if requests()[0].Key != "k1" {
+		t.Errorf("RecordingExecutionsWithSnapshot() snapshot should not share the recorded requests")
+	}
+}
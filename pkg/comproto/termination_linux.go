@@ -0,0 +1,39 @@
+// Copyright 2020 The Execstub Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !windows
+
+package comproto
+
+import (
+	"fmt"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// TerminateBySignal terminates the current process with the given signal,
+// so that its parent sees a process killed by the signal (see syscall.WaitStatus.Signaled).
+// It only returns in case of an error.
+// The go runtime handles some signals (e.g. SIGSEGV, SIGABRT) itself, terminating
+// with a stack dump and exit code 2. The process image is therefore replaced by a
+// shell (same process, default signal dispositions) which sends the signal to itself.
+func TerminateBySignal(sig syscall.Signal) error {
+	if sig <= 0 {
+		return errors.Errorf("invalid termination signal: %d", sig)
+	}
+	script := fmt.Sprintf("kill -%d $$", int(sig))
+	err := syscall.Exec("/bin/sh", []string{"sh", "-c", script}, nil)
+	return errors.Wrapf(err, "fail to terminate by signal %d(%v)", int(sig), sig)
+}
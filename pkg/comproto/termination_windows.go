@@ -0,0 +1,29 @@
+// Copyright 2020 The Execstub Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build windows
+
+package comproto
+
+import (
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// TerminateBySignal is not supported on windows, because windows has no signal
+// based process termination. It always returns an error.
+func TerminateBySignal(sig syscall.Signal) error {
+	return errors.Errorf("termination by signal %d(%v) not supported on windows", int(sig), sig)
+}
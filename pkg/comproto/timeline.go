@@ -0,0 +1,227 @@
+// Copyright 2020 The Execstub Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package comproto
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// InvocationTimeline is the journal of the invocations of all the commands stubbed by an ExecStubber.
+// The invocations are ordered by a monotonic sequence number, which is shared by all processes:
+// an entry is a file named after its sequence number and created exclusively (O_EXCL),
+// so that concurrent invocations cannot claim the same number.
+// The ordering therefore does not rely on wall-clock time.
+// The sequence numbers only go up, even across DeleteAll, because they also name
+// the request files persisted into the data dirs (see StubRequestDirRepo.SaveSequenced).
+type InvocationTimeline struct {
+	dir string
+}
+
+// TimelineEntry is an invocation recorded into the timeline.
+type TimelineEntry struct {
+	Seq     uint64
+	Request StubRequest
+}
+
+const timelineEntryFilePrefix = "inv_"
+
+// timelineHighWaterMarkFilePrefix prefixes the name of the file recording the highest sequence number
+// claimed before the entries were deleted, so that the numbering continues after it.
+const timelineHighWaterMarkFilePrefix = "hwm_"
+
+// maxTimelineClaimAttempts bounds the attempts to claim a sequence number concurrently claimed by others.
+const maxTimelineClaimAttempts = 1000
+
+// NewInvocationTimeline creates a new timeline given its directory.
+func NewInvocationTimeline(dir string) InvocationTimeline {
+	return InvocationTimeline{dir: dir}
+}
+
+func timelineEntryFileName(seq uint64) string {
+	return fmt.Sprintf("%s%0.20d", timelineEntryFilePrefix, seq)
+}
+
+func timelineHighWaterMarkFileName(seq uint64) string {
+	return fmt.Sprintf("%s%0.20d", timelineHighWaterMarkFilePrefix, seq)
+}
+
+// Append records the request as the next invocation and returns its sequence number.
+func (timeline InvocationTimeline) Append(req StubRequest) (seq uint64, err error) {
+	if timeline.dir == "" {
+		return 0, errors.New("timeline dir must not be empty, so cannot do <Append>")
+	}
+	seq, err = timeline.lastSeq()
+	if err != nil {
+		return 0, err
+	}
+	for attempt := 0; attempt < maxTimelineClaimAttempts; attempt++ {
+		seq++
+		entryPath := filepath.Join(timeline.dir, timelineEntryFileName(seq))
+		entryFile, err := os.OpenFile(entryPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0660)
+		if os.IsExist(err) {
+			// claimed by a concurrent invocation
+			continue
+		}
+		if err != nil {
+			return 0, errors.Wrapf(err, "fail to create timeline entry file:%s", entryPath)
+		}
+		err = StubRequestEncoderFunc(entryFile)(&req)
+		entryFile.Close()
+		if err != nil {
+			return 0, errors.Wrapf(err, "fail to write request into timeline entry file:%s", entryPath)
+		}
+		return seq, nil
+	}
+	return 0, errors.Errorf(
+		"fail to claim a timeline sequence number after %d attempts in:%s", maxTimelineClaimAttempts, timeline.dir)
+}
+
+// lastSeq returns the highest sequence number claimed so far, also before the entries were deleted; 0 if none.
+func (timeline InvocationTimeline) lastSeq() (uint64, error) {
+	entryFiles, err := timeline.globEntryFiles()
+	if err != nil {
+		return 0, err
+	}
+	markFiles, err := timeline.globHighWaterMarkFiles()
+	if err != nil {
+		return 0, err
+	}
+	lastSeq := uint64(0)
+	for _, entryFile := range append(entryFiles, markFiles...) {
+		seq, err := seqOfTimelineEntryFile(entryFile)
+		if err != nil {
+			return 0, err
+		}
+		if seq > lastSeq {
+			lastSeq = seq
+		}
+	}
+	return lastSeq, nil
+}
+
+func seqOfTimelineEntryFile(entryFile string) (uint64, error) {
+	seqStr := strings.TrimPrefix(filepath.Base(entryFile), timelineEntryFilePrefix)
+	seqStr = strings.TrimPrefix(seqStr, timelineHighWaterMarkFilePrefix)
+	seq, err := strconv.ParseUint(strings.TrimLeft(seqStr, "0"), 10, 64)
+	if err != nil {
+		return 0, errors.Wrapf(err, "bad timeline entry file name:%s", entryFile)
+	}
+	return seq, nil
+}
+
+func (timeline InvocationTimeline) globEntryFiles() ([]string, error) {
+	pattern := filepath.Join(timeline.dir, timelineEntryFilePrefix+"*")
+	entryFiles, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not glob timeline entry files with pattern= %s", pattern)
+	}
+	return entryFiles, nil
+}
+
+func (timeline InvocationTimeline) globHighWaterMarkFiles() ([]string, error) {
+	pattern := filepath.Join(timeline.dir, timelineHighWaterMarkFilePrefix+"*")
+	markFiles, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not glob timeline high-water mark files with pattern= %s", pattern)
+	}
+	return markFiles, nil
+}
+
+// FindAll returns all invocations ordered by their sequence number.
+func (timeline InvocationTimeline) FindAll() (entries *[]TimelineEntry, err error) {
+	if timeline.dir == "" {
+		return &[]TimelineEntry{}, nil
+	}
+	entryFiles, err := timeline.globEntryFiles()
+	if err != nil {
+		return nil, err
+	}
+	found := make([]TimelineEntry, 0, len(entryFiles))
+	for _, entryFile := range entryFiles {
+		seq, err := seqOfTimelineEntryFile(entryFile)
+		if err != nil {
+			return nil, err
+		}
+		entryBytes, err := ioutil.ReadFile(entryFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "fail to read timeline entry file:%s", entryFile)
+		}
+		req, err := StubRequestDecoderFunc(strings.NewReader(string(entryBytes)))
+		if err != nil {
+			return nil, errors.Wrapf(err, "fail to decode request from timeline entry file:%s", entryFile)
+		}
+		found = append(found, TimelineEntry{Seq: seq, Request: *req})
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].Seq < found[j].Seq })
+	return &found, nil
+}
+
+// DeleteAll deletes all invocations; the sequence numbering continues after the highest deleted one,
+// so that the requests persisted using their sequence number are not overwritten.
+func (timeline InvocationTimeline) DeleteAll() error {
+	if timeline.dir == "" {
+		return nil
+	}
+	lastSeq, err := timeline.lastSeq()
+	if err != nil {
+		return err
+	}
+	if lastSeq == 0 {
+		return nil
+	}
+	markFile := filepath.Join(timeline.dir, timelineHighWaterMarkFileName(lastSeq))
+	if err := ioutil.WriteFile(markFile, []byte{}, 0660); err != nil {
+		return errors.Wrapf(err, "fail to write timeline high-water mark file:%s", markFile)
+	}
+	entryFiles, err := timeline.globEntryFiles()
+	if err != nil {
+		return err
+	}
+	markFiles, err := timeline.globHighWaterMarkFiles()
+	if err != nil {
+		return err
+	}
+	for _, entryFile := range append(entryFiles, markFiles...) {
+		if entryFile == markFile {
+			continue
+		}
+		if err := os.Remove(entryFile); err != nil {
+			return errors.Wrapf(err, "fail to delete timeline entry file:%s", entryFile)
+		}
+	}
+	return nil
+}
+
+// SaveStaticRequest persists the request of a static outcome execution into the data dir of the config,
+// recording it into the invocation timeline if the config has one.
+func SaveStaticRequest(cfg CmdConfig, req StubRequest) error {
+	repo := NewStubRequestDirRepo(cfg.DataDir)
+	if cfg.TimelineDir == "" {
+		return repo.Save(req)
+	}
+	seq, err := NewInvocationTimeline(cfg.TimelineDir).Append(req)
+	if err != nil {
+		return err
+	}
+	return repo.SaveSequenced(req, seq)
+}
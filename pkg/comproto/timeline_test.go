@@ -0,0 +1,127 @@
+// Copyright 2020 The Execstub Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package comproto
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestInvocationTimeline_AppendConcurrently(t *testing.T) {
+	timelineDir, err := ioutil.TempDir("", "execstub-timeline-*")
+	if err != nil {
+		t.Fatalf("fail to create timeline dir: %v", err)
+	}
+	defer os.RemoveAll(timelineDir)
+	timeline := NewInvocationTimeline(timelineDir)
+	const appendCount = 32
+
+	var wg sync.WaitGroup
+	seqs := make(chan uint64, appendCount)
+	for i := 0; i < appendCount; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			seq, err := timeline.Append(StubRequest{Key: "git_42", CmdName: "git", Args: []string{fmt.Sprint(i)}})
+			if err != nil {
+				t.Errorf("Append() error = %v", err)
+				return
+			}
+			seqs <- seq
+		}(i)
+	}
+	wg.Wait()
+	close(seqs)
+
+	claimed := make(map[uint64]bool)
+	for seq := range seqs {
+		if claimed[seq] {
+			t.Errorf("sequence number %d claimed more than once", seq)
+		}
+		claimed[seq] = true
+	}
+	entries, err := timeline.FindAll()
+	if err != nil {
+		t.Fatalf("FindAll() error = %v", err)
+	}
+	if len(*entries) != appendCount {
+		t.Fatalf("FindAll() len = %d, want %d", len(*entries), appendCount)
+	}
+	for i, entry := range *entries {
+		if entry.Seq != uint64(i+1) {
+			t.Errorf("entry[%d].Seq = %d, want %d", i, entry.Seq, i+1)
+		}
+	}
+}
+
+func TestInvocationTimeline_AppendAfterDeletion(t *testing.T) {
+	timelineDir, err := ioutil.TempDir("", "execstub-timeline-*")
+	if err != nil {
+		t.Fatalf("fail to create timeline dir: %v", err)
+	}
+	defer os.RemoveAll(timelineDir)
+	timeline := NewInvocationTimeline(timelineDir)
+	fetch := StubRequest{Key: "git_42", CmdName: "git", Args: []string{"fetch"}}
+	merge := StubRequest{Key: "git_42", CmdName: "git", Args: []string{"merge", "origin/main"}}
+	push := StubRequest{Key: "git_42", CmdName: "git", Args: []string{"push"}}
+
+	for _, req := range []StubRequest{fetch, merge, push} {
+		if _, err := timeline.Append(req); err != nil {
+			t.Fatalf("Append() error = %v", err)
+		}
+	}
+	// a hole must not lead to a sequence number being reused
+	if err := os.Remove(filepath.Join(timelineDir, timelineEntryFileName(2))); err != nil {
+		t.Fatal(err)
+	}
+	seq, err := timeline.Append(fetch)
+	if err != nil || seq != 4 {
+		t.Errorf("Append() = (%d, %v), want (4, nil)", seq, err)
+	}
+
+	entries, err := timeline.FindAll()
+	if err != nil {
+		t.Fatalf("FindAll() error = %v", err)
+	}
+	want := []TimelineEntry{{Seq: 1, Request: fetch}, {Seq: 3, Request: push}, {Seq: 4, Request: fetch}}
+	if !reflect.DeepEqual(*entries, want) {
+		t.Errorf("FindAll() = %#v, want %#v", *entries, want)
+	}
+
+	if err := timeline.DeleteAll(); err != nil {
+		t.Fatalf("DeleteAll() error = %v", err)
+	}
+	if seq, err := timeline.Append(merge); err != nil || seq != 5 {
+		t.Errorf("Append() after DeleteAll() = (%d, %v), want (5, nil)", seq, err)
+	}
+	entries, err = timeline.FindAll()
+	if err != nil {
+		t.Fatalf("FindAll() after DeleteAll() error = %v", err)
+	}
+	if want := []TimelineEntry{{Seq: 5, Request: merge}}; !reflect.DeepEqual(*entries, want) {
+		t.Errorf("FindAll() after DeleteAll() = %#v, want %#v", *entries, want)
+	}
+	if err := timeline.DeleteAll(); err != nil {
+		t.Fatalf("DeleteAll() error = %v", err)
+	}
+	if seq, err := timeline.Append(merge); err != nil || seq != 6 {
+		t.Errorf("Append() after second DeleteAll() = (%d, %v), want (6, nil)", seq, err)
+	}
+}
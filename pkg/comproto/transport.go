@@ -0,0 +1,99 @@
+// Copyright 2020 The Execstub Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package comproto
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// TransportClientExecName is the name the stub executable is installed under to serve as
+// the transport client of the bash stub executable, which can connect neither to
+// a unix domain socket nor to an http server.
+// Invoked under that name, it expects the path of the config of the stubbed command
+// as first argument, followed by the arguments of the stubbed command.
+const TransportClientExecName = "execstub_transport_client"
+
+// HTTPInvocationPath is the path of the http endpoint the invocations are posted to.
+const HTTPInvocationPath = "/invocations"
+
+// HTTPAuthorizationHeaderValue returns the value of the Authorization header of an invocation
+// authenticated by the given token.
+func HTTPAuthorizationHeaderValue(token string) string {
+	return "Bearer " + token
+}
+
+// requestOutcomeThroughTransport requests the outcome of the invocation through the transport of the config.
+func requestOutcomeThroughTransport(cfg CmdConfig, req StubRequest, timeout time.Duration) (*ExecOutcome, error) {
+	switch {
+	case cfg.SocketPath != "":
+		return RequestOutcomeThroughSocket(cfg.SocketPath, req, timeout)
+	case cfg.HTTPAddress != "":
+		return RequestOutcomeThroughHTTP(cfg.HTTPAddress, cfg.HTTPToken, req, timeout)
+	default:
+		return RequestOutcomeForInvocation(cfg.PipeStubber, cfg.PipeTestHelperProcess, req, timeout)
+	}
+}
+
+// transportDescription describes the transport of the config for error messages.
+func (cfg CmdConfig) transportDescription() string {
+	switch {
+	case cfg.SocketPath != "":
+		return fmt.Sprintf("unix socket[%s]", cfg.SocketPath)
+	case cfg.HTTPAddress != "":
+		return fmt.Sprintf("http[%s]", cfg.HTTPAddress)
+	default:
+		return fmt.Sprintf("named pipes[stubber=%s, test-helper=%s]", cfg.PipeStubber, cfg.PipeTestHelperProcess)
+	}
+}
+
+// RequestOutcomeThroughHTTP posts the request to the http server of the stubber at the given
+// 127.0.0.1 address and reads the outcome from the response.
+func RequestOutcomeThroughHTTP(
+	address string, token string, req StubRequest, timeout time.Duration,
+) (*ExecOutcome, error) {
+	var body bytes.Buffer
+	if err := StubRequestEncoderFunc(&body)(&req); err != nil {
+		return nil, errors.Wrapf(err, "fail to encode request for stubber http[%s]", address)
+	}
+	url := "http://" + address + HTTPInvocationPath
+	httpReq, err := http.NewRequest(http.MethodPost, url, &body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "fail to create request for stubber http[%s]", address)
+	}
+	httpReq.Header.Set("Authorization", HTTPAuthorizationHeaderValue(token))
+	// no proxy: the stubber is only reachable through the loopback interface
+	client := http.Client{Timeout: timeout, Transport: &http.Transport{Proxy: nil}}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, errors.Wrapf(err, "fail to post request to stubber http[%s]", address)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return nil, errors.Errorf(
+			"stubber http[%s] responded with status %s: %s", address, resp.Status, msg)
+	}
+	outcome, err := ExecOutcomeDecoderFunc(resp.Body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "fail to read outcome from stubber http[%s]", address)
+	}
+	return outcome, nil
+}
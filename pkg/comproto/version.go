@@ -0,0 +1,88 @@
+// Copyright 2020 The Execstub Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package comproto
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ProtocolVersion is the version of the protocol the stubber and the stub executables
+// communicate with: the config file of the stub executables, the stub requests and the exec outcomes.
+// It has to be increased with every incompatible change of those encodings.
+// Version 1 is the initial protocol, whose encodings had no version marker.
+// Version 2 added the version markers.
+// Version 3 added the structured encoding used between go processes (see WireFormat);
+// the csv encoding is unchanged since version 2, so csv records of version 2 are still decoded.
+//
+// There is no negotiation: both sides must speak the same version, which is the case as long as
+// they come from the same execstub version. A mismatch, e.g. caused by a stale stub executable,
+// is reported as an IncompatibleVersionError.
+const ProtocolVersion = 3
+
+// oldestCSVProtocolVersion is the oldest protocol version whose csv records are still decoded.
+const oldestCSVProtocolVersion = 2
+
+// unversionedProtocolVersion is the version of encodings without version marker.
+const unversionedProtocolVersion = "1"
+
+// protocolVersionMarkerPrefix prefixes the version marker leading every encoded stub request
+// and exec outcome, be it the first record of a csv encoding or the header of a structured frame.
+// '-' is not part of the base64 alphabet, so that a marker cannot be mistaken
+// for the leading base64 record of an unversioned encoding.
+const protocolVersionMarkerPrefix = "execstub-v"
+
+// IncompatibleVersionError reports data encoded with a protocol version other than ProtocolVersion.
+type IncompatibleVersionError struct {
+	// Subject is what has been encoded, e.g. "stub request" or "config file <path>"
+	Subject string
+	// Version is the protocol version the subject has been encoded with
+	Version string
+}
+
+func (versionErr *IncompatibleVersionError) Error() string {
+	return fmt.Sprintf(
+		"incompatible execstub version: %s uses protocol version %s but version %d is expected;"+
+			" stub executables and stubber must come from the same execstub version",
+		versionErr.Subject, versionErr.Version, ProtocolVersion)
+}
+
+// IsIncompatibleVersionError tells whether the error is, or wraps, an IncompatibleVersionError.
+func IsIncompatibleVersionError(err error) bool {
+	var versionErr *IncompatibleVersionError
+	return errors.As(err, &versionErr)
+}
+
+func protocolVersionMarker(version int) string {
+	return protocolVersionMarkerPrefix + strconv.Itoa(version)
+}
+
+// recordsAfterVersionMarker asserts that the records start with the marker of a protocol version
+// from oldestVersion up to ProtocolVersion and returns the records following it.
+func recordsAfterVersionMarker(subject string, rec []string, oldestVersion int) ([]string, error) {
+	for v := ProtocolVersion; v >= oldestVersion; v-- {
+		if len(rec) > 0 && rec[0] == protocolVersionMarker(v) {
+			return rec[1:], nil
+		}
+	}
+	version := unversionedProtocolVersion
+	if len(rec) > 0 && strings.HasPrefix(rec[0], protocolVersionMarkerPrefix) {
+		version = strings.TrimPrefix(rec[0], protocolVersionMarkerPrefix)
+	}
+	return nil, &IncompatibleVersionError{Subject: subject, Version: version}
+}
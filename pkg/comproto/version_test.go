@@ -0,0 +1,167 @@
+// Copyright 2020 The Execstub Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package comproto
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// encodedWithVersion re-encodes the data, given encoded with ProtocolVersion,
+// as it would have been encoded with the given version; "1" drops the version marker of a csv record.
+func encodedWithVersion(t *testing.T, format WireFormat, encoded string, version string) string {
+	currentMarker := protocolVersionMarker(ProtocolVersion) + ","
+	if format == WireFormatStructured {
+		currentMarker = "#" + protocolVersionMarker(ProtocolVersion) + " "
+	}
+	if !strings.HasPrefix(encoded, currentMarker) {
+		t.Fatalf("encoding should start with the marker %q but was: %q", currentMarker, encoded)
+	}
+	if version == unversionedProtocolVersion {
+		return strings.TrimPrefix(encoded, currentMarker)
+	}
+	return strings.Replace(currentMarker, strconv.Itoa(ProtocolVersion), version, 1) +
+		strings.TrimPrefix(encoded, currentMarker)
+}
+
+func TestDecodersCheckProtocolVersion(t *testing.T) {
+	req := StubRequest{Key: "k_1", CmdName: "docker", Args: []string{"ps", "-a"}}
+	outcome := ExecOutcome{Key: "k_1", Stdout: "CONTAINER ID", ExitCode: 3}
+
+	newerVersion := strconv.Itoa(ProtocolVersion + 1)
+	tests := []struct {
+		name        string
+		version     string
+		wantVersion string
+		// wantStructuredVersion overrides wantVersion for the structured format if not empty
+		wantStructuredVersion string
+	}{
+		{name: "Should decode current version", version: strconv.Itoa(ProtocolVersion)},
+		{
+			name: "Should decode csv of version 2 but reject structured frame", version: "2",
+			wantStructuredVersion: "2",
+		},
+		{name: "Should reject unversioned encoding of version 1", version: "1", wantVersion: "1"},
+		{name: "Should reject newer version", version: newerVersion, wantVersion: newerVersion},
+		{name: "Should reject malformed version", version: "x", wantVersion: "x"},
+	}
+	for _, format := range []WireFormat{WireFormatStructured, WireFormatCSV} {
+		var reqBuf, outcomeBuf bytes.Buffer
+		if err := StubRequestEncoderFuncFor(&reqBuf, format)(&req); err != nil {
+			t.Fatalf("fail to encode request: %v", err)
+		}
+		if err := ExecOutcomeEncoderFuncFor(&outcomeBuf, format)(&outcome); err != nil {
+			t.Fatalf("fail to encode outcome: %v", err)
+		}
+		for _, tt := range tests {
+			if format == WireFormatStructured && tt.version == unversionedProtocolVersion {
+				// version 1 had no structured format
+				continue
+			}
+			wantVersion := tt.wantVersion
+			if format == WireFormatStructured && tt.wantStructuredVersion != "" {
+				wantVersion = tt.wantStructuredVersion
+			}
+			t.Run(format.String()+" "+tt.name, func(t *testing.T) {
+				gotReq, errReq := StubRequestDecoderFunc(
+					strings.NewReader(encodedWithVersion(t, format, reqBuf.String(), tt.version)))
+				gotOutcome, errOutcome := ExecOutcomeDecoderFunc(
+					strings.NewReader(encodedWithVersion(t, format, outcomeBuf.String(), tt.version)))
+				if wantVersion == "" {
+					if errReq != nil || !reflect.DeepEqual(*gotReq, req) {
+						t.Errorf("StubRequestDecoderFunc() = %#v, %v, want %#v", gotReq, errReq, req)
+					}
+					if errOutcome != nil || !reflect.DeepEqual(*gotOutcome, outcome) {
+						t.Errorf("ExecOutcomeDecoderFunc() = %#v, %v, want %#v", gotOutcome, errOutcome, outcome)
+					}
+					return
+				}
+				assertIncompatibleVersionError(t, errReq, "stub request", wantVersion)
+				assertIncompatibleVersionError(t, errOutcome, "exec outcome", wantVersion)
+			})
+		}
+	}
+}
+
+func TestCmdConfigLoadedFromFileChecksProtocolVersion(t *testing.T) {
+	tests := []struct {
+		name        string
+		version     int
+		dropVersion bool
+		wantVersion string
+	}{
+		{name: "Should default to current version", version: 0},
+		{name: "Should load current version", version: ProtocolVersion},
+		{name: "Should reject config of version 1", version: 1, wantVersion: "1"},
+		{name: "Should reject config of version 2", version: 2, wantVersion: "2"},
+		{name: "Should reject config without version as version 1", dropVersion: true, wantVersion: "1"},
+		{name: "Should reject config of newer version", version: ProtocolVersion + 1, wantVersion: strconv.Itoa(ProtocolVersion + 1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmdDir, err := ioutil.TempDir("", "execstub-config-version")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(cmdDir)
+			cfg := CmdConfig{StubKey: "docker_1", CmdToStub: "docker", ProtocolVersion: tt.version}
+			if err := cfg.CreateConfigFile(cmdDir); err != nil {
+				t.Fatalf("CmdConfig.CreateConfigFile() error = %v", err)
+			}
+			cfgPath := filepath.Join(cmdDir, "docker.config")
+			if tt.dropVersion {
+				cfgBytes, err := ioutil.ReadFile(cfgPath)
+				if err != nil {
+					t.Fatal(err)
+				}
+				versionLine := regexp.MustCompile(`(?m)^__EXECSTUBBING_PROTOCOL_VERSION=.*$`)
+				if err := ioutil.WriteFile(cfgPath, versionLine.ReplaceAll(cfgBytes, nil), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, err := CmdConfigLoadedFromFile(cfgPath)
+			if tt.wantVersion == "" {
+				if err != nil || got.ProtocolVersion != ProtocolVersion {
+					t.Errorf("CmdConfigLoadedFromFile() = %#v, %v, want protocol version %d",
+						got, err, ProtocolVersion)
+				}
+				return
+			}
+			assertIncompatibleVersionError(t, err, "config file "+cfgPath, tt.wantVersion)
+		})
+	}
+}
+
+func assertIncompatibleVersionError(t *testing.T, err error, wantSubject string, wantVersion string) {
+	t.Helper()
+	if !IsIncompatibleVersionError(err) {
+		t.Errorf("want IncompatibleVersionError but got: %v", err)
+		return
+	}
+	want := fmt.Sprintf("incompatible execstub version: %s uses protocol version %s but version %d is expected",
+		wantSubject, wantVersion, ProtocolVersion)
+	if !strings.Contains(err.Error(), want) {
+		t.Errorf("error should contain %q but was: %v", want, err)
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright 2020 The Execstub Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package execstub
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"testing"
+
+	comproto "github.com/congop/execstub/pkg/comproto"
+)
+
+// New constructs a new ExecStubber bound to the given test.
+// The stubber is cleaned up at the end of the test and logs using t.Logf.
+// Internal errors (e.g. executions of a command which is not or no longer registered,
+// too many executions, panicking StubFunc) are reported as test failures,
+// instead of only being surfaced as exit code 255 to the code under test.
+func New(t testing.TB) *ExecStubber {
+	stubber := NewExecStubber()
+	stubber.reporter = &stubberReporter{tb: t}
+	t.Cleanup(stubber.CleanUp)
+	return stubber
+}
+
+// stubberReporter forwards logs and internal errors to the test the stubber is bound to, if any.
+type stubberReporter struct {
+	mutex sync.Mutex
+	tb    testing.TB
+	// detached is true once the stubber has been cleaned up;
+	// the test may then be completed so that it must not be used anymore.
+	detached bool
+}
+
+func (reporter *stubberReporter) logf(format string, args ...interface{}) {
+	if reporter == nil {
+		log.Printf(format, args...)
+		return
+	}
+	reporter.mutex.Lock()
+	defer reporter.mutex.Unlock()
+	if reporter.tb == nil || reporter.detached {
+		log.Printf(format, args...)
+		return
+	}
+	reporter.tb.Logf(format, args...)
+}
+
+func (reporter *stubberReporter) internalError(sreq comproto.StubRequest, internalErrTxt string) {
+	if reporter == nil {
+		return
+	}
+	reporter.mutex.Lock()
+	defer reporter.mutex.Unlock()
+	if reporter.tb == nil || reporter.detached {
+		return
+	}
+	reporter.tb.Errorf(
+		"execstub internal error while stubbing: cmd=%q args=%q key=%s\n%s",
+		sreq.CmdName, sreq.Args, sreq.Key, internalErrTxt)
+}
+
+// blockedExecutions reports the executions blocked in strict mode as a test failure,
+// or logs them if the stubber is not bound to a test.
+func (reporter *stubberReporter) blockedExecutions(blocked []comproto.StubRequest) {
+	if len(blocked) == 0 {
+		return
+	}
+	var txt strings.Builder
+	fmt.Fprintf(&txt,
+		"execstub strict mode blocked %d execution(s) of commands neither stubbed nor allowed:", len(blocked))
+	for i, sreq := range blocked {
+		fmt.Fprintf(&txt, "\n\t%d. %s %q", i+1, sreq.CmdName, sreq.Args)
+	}
+	if reporter == nil {
+		log.Print(txt.String())
+		return
+	}
+	reporter.mutex.Lock()
+	defer reporter.mutex.Unlock()
+	if reporter.tb == nil || reporter.detached {
+		log.Print(txt.String())
+		return
+	}
+	reporter.tb.Errorf("%s", txt.String())
+}
+
+func (reporter *stubberReporter) detach() {
+	if reporter == nil {
+		return
+	}
+	reporter.mutex.Lock()
+	defer reporter.mutex.Unlock()
+	reporter.detached = true
+}
+
+// guardStubFunc returns a StubFuncWithContext which turns a panic or an error of cmdStub
+// into an internal error and reports the internal errors of the outcomes.
+// It never returns an error itself.
+// Internal errors of executions whose context is done are only logged, because their
+// outcome cannot be delivered anyway: the stub executable is gone or has timed out.
+func (stubber *ExecStubber) guardStubFunc(cmdStub comproto.StubFuncWithContext) comproto.StubFuncWithContext {
+	return func(ctx context.Context, sreq comproto.StubRequest) (outcome *comproto.ExecOutcome, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				outcome = &comproto.ExecOutcome{
+					Key:            sreq.Key,
+					ExitCode:       math.MaxUint8,
+					InternalErrTxt: fmt.Sprintf("StubFunc panicked: %v\n%s", r, debug.Stack()),
+				}
+			}
+			if outcome == nil || outcome.InternalErrTxt == "" {
+				return
+			}
+			if ctx.Err() != nil {
+				stubber.reporter.logf("Execution gone (%v), discarding internal error: req=%#v err=%s",
+					ctx.Err(), sreq, outcome.InternalErrTxt)
+				return
+			}
+			stubber.reporter.internalError(sreq, outcome.InternalErrTxt)
+		}()
+		outcome, err = cmdStub(ctx, sreq)
+		if err != nil {
+			outcome = &comproto.ExecOutcome{
+				Key:            sreq.Key,
+				ExitCode:       math.MaxUint8,
+				InternalErrTxt: fmt.Sprintf("StubFunc failed: %v", err),
+			}
+		}
+		return outcome, nil
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright 2020 The Execstub Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package execstub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	rt "github.com/congop/execstub/internal/runtime"
+	comproto "github.com/congop/execstub/pkg/comproto"
+)
+
+// reportRecordingTB records logs and failures instead of logging them and failing the test.
+// It may be used concurrently, as the stubber reports from the goroutines serving the stub requests.
+type reportRecordingTB struct {
+	testing.TB
+	mutex    sync.Mutex
+	logs     []string
+	failures []string
+}
+
+func (tb *reportRecordingTB) Helper() {}
+
+func (tb *reportRecordingTB) Logf(format string, args ...interface{}) {
+	tb.mutex.Lock()
+	defer tb.mutex.Unlock()
+	tb.logs = append(tb.logs, fmt.Sprintf(format, args...))
+}
+
+func (tb *reportRecordingTB) Errorf(format string, args ...interface{}) {
+	tb.mutex.Lock()
+	defer tb.mutex.Unlock()
+	tb.failures = append(tb.failures, fmt.Sprintf(format, args...))
+}
+
+func (tb *reportRecordingTB) recorded() (logs []string, failures []string) {
+	tb.mutex.Lock()
+	defer tb.mutex.Unlock()
+	return append([]string{}, tb.logs...), append([]string{}, tb.failures...)
+}
+
+func Test_New_reportsPanickingStubFuncAsTestFailure(t *testing.T) {
+	settings := comproto.SettingsDynaStubCmdDiscoveredByPath()
+	gitCmd := rt.EnsureHasExecExt("git")
+
+	runForAllStubKinds(t, settings, "panicking", func(t *testing.T, settings comproto.Settings) {
+		tb := &reportRecordingTB{TB: t}
+		stubber := New(tb)
+		_, err := stubber.WhenExecDoStubFunc(func(sreq comproto.StubRequest) *comproto.ExecOutcome {
+			panic("no outcome for git")
+		}, gitCmd, settings)
+		if err != nil {
+			t.Fatalf("Error resgistering cmd-stub: err=%#v", err)
+		}
+
+		gitOutcome := unStubbableExecCommandExecution(t, &settings, gitCmd, "status")
+
+		assert.Equal(t, uint8(255), gitOutcome.ExitCode)
+		assert.Contains(t, gitOutcome.Stderr, "StubFunc panicked: no outcome for git")
+		_, failures := tb.recorded()
+		if assert.Len(t, failures, 1) {
+			assert.Contains(t, failures[0], "execstub internal error while stubbing")
+			assert.Contains(t, failures[0], "StubFunc panicked: no outcome for git")
+		}
+	})
+}
+
+func Test_New_reportsFailingStubFuncWithContextAsTestFailure(t *testing.T) {
+	settings := comproto.SettingsDynaStubCmdDiscoveredByPath()
+	gitCmd := rt.EnsureHasExecExt("git")
+
+	runForAllStubKinds(t, settings, "failing", func(t *testing.T, settings comproto.Settings) {
+		tb := &reportRecordingTB{TB: t}
+		stubber := New(tb)
+		_, err := stubber.WhenExecDoStubFuncWithContext(
+			func(ctx context.Context, sreq comproto.StubRequest) (*comproto.ExecOutcome, error) {
+				return nil, errors.New("no repository for git")
+			}, gitCmd, settings)
+		if err != nil {
+			t.Fatalf("Error resgistering cmd-stub: err=%#v", err)
+		}
+
+		gitOutcome := unStubbableExecCommandExecution(t, &settings, gitCmd, "status")
+
+		assert.Equal(t, uint8(255), gitOutcome.ExitCode)
+		assert.Contains(t, gitOutcome.Stderr, "StubFunc failed: no repository for git")
+		_, failures := tb.recorded()
+		if assert.Len(t, failures, 1) {
+			assert.Contains(t, failures[0], "StubFunc failed: no repository for git")
+		}
+	})
+}
+
+func Test_New_reportsTooManyExecutionsAsTestFailure(t *testing.T) {
+	settings := comproto.SettingsDynaStubCmdDiscoveredByPath()
+	settings.ModeDanymic()
+	gitCmd := rt.EnsureHasExecExt("git")
+	tb := &reportRecordingTB{TB: t}
+	stubber := New(tb)
+	outcomes := []*comproto.ExecOutcome{{Stdout: "On branch main"}}
+	_, err := stubber.WhenExecDoStubFunc(comproto.AdaptOutcomesToCmdStub(outcomes, false), gitCmd, *settings)
+	if err != nil {
+		t.Fatalf("Error resgistering cmd-stub: err=%#v", err)
+	}
+
+	firstOutcome := unStubbableExecCommandExecution(t, settings, gitCmd, "status")
+	secondOutcome := unStubbableExecCommandExecution(t, settings, gitCmd, "status")
+
+	assert.Equal(t, "On branch main", firstOutcome.Stdout)
+	assert.Equal(t, uint8(255), secondOutcome.ExitCode)
+	_, failures := tb.recorded()
+	if assert.Len(t, failures, 1) {
+		assert.Contains(t, failures[0], `cmd="git" args=["status"]`)
+		assert.Contains(t, failures[0], "Too many executions while repeat-last not selected")
+	}
+}
+
+func Test_New_cleansUpAtTestEndAndLogsToTest(t *testing.T) {
+	settings := comproto.SettingsDynaStubCmdDiscoveredByPath()
+	gitCmd := rt.EnsureHasExecExt("git")
+	pathBefore := os.Getenv("PATH")
+	var tb *reportRecordingTB
+
+	t.Run("stubbing", func(t *testing.T) {
+		tb = &reportRecordingTB{TB: t}
+		stubber := New(tb)
+		_, err := stubber.WhenExecDoStubFunc(comproto.AdaptOutcomeToCmdStub(&comproto.ExecOutcome{}), gitCmd, *settings)
+		if err != nil {
+			t.Fatalf("Error resgistering cmd-stub: err=%#v", err)
+		}
+		assert.NotEqual(t, pathBefore, os.Getenv("PATH"))
+	})
+
+	assert.Equal(t, pathBefore, os.Getenv("PATH"), "discovery setup should have been reset at test end")
+	logs, failures := tb.recorded()
+	assert.Empty(t, failures)
+	assert.Contains(t, strings.Join(logs, "\n"), "Cleaning up cmdStubbingSpec")
+}
@@ -0,0 +1,275 @@
+// Copyright 2020 The Execstub Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package execstub
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/congop/execstub/internal/assets"
+	rt "github.com/congop/execstub/internal/runtime"
+	comproto "github.com/congop/execstub/pkg/comproto"
+	"github.com/pkg/errors"
+)
+
+// envToolsPath is the name of the environment variable holding the PATH in place
+// before strict mode was enabled. The stub executables use it to look up the tools they need.
+const envToolsPath = "__EXECSTUBBING_TOOLS_PATH"
+
+// blockedExitCode is the exit code of the execution of a command blocked in strict mode;
+// it is the one used by shells for commands which are not found.
+const blockedExitCode = 127
+
+// strictModeSpec holds the setup of the strict mode.
+type strictModeSpec struct {
+	// homeDir holds the bin dir, the blocker executable and the journal of the blocked executions.
+	homeDir string
+	// binDir is the only dir of PATH beside the dirs of the stubbed commands.
+	// It holds links to the allowed executables and a blocking stub for every other command of PATH.
+	binDir      string
+	dataDir     string
+	timelineDir string
+	// basePath is PATH as it was before strict mode was enabled, without the dirs of the stubbed commands.
+	basePath string
+}
+
+// EnableStrictMode makes the stubbed commands and the allowed ones the only commands discoverable by PATH,
+// so that the code under test cannot quietly run a real executable which has not been stubbed.
+// Every other command found in PATH is replaced by a blocking stub, which exits with code 127
+// and records its execution. The blocked executions are reported at CleanUp (as test failures
+// for a stubber obtained by New(t)) and can be inspected using BlockedExecutions.
+// Commands discovered by a home dir (see comproto.Settings.DiscoveredByHomeDirBin) are not affected.
+//
+// The blocking stubs are a snapshot of PATH taken when strict mode is enabled: a blocking stub,
+// a hard link with its own config, is installed for each executable then found in PATH, which
+// is costly for a large PATH; executables added to PATH dirs afterwards are not blocked.
+// Only the discovery through PATH is guarded: an execution by absolute path (e.g. /usr/bin/git)
+// bypasses strict mode and is neither blocked nor reported.
+func (stubber *ExecStubber) EnableStrictMode(allowed ...string) (err error) {
+	stubber.mutex.Lock()
+	defer stubber.mutex.Unlock()
+	if stubber.strictSpec != nil {
+		return errors.New("strict mode is already enabled")
+	}
+
+	stubDirs, basePath := stubber.splitEnvPathNotThreadSafe()
+	homeDir, err := ioutil.TempDir("", "__execstub_strict_"+strconv.Itoa(os.Getpid())+"_")
+	if err != nil {
+		return errors.Wrap(err, "fail to create strict mode home dir")
+	}
+	spec := &strictModeSpec{
+		homeDir:     homeDir,
+		binDir:      filepath.Join(homeDir, "bin"),
+		dataDir:     filepath.Join(homeDir, "data"),
+		timelineDir: filepath.Join(homeDir, "blocked"),
+		basePath:    basePath.String(),
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(homeDir)
+		}
+	}()
+	for _, dir := range []string{spec.binDir, spec.dataDir, spec.timelineDir} {
+		if err := os.MkdirAll(dir, 0770); err != nil {
+			return errors.Wrapf(err, "fail to create strict mode dir:%s", dir)
+		}
+	}
+
+	allowedNames := make(map[string]bool, len(allowed))
+	for _, cmd := range allowed {
+		cmd = rt.EnsureHasExecExt(strings.TrimSpace(cmd))
+		if err := spec.allow(cmd); err != nil {
+			return err
+		}
+		allowedNames[cmd] = true
+	}
+	if err := spec.block(allowedNames); err != nil {
+		return err
+	}
+
+	os.Setenv(envToolsPath, spec.basePath)
+	os.Setenv("PATH", newEnvPath(spec.binDir).positionFirst(stubDirs.String()).String())
+	stubber.strictSpec = spec
+	stubber.reporter.logf("Strict mode enabled: allowed=%v PATH=%s", allowed, os.Getenv("PATH"))
+	return nil
+}
+
+// BlockedExecutions returns the executions blocked in strict mode, in execution order.
+func (stubber *ExecStubber) BlockedExecutions() ([]comproto.StubRequest, error) {
+	stubber.mutex.Lock()
+	spec := stubber.strictSpec
+	stubber.mutex.Unlock()
+	if spec == nil {
+		return []comproto.StubRequest{}, nil
+	}
+	return spec.blockedExecutions()
+}
+
+// splitEnvPathNotThreadSafe splits PATH into the dirs of the stubbed commands and the other dirs.
+func (stubber *ExecStubber) splitEnvPathNotThreadSafe() (stubDirs *envPath, otherDirs *envPath) {
+	stubHomeDirs := make(map[string]bool)
+	for _, spec := range stubber.cmdStabStore {
+		stubHomeDirs[spec.instDirStruct.homeDir] = true
+	}
+	for _, groupSpec := range stubber.stubGroupStore {
+		stubHomeDirs[groupSpec.homeDir] = true
+	}
+	stubDirs, otherDirs = &envPath{}, &envPath{}
+	for _, part := range currentEnvPath().parts {
+		if stubHomeDirs[part] {
+			stubDirs.parts = append(stubDirs.parts, part)
+		} else {
+			otherDirs.parts = append(otherDirs.parts, part)
+		}
+	}
+	return stubDirs, otherDirs
+}
+
+// allow makes the real executable of the command discoverable through the bin dir.
+func (spec *strictModeSpec) allow(cmd string) error {
+	if isPath(cmd) {
+		return errors.Errorf("allowed command must not be a path, but got:%s", cmd)
+	}
+	realExec := lookPathIn(cmd, spec.basePath)
+	if realExec == "" {
+		return errors.Errorf("allowed command %s not found in PATH=%s", cmd, spec.basePath)
+	}
+	link := filepath.Join(spec.binDir, cmd)
+	if err := os.Symlink(realExec, link); err != nil {
+		if errl := os.Link(realExec, link); errl != nil {
+			return errors.Wrapf(err, "fail to link allowed command %s to %s", link, realExec)
+		}
+	}
+	return nil
+}
+
+// block installs a blocking stub into the bin dir for every command of PATH which is not allowed.
+// The stubs are hard links to a single stub executable, each with its own config.
+func (spec *strictModeSpec) block(allowedNames map[string]bool) error {
+	blockerBytes, err := assets.ExecStubExe()
+	if err != nil {
+		return errors.Wrap(err, "fail to get the exec stub for blocking")
+	}
+	blockerPath := filepath.Join(spec.homeDir, rt.EnsureHasExecExt("blocker"))
+	if err := ioutil.WriteFile(blockerPath, blockerBytes, 0770); err != nil { //nolint:gosec
+		return errors.Wrapf(err, "fail to write blocker executable:%s", blockerPath)
+	}
+	stubKey := fmt.Sprint("strict_", os.Getpid())
+	for _, cmd := range executableNamesIn(spec.basePath) {
+		if allowedNames[cmd] || (rt.IsWindows() && !strings.HasSuffix(strings.ToLower(cmd), ".exe")) {
+			continue
+		}
+		cmdPath := filepath.Join(spec.binDir, cmd)
+		if err := os.Link(blockerPath, cmdPath); err != nil {
+			if err := ioutil.WriteFile(cmdPath, blockerBytes, 0770); err != nil { //nolint:gosec
+				return errors.Wrapf(err, "fail to write blocking stub:%s", cmdPath)
+			}
+		}
+		cfg := comproto.CmdConfig{
+			StubKey:      stubKey,
+			CmdToStub:    cmd,
+			UnitTestExec: os.Args[0],
+			TxtStderr: fmt.Sprintf(
+				"execstub strict mode: %s is neither stubbed nor allowed, its execution has been blocked\n", cmd),
+			ExitCode:    uint8(blockedExitCode),
+			DataDir:     spec.dataDir,
+			TimelineDir: spec.timelineDir,
+		}
+		if err := cfg.CreateConfigFile(spec.binDir); err != nil {
+			return errors.Wrapf(err, "fail to write config of blocking stub %s", cmdPath)
+		}
+	}
+	return nil
+}
+
+func (spec *strictModeSpec) blockedExecutions() ([]comproto.StubRequest, error) {
+	entries, err := comproto.NewInvocationTimeline(spec.timelineDir).FindAll()
+	if err != nil {
+		return nil, errors.Wrap(err, "fail to read blocked executions")
+	}
+	blocked := make([]comproto.StubRequest, 0, len(*entries))
+	for _, entry := range *entries {
+		blocked = append(blocked, entry.Request)
+	}
+	return blocked, nil
+}
+
+// cleanUp reports the blocked executions and restores PATH, keeping the dirs of the still stubbed commands.
+func (spec *strictModeSpec) cleanUp(reporter *stubberReporter) {
+	blocked, err := spec.blockedExecutions()
+	if err != nil {
+		reporter.logf("Strict mode: %v", err)
+	}
+	reporter.blockedExecutions(blocked)
+
+	stubDirs := currentEnvPath().removeParts(spec.binDir)
+	os.Setenv("PATH", newEnvPath(spec.basePath).positionFirst(stubDirs.String()).String())
+	os.Unsetenv(envToolsPath)
+	os.RemoveAll(spec.homeDir)
+}
+
+// toolsEnvPath returns the PATH used to look up real executables,
+// which is PATH as it was before strict mode was enabled.
+func toolsEnvPath() string {
+	if toolsPath := os.Getenv(envToolsPath); toolsPath != "" {
+		return toolsPath
+	}
+	return os.Getenv("PATH")
+}
+
+// lookPathIn returns the path of the executable found for the command in the given PATH; "" if none.
+func lookPathIn(cmd string, envPathAsString string) string {
+	for _, dir := range newEnvPath(envPathAsString).parts {
+		if path, err := exec.LookPath(filepath.Join(dir, cmd)); err == nil {
+			if pathAbs, err := filepath.Abs(path); err == nil {
+				return pathAbs
+			}
+			return path
+		}
+	}
+	return ""
+}
+
+// executableNamesIn returns the names of the executables found in the dirs of the given PATH.
+func executableNamesIn(envPathAsString string) []string {
+	names := make([]string, 0, 1024)
+	seen := make(map[string]bool)
+	for _, dir := range newEnvPath(envPathAsString).parts {
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, file := range files {
+			if seen[file.Name()] {
+				continue
+			}
+			if file.Mode()&os.ModeSymlink != 0 {
+				if file, err = os.Stat(filepath.Join(dir, file.Name())); err != nil {
+					continue
+				}
+			}
+			if rt.IsExecutableFile(file) {
+				seen[file.Name()] = true
+				names = append(names, file.Name())
+			}
+		}
+	}
+	return names
+}
@@ -0,0 +1,93 @@
+// Copyright 2020 The Execstub Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package execstub
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	rt "github.com/congop/execstub/internal/runtime"
+	comproto "github.com/congop/execstub/pkg/comproto"
+)
+
+func Test_strictModeBlocksCommandsNeitherStubbedNorAllowed(t *testing.T) {
+	if rt.IsWindows() {
+		t.Skip("relies on sh and ls being found in PATH")
+	}
+	settings := comproto.SettingsDynaStubCmdDiscoveredByPath()
+	gitOutcome := comproto.ExecOutcome{Stdout: "On branch main", ExitCode: 0}
+	dockerOutcome := comproto.ExecOutcome{Stdout: "Docker version 19.03.8", ExitCode: 0}
+
+	runForAllStubKinds(t, settings, "strict", func(t *testing.T, settings comproto.Settings) {
+		pathBefore := os.Getenv("PATH")
+		tb := &reportRecordingTB{TB: t}
+		stubber := New(tb)
+		// stubbed before and after strict mode is enabled
+		_, err := stubber.WhenExecDoStubFunc(comproto.AdaptOutcomeToCmdStub(&dockerOutcome), "docker", settings)
+		if err != nil {
+			t.Fatalf("Error resgistering cmd-stub: err=%#v", err)
+		}
+		if err := stubber.EnableStrictMode("sh"); err != nil {
+			t.Fatalf("Error enabling strict mode: err=%#v", err)
+		}
+		_, err = stubber.WhenExecDoStubFunc(comproto.AdaptOutcomeToCmdStub(&gitOutcome), "git", settings)
+		if err != nil {
+			t.Fatalf("Error resgistering cmd-stub: err=%#v", err)
+		}
+
+		dockerCmdOutcome := unStubbableExecCommandExecution(t, &settings, "docker", "--version")
+		gitCmdOutcome := unStubbableExecCommandExecution(t, &settings, "git", "status")
+		lsCmdOutcome := unStubbableExecCommandExecution(t, &settings, "ls", "-la", "/")
+		shCmd := exec.Command("sh", "-c", "exit 3")
+		shErr := shCmd.Run()
+
+		assert.Equal(t, dockerOutcome.Stdout, dockerCmdOutcome.Stdout)
+		assert.Equal(t, gitOutcome.Stdout, gitCmdOutcome.Stdout)
+		assert.Equal(t, uint8(blockedExitCode), lsCmdOutcome.ExitCode)
+		assert.Contains(t, lsCmdOutcome.Stderr, "execstub strict mode: ls is neither stubbed nor allowed")
+		assert.Error(t, shErr)
+		assert.Equal(t, 3, shCmd.ProcessState.ExitCode(), "allowed command should run for real")
+		blocked, err := stubber.BlockedExecutions()
+		assert.NoError(t, err)
+		if assert.Len(t, blocked, 1) {
+			assert.Equal(t, "ls", blocked[0].CmdName)
+			assert.Equal(t, []string{"-la", "/"}, blocked[0].Args)
+		}
+
+		stubber.CleanUp()
+		assert.Equal(t, newEnvPath(pathBefore).String(), os.Getenv("PATH"), "PATH should have been restored")
+		assert.Equal(t, "", os.Getenv(envToolsPath))
+		_, failures := tb.recorded()
+		if assert.Len(t, failures, 1) {
+			assert.Contains(t, failures[0], "execstub strict mode blocked 1 execution(s)")
+			assert.Contains(t, failures[0], `1. ls ["-la" "/"]`)
+		}
+	})
+}
+
+func Test_strictModeRequiresAllowedCommandsToBeFound(t *testing.T) {
+	stubber := NewExecStubber()
+	t.Cleanup(stubber.CleanUp)
+	pathBefore := os.Getenv("PATH")
+
+	err := stubber.EnableStrictMode("__execstub_no_such_command")
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "allowed command __execstub_no_such_command not found")
+	assert.Equal(t, pathBefore, os.Getenv("PATH"))
+}
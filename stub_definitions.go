@@ -0,0 +1,398 @@
+// Copyright 2020 The Execstub Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package execstub
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	rt "github.com/congop/execstub/internal/runtime"
+	comproto "github.com/congop/execstub/pkg/comproto"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// StubDefinitionsDir is the directory stub definition files are stored in by default,
+// relative to the package directory of the running test.
+var StubDefinitionsDir = filepath.Join("testdata", "stubs")
+
+// stubDefinitionsExts are the supported extensions of stub definition files, in lookup order.
+var stubDefinitionsExts = []string{".yaml", ".yml", ".json"}
+
+// stubDefinitions is the content of a stub definition file.
+type stubDefinitions struct {
+	// Settings are the settings of all commands, defaulting to dynamic stubbing using exe and discovery by PATH.
+	Settings *settingsDefinition `yaml:"settings" json:"settings"`
+	Commands []commandDefinition `yaml:"commands" json:"commands"`
+}
+
+// settingsDefinition defines comproto.Settings; an empty field keeps the inherited setting.
+type settingsDefinition struct {
+	// Discovery is either path or homeBinDir.
+	Discovery string `yaml:"discovery" json:"discovery"`
+	// HomeEnvKey and BinDirs are used for discovery homeBinDir, e.g. JAVA_HOME and [bin].
+	HomeEnvKey string   `yaml:"homeEnvKey" json:"homeEnvKey"`
+	BinDirs    []string `yaml:"binDirs" json:"binDirs"`
+	// ExecType is either exe or bash.
+	ExecType string `yaml:"execType" json:"execType"`
+	// Mode is either dynamic or static.
+	Mode string `yaml:"mode" json:"mode"`
+	// Timeout is a duration, e.g. 5s.
+	Timeout           string `yaml:"timeout" json:"timeout"`
+	TestHelperProcess string `yaml:"testHelperProcess" json:"testHelperProcess"`
+	CaptureStdin      *bool  `yaml:"captureStdin" json:"captureStdin"`
+}
+
+// commandDefinition defines the stubbing of a command.
+type commandDefinition struct {
+	Cmd      string              `yaml:"cmd" json:"cmd"`
+	Settings *settingsDefinition `yaml:"settings" json:"settings"`
+	// Outcome is yielded if no expectation matches; it is the only outcome in static mode.
+	Outcome      *outcomeDefinition      `yaml:"outcome" json:"outcome"`
+	Expectations []expectationDefinition `yaml:"expectations" json:"expectations"`
+}
+
+// expectationDefinition defines an expected execution (see comproto.Expectation).
+// Without args and argCount any arguments are accepted; setting both is rejected.
+type expectationDefinition struct {
+	// Args are matched one by one: a scalar matches an equal argument,
+	// while a mapping is a matcher: {any: true}, {prefix: "/tmp"}, {regex: "^v[0-9]+$"} or {eq: "--"}.
+	Args     []interface{}     `yaml:"args" json:"args"`
+	ArgCount *int              `yaml:"argCount" json:"argCount"`
+	Outcome  outcomeDefinition `yaml:"outcome" json:"outcome"`
+}
+
+// outcomeDefinition defines an outcome; stdoutFile and stderrFile are paths
+// relative to the definition file, e.g. for multi-line output.
+type outcomeDefinition struct {
+	Stdout      string `yaml:"stdout" json:"stdout"`
+	StdoutFile  string `yaml:"stdoutFile" json:"stdoutFile"`
+	Stderr      string `yaml:"stderr" json:"stderr"`
+	StderrFile  string `yaml:"stderrFile" json:"stderrFile"`
+	ExitCode    uint8  `yaml:"exitCode" json:"exitCode"`
+	ExitDelay   string `yaml:"exitDelay" json:"exitDelay"`
+	Passthrough bool   `yaml:"passthrough" json:"passthrough"`
+}
+
+// LoadStubDefinitions registers the commands defined in the stub definition file with the given name
+// stored in StubDefinitionsDir, the extension (.yaml, .yml or .json) being optional.
+// See LoadStubDefinitionsAt.
+func (stubber *ExecStubber) LoadStubDefinitions(name string) (keys []string, err error) {
+	path := filepath.Join(StubDefinitionsDir, name)
+	if filepath.Ext(name) == "" {
+		for _, ext := range stubDefinitionsExts {
+			if _, err := os.Stat(path + ext); err == nil {
+				path += ext
+				break
+			}
+		}
+	}
+	return stubber.LoadStubDefinitionsAt(path)
+}
+
+// LoadStubDefinitionsAt registers the commands defined in the YAML or JSON stub definition file
+// at the given path and returns their keys in definition order.
+// A command is registered with its settings, expectations (arguments matchers and outcomes)
+// and the outcome yielded if no expectation matches, e.g.
+//
+//	settings:
+//	  mode: dynamic
+//	  timeout: 5s
+//	commands:
+//	  - cmd: git
+//	    expectations:
+//	      - args: [clone, {any: true}, {prefix: /tmp}]
+//	        outcome: {stderr: "Cloning into '/tmp/repo'..."}
+//	      - args: [log, --oneline]
+//	        outcome: {stdoutFile: git_log.txt}
+//	    outcome: {exitCode: 1, stderr: "unexpected git execution"}
+//	  - cmd: java
+//	    settings: {discovery: homeBinDir, homeEnvKey: JAVA_HOME, binDirs: [bin], mode: static}
+//	    outcome: {stderr: "openjdk version \"11.0.7\""}
+//
+// Nothing is registered if the file is invalid, e.g. if it defines a command more than once.
+func (stubber *ExecStubber) LoadStubDefinitionsAt(path string) (keys []string, err error) {
+	defs, err := readStubDefinitions(path)
+	if err != nil {
+		return nil, err
+	}
+	baseDir := filepath.Dir(path)
+	type cmdStub struct {
+		cmd      string
+		stubFunc comproto.StubFunc
+		settings comproto.Settings
+	}
+	cmdStubs := make([]cmdStub, 0, len(defs.Commands))
+	definedAt := make(map[string]int, len(defs.Commands))
+	for i, cmdDef := range defs.Commands {
+		cmd := rt.EnsureHasExecExt(strings.TrimSpace(cmdDef.Cmd))
+		if j, found := definedAt[cmd]; found {
+			return nil, errors.Errorf("command[%d] %s in %s is already defined by command[%d]", i, cmd, path, j)
+		}
+		definedAt[cmd] = i
+		settings, err := cmdDef.settings(defs.Settings)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid settings of command[%d] %s in %s", i, cmd, path)
+		}
+		stubFunc, err := cmdDef.stubFunc(cmd, settings, baseDir)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid command[%d] %s in %s", i, cmd, path)
+		}
+		cmdStubs = append(cmdStubs, cmdStub{cmd: cmd, stubFunc: stubFunc, settings: settings})
+	}
+
+	keys = make([]string, 0, len(cmdStubs))
+	for _, cmdStub := range cmdStubs {
+		key, err := stubber.WhenExecDoStubFunc(cmdStub.stubFunc, cmdStub.cmd, cmdStub.settings)
+		if err != nil {
+			for _, key := range keys {
+				stubber.Unregister(key)
+			}
+			return nil, errors.Wrapf(err, "fail to stub command %s defined in %s", cmdStub.cmd, path)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func readStubDefinitions(path string) (*stubDefinitions, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "fail to read stub definitions %s", path)
+	}
+	var defs stubDefinitions
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&defs)
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		err = decoder.Decode(&defs)
+	default:
+		return nil, errors.Errorf(
+			"unsupported stub definitions file %s, expecting one of the extensions %v", path, stubDefinitionsExts)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "fail to decode stub definitions %s", path)
+	}
+	if len(defs.Commands) == 0 {
+		return nil, errors.Errorf("stub definitions %s define no command", path)
+	}
+	return &defs, nil
+}
+
+func (cmdDef commandDefinition) settings(fileSettings *settingsDefinition) (comproto.Settings, error) {
+	settings := comproto.SettingsDynaStubCmdDiscoveredByPath()
+	for _, settingsDef := range []*settingsDefinition{fileSettings, cmdDef.Settings} {
+		if settingsDef == nil {
+			continue
+		}
+		if err := settingsDef.applyTo(settings); err != nil {
+			return comproto.Settings{}, err
+		}
+	}
+	return *settings, nil
+}
+
+func (settingsDef settingsDefinition) applyTo(settings *comproto.Settings) error {
+	switch strings.ToLower(settingsDef.Discovery) {
+	case "":
+	case "path":
+		settings.DiscoveredByPath()
+	case "homebindir":
+		if settingsDef.HomeEnvKey == "" {
+			return errors.New("discovery homeBinDir requires homeEnvKey")
+		}
+		settings.DiscoveredByHomeDirBin(settingsDef.HomeEnvKey, settingsDef.BinDirs...)
+	default:
+		return errors.Errorf("unsupported discovery '%s', expecting path or homeBinDir", settingsDef.Discovery)
+	}
+	switch strings.ToLower(settingsDef.ExecType) {
+	case "":
+	case "exe":
+		settings.ExecTypeExe()
+	case "bash":
+		settings.ExecTypeBash()
+	default:
+		return errors.Errorf("unsupported execType '%s', expecting exe or bash", settingsDef.ExecType)
+	}
+	switch strings.ToLower(settingsDef.Mode) {
+	case "":
+	case "dynamic":
+		settings.ModeDanymic()
+	case "static":
+		settings.ModeStatic()
+	default:
+		return errors.Errorf("unsupported mode '%s', expecting dynamic or static", settingsDef.Mode)
+	}
+	if settingsDef.Timeout != "" {
+		timeout, err := time.ParseDuration(settingsDef.Timeout)
+		if err != nil {
+			return errors.Wrapf(err, "invalid timeout '%s'", settingsDef.Timeout)
+		}
+		settings.Timeout = timeout
+	}
+	if settingsDef.TestHelperProcess != "" {
+		settings.WithTestProcessHelper(settingsDef.TestHelperProcess)
+	}
+	if settingsDef.CaptureStdin != nil {
+		settings.CaptureStdin = *settingsDef.CaptureStdin
+	}
+	return nil
+}
+
+func (cmdDef commandDefinition) stubFunc(
+	cmd string, settings comproto.Settings, baseDir string,
+) (comproto.StubFunc, error) {
+	if cmd == "" {
+		return nil, errors.New("cmd must not be empty")
+	}
+	var outcome *comproto.ExecOutcome
+	if cmdDef.Outcome != nil {
+		var err error
+		if outcome, err = cmdDef.Outcome.toOutcome(baseDir); err != nil {
+			return nil, errors.Wrap(err, "invalid outcome")
+		}
+	}
+	if settings.InModStatic() {
+		if len(cmdDef.Expectations) > 0 || outcome == nil {
+			return nil, errors.New(
+				"static mode requires a single outcome, the outcome cannot depend on the arguments")
+		}
+		return comproto.AdaptOutcomeToCmdStub(outcome), nil
+	}
+	if len(cmdDef.Expectations) == 0 && outcome == nil {
+		return nil, errors.New("neither an outcome nor expectations are defined")
+	}
+
+	stubs := comproto.NewExpectations()
+	for i, expDef := range cmdDef.Expectations {
+		if expDef.Args != nil && expDef.ArgCount != nil {
+			return nil, errors.Errorf("expectation[%d] sets both args and argCount", i)
+		}
+		exp := stubs.On(cmd)
+		switch {
+		case expDef.Args != nil:
+			matchers, err := toArgMatchers(expDef.Args)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid args of expectation[%d]", i)
+			}
+			exp.WithArgs(matchers...)
+		case expDef.ArgCount != nil:
+			exp.WithArgCount(*expDef.ArgCount)
+		}
+		expOutcome, err := expDef.Outcome.toOutcome(baseDir)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid outcome of expectation[%d]", i)
+		}
+		exp.Return(expOutcome)
+	}
+	if outcome != nil {
+		stubs.On(cmd).Return(outcome)
+	}
+	return stubs.StubFunc, nil
+}
+
+func toArgMatchers(args []interface{}) ([]interface{}, error) {
+	matchers := make([]interface{}, 0, len(args))
+	for i, arg := range args {
+		switch argTyped := arg.(type) {
+		case string:
+			matchers = append(matchers, argTyped)
+		case int, int64, uint64, float64, bool:
+			matchers = append(matchers, fmt.Sprint(argTyped))
+		case map[string]interface{}:
+			matcher, err := toArgMatcher(argTyped)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid arg[%d]", i)
+			}
+			matchers = append(matchers, matcher)
+		default:
+			return nil, errors.Errorf("arg[%d] must be a scalar or a matcher mapping, but got:%T", i, arg)
+		}
+	}
+	return matchers, nil
+}
+
+func toArgMatcher(matcherDef map[string]interface{}) (comproto.ArgMatcher, error) {
+	if len(matcherDef) != 1 {
+		return nil, errors.Errorf("matcher must have exactly one of any, eq, prefix or regex, but got:%v", matcherDef)
+	}
+	for kind, value := range matcherDef {
+		valueStr := fmt.Sprint(value)
+		switch kind {
+		case "any":
+			if value != true {
+				return nil, errors.Errorf("matcher any must be true, but got:%v", value)
+			}
+			return comproto.AnyString(), nil
+		case "eq":
+			return comproto.Eq(valueStr), nil
+		case "prefix":
+			return comproto.HasPrefix(valueStr), nil
+		case "regex":
+			if _, err := regexp.Compile(valueStr); err != nil {
+				return nil, errors.Wrapf(err, "invalid regex matcher '%s'", valueStr)
+			}
+			return comproto.MatchesRegex(valueStr), nil
+		default:
+			return nil, errors.Errorf("unsupported matcher '%s', expecting any, eq, prefix or regex", kind)
+		}
+	}
+	return nil, nil
+}
+
+func (outcomeDef outcomeDefinition) toOutcome(baseDir string) (*comproto.ExecOutcome, error) {
+	outcome := comproto.ExecOutcome{
+		Stdout:      outcomeDef.Stdout,
+		Stderr:      outcomeDef.Stderr,
+		ExitCode:    outcomeDef.ExitCode,
+		Passthrough: outcomeDef.Passthrough,
+	}
+	for _, output := range []struct {
+		name   string
+		file   string
+		target *string
+	}{{"stdout", outcomeDef.StdoutFile, &outcome.Stdout}, {"stderr", outcomeDef.StderrFile, &outcome.Stderr}} {
+		if output.file == "" {
+			continue
+		}
+		if *output.target != "" {
+			return nil, errors.Errorf("%s and %sFile are mutually exclusive", output.name, output.name)
+		}
+		data, err := ioutil.ReadFile(filepath.Join(baseDir, output.file))
+		if err != nil {
+			return nil, errors.Wrapf(err, "fail to read %s file %s", output.name, output.file)
+		}
+		*output.target = string(data)
+	}
+	if outcomeDef.ExitDelay != "" {
+		exitDelay, err := time.ParseDuration(outcomeDef.ExitDelay)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid exitDelay '%s'", outcomeDef.ExitDelay)
+		}
+		outcome.ExitDelay = exitDelay
+	}
+	return &outcome, nil
+}
@@ -0,0 +1,156 @@
+// Copyright 2020 The Execstub Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package execstub
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	rt "github.com/congop/execstub/internal/runtime"
+	comproto "github.com/congop/execstub/pkg/comproto"
+)
+
+func Test_loadStubDefinitionsFromYaml(t *testing.T) {
+	stubber := New(t)
+	gitCmd := rt.EnsureHasExecExt("git")
+	dockerCmd := rt.EnsureHasExecExt("docker")
+	javaCmd := rt.EnsureHasExecExt("java")
+
+	keys, err := stubber.LoadStubDefinitions("ci_tools")
+	if err != nil {
+		t.Fatalf("Error loading stub definitions: err=%v", err)
+	}
+
+	assert.Len(t, keys, 3)
+	pathSettings := comproto.SettingsDynaStubCmdDiscoveredByPath()
+	cloneOutcome := unStubbableExecCommandExecution(t, pathSettings, gitCmd, "clone", "https://github.com/congop/execstub", "/tmp/execstub")
+	assert.Equal(t, "Cloning into '/tmp/execstub'...", cloneOutcome.Stderr)
+	logOutcome := unStubbableExecCommandExecution(t, pathSettings, gitCmd, "log", "--oneline", "-n3")
+	gitLog, err := ioutil.ReadFile(filepath.Join(StubDefinitionsDir, "git_log_oneline.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, string(gitLog), logOutcome.Stdout)
+	usageOutcome := unStubbableExecCommandExecution(t, pathSettings, gitCmd)
+	assert.Equal(t, uint8(1), usageOutcome.ExitCode)
+	otherOutcome := unStubbableExecCommandExecution(t, pathSettings, gitCmd, "push")
+	assert.Equal(t, uint8(128), otherOutcome.ExitCode)
+	assert.Equal(t, "fatal: not stubbed", otherOutcome.Stderr)
+
+	dockerOutcome := unStubbableExecCommandExecution(t, pathSettings, dockerCmd, "--version")
+	assert.Equal(t, "Docker version 19.03.8, build afacb8b", dockerOutcome.Stdout)
+	dockerReqs, err := stubber.FindAllPersistedStubRequests(keys[1])
+	assert.NoError(t, err, "docker should have been stubbed in static mode")
+	assert.Equal(t, []comproto.StubRequest{{Key: keys[1], CmdName: dockerCmd, Args: []string{"--version"}}}, *dockerReqs)
+
+	javaSettings := comproto.SettingsDynaStubCmdDiscoveredByPath()
+	javaSettings.DiscoveredByHomeDirBin("EXECSTUB_TEST_JAVA_HOME", "bin")
+	javaOutcome := unStubbableExecCommandExecution(t, javaSettings, javaCmd, "-version")
+	assert.Equal(t, "openjdk version \"11.0.7\" 2020-04-14", javaOutcome.Stderr)
+}
+
+func Test_loadStubDefinitionsFromJSON(t *testing.T) {
+	stubber := New(t)
+
+	keys, err := stubber.LoadStubDefinitions("kubectl.json")
+	if err != nil {
+		t.Fatalf("Error loading stub definitions: err=%v", err)
+	}
+
+	assert.Len(t, keys, 1)
+	gotStdout, err := exec.Command("kubectl", "get", "pods", "-n", "default").Output()
+	assert.NoError(t, err)
+	assert.Equal(t, "NAME    READY   STATUS\nnginx   1/1     Running\n", string(gotStdout))
+}
+
+func Test_loadInvalidStubDefinitions(t *testing.T) {
+	defDir, err := ioutil.TempDir("", "execstub-stub-definitions-")
+	if err != nil {
+		t.Fatalf("fail to create definitions dir: %v", err)
+	}
+	defer os.RemoveAll(defDir)
+	tests := []struct {
+		name      string
+		file      string
+		content   string
+		wantInErr string
+	}{
+		{
+			name: "static mode with expectations",
+			file: "static.yaml",
+			content: `
+commands:
+  - cmd: git
+    outcome: {stdout: ok}
+  - cmd: docker
+    settings: {mode: static}
+    expectations:
+      - args: [ps]
+`,
+			wantInErr: "invalid command[1] docker",
+		},
+		{
+			name:      "unknown field",
+			file:      "unknown.yaml",
+			content:   "commands:\n  - cmd: git\n    outcom: {stdout: ok}\n",
+			wantInErr: "field outcom not found",
+		},
+		{
+			name:      "unknown matcher",
+			file:      "matcher.json",
+			content:   `{"commands": [{"cmd": "git", "expectations": [{"args": [{"suffix": ".go"}]}]}]}`,
+			wantInErr: "unsupported matcher 'suffix'",
+		},
+		{
+			name:      "missing output file",
+			file:      "output.yaml",
+			content:   "commands:\n  - cmd: git\n    outcome: {stdoutFile: missing.txt}\n",
+			wantInErr: "fail to read stdout file missing.txt",
+		},
+		{
+			name:      "args and argCount",
+			file:      "argcount.yaml",
+			content:   "commands:\n  - cmd: git\n    expectations:\n      - args: [status]\n        argCount: 1\n",
+			wantInErr: "expectation[0] sets both args and argCount",
+		},
+		{
+			name:      "duplicate cmd",
+			file:      "duplicate.yaml",
+			content:   "commands:\n  - cmd: git\n    outcome: {stdout: ok}\n  - cmd: ' git'\n    outcome: {stdout: ko}\n",
+			wantInErr: "is already defined by command[0]",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stubber := New(t)
+			pathBefore := os.Getenv("PATH")
+			defPath := filepath.Join(defDir, tt.file)
+			if err := ioutil.WriteFile(defPath, []byte(tt.content), 0600); err != nil {
+				t.Fatalf("fail to write definitions: %v", err)
+			}
+
+			keys, err := stubber.LoadStubDefinitionsAt(defPath)
+
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), tt.wantInErr)
+			}
+			assert.Nil(t, keys)
+			assert.Equal(t, pathBefore, os.Getenv("PATH"), "nothing should have been stubbed")
+		})
+	}
+}
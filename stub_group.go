@@ -0,0 +1,277 @@
+// Copyright 2020 The Execstub Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package execstub
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/congop/execstub/internal/ipc"
+	"github.com/congop/execstub/internal/rand"
+	rt "github.com/congop/execstub/internal/runtime"
+	comproto "github.com/congop/execstub/pkg/comproto"
+	"github.com/pkg/errors"
+)
+
+// GroupCmdStub specifies the stubbing of a command which is part of a stub group.
+type GroupCmdStub struct {
+	// Cmd is the command to stub.
+	Cmd string
+	// Aliases are additional names the command is installed under.
+	// Executions through an alias are served by the stub func and journaled with the command;
+	// the CmdName of the request being the alias.
+	Aliases []string
+	// StubFunc replaces the execution of the command and its aliases.
+	// Exactly one of StubFunc and StubFuncWithContext must be set.
+	StubFunc comproto.StubFunc
+	// StubFuncWithContext replaces the execution of the command and its aliases,
+	// with cancellation and error return (see ExecStubber.WhenExecDoStubFuncWithContext).
+	StubFuncWithContext comproto.StubFuncWithContext
+}
+
+// stubFuncWithContext returns the stub func of the command as StubFuncWithContext.
+func (cmdStub GroupCmdStub) stubFuncWithContext() comproto.StubFuncWithContext {
+	if cmdStub.StubFuncWithContext != nil {
+		return cmdStub.StubFuncWithContext
+	}
+	return comproto.AdaptCmdStubToStubFuncWithContext(cmdStub.StubFunc)
+}
+
+// StubGroup is the stubbing setup of several commands sharing one installation (home/bin dir)
+// and a single discovery change (i.e. one PATH entry or one home environment variable).
+type StubGroup struct {
+	key     string
+	cmdKeys map[string]string
+}
+
+// Key returns the key identifying the group.
+// Unregistering it unwinds the stubbing of all the commands of the group.
+func (group StubGroup) Key() string {
+	return group.key
+}
+
+// CmdKey returns the key of the stubbing setup of the given command or alias; "" if not part of the group.
+// The key can be used to access the request journal of the command (e.g. FindAllPersistedStubRequests).
+func (group StubGroup) CmdKey(cmd string) string {
+	return group.cmdKeys[strings.TrimSpace(cmd)]
+}
+
+type stubGroupSpec struct {
+	homeDir             string
+	cmdKeys             []string
+	resetDiscoverySetup func()
+}
+
+func (groupSpec *stubGroupSpec) cleanUp(logf ipc.Logf) {
+	logf("Cleaning up stubGroupSpec=%v", *groupSpec)
+	if nil != groupSpec.resetDiscoverySetup {
+		groupSpec.resetDiscoverySetup()
+	}
+	os.RemoveAll(groupSpec.homeDir)
+}
+
+// WhenGroupExecDoStubFuncs configures the stubbing of several commands, installing them
+// and their aliases into one home/bin dir, which is made discoverable with a single discovery change.
+// Each command keeps its own StubFunc and request journal, accessible using StubGroup.CmdKey(..).
+// A command already stubbed by this stubber is replaced.
+func (stubber *ExecStubber) WhenGroupExecDoStubFuncs(
+	settings comproto.Settings, cmdStubs ...GroupCmdStub,
+) (group *StubGroup, err error) {
+	stubber.mutex.Lock()
+	defer stubber.mutex.Unlock()
+
+	if err := assertGroupCmdStubsValid(cmdStubs); err != nil {
+		return nil, err
+	}
+
+	pid := strconv.Itoa(os.Getpid())
+	groupKey := fmt.Sprint("group_", rand.NextRandInt63AsHexStr(), "_", pid)
+	group = &StubGroup{key: groupKey, cmdKeys: make(map[string]string)}
+	for _, cmdStub := range cmdStubs {
+		stubKey := fmt.Sprint(strings.TrimSpace(cmdStub.Cmd), "_", pid)
+		if spec, ok := stubber.cmdStabStore[stubKey]; ok {
+			stubber.reporter.logf("[Warning] discarding old stub setting:%s %v", stubKey, spec)
+			stubber.unregisterNotThreadSafe(stubKey)
+		}
+	}
+
+	testExecutable := os.Args[0]
+	// must be done before the stub executables are made discoverable
+	realExecs := make(map[string]string)
+	for _, cmdStub := range cmdStubs {
+		for _, cmd := range append([]string{cmdStub.Cmd}, cmdStub.Aliases...) {
+			cmd = strings.TrimSpace(cmd)
+			realExecs[cmd] = lookupRealExec(cmd, settings)
+		}
+	}
+
+	timelineDir, err := stubber.ensureTimelineDirNotThreadSafe()
+	if err != nil {
+		return nil, err
+	}
+	homeDir, execDir, err := createStubHomeAndBinDir(groupKey, settings)
+	if err != nil {
+		return nil, err
+	}
+	groupSpec := &stubGroupSpec{homeDir: homeDir}
+	specs := make(map[string]*cmdStubbingSpec, len(cmdStubs))
+	cleanUpOnErr := func() {
+		for _, spec := range specs {
+			spec.cleanUp(stubber.reporter.logf)
+		}
+		groupSpec.cleanUp(stubber.reporter.logf)
+	}
+
+	for _, cmdStub := range cmdStubs {
+		cmd := strings.TrimSpace(cmdStub.Cmd)
+		stubKey := fmt.Sprint(cmd, "_", pid)
+		stubFunc := stubber.guardStubFunc(cmdStub.stubFuncWithContext())
+		spec, err := installGroupCmdStub(
+			cmdStub, stubFunc, stubKey, groupKey, settings, testExecutable, realExecs, homeDir, execDir, timelineDir, stubber.reporter.logf)
+		if err != nil {
+			cleanUpOnErr()
+			return nil, err
+		}
+		specs[stubKey] = spec
+		groupSpec.cmdKeys = append(groupSpec.cmdKeys, stubKey)
+		group.cmdKeys[cmd] = stubKey
+		for _, alias := range cmdStub.Aliases {
+			group.cmdKeys[strings.TrimSpace(alias)] = stubKey
+		}
+	}
+
+	groupSpec.resetDiscoverySetup = makeExecStubBeDiscoveredInsteadOfAcualExec(homeDir, settings)
+	stubber.reporter.logf(
+		"Stub group installation: \ngroupKey=%s homeDir=%s binDir=%s \nPATH=%s",
+		groupKey, homeDir, execDir, os.Getenv("PATH"))
+
+	if stubber.stubGroupStore == nil {
+		stubber.stubGroupStore = make(map[string]*stubGroupSpec)
+	}
+	stubber.stubGroupStore[groupKey] = groupSpec
+	for stubKey, spec := range specs {
+		stubber.cmdStabStore[stubKey] = spec
+		if spec.comChannel != nil {
+			stubber.respondToStubRequests(spec.comChannel)
+		}
+	}
+	return group, nil
+}
+
+func assertGroupCmdStubsValid(cmdStubs []GroupCmdStub) error {
+	if len(cmdStubs) == 0 {
+		return errors.New("a stub group requires at least one command")
+	}
+	names := make(map[string]bool)
+	for _, cmdStub := range cmdStubs {
+		if (cmdStub.StubFunc == nil) == (cmdStub.StubFuncWithContext == nil) {
+			return errors.Errorf(
+				"exactly one of StubFunc and StubFuncWithContext of group command %s must be set", cmdStub.Cmd)
+		}
+		for _, name := range append([]string{cmdStub.Cmd}, cmdStub.Aliases...) {
+			nameTrimmed := strings.TrimSpace(name)
+			if nameTrimmed == "" || isPath(nameTrimmed) {
+				return errors.Errorf(
+					"group command and alias must be a non empty name and not a path, but got:'%s'", name)
+			}
+			if names[nameTrimmed] {
+				return errors.Errorf("group command or alias %s is specified more than once", nameTrimmed)
+			}
+			names[nameTrimmed] = true
+		}
+	}
+	return nil
+}
+
+// installGroupCmdStub installs the stub executable of the command and its aliases into the bin dir of the group.
+// The aliases share the config (and so the com-channel in dynamic mode) of the command.
+func installGroupCmdStub(
+	cmdStub GroupCmdStub, stubFunc comproto.StubFuncWithContext, stubKey string, groupKey string,
+	settings comproto.Settings, testExecutable string, realExecs map[string]string,
+	homeDir string, execDir string, timelineDir string, logf ipc.Logf,
+) (*cmdStubbingSpec, error) {
+	cmd := strings.TrimSpace(cmdStub.Cmd)
+	rt.AssertFileNameEndsWithExecExt(cmd)
+	for _, alias := range cmdStub.Aliases {
+		rt.AssertFileNameEndsWithExecExt(strings.TrimSpace(alias))
+	}
+	dataDir := filepath.Join(homeDir, "data", cmd)
+	if err := os.MkdirAll(dataDir, 0770); err != nil {
+		return nil, errors.Wrapf(err, "fail to create data dir:%s", dataDir)
+	}
+	instDirStruct := &stubExecInstallationDirStructure{
+		binDir:      execDir,
+		dataDir:     dataDir,
+		execPath:    filepath.Join(execDir, cmd),
+		homeDir:     homeDir,
+		timelineDir: timelineDir,
+	}
+	cmdCfg, httpListener, err := createStubExecConfigFile(
+		stubKey, cmd, comproto.AdaptStubFuncWithContextToCmdStub(stubFunc), settings, testExecutable, realExecs[cmd], instDirStruct)
+	if err != nil {
+		return nil, err
+	}
+	if err := createStubExecFile(instDirStruct, settings.ExecType); err != nil {
+		closeHTTPListener(httpListener)
+		return nil, err
+	}
+	for _, alias := range cmdStub.Aliases {
+		alias = strings.TrimSpace(alias)
+		aliasCfg := *cmdCfg
+		aliasCfg.CmdToStub = alias
+		aliasCfg.RealExec = realExecs[alias]
+		if err := aliasCfg.CreateConfigFile(execDir); err != nil {
+			closeHTTPListener(httpListener)
+			return nil, errors.Wrapf(err, "fail to write config of alias %s to %s", alias, execDir)
+		}
+		aliasDirStruct := *instDirStruct
+		aliasDirStruct.execPath = filepath.Join(execDir, alias)
+		if err := createStubExecFile(&aliasDirStruct, settings.ExecType); err != nil {
+			closeHTTPListener(httpListener)
+			return nil, err
+		}
+	}
+
+	spec := &cmdStubbingSpec{
+		cmdStub:       stubFunc,
+		timeout:       cmdCfg.TimeoutAsDurationOrDefault(),
+		instDirStruct: instDirStruct,
+		groupKey:      groupKey,
+	}
+	if settings.InModStatic() {
+		// No need for a communication channel for static outcome
+		return spec, nil
+	}
+	comChannel, err := newComChannel(cmdCfg, httpListener, logf)
+	if err != nil {
+		return nil, err
+	}
+	spec.comChannel = comChannel
+	return spec, nil
+}
+
+func (stubber *ExecStubber) unregisterStubGroupNotThreadSafe(groupKey string, groupSpec *stubGroupSpec) {
+	delete(stubber.stubGroupStore, groupKey)
+	for _, stubKey := range groupSpec.cmdKeys {
+		// the command may have been replaced by a later stubbing setup
+		if spec, ok := stubber.cmdStabStore[stubKey]; ok && spec.groupKey == groupKey {
+			stubber.unregisterNotThreadSafe(stubKey)
+		}
+	}
+	groupSpec.cleanUp(stubber.reporter.logf)
+}
@@ -0,0 +1,191 @@
+// Copyright 2020 The Execstub Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package execstub
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	rt "github.com/congop/execstub/internal/runtime"
+	comproto "github.com/congop/execstub/pkg/comproto"
+)
+
+func Test_stubGroupSharesOneHomeBinDir(t *testing.T) {
+	stubber := NewExecStubber()
+	t.Cleanup(stubber.CleanUp)
+	settings := comproto.SettingsDynaStubCmdDiscoveredByPath()
+	settings.DiscoveredByHomeDirBin("EXECSTUB_TEST_JAVA_HOME", "bin")
+	javaCmd := rt.EnsureHasExecExt("java")
+	javacCmd := rt.EnsureHasExecExt("javac")
+	javaOutcome := comproto.ExecOutcome{Stderr: "openjdk version \"11.0.7\"", ExitCode: 0}
+	javacOutcome := comproto.ExecOutcome{Stderr: "Main.java:1: error: class expected", ExitCode: 1}
+
+	runForAllStubKinds(t, settings, "java and javac", func(t *testing.T, settings comproto.Settings) {
+		javaStubFunc, javaReqs := comproto.RecordingExecutions(comproto.AdaptOutcomeToCmdStub(&javaOutcome))
+		javacStubFunc, javacReqs := comproto.RecordingExecutions(comproto.AdaptOutcomeToCmdStub(&javacOutcome))
+		group, err := stubber.WhenGroupExecDoStubFuncs(settings,
+			GroupCmdStub{Cmd: javaCmd, StubFunc: javaStubFunc},
+			GroupCmdStub{Cmd: javacCmd, StubFunc: javacStubFunc},
+		)
+		if err != nil {
+			t.Fatalf("Error resgistering stub group: err=%#v", err)
+		}
+		defer stubber.Unregister(group.Key())
+
+		javaHome := os.Getenv("EXECSTUB_TEST_JAVA_HOME")
+		javaCmdOutcome := unStubbableExecCommandExecution(t, &settings, javaCmd, "-version")
+		javacCmdOutcome := unStubbableExecCommandExecution(t, &settings, javacCmd, "Main.java")
+
+		assert.Equal(t, javaOutcome.Stderr, javaCmdOutcome.Stderr)
+		assert.Equal(t, javaOutcome.ExitCode, javaCmdOutcome.ExitCode)
+		assert.Equal(t, javacOutcome.Stderr, javacCmdOutcome.Stderr)
+		assert.Equal(t, javacOutcome.ExitCode, javacCmdOutcome.ExitCode)
+		assert.Equal(t,
+			[]comproto.StubRequest{{Key: group.CmdKey(javaCmd), CmdName: javaCmd, Args: []string{"-version"}}},
+			requestsReceived(t, stubber, group.CmdKey(javaCmd), settings, javaReqs))
+		assert.Equal(t,
+			[]comproto.StubRequest{{Key: group.CmdKey(javacCmd), CmdName: javacCmd, Args: []string{"Main.java"}}},
+			requestsReceived(t, stubber, group.CmdKey(javacCmd), settings, javacReqs))
+
+		stubber.Unregister(group.Key())
+		assert.Equal(t, "", os.Getenv("EXECSTUB_TEST_JAVA_HOME"), "home env var should have been reset")
+		_, err = os.Stat(javaHome)
+		assert.True(t, os.IsNotExist(err), "group home dir should have been removed: %s", javaHome)
+	})
+}
+
+func Test_stubGroupWithAliases(t *testing.T) {
+	stubber := NewExecStubber()
+	t.Cleanup(stubber.CleanUp)
+	settings := comproto.SettingsDynaStubCmdDiscoveredByPath()
+	kubectlCmd := rt.EnsureHasExecExt("kubectl")
+	kubectlAlias := rt.EnsureHasExecExt("k")
+	helmCmd := rt.EnsureHasExecExt("helm")
+	kubectlOutcome := comproto.ExecOutcome{Stdout: "No resources found in default namespace.", ExitCode: 0}
+	helmOutcome := comproto.ExecOutcome{Stdout: "NAME\tNAMESPACE\tREVISION", ExitCode: 0}
+
+	runForAllStubKinds(t, settings, "kubectl and helm", func(t *testing.T, settings comproto.Settings) {
+		kubectlStubFunc, kubectlReqs := comproto.RecordingExecutions(comproto.AdaptOutcomeToCmdStub(&kubectlOutcome))
+		helmStubFunc, helmReqs := comproto.RecordingExecutions(comproto.AdaptOutcomeToCmdStub(&helmOutcome))
+		pathBefore := currentEnvPath()
+		group, err := stubber.WhenGroupExecDoStubFuncs(settings,
+			GroupCmdStub{Cmd: kubectlCmd, Aliases: []string{kubectlAlias}, StubFunc: kubectlStubFunc},
+			GroupCmdStub{Cmd: helmCmd, StubFunc: helmStubFunc},
+		)
+		if err != nil {
+			t.Fatalf("Error resgistering stub group: err=%#v", err)
+		}
+		defer stubber.Unregister(group.Key())
+
+		pathAfter := currentEnvPath()
+		assert.Equal(t, len(pathBefore.parts)+1, len(pathAfter.parts), "a single PATH entry should have been added")
+		kubectlPath, errKubectl := exec.LookPath(kubectlCmd)
+		helmPath, errHelm := exec.LookPath(helmCmd)
+		aliasPath, errAlias := exec.LookPath(kubectlAlias)
+		assert.NoError(t, errKubectl)
+		assert.NoError(t, errHelm)
+		assert.NoError(t, errAlias)
+		assert.Equal(t, filepath.Dir(kubectlPath), filepath.Dir(helmPath))
+		assert.Equal(t, filepath.Dir(kubectlPath), filepath.Dir(aliasPath))
+
+		gotKubectlStdout, err := exec.Command("kubectl", "get", "pods").Output()
+		assert.NoError(t, err)
+		gotAliasStdout, err := exec.Command("k", "get", "svc").Output()
+		assert.NoError(t, err)
+		gotHelmStdout, err := exec.Command("helm", "list").Output()
+		assert.NoError(t, err)
+
+		assert.Equal(t, kubectlOutcome.Stdout, string(gotKubectlStdout))
+		assert.Equal(t, kubectlOutcome.Stdout, string(gotAliasStdout))
+		assert.Equal(t, helmOutcome.Stdout, string(gotHelmStdout))
+		assert.Equal(t, group.CmdKey(kubectlCmd), group.CmdKey(kubectlAlias))
+		assert.Equal(t,
+			[]comproto.StubRequest{
+				{Key: group.CmdKey(kubectlCmd), CmdName: kubectlCmd, Args: []string{"get", "pods"}},
+				{Key: group.CmdKey(kubectlCmd), CmdName: kubectlAlias, Args: []string{"get", "svc"}},
+			},
+			requestsReceived(t, stubber, group.CmdKey(kubectlCmd), settings, kubectlReqs))
+		assert.Equal(t,
+			[]comproto.StubRequest{{Key: group.CmdKey(helmCmd), CmdName: helmCmd, Args: []string{"list"}}},
+			requestsReceived(t, stubber, group.CmdKey(helmCmd), settings, helmReqs))
+
+		stubber.Unregister(group.Key())
+		assert.Equal(t, pathBefore.String(), currentEnvPath().String(), "PATH should have been reset")
+	})
+}
+
+func Test_stubGroupRejectsDuplicateNames(t *testing.T) {
+	stubber := NewExecStubber()
+	t.Cleanup(stubber.CleanUp)
+	settings := comproto.SettingsDynaStubCmdDiscoveredByPath()
+	stubFunc := comproto.AdaptOutcomeToCmdStub(&comproto.ExecOutcome{})
+
+	_, err := stubber.WhenGroupExecDoStubFuncs(*settings,
+		GroupCmdStub{Cmd: rt.EnsureHasExecExt("kubectl"), Aliases: []string{rt.EnsureHasExecExt("k")}, StubFunc: stubFunc},
+		GroupCmdStub{Cmd: rt.EnsureHasExecExt("k"), StubFunc: stubFunc},
+	)
+	assert.Error(t, err)
+}
+
+func Test_stubGroupWithStubFuncWithContext(t *testing.T) {
+	stubber := NewExecStubber()
+	t.Cleanup(stubber.CleanUp)
+	settings := comproto.SettingsDynaStubCmdDiscoveredByPath()
+	helmCmd := rt.EnsureHasExecExt("helm")
+	kubectlCmd := rt.EnsureHasExecExt("kubectl")
+	helmStubFunc := func(ctx context.Context, sreq comproto.StubRequest) (*comproto.ExecOutcome, error) {
+		if len(sreq.Args) > 0 && sreq.Args[0] == "uninstall" {
+			return nil, errors.New("no release web")
+		}
+		return &comproto.ExecOutcome{Stdout: "NAME\tNAMESPACE\tREVISION"}, nil
+	}
+
+	group, err := stubber.WhenGroupExecDoStubFuncs(*settings,
+		GroupCmdStub{Cmd: helmCmd, StubFuncWithContext: helmStubFunc},
+		GroupCmdStub{Cmd: kubectlCmd, StubFunc: comproto.AdaptOutcomeToCmdStub(&comproto.ExecOutcome{})},
+	)
+	if err != nil {
+		t.Fatalf("Error resgistering stub group: err=%#v", err)
+	}
+	defer stubber.Unregister(group.Key())
+
+	gotStdout, err := exec.Command("helm", "list").Output()
+	assert.NoError(t, err)
+	assert.Equal(t, "NAME\tNAMESPACE\tREVISION", string(gotStdout))
+	got := unStubbableExecCommandExecution(t, settings, helmCmd, "uninstall", "web")
+	assert.Equal(t, uint8(255), got.ExitCode)
+	assert.Contains(t, got.Stderr, "StubFunc failed: no release web")
+}
+
+func Test_stubGroupRequiresExactlyOneStubFunc(t *testing.T) {
+	stubber := NewExecStubber()
+	t.Cleanup(stubber.CleanUp)
+	settings := comproto.SettingsDynaStubCmdDiscoveredByPath()
+	stubFunc := comproto.AdaptOutcomeToCmdStub(&comproto.ExecOutcome{})
+	stubFuncWithContext := comproto.AdaptCmdStubToStubFuncWithContext(stubFunc)
+
+	_, err := stubber.WhenGroupExecDoStubFuncs(*settings, GroupCmdStub{Cmd: rt.EnsureHasExecExt("helm")})
+	assert.Error(t, err)
+	_, err = stubber.WhenGroupExecDoStubFuncs(*settings,
+		GroupCmdStub{Cmd: rt.EnsureHasExecExt("helm"), StubFunc: stubFunc, StubFuncWithContext: stubFuncWithContext},
+	)
+	assert.Error(t, err)
+}
@@ -15,15 +15,18 @@
 package execstub
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"math"
+	"net"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/congop/execstub/internal/assets"
 	"github.com/congop/execstub/internal/fifo"
@@ -35,16 +38,31 @@ import (
 )
 
 type cmdStubbingSpec struct {
-	cmdStub             func(sreq comproto.StubRequest) *comproto.ExecOutcome
+	cmdStub             comproto.StubFuncWithContext
 	instDirStruct       *stubExecInstallationDirStructure
 	comChannel          *ipc.StubbingComChannel
 	resetDiscoverySetup func()
+	// timeout is the time the stub executable waits for its outcome in dynamic mode,
+	// after which the context of the execution is cancelled
+	timeout time.Duration
+	// groupKey is the key of the stub group the spec belongs to; "" if none.
+	// The installation dir and the discovery setup are then owned by the group.
+	groupKey string
+	// calls is the number of executions requested so far, guarded by the stubber mutex
+	calls int
 }
 
 // ExecStubber provides a mechanism to stub command executions.
 type ExecStubber struct {
-	cmdStabStore map[string]*cmdStubbingSpec
-	mutex        sync.Mutex
+	cmdStabStore   map[string]*cmdStubbingSpec
+	stubGroupStore map[string]*stubGroupSpec
+	// timelineDir holds the invocation timeline shared by all stubbed commands; created on first use
+	timelineDir string
+	// reporter forwards logs and internal errors to the test the stubber is bound to; see New(..)
+	reporter *stubberReporter
+	// strictSpec is the setup of the strict mode; nil if not enabled
+	strictSpec *strictModeSpec
+	mutex      sync.Mutex
 }
 
 // CleanUp cleanup resources allocated for stubbing.
@@ -54,19 +72,49 @@ func (stubber *ExecStubber) CleanUp() {
 	defer stubber.mutex.Unlock()
 	for _, spec := range stubber.cmdStabStore {
 		// TODO donot let some panicking prevent attempts to cleanup every spec
-		spec.cleanUp()
+		spec.cleanUp(stubber.reporter.logf)
 	}
 	stubber.cmdStabStore = nil
+	for _, groupSpec := range stubber.stubGroupStore {
+		groupSpec.cleanUp(stubber.reporter.logf)
+	}
+	stubber.stubGroupStore = nil
+	if stubber.strictSpec != nil {
+		stubber.strictSpec.cleanUp(stubber.reporter)
+		stubber.strictSpec = nil
+	}
+	if stubber.timelineDir != "" {
+		os.RemoveAll(stubber.timelineDir)
+		stubber.timelineDir = ""
+	}
+	stubber.reporter.detach()
+}
+
+// ensureTimelineDirNotThreadSafe creates the directory of the invocation timeline if not already done.
+func (stubber *ExecStubber) ensureTimelineDirNotThreadSafe() (timelineDir string, err error) {
+	if stubber.timelineDir != "" {
+		return stubber.timelineDir, nil
+	}
+	timelineDir, err = ioutil.TempDir("", "__execstub_timeline_"+strconv.Itoa(os.Getpid())+"_")
+	if err != nil {
+		return "", errors.Wrap(err, "fail to create invocation timeline dir")
+	}
+	stubber.timelineDir = timelineDir
+	return timelineDir, nil
 }
 
-func (spec *cmdStubbingSpec) cleanUp() {
-	log.Printf("Cleaning up cmdStubbingSpec=%v", *spec)
+func (spec *cmdStubbingSpec) cleanUp(logf ipc.Logf) {
+	logf("Cleaning up cmdStubbingSpec=%v", *spec)
 	if nil != spec.resetDiscoverySetup {
 		spec.resetDiscoverySetup()
 	}
 	if spec.comChannel != nil {
 		spec.comChannel.Close()
 	}
+	if spec.groupKey != "" {
+		// the group removes the installation dir
+		return
+	}
 	os.Remove(spec.instDirStruct.homeDir)
 }
 
@@ -87,7 +135,23 @@ func (stubber *ExecStubber) FindAllPersistedStubRequests(
 	return repo.FindAll()
 }
 
-// DeleteAllPersistedStubRequests deletes all stub-requests which have
+// FindAllPersistedExecOutcomes returns the outcomes of all executions which
+// have been delegated to the real executable (passthrough) for the stubbing
+// specification identified by the given key, in execution order.
+func (stubber *ExecStubber) FindAllPersistedExecOutcomes(
+	stubKey string,
+) (peristedOutcomes *[]comproto.ExecOutcome, err error) {
+	stubber.mutex.Lock()
+	spec, ok := stubber.cmdStabStore[stubKey]
+	stubber.mutex.Unlock()
+	if !ok {
+		return &[]comproto.ExecOutcome{}, nil
+	}
+	repo := comproto.NewStubRequestDirRepo(spec.instDirStruct.dataDir)
+	return repo.FindAllOutcomes()
+}
+
+// DeleteAllPersistedStubRequests deletes all stub-requests (and passthrough outcomes) which have
 // been persisted into the data basis belonging to the stubbing specification
 // identified by the given key.
 func (stubber *ExecStubber) DeleteAllPersistedStubRequests(
@@ -117,6 +181,20 @@ func NewExecStubber() *ExecStubber {
 func (stubber *ExecStubber) WhenExecDoStubFunc(
 	cmdStub comproto.StubFunc, cmdToStub string,
 	settings comproto.Settings,
+) (key string, err error) {
+	return stubber.WhenExecDoStubFuncWithContext(
+		comproto.AdaptCmdStubToStubFuncWithContext(cmdStub), cmdToStub, settings)
+}
+
+// WhenExecDoStubFuncWithContext configures stubbing so that the execution of the command
+// is replaced by the call of StubFuncWithContext.
+// In dynamic mode the context is cancelled once the execution cannot receive its outcome anymore,
+// because its process is gone or the timeout of the settings has passed.
+// In static mode the StubFuncWithContext is called once with a background context.
+// The defaults of WhenExecDoStubFunc(..) apply.
+func (stubber *ExecStubber) WhenExecDoStubFuncWithContext(
+	cmdStub comproto.StubFuncWithContext, cmdToStub string,
+	settings comproto.Settings,
 ) (key string, err error) {
 	stubber.mutex.Lock()
 	defer stubber.mutex.Unlock()
@@ -143,7 +221,7 @@ func (stubber *ExecStubber) WhenExecDoStubFunc(
 	// one stubbing configuration for a given command per test process
 	// So we can just replace the stored cmdStub if already in place
 	if spec, ok := stubber.cmdStabStore[stubKey]; ok {
-		log.Printf("[Warning] discarding old stub setting:%s %v", stubKey, spec)
+		stubber.reporter.logf("[Warning] discarding old stub setting:%s %v", stubKey, spec)
 		stubber.unregisterNotThreadSafe(stubKey)
 	}
 
@@ -157,8 +235,14 @@ func (stubber *ExecStubber) WhenExecDoStubFunc(
 		return "", err
 	}
 
-	cmdCfg, instDirStruct, resetDiscoverySetup, err :=
-		setupStubbingExecutable(cmdStub, cmdToStubStrimmed, stubKey, settings)
+	timelineDir, err := stubber.ensureTimelineDirNotThreadSafe()
+	if err != nil {
+		return "", err
+	}
+	cmdStub = stubber.guardStubFunc(cmdStub)
+	cmdCfg, httpListener, instDirStruct, resetDiscoverySetup, err :=
+		setupStubbingExecutable(comproto.AdaptStubFuncWithContextToCmdStub(cmdStub),
+			cmdToStubStrimmed, stubKey, settings, timelineDir)
 	if err != nil {
 		if nil != resetDiscoverySetup {
 			resetDiscoverySetup()
@@ -168,8 +252,12 @@ func (stubber *ExecStubber) WhenExecDoStubFunc(
 		// should eventually be clean up by "go-test mechanism"
 		return "", err
 	}
+	stubber.reporter.logf(
+		"Stubbing executable installation structure: \nstub instExecStrubf=%#v \nPATH=%s",
+		instDirStruct, os.Getenv("PATH"))
 	spec := cmdStubbingSpec{
 		cmdStub:             cmdStub,
+		timeout:             cmdCfg.TimeoutAsDurationOrDefault(),
 		instDirStruct:       instDirStruct,
 		resetDiscoverySetup: resetDiscoverySetup,
 	}
@@ -179,47 +267,52 @@ func (stubber *ExecStubber) WhenExecDoStubFunc(
 		return stubKey, nil
 	}
 	// for dynamic mode
-	comChannel, err := ipc.NewStubbingComChannel(cmdCfg.PipeStubber, cmdCfg.PipeTestHelperProcess)
+	comChannel, err := newComChannel(cmdCfg, httpListener, stubber.reporter.logf)
 	if err != nil {
-		spec.cleanUp()
+		spec.cleanUp(stubber.reporter.logf)
 		return "", err
 	}
 
 	spec.comChannel = comChannel
+	stubber.respondToStubRequests(comChannel)
 
+	return stubKey, nil
+}
+
+// respondToStubRequests serves the stub requests received through the com channel
+// until the channel is closed.
+func (stubber *ExecStubber) respondToStubRequests(comChannel *ipc.StubbingComChannel) {
 	//  bulkheading by using request/response per exec,
 	//  therefore not using 2 central channels for all stubbing
 	//  lookup by key of spec is therefore not need to get the comChannels
 	go func() {
 		for {
-			req, ok := <-comChannel.StubRequestChan
+			invocation, ok := <-comChannel.StubRequestChan
 			if !ok {
-				log.Println("Stop responding to stub requets from StubRequestChan; req=", req)
-				return
-			}
-			log.Printf("Stubbing requested:%#v", req)
-			if req.IsRequestingStop() {
+				stubber.reporter.logf("Stop responding to stub requets from StubRequestChan; invocation=%v", invocation)
 				return
 			}
+			req := invocation.Request
+			stubber.reporter.logf("Stubbing requested:%#v", req)
 			// Not using closure cmdStub because store cmdToStub can be removed
 			// Using closure spec directly cannot detect the removal.
 			//	- therefore using <central locking> through doStub(..)
-			resp := stubber.doStub(*req)
+			ctx, cancel := comChannel.InvocationContext(context.Background(), invocation.ID)
+			resp := stubber.doStub(ctx, *req, invocation.Received)
+			cancel()
 			if resp.InternalErrTxt != "" {
 				resp.ExitCode = math.MaxUint8
 			}
 
 			// comChannel.ExecResponseChan <- resp
-			errs := comChannel.EnqueueRespnse(resp)
+			errs := comChannel.EnqueueRespnse(invocation.ID, resp)
 			if errs != nil {
-				log.Printf("Error enqueuing response resp=%#v req:%#v err=%v", resp, req, err)
+				stubber.reporter.logf("Error enqueuing response resp=%#v req:%#v err=%v", resp, req, errs)
 			}
-			log.Printf("Stubbing ExecOutcome queued resp=%#v req:%#v", resp, req)
+			stubber.reporter.logf("Stubbing ExecOutcome queued resp=%#v req:%#v", resp, req)
 		}
 
 	}()
-
-	return stubKey, nil
 }
 
 func isPath(testee string) bool {
@@ -235,7 +328,11 @@ func (stubber *ExecStubber) Unregister(key string) {
 }
 
 func (stubber *ExecStubber) unregisterNotThreadSafe(key string) {
-	log.Println("Unregistering key=", key)
+	stubber.reporter.logf("Unregistering key=%s", key)
+	if groupSpec, ok := stubber.stubGroupStore[key]; ok {
+		stubber.unregisterStubGroupNotThreadSafe(key, groupSpec)
+		return
+	}
 	spec, ok := stubber.cmdStabStore[key]
 	if ok {
 		delete(stubber.cmdStabStore, key)
@@ -245,7 +342,7 @@ func (stubber *ExecStubber) unregisterNotThreadSafe(key string) {
 		return
 	}
 
-	spec.cleanUp()
+	spec.cleanUp(stubber.reporter.logf)
 }
 
 func (stubber *ExecStubber) getStubbedExecKeys() []string {
@@ -258,18 +355,32 @@ func (stubber *ExecStubber) getStubbedExecKeys() []string {
 	return keys
 }
 
-func (stubber *ExecStubber) doStub(sreq comproto.StubRequest) *comproto.ExecOutcome {
+// doStub produces the outcome of the request received at the given time,
+// from which on its stub executable waits for the outcome.
+func (stubber *ExecStubber) doStub(
+	ctx context.Context, sreq comproto.StubRequest, received time.Time,
+) *comproto.ExecOutcome {
 
 	stubber.mutex.Lock()
 	spec, ok := stubber.cmdStabStore[sreq.Key]
-	var cmdStub comproto.StubFunc
+	var cmdStub comproto.StubFuncWithContext
+	var timeout time.Duration
+	call := 0
 	if spec != nil {
 		cmdStub = spec.cmdStub
+		timeout = spec.timeout
+		spec.calls++
+		call = spec.calls
 	}
+	timelineDir := stubber.timelineDir
 	stubber.mutex.Unlock()
 
+	if _, err := comproto.NewInvocationTimeline(timelineDir).Append(sreq); err != nil {
+		stubber.reporter.logf("fail to record request into invocation timeline: req=%#v err=%v", sreq, err)
+	}
+
 	if !ok {
-		return &comproto.ExecOutcome{
+		outcome := &comproto.ExecOutcome{
 			Key:      sreq.Key,
 			ExitCode: math.MaxUint8,
 			InternalErrTxt: fmt.Sprintf(
@@ -277,41 +388,59 @@ func (stubber *ExecStubber) doStub(sreq comproto.StubRequest) *comproto.ExecOutc
 					"\nrequest=%#v \navailable keys:%s",
 				sreq, stubber.getStubbedExecKeys()),
 		}
-	}
-	return cmdStub(sreq)
+		stubber.reporter.internalError(sreq, outcome.InternalErrTxt)
+		return outcome
+	}
+	// the stub executable gives up waiting for the outcome once the timeout has passed,
+	// even while the request is still queued behind other invocations
+	ctx, cancel := context.WithDeadline(ctx, received.Add(timeout))
+	defer cancel()
+	outcome, _ := cmdStub(ctx, sreq) // guarded, see guardStubFunc(..)
+	if outcome == nil || outcome.Template == nil {
+		return outcome
+	}
+	outcome = outcome.WithRenderedTemplate(sreq, call)
+	if outcome.InternalErrTxt != "" {
+		stubber.reporter.internalError(sreq, outcome.InternalErrTxt)
+	}
+	return outcome
 }
 
 func setupStubbingExecutable(
 	cmdStub comproto.StubFunc, cmdToStub string, stubKey string,
-	settings comproto.Settings,
-) (cmdConfig *comproto.CmdConfig, instDirStruct *stubExecInstallationDirStructure, resetDiscoverySetup func(), err error) {
+	settings comproto.Settings, timelineDir string,
+) (
+	cmdConfig *comproto.CmdConfig, httpListener net.Listener,
+	instDirStruct *stubExecInstallationDirStructure, resetDiscoverySetup func(), err error,
+) {
 
 	testExecutable := os.Args[0]
+	// must be done before the stub executable is made discoverable
+	realExec := lookupRealExec(cmdToStub, settings)
 
 	instDirStruct, err = createStubExecutableInstDirStucture(stubKey, cmdToStub, settings)
 	if nil != err {
-		return nil, nil, nil, err
+		return nil, nil, nil, nil, err
 	}
+	instDirStruct.timelineDir = timelineDir
 
-	cmdConfig, err = createStubExecConfigFile(
-		stubKey, cmdToStub, cmdStub, settings, testExecutable, instDirStruct)
+	cmdConfig, httpListener, err = createStubExecConfigFile(
+		stubKey, cmdToStub, cmdStub, settings, testExecutable, realExec, instDirStruct)
 	if nil != err {
 		os.RemoveAll(instDirStruct.homeDir)
-		return nil, nil, nil, err
+		return nil, nil, nil, nil, err
 	}
 
 	err = createStubExecFile(instDirStruct, settings.ExecType)
 	if nil != err {
+		closeHTTPListener(httpListener)
 		os.RemoveAll(instDirStruct.homeDir)
-		return nil, nil, nil, err
+		return nil, nil, nil, nil, err
 	}
 
 	resetDiscoverySetup = makeExecStubBeDiscoveredInsteadOfAcualExec(instDirStruct.homeDir, settings)
-	log.Printf(
-		"Stubbing executable installation structure: \nstub instExecStrubf=%#v \nPATH=%s",
-		instDirStruct, os.Getenv("PATH"))
 
-	return cmdConfig, instDirStruct, resetDiscoverySetup, nil
+	return cmdConfig, httpListener, instDirStruct, resetDiscoverySetup, nil
 
 }
 
@@ -320,12 +449,38 @@ type stubExecInstallationDirStructure struct {
 	binDir   string
 	execPath string
 	dataDir  string
+	// timelineDir is the dir of the invocation timeline shared by all the stubbed commands
+	timelineDir string
 }
 
 func createStubExecutableInstDirStucture(
 	stubKey string, cmdToStub string, settings comproto.Settings,
 ) (instDirStruct *stubExecInstallationDirStructure, err error) {
 	rt.AssertFileNameEndsWithExecExt(cmdToStub)
+	homeDir, execDir, err := createStubHomeAndBinDir(stubKey, settings)
+	if err != nil {
+		return nil, err
+	}
+	dataDir := filepath.Join(homeDir, "data")
+	if err := os.MkdirAll(dataDir, 0770); err != nil {
+		os.RemoveAll(homeDir)
+		return nil, errors.Wrapf(err, "fail to create data dir:%s", dataDir)
+	}
+
+	dirStucture := stubExecInstallationDirStructure{
+		binDir:   execDir,
+		dataDir:  dataDir,
+		execPath: filepath.Join(execDir, cmdToStub),
+		homeDir:  homeDir,
+	}
+	return &dirStucture, nil
+
+}
+
+// createStubHomeAndBinDir creates the home dir and the bin dir the stub executables are installed into.
+func createStubHomeAndBinDir(
+	key string, settings comproto.Settings,
+) (homeDir string, execDir string, err error) {
 	// testExecutable := os.Args[0]
 	unittestExecDir := os.TempDir()
 	// unittestExecDir := filepath.Dir(testExecutable)
@@ -333,41 +488,26 @@ func createStubExecutableInstDirStucture(
 	// same directory. We therefore add a random suffix
 	// stubExecDir := filepath.Join(unittestExecDir, stubKey+"_"+ipc.NextRandInt63AsHexStr())
 	// stubExecHomeDir := stubExecDir
-	homeDir := filepath.Join(unittestExecDir, "__execstub_"+stubKey+"_"+rand.NextRandInt63AsHexStr())
-	dataDir := filepath.Join(homeDir, "data")
-	execDir := homeDir
+	homeDir = filepath.Join(unittestExecDir, "__execstub_"+key+"_"+rand.NextRandInt63AsHexStr())
+	execDir = homeDir
 	if comproto.DiscoveredByHomeBinDir == settings.DiscoveredBy {
 		if len(settings.DiscoveredByHomeDirBinData.BinDirs) > 0 {
 			binDirs := filepath.Join(settings.DiscoveredByHomeDirBinData.BinDirs...)
 			execDir = filepath.Join(homeDir, binDirs)
 		}
 	}
-	execPath := filepath.Join(execDir, cmdToStub)
 	if err := os.MkdirAll(execDir, 0770); err != nil {
-		return nil, errors.Wrapf(err, "fail to create exec dir:%s", execDir)
-	}
-
-	if err := os.MkdirAll(dataDir, 0770); err != nil {
-		os.RemoveAll(homeDir)
-		return nil, errors.Wrapf(err, "fail to create data dir:%s", dataDir)
-	}
-
-	dirStucture := stubExecInstallationDirStructure{
-		binDir:   execDir,
-		dataDir:  dataDir,
-		execPath: execPath,
-		homeDir:  homeDir,
+		return "", "", errors.Wrapf(err, "fail to create exec dir:%s", execDir)
 	}
-	return &dirStucture, nil
-
+	return homeDir, execDir, nil
 }
 
 func createStubExecConfigFile(
 	stubKey string, cmdToStub string, cmdStub comproto.StubFunc,
 	settings comproto.Settings,
-	testExecutable string,
+	testExecutable string, realExec string,
 	instDirStruct *stubExecInstallationDirStructure,
-) (*comproto.CmdConfig, error) {
+) (cmdCfg *comproto.CmdConfig, httpListener net.Listener, err error) {
 	cfg := comproto.CmdConfig{
 		CmdToStub:               cmdToStub,
 		PipeStubber:             "",
@@ -380,26 +520,98 @@ func createStubExecConfigFile(
 		TxtStdout:               "",
 		UnitTestExec:            testExecutable,
 		DataDir:                 instDirStruct.dataDir,
+		TimelineDir:             instDirStruct.timelineDir,
+		CaptureStdin:            settings.CaptureStdin,
+		CaptureDir:              settings.CaptureDir,
+		EnvFilter:               settings.EnvFilter,
+		RealExec:                realExec,
+	}
+	if settings.IsCmdDiscoveredByHomeDir() {
+		// read before the home env variable points at the stub home dir
+		cfg.RealHomeEnvKey = settings.DiscoveredByHomeDirBinData.EnvHomeKey
+		cfg.RealHome = os.Getenv(cfg.RealHomeEnvKey)
 	}
 	if settings.InModStatic() {
 		resp := cmdStub(comproto.StubRequest{Key: stubKey, CmdName: cmdToStub, Args: nil}) // static return not dependent of input
 		cfg.ExitCode = resp.ExitCode
 		cfg.TxtStderr = resp.Stderr
 		cfg.TxtStdout = resp.Stdout
+		cfg.OutputScript = resp.OutputScript
+		cfg.ExitDelay = resp.ExitDelay
+		cfg.HangUntilSignalled = resp.HangUntilSignalled
+		cfg.TerminationSignal = resp.TerminationSignal
+		cfg.Passthrough = resp.Passthrough
+		if resp.Template != nil {
+			if err := resp.Template.Validate(); err != nil {
+				return nil, nil, errors.Wrap(err, "bad outcome template")
+			}
+			tmpl := *resp.Template
+			cfg.OutcomeTemplate = &tmpl
+			if settings.IsUsingExecTypeBash() && !settings.IsUsingTestProcessHelper() {
+				// bash cannot render the template, the invocation is delegated to the exe stub executable
+				transportClient, err := createTransportClientExecFile(instDirStruct)
+				if err != nil {
+					return nil, nil, err
+				}
+				cfg.TransportClient = transportClient
+			}
+		}
 		if resp.InternalErrTxt != "" {
 			cfg.TxtStderr += resp.InternalErrTxt
 			cfg.ExitCode = 255
 		}
+	} else if settings.IsUsingTransportUnixSocket() || settings.IsUsingTransportHTTP() {
+		if settings.IsUsingTransportUnixSocket() {
+			socketPath, err := ipc.NewSocketPathForIpc(instDirStruct.homeDir)
+			if err != nil {
+				return nil, nil, err
+			}
+			cfg.SocketPath = socketPath
+		} else {
+			listener, token, err := ipc.NewHTTPEndpointForIpc()
+			if err != nil {
+				return nil, nil, err
+			}
+			httpListener = listener
+			cfg.HTTPAddress = listener.Addr().String()
+			cfg.HTTPToken = token
+		}
+		if settings.IsUsingExecTypeBash() {
+			transportClient, err := createTransportClientExecFile(instDirStruct)
+			if err != nil {
+				closeHTTPListener(httpListener)
+				return nil, nil, err
+			}
+			cfg.TransportClient = transportClient
+		}
 	} else {
 		stubberPipePath, testProcessHelperPipePath := fifo.NewFifoNamesForIpc(instDirStruct.execPath)
 		cfg.PipeStubber = stubberPipePath
 		cfg.PipeTestHelperProcess = testProcessHelperPipePath
 	}
-	err := cfg.CreateConfigFile(instDirStruct.binDir)
-	if err != nil {
-		return nil, errors.Wrapf(err, "fail to write config to %s", instDirStruct.binDir)
+	if err := cfg.CreateConfigFile(instDirStruct.binDir); err != nil {
+		closeHTTPListener(httpListener)
+		return nil, nil, errors.Wrapf(err, "fail to write config to %s", instDirStruct.binDir)
+	}
+	return &cfg, httpListener, nil
+}
+
+// lookupRealExec returns the path of the executable which is discovered for
+// the command given the current environment; "" if none is found.
+func lookupRealExec(cmdToStub string, settings comproto.Settings) string {
+	if settings.IsCmdDiscoveredByHomeDir() {
+		homeDir := os.Getenv(settings.DiscoveredByHomeDirBinData.EnvHomeKey)
+		if homeDir == "" {
+			return ""
+		}
+		pathElems := append([]string{homeDir}, settings.DiscoveredByHomeDirBinData.BinDirs...)
+		realExec := filepath.Join(append(pathElems, cmdToStub)...)
+		if info, err := os.Stat(realExec); err != nil || info.IsDir() {
+			return ""
+		}
+		return realExec
 	}
-	return &cfg, nil
+	return lookPathIn(cmdToStub, toolsEnvPath())
 }
 
 func makeExecStubBeDiscoveredInsteadOfAcualExec(
@@ -467,6 +679,9 @@ func createStubExecFile(
 		return errors.Wrapf(err,
 			"fail to get the exec stub from: %T", execStubProvider)
 	}
+	if comproto.ExecTypeBash == execType {
+		execStubBytes = withAbsoluteBashShebang(execStubBytes)
+	}
 	err = ioutil.WriteFile(stubCommandPath, execStubBytes, 0770) //nolint:gosec
 	if nil != err {
 		return errors.Wrapf(err,
@@ -476,6 +691,60 @@ func createStubExecFile(
 	return nil
 }
 
+// createTransportClientExecFile installs the exe stub executable as the transport client of the bash
+// stub executable into the data dir, which is not discoverable through PATH.
+func createTransportClientExecFile(
+	instExecInstStruct *stubExecInstallationDirStructure,
+) (transportClient string, err error) {
+	transportClient = filepath.Join(instExecInstStruct.dataDir, comproto.TransportClientExecName)
+	execStubBytes, err := assets.ExecStubExe()
+	if err != nil {
+		return "", errors.Wrap(err, "fail to get the exec stub used as transport client")
+	}
+	err = ioutil.WriteFile(transportClient, execStubBytes, 0770) //nolint:gosec
+	if nil != err {
+		return "", errors.Wrapf(err, "fail to write transport client: %s", transportClient)
+	}
+	return transportClient, nil
+}
+
+// newComChannel creates the com channel of the transport configured by the config.
+// httpListener is the listener bound for the http address of the config; nil if not using http.
+func newComChannel(
+	cmdCfg *comproto.CmdConfig, httpListener net.Listener, logf ipc.Logf,
+) (*ipc.StubbingComChannel, error) {
+	if cmdCfg.SocketPath != "" {
+		return ipc.NewStubbingSocketComChannel(cmdCfg.SocketPath, cmdCfg.TimeoutAsDurationOrDefault(), logf)
+	}
+	if cmdCfg.HTTPAddress != "" {
+		comChannel, err := ipc.NewStubbingHTTPComChannel(httpListener, cmdCfg.HTTPToken, logf)
+		if err != nil {
+			closeHTTPListener(httpListener)
+		}
+		return comChannel, err
+	}
+	return ipc.NewStubbingComChannel(cmdCfg.PipeStubber, cmdCfg.PipeTestHelperProcess, logf)
+}
+
+// closeHTTPListener releases the listener bound for the http address of a config,
+// which has not been taken over by a com channel.
+func closeHTTPListener(httpListener net.Listener) {
+	if httpListener != nil {
+		httpListener.Close()
+	}
+}
+
+// withAbsoluteBashShebang makes the bash script run by the bash found in the tools PATH,
+// so that it does not depend on PATH at execution time, which only leads to stubs in strict mode.
+func withAbsoluteBashShebang(script []byte) []byte {
+	const envShebang = "#!/usr/bin/env bash"
+	bashPath := lookPathIn("bash", toolsEnvPath())
+	if bashPath == "" || strings.ContainsAny(bashPath, " \t") || !bytes.HasPrefix(script, []byte(envShebang)) {
+		return script
+	}
+	return append([]byte("#!"+bashPath), script[len(envShebang):]...)
+}
+
 func toExecStubProvider(execType comproto.ExecType) func() (script []byte, err error) {
 	if comproto.ExecTypeBash == execType {
 		return assets.ExecStubBashScript
@@ -20,6 +20,7 @@ import (
 	"os"
 	"os/exec"
 	"reflect"
+	"strings"
 	"testing"
 
 	rt "github.com/congop/execstub/internal/runtime"
@@ -296,6 +297,102 @@ func Example_homeBinDir() {
 	// Output:
 }
 
+func Example_expectations() {
+	stubber := NewExecStubber()
+	defer stubber.CleanUp()
+	stubs := comproto.NewExpectations()
+	stubs.On("git").WithArgs("clone", comproto.AnyString(), comproto.HasPrefix("/tmp")).
+		Return(&comproto.ExecOutcome{Stderr: "Cloning into '/tmp/execstub'...", ExitCode: 0})
+	stubs.On("git").WithArgs("checkout", comproto.MatchesRegex(`^v\d+\.\d+\.\d+$`)).
+		Return(&comproto.ExecOutcome{Stderr: "HEAD is now at 1a2b3c4", ExitCode: 0})
+	_, err := stubber.WhenExecDoStubFunc(stubs.StubFunc, rt.EnsureHasExecExt("git"), comproto.Settings{})
+	ifNotEqPanic(nil, err, "fail to setup stub")
+
+	gotStderr, err := exec.Command("git", "clone", "https://github.com/congop/execstub", "/tmp/execstub").CombinedOutput()
+	ifNotEqPanic(nil, err, "clone into /tmp should have been expected")
+	ifNotEqPanic("Cloning into '/tmp/execstub'...", string(gotStderr), "unexpected clone stderr")
+
+	gotStderr, err = exec.Command("git", "checkout", "master").CombinedOutput()
+	ifNotEqPanic(true, err != nil, "checkout of a branch should not have been expected")
+	// the closest expectations are listed
+	for _, line := range strings.Split(string(gotStderr), "\n")[1:3] {
+		fmt.Println(strings.TrimSpace(line))
+	}
+
+	// Output:
+	// closest expectations:
+	// - On("git").WithArgs("checkout", MatchesRegex("^v\\d+\\.\\d+\\.\\d+$")): arg[1] "master" does not match MatchesRegex("^v\\d+\\.\\d+\\.\\d+$")
+}
+
+func Example_subcommandRouter() {
+	stubber := NewExecStubber()
+	defer stubber.CleanUp()
+	router := comproto.NewSubcommandRouter().
+		WithGlobalFlags("--debug").
+		WithGlobalValueFlags("-H", "--host").
+		RouteOutcomes("image ls", []*comproto.ExecOutcome{
+			{Stdout: "nginx", ExitCode: 0},
+			{Stdout: "nginx redis", ExitCode: 0},
+		}, true).
+		Route("image rm", comproto.AdaptOutcomeToCmdStub(&comproto.ExecOutcome{ExitCode: 1})).
+		Default(comproto.AdaptOutcomeToCmdStub(&comproto.ExecOutcome{Stdout: "Docker version 19.03.8"}))
+	_, err := stubber.WhenExecDoStubFunc(router.StubFunc, rt.EnsureHasExecExt("docker"), comproto.Settings{})
+	ifNotEqPanic(nil, err, "fail to setup stub")
+
+	for _, args := range [][]string{
+		{"image", "ls"},
+		{"--debug", "-H", "tcp://docker:2375", "image", "ls", "--all"},
+		{"--version"},
+	} {
+		gotStdout, err := exec.Command("docker", args...).Output()
+		ifNotEqPanic(nil, err, "docker execution should have succeeded")
+		fmt.Println(string(gotStdout))
+	}
+	err = exec.Command("docker", "image", "rm", "nginx").Run()
+	ifNotEqPanic(true, err != nil, "image rm should have failed")
+
+	// Output:
+	// nginx
+	// nginx redis
+	// Docker version 19.03.8
+}
+
+func Example_scenario() {
+	stubber := NewExecStubber()
+	defer stubber.CleanUp()
+	scenario := comproto.NewScenario("container")
+	scenario.WhenIn(comproto.ScenarioStarted, comproto.CallTo("docker").WithArgs("ps", "-q")).
+		Return(&comproto.ExecOutcome{Stdout: ""})
+	scenario.WhenIn(comproto.ScenarioStarted, comproto.CallTo("docker").WithArgs("run", "-d", "nginx")).
+		Return(&comproto.ExecOutcome{Stdout: "4c01db0b339c"}).
+		MoveTo("Running")
+	scenario.WhenIn("Running", comproto.CallTo("docker").WithArgs("ps", "-q")).
+		Return(&comproto.ExecOutcome{Stdout: "4c01db0b339c"})
+	scenario.WhenIn("Running", comproto.CallTo("docker").WithArgs("rm", "-f", "4c01db0b339c")).
+		MoveTo(comproto.ScenarioStarted)
+	_, err := stubber.WhenExecDoStubFunc(scenario.StubFunc, rt.EnsureHasExecExt("docker"), comproto.Settings{})
+	ifNotEqPanic(nil, err, "fail to setup stub")
+
+	for _, args := range [][]string{
+		{"ps", "-q"},
+		{"run", "-d", "nginx"},
+		{"ps", "-q"},
+		{"rm", "-f", "4c01db0b339c"},
+		{"ps", "-q"},
+	} {
+		gotStdout, err := exec.Command("docker", args...).Output()
+		ifNotEqPanic(nil, err, "docker execution should have succeeded")
+		fmt.Printf("docker %s: %q state=%s\n", strings.Join(args, " "), gotStdout, scenario.State())
+	}
+
+	// Output:
+	// docker ps -q: "" state=Started
+	// docker run -d nginx: "4c01db0b339c" state=Running
+	// docker ps -q: "4c01db0b339c" state=Running
+	// docker rm -f 4c01db0b339c: "" state=Started
+	// docker ps -q: "" state=Started
+}
+
 func ifNotEqPanic(want interface{}, got interface{}, mesg string) {
 
 	if reflect.DeepEqual(want, got) {
@@ -15,12 +15,20 @@
 package execstub
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
 	"math"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
@@ -307,3 +315,891 @@ func Test_NoReEntrantLockingSoThatStubberCanBeReused(t *testing.T) {
 		})
 	}
 }
+
+// runForAllStubKinds runs the given test for every combination of exec type,
+// stubbing mode and test helper process usage.
+func runForAllStubKinds(
+	t *testing.T, settings *comproto.Settings, name string,
+	test func(t *testing.T, settings comproto.Settings),
+) {
+	setModeFuncs := []func(){
+		func() {
+			settings.ModeStatic()
+			settings.WithTestProcessHelper("TestHelperProcessStatic")
+		},
+		func() {
+			settings.ModeStatic()
+			settings.WithoutTestProcessHelper()
+		},
+		func() {
+			settings.ModeDanymic()
+			settings.WithTestProcessHelper("TestHelperProcess")
+		},
+		func() {
+			settings.ModeDanymic()
+			settings.WithoutTestProcessHelper()
+		},
+	}
+	for _, setExecTypeFunc := range []func(){settings.ExecTypeBash, settings.ExecTypeExe} {
+		setExecTypeFunc()
+		if settings.IsUsingExecTypeBash() && rt.IsWindows() {
+			continue
+		}
+		for _, setModeFunc := range setModeFuncs {
+			setModeFunc()
+			settingsCopy := *settings
+			t.Run(name+testNamePart(settingsCopy), func(t *testing.T) {
+				test(t, settingsCopy)
+			})
+		}
+	}
+}
+
+// requestsReceived returns the requests received by the stubber
+// according to the stubbing mode.
+func requestsReceived(
+	t *testing.T, stubber *ExecStubber, key string,
+	settings comproto.Settings, dynaReqs *[]comproto.StubRequest,
+) []comproto.StubRequest {
+	if settings.InModStatic() {
+		gotReqs, err := stubber.FindAllPersistedStubRequests(key)
+		assert.NoError(t, err, "fail to find all")
+		return *gotReqs
+	}
+	return *dynaReqs
+}
+
+func Test_stdinCaptured(t *testing.T) {
+	stubber := NewExecStubber()
+	t.Cleanup(stubber.CleanUp)
+	settings := comproto.SettingsDynaStubCmdDiscoveredByPath()
+	settings.WithStdinCapture()
+	cmdToStub := rt.EnsureHasExecExt("kubectl")
+	stdin := "apiVersion: v1\nkind: Pod\nmetadata:\n  name: p1\n"
+	outcome := comproto.ExecOutcome{Stdout: "pod/p1 created", ExitCode: 0}
+
+	runForAllStubKinds(t, settings, "stdin captured", func(t *testing.T, settings comproto.Settings) {
+		stubFunc, reqs := comproto.RecordingExecutions(
+			comproto.AdaptOutcomeToCmdStub(&outcome))
+		key, err := stubber.WhenExecDoStubFunc(stubFunc, cmdToStub, settings)
+		if err != nil {
+			t.Fatalf("Error resgistering cmd-stub: err=%#v key=%s", err, key)
+		}
+		defer stubber.Unregister(key)
+
+		cmd := exec.Command("kubectl", "apply", "-f", "-")
+		cmd.Stdin = strings.NewReader(stdin)
+		gotStdout, err := cmd.Output()
+
+		assert.NoError(t, err)
+		assert.Equal(t, outcome.Stdout, string(gotStdout))
+		wantReqs := []comproto.StubRequest{{
+			Key: key, CmdName: cmdToStub,
+			Args: []string{"apply", "-f", "-"}, Stdin: stdin,
+		}}
+		assert.Equal(t, wantReqs, requestsReceived(t, stubber, key, settings, reqs))
+	})
+}
+
+func Test_dirAndEnvCaptured(t *testing.T) {
+	stubber := NewExecStubber()
+	t.Cleanup(stubber.CleanUp)
+	settings := comproto.SettingsDynaStubCmdDiscoveredByPath()
+	settings.WithDirCapture()
+	settings.WithEnvCapture("GIT_*", "EXECSTUB_TEST_EMPTY")
+	settings.WithEnvCaptureDenied("GIT_TRACE*")
+	cmdToStub := rt.EnsureHasExecExt("git")
+	outcome := comproto.ExecOutcome{Stdout: "Already up to date.", ExitCode: 0}
+	repoDir, err := ioutil.TempDir("", "execstub-repo-dir-*")
+	if err != nil {
+		t.Fatalf("fail to create repo dir: %v", err)
+	}
+	defer os.RemoveAll(repoDir)
+
+	runForAllStubKinds(t, settings, "dir and env captured", func(t *testing.T, settings comproto.Settings) {
+		stubFunc, reqs := comproto.RecordingExecutions(
+			comproto.AdaptOutcomeToCmdStub(&outcome))
+		key, err := stubber.WhenExecDoStubFunc(stubFunc, cmdToStub, settings)
+		if err != nil {
+			t.Fatalf("Error resgistering cmd-stub: err=%#v key=%s", err, key)
+		}
+		defer stubber.Unregister(key)
+
+		cmd := exec.Command("git", "pull")
+		cmd.Dir = repoDir
+		cmd.Env = append(make([]string, 0, 5),
+			"PATH="+os.Getenv("PATH"),
+			"GIT_SSH_COMMAND=ssh -i /tmp/id_rsa -o 'StrictHostKeyChecking no'",
+			"GIT_TRACE=1",
+			"EXECSTUB_TEST_EMPTY=",
+			"EXECSTUB_TEST_IGNORED=ignored",
+		)
+		gotStdout, err := cmd.Output()
+
+		assert.NoError(t, err)
+		assert.Equal(t, outcome.Stdout, string(gotStdout))
+		wantReqs := []comproto.StubRequest{{
+			Key: key, CmdName: cmdToStub, Args: []string{"pull"},
+			Dir: repoDir,
+			Env: []string{
+				"EXECSTUB_TEST_EMPTY=",
+				"GIT_SSH_COMMAND=ssh -i /tmp/id_rsa -o 'StrictHostKeyChecking no'",
+			},
+		}}
+		gotReqs := requestsReceived(t, stubber, key, settings, reqs)
+		assert.Equal(t, wantReqs, gotReqs)
+		if len(gotReqs) == 1 {
+			sshCmd, found := gotReqs[0].LookupEnv("GIT_SSH_COMMAND")
+			assert.True(t, found, "GIT_SSH_COMMAND should have been captured")
+			assert.Equal(t, "ssh -i /tmp/id_rsa -o 'StrictHostKeyChecking no'", sshCmd)
+		}
+	})
+}
+
+func Test_binaryOutputIsByteExact(t *testing.T) {
+	stubber := NewExecStubber()
+	t.Cleanup(stubber.CleanUp)
+	settings := comproto.SettingsDynaStubCmdDiscoveredByPath()
+	cmdToStub := rt.EnsureHasExecExt("tar")
+	allBytes := make([]byte, 0, 256)
+	for i := 0; i < 256; i++ {
+		allBytes = append(allBytes, byte(i))
+	}
+	outcome := comproto.ExecOutcome{
+		Stdout:   "\x00ustar\x00" + string(allBytes) + "\x00\x00\n\n",
+		Stderr:   "\xfe\xff\x00 warning\n\n",
+		ExitCode: 0,
+	}
+
+	runForAllStubKinds(t, settings, "binary output", func(t *testing.T, settings comproto.Settings) {
+		key, err := stubber.WhenExecDoStubFunc(
+			comproto.AdaptOutcomeToCmdStub(&outcome), cmdToStub, settings)
+		if err != nil {
+			t.Fatalf("Error resgistering cmd-stub: err=%#v key=%s", err, key)
+		}
+		defer stubber.Unregister(key)
+
+		cmd := exec.Command("tar", "-c", ".")
+		var bufStdout, bufStderr bytes.Buffer
+		cmd.Stdout = &bufStdout
+		cmd.Stderr = &bufStderr
+		err = cmd.Run()
+
+		assert.NoError(t, err)
+		assert.Equal(t, []byte(outcome.Stdout), bufStdout.Bytes())
+		assert.Equal(t, []byte(outcome.Stderr), bufStderr.Bytes())
+	})
+}
+
+func Test_outputScriptIsStreamedInOrder(t *testing.T) {
+	stubber := NewExecStubber()
+	t.Cleanup(stubber.CleanUp)
+	settings := comproto.SettingsDynaStubCmdDiscoveredByPath()
+	cmdToStub := rt.EnsureHasExecExt("kubectl")
+	delay := 300 * time.Millisecond
+	outcome := comproto.ExecOutcome{
+		OutputScript: []comproto.OutputChunk{
+			comproto.StdoutChunk(0, "line1\n"),
+			comproto.StderrChunk(0, "warn\n"),
+			comproto.StdoutChunk(delay, "line2\n"),
+		},
+		ExitCode: 0,
+	}
+
+	runForAllStubKinds(t, settings, "output script", func(t *testing.T, settings comproto.Settings) {
+		key, err := stubber.WhenExecDoStubFunc(
+			comproto.AdaptOutcomeToCmdStub(&outcome), cmdToStub, settings)
+		if err != nil {
+			t.Fatalf("Error resgistering cmd-stub: err=%#v key=%s", err, key)
+		}
+		defer stubber.Unregister(key)
+
+		// stdout and stderr share the same pipe so that interleaving is observable
+		pipeReader, pipeWriter, err := os.Pipe()
+		if err != nil {
+			t.Fatalf("fail to create pipe: %v", err)
+		}
+		defer pipeReader.Close()
+		cmd := exec.Command("kubectl", "logs", "-f", "p1")
+		cmd.Stdout = pipeWriter
+		cmd.Stderr = pipeWriter
+		err = cmd.Start()
+		pipeWriter.Close()
+		if err != nil {
+			t.Fatalf("fail to start cmd: %v", err)
+		}
+
+		var lines []string
+		var arrivals []time.Time
+		scanner := bufio.NewScanner(pipeReader)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+			arrivals = append(arrivals, time.Now())
+		}
+
+		assert.NoError(t, cmd.Wait())
+		assert.Equal(t, []string{"line1", "warn", "line2"}, lines)
+		if len(arrivals) == 3 {
+			gap := arrivals[2].Sub(arrivals[1])
+			assert.True(t, gap >= delay-50*time.Millisecond,
+				"line2 should have been delayed: gap=%v delay=%v", gap, delay)
+		}
+	})
+}
+
+func Test_exitDelayIsNotCutShortByIpcTimeout(t *testing.T) {
+	stubber := NewExecStubber()
+	t.Cleanup(stubber.CleanUp)
+	settings := comproto.SettingsDynaStubCmdDiscoveredByPath()
+	settings.Timeout = 500 * time.Millisecond
+	cmdToStub := rt.EnsureHasExecExt("terraform")
+	outcome := comproto.ExecOutcome{
+		Stdout:    "Apply complete!",
+		ExitCode:  0,
+		ExitDelay: 700 * time.Millisecond,
+	}
+
+	runForAllStubKinds(t, settings, "exit delay", func(t *testing.T, settings comproto.Settings) {
+		key, err := stubber.WhenExecDoStubFunc(
+			comproto.AdaptOutcomeToCmdStub(&outcome), cmdToStub, settings)
+		if err != nil {
+			t.Fatalf("Error resgistering cmd-stub: err=%#v key=%s", err, key)
+		}
+		defer stubber.Unregister(key)
+
+		start := time.Now()
+		gotStdout, err := exec.Command("terraform", "apply").Output()
+		elapsed := time.Since(start)
+
+		assert.NoError(t, err)
+		assert.Equal(t, outcome.Stdout, string(gotStdout))
+		assert.True(t, elapsed >= outcome.ExitDelay,
+			"exit should have been delayed: elapsed=%v delay=%v", elapsed, outcome.ExitDelay)
+	})
+}
+
+func Test_hangUntilSignalledIsKilledByContext(t *testing.T) {
+	stubber := NewExecStubber()
+	t.Cleanup(stubber.CleanUp)
+	settings := comproto.SettingsDynaStubCmdDiscoveredByPath()
+	cmdToStub := rt.EnsureHasExecExt("terraform")
+	outcome := comproto.ExecOutcome{
+		Stdout:             "Acquiring state lock. This may take a few moments...",
+		ExitCode:           0,
+		HangUntilSignalled: true,
+	}
+
+	runForAllStubKinds(t, settings, "hang until signalled", func(t *testing.T, settings comproto.Settings) {
+		key, err := stubber.WhenExecDoStubFunc(
+			comproto.AdaptOutcomeToCmdStub(&outcome), cmdToStub, settings)
+		if err != nil {
+			t.Fatalf("Error resgistering cmd-stub: err=%#v key=%s", err, key)
+		}
+		defer stubber.Unregister(key)
+
+		timeout := 500 * time.Millisecond
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		start := time.Now()
+		cmd := exec.CommandContext(ctx, "terraform", "apply")
+		var bufStdout bytes.Buffer
+		cmd.Stdout = &bufStdout
+		err = cmd.Run()
+		elapsed := time.Since(start)
+
+		assert.Error(t, err, "wedged command should have been killed")
+		assert.Equal(t, context.DeadlineExceeded, ctx.Err())
+		assert.Equal(t, outcome.Stdout, bufStdout.String())
+		assert.True(t, elapsed >= timeout, "killed too early: elapsed=%v", elapsed)
+		assert.True(t, elapsed < timeout+3*time.Second,
+			"wedged command output should not outlive the kill: elapsed=%v", elapsed)
+	})
+}
+
+func Test_terminationBySignal(t *testing.T) {
+	if rt.IsWindows() {
+		t.Skip("termination by signal is not supported on windows")
+	}
+	stubber := NewExecStubber()
+	t.Cleanup(stubber.CleanUp)
+	settings := comproto.SettingsDynaStubCmdDiscoveredByPath()
+	cmdToStub := rt.EnsureHasExecExt("terraform")
+
+	for _, sig := range []syscall.Signal{syscall.SIGKILL, syscall.SIGSEGV, syscall.SIGABRT} {
+		outcome := comproto.ExecOutcome{
+			Stdout:            "Refreshing state...",
+			Stderr:            "fatal error: runtime: out of memory",
+			TerminationSignal: sig,
+		}
+		name := "terminated by " + sig.String()
+		runForAllStubKinds(t, settings, name, func(t *testing.T, settings comproto.Settings) {
+			key, err := stubber.WhenExecDoStubFunc(
+				comproto.AdaptOutcomeToCmdStub(&outcome), cmdToStub, settings)
+			if err != nil {
+				t.Fatalf("Error resgistering cmd-stub: err=%#v key=%s", err, key)
+			}
+			defer stubber.Unregister(key)
+
+			cmd := exec.Command("terraform", "plan")
+			var bufStdout, bufStderr bytes.Buffer
+			cmd.Stdout = &bufStdout
+			cmd.Stderr = &bufStderr
+			err = cmd.Run()
+
+			assert.Error(t, err)
+			assert.Equal(t, outcome.Stdout, bufStdout.String())
+			assert.Equal(t, outcome.Stderr, bufStderr.String())
+			status, ok := cmd.ProcessState.Sys().(syscall.WaitStatus)
+			if assert.True(t, ok, "should have a wait status") {
+				assert.True(t, status.Signaled(), "should have been signaled: %v", cmd.ProcessState)
+				assert.Equal(t, sig, status.Signal())
+			}
+		})
+	}
+}
+
+// givenRealExecInPath installs a script named cmd into a new directory
+// positioned first in PATH and returns a func to undo it.
+func givenRealExecInPath(t *testing.T, cmd string, script string) (reset func()) {
+	realExecDir, err := ioutil.TempDir("", "execstub-real-exec-*")
+	if err != nil {
+		t.Fatalf("fail to create real exec dir: %v", err)
+	}
+	err = ioutil.WriteFile(filepath.Join(realExecDir, cmd), []byte(script), 0770) //nolint:gosec
+	if err != nil {
+		os.RemoveAll(realExecDir)
+		t.Fatalf("fail to write real exec: %v", err)
+	}
+	oldPath := os.Getenv("PATH")
+	os.Setenv("PATH", realExecDir+string(os.PathListSeparator)+oldPath)
+	return func() {
+		os.Setenv("PATH", oldPath)
+		os.RemoveAll(realExecDir)
+	}
+}
+
+const realTerraformScript = `#!/bin/sh
+printf "terraform %s resolved=%s\n" "$*" "$(command -v terraform)"
+printf "input=%s\n" "$(cat)"
+printf "var=%s\n" "${TF_VAR_region}"
+printf "Warning: deprecated\n" >&2
+exit 3
+`
+
+func Test_passthroughDelegatesToRealExec(t *testing.T) {
+	if rt.IsWindows() {
+		t.Skip("real exec is a shell script")
+	}
+	cmdToStub := rt.EnsureHasExecExt("terraform")
+	resetPath := givenRealExecInPath(t, cmdToStub, realTerraformScript)
+	defer resetPath()
+	stubber := NewExecStubber()
+	t.Cleanup(stubber.CleanUp)
+	settings := comproto.SettingsDynaStubCmdDiscoveredByPath()
+
+	runForAllStubKinds(t, settings, "passthrough", func(t *testing.T, settings comproto.Settings) {
+		stubFunc, reqs := comproto.RecordingExecutions(comproto.AdaptPassthroughToCmdStub())
+		realExec, err := exec.LookPath(cmdToStub)
+		if err != nil {
+			t.Fatalf("real exec not found: %v", err)
+		}
+		key, err := stubber.WhenExecDoStubFunc(stubFunc, cmdToStub, settings)
+		if err != nil {
+			t.Fatalf("Error resgistering cmd-stub: err=%#v key=%s", err, key)
+		}
+		defer stubber.Unregister(key)
+
+		cmd := exec.Command("terraform", "plan", "-input=false")
+		cmd.Env = append(os.Environ(), "TF_VAR_region=eu-west-1")
+		cmd.Stdin = strings.NewReader("yes")
+		var bufStdout, bufStderr bytes.Buffer
+		cmd.Stdout = &bufStdout
+		cmd.Stderr = &bufStderr
+		_ = cmd.Run()
+
+		wantStdout := "terraform plan -input=false resolved=" + realExec + "\n" +
+			"input=yes\nvar=eu-west-1\n"
+		assert.Equal(t, 3, cmd.ProcessState.ExitCode())
+		assert.Equal(t, wantStdout, bufStdout.String())
+		assert.Equal(t, "Warning: deprecated\n", bufStderr.String())
+
+		wantReqs := []comproto.StubRequest{{
+			Key: key, CmdName: cmdToStub, Args: []string{"plan", "-input=false"},
+		}}
+		assert.Equal(t, wantReqs, requestsReceived(t, stubber, key, settings, reqs))
+		gotOutcomes, err := stubber.FindAllPersistedExecOutcomes(key)
+		assert.NoError(t, err)
+		wantOutcomes := []comproto.ExecOutcome{{
+			Key: key, Stdout: wantStdout, Stderr: "Warning: deprecated\n", ExitCode: 3,
+		}}
+		assert.Equal(t, wantOutcomes, *gotOutcomes)
+	})
+}
+
+func Test_passthroughRestoresHomeOfCmdDiscoveredByHomeDir(t *testing.T) {
+	if rt.IsWindows() {
+		t.Skip("real exec is a shell script")
+	}
+	const homeKey = "EXECSTUB_TEST_TF_HOME"
+	cmdToStub := rt.EnsureHasExecExt("terraform")
+	realHome, err := ioutil.TempDir("", "execstub-real-home-*")
+	if err != nil {
+		t.Fatalf("fail to create real home dir: %v", err)
+	}
+	defer os.RemoveAll(realHome)
+	if err := os.MkdirAll(filepath.Join(realHome, "bin"), 0770); err != nil {
+		t.Fatalf("fail to create real bin dir: %v", err)
+	}
+	realExecScript := "#!/bin/sh\nprintf \"terraform %s home=%s\\n\" \"$*\" \"${" + homeKey + "}\"\n"
+	err = ioutil.WriteFile(filepath.Join(realHome, "bin", cmdToStub), []byte(realExecScript), 0770) //nolint:gosec
+	if err != nil {
+		t.Fatalf("fail to write real exec: %v", err)
+	}
+	os.Setenv(homeKey, realHome)
+	defer os.Unsetenv(homeKey)
+	stubber := NewExecStubber()
+	t.Cleanup(stubber.CleanUp)
+	settings := comproto.SettingsDynaStubCmdDiscoveredByPath()
+	settings.DiscoveredByHomeDirBin(homeKey, "bin")
+
+	runForAllStubKinds(t, settings, "passthrough by home dir", func(t *testing.T, settings comproto.Settings) {
+		key, err := stubber.WhenExecDoStubFunc(comproto.AdaptPassthroughToCmdStub(), cmdToStub, settings)
+		if err != nil {
+			t.Fatalf("Error resgistering cmd-stub: err=%#v key=%s", err, key)
+		}
+		defer stubber.Unregister(key)
+		assert.NotEqual(t, realHome, os.Getenv(homeKey), "home should lead to the stub")
+
+		got := unStubbableExecCommandExecution(t, &settings, cmdToStub, "version")
+
+		assert.Equal(t, "terraform version home="+realHome+"\n", got.Stdout)
+		assert.Equal(t, uint8(0), got.ExitCode)
+	})
+	assert.Equal(t, realHome, os.Getenv(homeKey))
+}
+
+func Test_passthroughChosenPerRequest(t *testing.T) {
+	if rt.IsWindows() {
+		t.Skip("real exec is a shell script")
+	}
+	cmdToStub := rt.EnsureHasExecExt("terraform")
+	resetPath := givenRealExecInPath(t, cmdToStub, realTerraformScript)
+	defer resetPath()
+	stubber := NewExecStubber()
+	t.Cleanup(stubber.CleanUp)
+	settings := comproto.SettingsDynaStubCmdDiscoveredByPath()
+	settings.WithStdinCapture()
+	stubFunc := func(sreq comproto.StubRequest) *comproto.ExecOutcome {
+		if len(sreq.Args) > 0 && sreq.Args[0] == "apply" {
+			return &comproto.ExecOutcome{Stdout: "Apply complete!", ExitCode: 0}
+		}
+		return comproto.PassthroughOutcome()
+	}
+
+	runForAllStubKinds(t, settings, "passthrough per request", func(t *testing.T, settings comproto.Settings) {
+		if settings.InModStatic() {
+			t.Skip("the outcome cannot depend on the request in static mode")
+		}
+		key, err := stubber.WhenExecDoStubFunc(stubFunc, cmdToStub, settings)
+		if err != nil {
+			t.Fatalf("Error resgistering cmd-stub: err=%#v key=%s", err, key)
+		}
+		defer stubber.Unregister(key)
+
+		cmd := exec.Command("terraform", "apply")
+		cmd.Stdin = strings.NewReader("")
+		gotStdout, err := cmd.Output()
+		assert.NoError(t, err)
+		assert.Equal(t, "Apply complete!", string(gotStdout))
+
+		cmd = exec.Command("terraform", "version")
+		cmd.Stdin = strings.NewReader("captured and forwarded")
+		gotStdout, _ = cmd.Output()
+		assert.Equal(t, 3, cmd.ProcessState.ExitCode())
+		assert.Contains(t, string(gotStdout), "terraform version resolved=")
+		assert.Contains(t, string(gotStdout), "input=captured and forwarded\n")
+
+		gotOutcomes, err := stubber.FindAllPersistedExecOutcomes(key)
+		assert.NoError(t, err)
+		assert.Len(t, *gotOutcomes, 1, "only version should have been delegated")
+	})
+}
+
+func Test_concurrentExecutionsGetTheirOwnOutcome(t *testing.T) {
+	stubber := NewExecStubber()
+	t.Cleanup(stubber.CleanUp)
+	settings := comproto.SettingsDynaStubCmdDiscoveredByPath()
+	settings.WithStdinCapture()
+	cmdToStub := rt.EnsureHasExecExt("docker")
+	stubFunc := func(sreq comproto.StubRequest) *comproto.ExecOutcome {
+		return &comproto.ExecOutcome{
+			Stdout:   strings.Join(sreq.Args, " ") + ":" + sreq.Stdin,
+			ExitCode: uint8(len(sreq.Args)),
+		}
+	}
+	const execCount = 24
+
+	runForAllStubKinds(t, settings, "concurrent executions", func(t *testing.T, settings comproto.Settings) {
+		if settings.InModStatic() {
+			t.Skip("the outcome cannot depend on the request in static mode")
+		}
+		key, err := stubber.WhenExecDoStubFunc(stubFunc, cmdToStub, settings)
+		if err != nil {
+			t.Fatalf("Error resgistering cmd-stub: err=%#v key=%s", err, key)
+		}
+		defer stubber.Unregister(key)
+
+		type execResult struct {
+			wantStdout   string
+			wantExitCode int
+			gotStdout    string
+			gotExitCode  int
+		}
+		results := make(chan execResult, execCount)
+		for i := 0; i < execCount; i++ {
+			go func(i int) {
+				args := []string{"inspect", fmt.Sprintf("container%d", i)}
+				if i%2 == 0 {
+					args = append(args, "--format", "{{.State.Status}}")
+				}
+				// bigger than PIPE_BUF, so that requests would interleave if written to the same pipe
+				stdin := strings.Repeat(fmt.Sprintf("stdin of container%d\n", i), 512)
+				cmd := exec.Command("docker", args...)
+				cmd.Stdin = strings.NewReader(stdin)
+				gotStdout, _ := cmd.Output()
+				results <- execResult{
+					wantStdout:   strings.Join(args, " ") + ":" + stdin,
+					wantExitCode: len(args),
+					gotStdout:    string(gotStdout),
+					gotExitCode:  cmd.ProcessState.ExitCode(),
+				}
+			}(i)
+		}
+
+		for i := 0; i < execCount; i++ {
+			select {
+			case result := <-results:
+				assert.Equal(t, result.wantStdout, result.gotStdout)
+				assert.Equal(t, result.wantExitCode, result.gotExitCode)
+			case <-time.After(30 * time.Second):
+				t.Fatalf("timeout waiting for execution results, received %d of %d", i, execCount)
+			}
+		}
+	})
+}
+
+func Test_awaitInvocationsOfCommandsSpawnedInBackground(t *testing.T) {
+	stubber := NewExecStubber()
+	t.Cleanup(stubber.CleanUp)
+	settings := comproto.SettingsDynaStubCmdDiscoveredByPath()
+	cmdToStub := rt.EnsureHasExecExt("rsync")
+	const syncCount = 6
+
+	runForAllStubKinds(t, settings, "background", func(t *testing.T, settings comproto.Settings) {
+		if settings.InModStatic() {
+			t.Skip("the StubFunc is only called at setup in static mode")
+		}
+		recorder := comproto.NewInvocationRecorder()
+		key, err := stubber.WhenExecDoStubFunc(
+			recorder.Recording(comproto.AdaptOutcomeToCmdStub(&comproto.ExecOutcome{})), cmdToStub, settings)
+		if err != nil {
+			t.Fatalf("Error resgistering cmd-stub: err=%#v key=%s", err, key)
+		}
+		defer stubber.Unregister(key)
+		invocations, unsubscribe := recorder.Subscribe()
+		defer unsubscribe()
+
+		for i := 0; i < syncCount; i++ {
+			go func(i int) {
+				_ = exec.Command("rsync", "-a", fmt.Sprintf("src%d/", i), "dst/").Run()
+			}(i)
+		}
+
+		synced, err := recorder.AwaitInvocations(
+			syncCount, comproto.CallTo("rsync").WithArgs("-a", comproto.HasPrefix("src"), "dst/"), 30*time.Second)
+		assert.NoError(t, err)
+		assert.Len(t, synced, syncCount)
+		for i := 0; i < syncCount; i++ {
+			select {
+			case sreq := <-invocations:
+				assert.Equal(t, cmdToStub, sreq.CmdName)
+			case <-time.After(30 * time.Second):
+				t.Fatalf("timeout waiting for subscribed invocations, received %d of %d", i, syncCount)
+			}
+		}
+	})
+}
+
+func Test_templatedOutcomeRenderedFromRequest(t *testing.T) {
+	stubber := NewExecStubber()
+	t.Cleanup(stubber.CleanUp)
+	settings := comproto.SettingsDynaStubCmdDiscoveredByPath()
+	settings.WithEnvCapture("EXECSTUB_TEST_USER")
+	cmdToStub := rt.EnsureHasExecExt("git")
+	tmpl := comproto.OutcomeTemplate{
+		Stdout:   "Cloning into '{{lastArg}}' from branch {{flag \"--branch\"}} as {{env \"EXECSTUB_TEST_USER\"}}...\n",
+		Stderr:   "call #{{call}}\n",
+		ExitCode: "{{if gt call 1}}128{{else}}0{{end}}",
+	}
+	os.Setenv("EXECSTUB_TEST_USER", "gopher")
+	defer os.Unsetenv("EXECSTUB_TEST_USER")
+
+	runForAllStubKinds(t, settings, "templated outcome", func(t *testing.T, settings comproto.Settings) {
+		key, err := stubber.WhenExecDoStubFunc(comproto.AdaptOutcomeTemplateToCmdStub(tmpl), cmdToStub, settings)
+		if err != nil {
+			t.Fatalf("Error resgistering cmd-stub: err=%#v key=%s", err, key)
+		}
+		defer stubber.Unregister(key)
+
+		for call, wantExitCode := range []uint8{0, 128} {
+			got := unStubbableExecCommandExecution(t, &settings, cmdToStub, "clone", "--branch", "main", "https://example.com/app.git", "app")
+			assert.Equal(t, "Cloning into 'app' from branch main as gopher...\n", got.Stdout)
+			assert.Equal(t, fmt.Sprintf("call #%d\n", call+1), got.Stderr)
+			assert.Equal(t, wantExitCode, got.ExitCode)
+		}
+	})
+}
+
+func Test_unixSocketAndHTTPTransports(t *testing.T) {
+	stubber := NewExecStubber()
+	t.Cleanup(stubber.CleanUp)
+	settings := comproto.SettingsDynaStubCmdDiscoveredByPath()
+	settings.WithStdinCapture()
+	settings.WithEnvCapture("EXECSTUB_TEST_*")
+	cmdToStub := rt.EnsureHasExecExt("docker")
+	stubFunc, reqs := comproto.RecordingExecutions(func(sreq comproto.StubRequest) *comproto.ExecOutcome {
+		return &comproto.ExecOutcome{
+			Stdout:   strings.Join(sreq.Args, " ") + ":" + sreq.Stdin,
+			ExitCode: uint8(len(sreq.Args)),
+		}
+	})
+	const execCount = 8
+
+	for _, transport := range []comproto.Transport{comproto.TransportUnixSocket, comproto.TransportHTTP} {
+		if transport == comproto.TransportUnixSocket && rt.IsWindows() {
+			continue
+		}
+		settings.Transport = transport
+		runForAllStubKinds(t, settings, string(transport), func(t *testing.T, settings comproto.Settings) {
+			if settings.InModStatic() {
+				t.Skip("no transport is used in static mode")
+			}
+			*reqs = (*reqs)[:0]
+			key, err := stubber.WhenExecDoStubFunc(stubFunc, cmdToStub, settings)
+			if err != nil {
+				t.Fatalf("Error resgistering cmd-stub: err=%#v key=%s", err, key)
+			}
+			stubber.mutex.Lock()
+			comChannel := stubber.cmdStabStore[key].comChannel
+			stubber.mutex.Unlock()
+			assert.Empty(t, comChannel.StubberPipePath, "named pipes should not be used")
+			socketPath, httpAddress := comChannel.SocketPath, comChannel.HTTPAddress
+
+			results := make(chan error, execCount)
+			for i := 0; i < execCount; i++ {
+				go func(i int) {
+					args := []string{"logs", fmt.Sprintf("container%d", i)}[:1+i%2]
+					stdin := fmt.Sprintf("stdin of execution %d", i)
+					cmd := exec.Command("docker", args...)
+					cmd.Env = append(os.Environ(), fmt.Sprintf("EXECSTUB_TEST_EXECUTION=%d", i))
+					cmd.Stdin = strings.NewReader(stdin)
+					gotStdout, _ := cmd.Output()
+					if want := strings.Join(args, " ") + ":" + stdin; want != string(gotStdout) {
+						results <- fmt.Errorf("execution %d: want stdout %q but got %q", i, want, gotStdout)
+						return
+					}
+					if cmd.ProcessState.ExitCode() != len(args) {
+						results <- fmt.Errorf("execution %d: want exit code %d but got %d", i, len(args), cmd.ProcessState.ExitCode())
+						return
+					}
+					results <- nil
+				}(i)
+			}
+			for i := 0; i < execCount; i++ {
+				select {
+				case err := <-results:
+					assert.NoError(t, err)
+				case <-time.After(30 * time.Second):
+					t.Fatalf("timeout waiting for execution results, received %d of %d", i, execCount)
+				}
+			}
+			gotReqs := requestsReceived(t, stubber, key, settings, reqs)
+			assert.Len(t, gotReqs, execCount)
+			for _, req := range gotReqs {
+				_, found := req.LookupEnv("EXECSTUB_TEST_EXECUTION")
+				assert.True(t, found, "env should have been captured: %v", req)
+			}
+
+			stubber.Unregister(key)
+			if transport == comproto.TransportUnixSocket {
+				_, err = os.Stat(socketPath)
+				assert.True(t, os.IsNotExist(err), "socket should have been closed and removed: %v", err)
+			} else {
+				_, err = net.Dial("tcp", httpAddress)
+				assert.Error(t, err, "http server should have been closed")
+			}
+		})
+	}
+}
+
+func Test_incompatibleProtocolVersionIsReported(t *testing.T) {
+	stubber := NewExecStubber()
+	t.Cleanup(stubber.CleanUp)
+	settings := comproto.SettingsDynaStubCmdDiscoveredByPath()
+	cmdToStub := rt.EnsureHasExecExt("kubectl")
+
+	runForAllStubKinds(t, settings, "stale config", func(t *testing.T, settings comproto.Settings) {
+		key, err := stubber.WhenExecDoStubFunc(comproto.AdaptOutcomeToCmdStub(
+			&comproto.ExecOutcome{Stdout: "deployment.apps/web created\n"}), cmdToStub, settings)
+		if err != nil {
+			t.Fatalf("Error resgistering cmd-stub: err=%#v key=%s", err, key)
+		}
+		defer stubber.Unregister(key)
+		stubber.mutex.Lock()
+		cfgPath := filepath.Join(stubber.cmdStabStore[key].instDirStruct.binDir, cmdToStub+".config")
+		stubber.mutex.Unlock()
+		// as left by an execstub version speaking the first protocol version
+		cfgBytes, err := ioutil.ReadFile(cfgPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		versionLine := fmt.Sprintf("__EXECSTUBBING_PROTOCOL_VERSION=%d\n", comproto.ProtocolVersion)
+		if !bytes.Contains(cfgBytes, []byte(versionLine)) {
+			t.Fatalf("config should hold the protocol version %q:\n%s", versionLine, cfgBytes)
+		}
+		cfgBytes = bytes.Replace(cfgBytes, []byte(versionLine), []byte("__EXECSTUBBING_PROTOCOL_VERSION=1\n"), 1)
+		if err := ioutil.WriteFile(cfgPath, cfgBytes, 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		got := unStubbableExecCommandExecution(t, &settings, cmdToStub, "apply", "-f", "web.yaml")
+		assert.Equal(t, uint8(math.MaxUint8), got.ExitCode)
+		assert.Empty(t, got.Stdout)
+		assert.Contains(t, got.Stderr, "incompatible execstub version: config file ")
+		assert.Contains(t, got.Stderr,
+			fmt.Sprintf("uses protocol version 1 but version %d is expected", comproto.ProtocolVersion))
+	})
+}
+
+func Test_stubFuncWithContextIsCancelledWhenExecutionIsKilled(t *testing.T) {
+	stubber := NewExecStubber()
+	t.Cleanup(stubber.CleanUp)
+	settings := comproto.SettingsDynaStubCmdDiscoveredByPath()
+	cmdToStub := rt.EnsureHasExecExt("helm")
+
+	for _, transport := range []comproto.Transport{
+		comproto.TransportFifo, comproto.TransportUnixSocket, comproto.TransportHTTP,
+	} {
+		if transport == comproto.TransportUnixSocket && rt.IsWindows() {
+			continue
+		}
+		settings.Transport = transport
+		runForAllStubKinds(t, settings, string(transport), func(t *testing.T, settings comproto.Settings) {
+			if settings.InModStatic() {
+				t.Skip("the StubFunc is called before any execution in static mode")
+			}
+			if !settings.IsUsingExecTypeBash() && settings.IsUsingTestProcessHelper() {
+				t.Skip("killing the exe stub does not kill the test helper process it waits for")
+			}
+			started := make(chan struct{}, 1)
+			cancelled := make(chan error, 1)
+			key, err := stubber.WhenExecDoStubFuncWithContext(
+				func(ctx context.Context, sreq comproto.StubRequest) (*comproto.ExecOutcome, error) {
+					started <- struct{}{}
+					<-ctx.Done()
+					cancelled <- ctx.Err()
+					return nil, ctx.Err()
+				}, cmdToStub, settings)
+			if err != nil {
+				t.Fatalf("Error resgistering cmd-stub: err=%#v key=%s", err, key)
+			}
+			defer stubber.Unregister(key)
+
+			cmd := exec.Command("helm", "install", "web")
+			if err := cmd.Start(); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case <-started:
+			case <-time.After(10 * time.Second):
+				t.Fatal("StubFunc should have been called")
+			}
+			start := time.Now()
+			assert.NoError(t, cmd.Process.Kill())
+			_ = cmd.Wait()
+
+			select {
+			case err := <-cancelled:
+				// well before the invocation deadline
+				assert.Equal(t, context.Canceled, err)
+				assert.True(t, time.Since(start) < 5*time.Second, "cancelled too late: %v", time.Since(start))
+			case <-time.After(15 * time.Second):
+				t.Fatal("context of killed execution should have been cancelled")
+			}
+		})
+	}
+}
+
+func Test_slowStubFuncIsAwaitedUntilConfiguredTimeout(t *testing.T) {
+	stubber := NewExecStubber()
+	t.Cleanup(stubber.CleanUp)
+	settings := comproto.SettingsDynaStubCmdDiscoveredByPath()
+	settings.Timeout = 6 * time.Second
+	cmdToStub := rt.EnsureHasExecExt("helm")
+	const stubFuncDelay = 2500 * time.Millisecond
+	stubFunc := func(sreq comproto.StubRequest) *comproto.ExecOutcome {
+		time.Sleep(stubFuncDelay)
+		return &comproto.ExecOutcome{Stdout: "deployed"}
+	}
+
+	runForAllStubKinds(t, settings, "slow stub func", func(t *testing.T, settings comproto.Settings) {
+		if settings.InModStatic() {
+			t.Skip("the stub func is not called on execution in static mode")
+		}
+		key, err := stubber.WhenExecDoStubFunc(stubFunc, cmdToStub, settings)
+		if err != nil {
+			t.Fatalf("Error resgistering cmd-stub: err=%#v key=%s", err, key)
+		}
+		defer stubber.Unregister(key)
+
+		got := unStubbableExecCommandExecution(t, &settings, cmdToStub, "install")
+
+		assert.Equal(t, "deployed", got.Stdout)
+		assert.Equal(t, uint8(0), got.ExitCode, "stderr: %s", got.Stderr)
+	})
+}
+
+func Test_stubFuncWithContextIsCancelledWhenInvocationDeadlinePasses(t *testing.T) {
+	stubber := NewExecStubber()
+	t.Cleanup(stubber.CleanUp)
+	settings := comproto.SettingsDynaStubCmdDiscoveredByPath()
+	settings.Timeout = 300 * time.Millisecond
+	cmdToStub := rt.EnsureHasExecExt("helm")
+	key, err := stubber.WhenExecDoStubFuncWithContext(
+		func(ctx context.Context, sreq comproto.StubRequest) (*comproto.ExecOutcome, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}, cmdToStub, *settings)
+	if err != nil {
+		t.Fatalf("Error resgistering cmd-stub: err=%#v key=%s", err, key)
+	}
+
+	// served as received through the com channel, but without a process which could be gone
+	start := time.Now()
+	outcome := stubber.doStub(context.Background(), comproto.StubRequest{Key: key, CmdName: cmdToStub}, start)
+	elapsed := time.Since(start)
+
+	assert.True(t, elapsed >= 300*time.Millisecond, "cancelled too early: %v", elapsed)
+	assert.True(t, elapsed < 3*time.Second, "cancelled too late: %v", elapsed)
+	assert.Equal(t, uint8(math.MaxUint8), outcome.ExitCode)
+	assert.Contains(t, outcome.InternalErrTxt, context.DeadlineExceeded.Error())
+
+	// the deadline is measured from the reception, not from when a queued request is served
+	start = time.Now()
+	outcome = stubber.doStub(context.Background(), comproto.StubRequest{Key: key, CmdName: cmdToStub},
+		start.Add(-250*time.Millisecond))
+	elapsed = time.Since(start)
+
+	assert.True(t, elapsed < 250*time.Millisecond, "deadline should not restart when served: %v", elapsed)
+	assert.Contains(t, outcome.InternalErrTxt, context.DeadlineExceeded.Error())
+}
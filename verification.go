@@ -0,0 +1,166 @@
+// Copyright 2020 The Execstub Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package execstub
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	comproto "github.com/congop/execstub/pkg/comproto"
+)
+
+// Timeline returns the invocations of all commands stubbed by this stubber,
+// ordered by their sequence number.
+// It covers both modes: in dynamic mode the stubber records the received requests,
+// in static mode the stub executables record their requests themselves.
+func (stubber *ExecStubber) Timeline() ([]comproto.TimelineEntry, error) {
+	stubber.mutex.Lock()
+	timelineDir := stubber.timelineDir
+	stubber.mutex.Unlock()
+
+	entries, err := comproto.NewInvocationTimeline(timelineDir).FindAll()
+	if err != nil {
+		return nil, err
+	}
+	return *entries, nil
+}
+
+// ClearTimeline deletes all invocations recorded so far; the sequence numbering continues
+// after the deleted invocations, whose requests remain persisted in the data dirs.
+func (stubber *ExecStubber) ClearTimeline() error {
+	stubber.mutex.Lock()
+	timelineDir := stubber.timelineDir
+	stubber.mutex.Unlock()
+
+	return comproto.NewInvocationTimeline(timelineDir).DeleteAll()
+}
+
+// Invocations returns the requests of the executions of all commands stubbed by this stubber,
+// in execution order (see Timeline).
+func (stubber *ExecStubber) Invocations() ([]comproto.StubRequest, error) {
+	entries, err := stubber.Timeline()
+	if err != nil {
+		return nil, err
+	}
+	reqs := make([]comproto.StubRequest, 0, len(entries))
+	for _, entry := range entries {
+		reqs = append(reqs, entry.Request)
+	}
+	return reqs, nil
+}
+
+// AssertCalledTimes asserts that the executions matching the given call happened the given number of times.
+// e.g. stubber.AssertCalledTimes(t, 1, comproto.CallTo("git").WithArgs("push", comproto.AnyString()))
+func (stubber *ExecStubber) AssertCalledTimes(t testing.TB, times int, call *comproto.Expectation) bool {
+	t.Helper()
+	invocations, ok := stubber.invocationsOrFail(t)
+	if !ok {
+		return false
+	}
+	if count := countMatching(invocations, call); count != times {
+		t.Errorf("expected %s to be called %d time(s), but was called %d time(s)\n%s",
+			call, times, count, describeInvocations(invocations))
+		return false
+	}
+	return true
+}
+
+// AssertCalledOnce asserts that exactly one execution matches the given call.
+func (stubber *ExecStubber) AssertCalledOnce(t testing.TB, call *comproto.Expectation) bool {
+	t.Helper()
+	return stubber.AssertCalledTimes(t, 1, call)
+}
+
+// AssertNeverCalled asserts that no execution matches the given call.
+func (stubber *ExecStubber) AssertNeverCalled(t testing.TB, call *comproto.Expectation) bool {
+	t.Helper()
+	invocations, ok := stubber.invocationsOrFail(t)
+	if !ok {
+		return false
+	}
+	if count := countMatching(invocations, call); count != 0 {
+		t.Errorf("expected %s to never be called, but was called %d time(s)\n%s",
+			call, count, describeInvocations(invocations))
+		return false
+	}
+	return true
+}
+
+// AssertCalledInOrder asserts that executions matching the given calls happened in the given order,
+// possibly interleaved with other executions, across all stubbed commands.
+// e.g. stubber.AssertCalledInOrder(t, comproto.CallTo("docker").WithArgs("build", comproto.AnyString()),
+//
+//	comproto.CallTo("docker").WithArgs("push", comproto.AnyString()))
+func (stubber *ExecStubber) AssertCalledInOrder(t testing.TB, calls ...*comproto.Expectation) bool {
+	t.Helper()
+	invocations, ok := stubber.invocationsOrFail(t)
+	if !ok {
+		return false
+	}
+	callIndex := 0
+	for _, invocation := range invocations {
+		if callIndex < len(calls) && calls[callIndex].Matches(invocation) {
+			callIndex++
+		}
+	}
+	if callIndex == len(calls) {
+		return true
+	}
+	var msg strings.Builder
+	msg.WriteString("expected calls in order:")
+	for i, call := range calls {
+		fmt.Fprintf(&msg, "\n\t%d. %s", i+1, call)
+	}
+	if callIndex == 0 {
+		fmt.Fprintf(&msg, "\nbut %s has not been called", calls[0])
+	} else {
+		fmt.Fprintf(&msg, "\nbut %s has not been called after %s", calls[callIndex], calls[callIndex-1])
+	}
+	t.Errorf("%s\n%s", msg.String(), describeInvocations(invocations))
+	return false
+}
+
+func (stubber *ExecStubber) invocationsOrFail(t testing.TB) ([]comproto.StubRequest, bool) {
+	t.Helper()
+	invocations, err := stubber.Invocations()
+	if err != nil {
+		t.Errorf("fail to get the invocations: %v", err)
+		return nil, false
+	}
+	return invocations, true
+}
+
+func countMatching(invocations []comproto.StubRequest, call *comproto.Expectation) int {
+	count := 0
+	for _, invocation := range invocations {
+		if call.Matches(invocation) {
+			count++
+		}
+	}
+	return count
+}
+
+func describeInvocations(invocations []comproto.StubRequest) string {
+	if len(invocations) == 0 {
+		return "no invocation has been recorded"
+	}
+	var desc strings.Builder
+	desc.WriteString("recorded invocations:")
+	for i, invocation := range invocations {
+		fmt.Fprintf(&desc, "\n\t%d. %s %q", i+1, invocation.CmdName, invocation.Args)
+	}
+	return desc.String()
+}
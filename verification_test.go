@@ -0,0 +1,191 @@
+// Copyright 2020 The Execstub Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package execstub
+
+import (
+	"fmt"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	rt "github.com/congop/execstub/internal/runtime"
+	comproto "github.com/congop/execstub/pkg/comproto"
+)
+
+// failureRecordingTB records failures instead of failing the test.
+type failureRecordingTB struct {
+	testing.TB
+	failures []string
+}
+
+func (tb *failureRecordingTB) Helper() {}
+
+func (tb *failureRecordingTB) Errorf(format string, args ...interface{}) {
+	tb.failures = append(tb.failures, fmt.Sprintf(format, args...))
+}
+
+func Test_verification(t *testing.T) {
+	stubber := NewExecStubber()
+	t.Cleanup(stubber.CleanUp)
+	settings := comproto.SettingsDynaStubCmdDiscoveredByPath()
+	gitCmd := rt.EnsureHasExecExt("git")
+	dockerCmd := rt.EnsureHasExecExt("docker")
+	outcome := comproto.ExecOutcome{ExitCode: 0}
+
+	runForAllStubKinds(t, settings, "verification", func(t *testing.T, settings comproto.Settings) {
+		assert.NoError(t, stubber.ClearTimeline())
+		gitKey, err := stubber.WhenExecDoStubFunc(comproto.AdaptOutcomeToCmdStub(&outcome), gitCmd, settings)
+		if err != nil {
+			t.Fatalf("Error resgistering cmd-stub: err=%#v key=%s", err, gitKey)
+		}
+		defer stubber.Unregister(gitKey)
+		dockerKey, err := stubber.WhenExecDoStubFunc(comproto.AdaptOutcomeToCmdStub(&outcome), dockerCmd, settings)
+		if err != nil {
+			t.Fatalf("Error resgistering cmd-stub: err=%#v key=%s", err, dockerKey)
+		}
+		defer stubber.Unregister(dockerKey)
+
+		for _, cmdArgs := range [][]string{
+			{"git", "fetch", "origin"},
+			{"docker", "build", "-t", "app:1.0", "."},
+			{"git", "merge", "origin/main"},
+			{"docker", "push", "app:1.0"},
+			{"git", "push", "origin", "main"},
+		} {
+			err := exec.Command(cmdArgs[0], cmdArgs[1:]...).Run()
+			assert.NoError(t, err, "execution of %v", cmdArgs)
+		}
+
+		dockerBuild := comproto.CallTo("docker").WithArgs("build", "-t", comproto.AnyString(), ".")
+		dockerPush := comproto.CallTo("docker").WithArgs("push", comproto.HasPrefix("app:"))
+		gitFetch := comproto.CallTo("git").WithArgs("fetch", comproto.AnyString())
+		gitMerge := comproto.CallTo("git").WithArgs("merge", comproto.AnyString())
+		gitPush := comproto.CallTo("git").WithArgs("push", comproto.AnyString(), comproto.AnyString())
+
+		stubber.AssertCalledOnce(t, gitPush)
+		stubber.AssertCalledTimes(t, 3, comproto.CallTo("git"))
+		stubber.AssertCalledTimes(t, 2, comproto.CallTo("docker"))
+		stubber.AssertCalledTimes(t, 1, comproto.CallTo("docker").WithArgCount(2))
+		stubber.AssertNeverCalled(t, comproto.CallTo("rm"))
+		stubber.AssertCalledInOrder(t, gitFetch, dockerBuild, gitMerge, dockerPush, gitPush)
+		stubber.AssertCalledInOrder(t, dockerBuild, dockerPush)
+
+		failingTB := &failureRecordingTB{TB: t}
+		assert.False(t, stubber.AssertCalledOnce(failingTB, comproto.CallTo("git")))
+		assert.False(t, stubber.AssertNeverCalled(failingTB, dockerPush))
+		assert.False(t, stubber.AssertCalledInOrder(failingTB, dockerPush, dockerBuild))
+		if assert.Len(t, failingTB.failures, 3) {
+			assert.Contains(t, failingTB.failures[0],
+				`expected On("git").WithAnyArgs() to be called 1 time(s), but was called 3 time(s)`)
+			assert.Contains(t, failingTB.failures[1],
+				`expected On("docker").WithArgs("push", HasPrefix("app:")) to never be called, but was called 1 time(s)`)
+			assert.Contains(t, failingTB.failures[2],
+				`but On("docker").WithArgs("build", "-t", AnyString(), ".") has not been called after On("docker").WithArgs("push", HasPrefix("app:"))`)
+			assert.Contains(t, failingTB.failures[2],
+				fmt.Sprintf("recorded invocations:\n\t1. %s [\"fetch\" \"origin\"]", gitCmd))
+		}
+	})
+}
+
+func Test_timelineOrdersInvocationsAcrossCommands(t *testing.T) {
+	stubber := NewExecStubber()
+	t.Cleanup(stubber.CleanUp)
+	settings := comproto.SettingsDynaStubCmdDiscoveredByPath()
+	gitCmd := rt.EnsureHasExecExt("git")
+	makeCmd := rt.EnsureHasExecExt("make")
+	outcome := comproto.ExecOutcome{ExitCode: 0}
+	const concurrentBuildCount = 12
+
+	runForAllStubKinds(t, settings, "timeline", func(t *testing.T, settings comproto.Settings) {
+		assert.NoError(t, stubber.ClearTimeline())
+		gitKey, err := stubber.WhenExecDoStubFunc(comproto.AdaptOutcomeToCmdStub(&outcome), gitCmd, settings)
+		if err != nil {
+			t.Fatalf("Error resgistering cmd-stub: err=%#v key=%s", err, gitKey)
+		}
+		defer stubber.Unregister(gitKey)
+		makeKey, err := stubber.WhenExecDoStubFunc(comproto.AdaptOutcomeToCmdStub(&outcome), makeCmd, settings)
+		if err != nil {
+			t.Fatalf("Error resgistering cmd-stub: err=%#v key=%s", err, makeKey)
+		}
+		defer stubber.Unregister(makeKey)
+
+		assert.NoError(t, exec.Command("git", "fetch", "origin").Run())
+		assert.NoError(t, exec.Command("git", "merge", "origin/main").Run())
+		done := make(chan error, concurrentBuildCount)
+		for i := 0; i < concurrentBuildCount; i++ {
+			go func(i int) {
+				done <- exec.Command("make", fmt.Sprintf("target%d", i)).Run()
+			}(i)
+		}
+		for i := 0; i < concurrentBuildCount; i++ {
+			assert.NoError(t, <-done)
+		}
+		assert.NoError(t, exec.Command("git", "push").Run())
+
+		timeline, err := stubber.Timeline()
+		assert.NoError(t, err)
+		if !assert.Len(t, timeline, concurrentBuildCount+3) {
+			return
+		}
+		for i, entry := range timeline {
+			// the numbering continues after the invocations cleared by the previous sub-test
+			assert.Equal(t, timeline[0].Seq+uint64(i), entry.Seq, "sequence numbers must be contiguous")
+		}
+		assert.Equal(t, []string{"fetch", "origin"}, timeline[0].Request.Args)
+		assert.Equal(t, []string{"merge", "origin/main"}, timeline[1].Request.Args)
+		for _, entry := range timeline[2 : concurrentBuildCount+2] {
+			assert.Equal(t, makeKey, entry.Request.Key)
+		}
+		assert.Equal(t, []string{"push"}, timeline[concurrentBuildCount+2].Request.Args)
+		stubber.AssertCalledInOrder(t,
+			comproto.CallTo("git").WithArgs("fetch", comproto.AnyString()),
+			comproto.CallTo("git").WithArgs("merge", comproto.AnyString()))
+		stubber.AssertCalledTimes(t, concurrentBuildCount, comproto.CallTo("make").WithArgCount(1))
+	})
+}
+
+func Test_clearTimelineKeepsPersistedStaticRequests(t *testing.T) {
+	stubber := NewExecStubber()
+	t.Cleanup(stubber.CleanUp)
+	settings := comproto.SettingsDynaStubCmdDiscoveredByPath()
+	gitCmd := rt.EnsureHasExecExt("git")
+	outcome := comproto.ExecOutcome{ExitCode: 0}
+
+	runForAllStubKinds(t, settings, "clear timeline", func(t *testing.T, settings comproto.Settings) {
+		if !settings.InModStatic() {
+			t.Skip("requests are only persisted in static mode")
+		}
+		gitKey, err := stubber.WhenExecDoStubFunc(comproto.AdaptOutcomeToCmdStub(&outcome), gitCmd, settings)
+		if err != nil {
+			t.Fatalf("Error resgistering cmd-stub: err=%#v key=%s", err, gitKey)
+		}
+		defer stubber.Unregister(gitKey)
+
+		assert.NoError(t, exec.Command("git", "fetch", "origin").Run())
+		assert.NoError(t, stubber.ClearTimeline())
+		assert.NoError(t, exec.Command("git", "push", "origin", "main").Run())
+
+		reqs, err := stubber.FindAllPersistedStubRequests(gitKey)
+		if assert.NoError(t, err) && assert.Len(t, *reqs, 2) {
+			assert.Equal(t, []string{"fetch", "origin"}, (*reqs)[0].Args)
+			assert.Equal(t, []string{"push", "origin", "main"}, (*reqs)[1].Args)
+		}
+		invocations, err := stubber.Invocations()
+		if assert.NoError(t, err) && assert.Len(t, invocations, 1) {
+			assert.Equal(t, []string{"push", "origin", "main"}, invocations[0].Args)
+		}
+	})
+}